@@ -339,6 +339,7 @@ class CreateRemoteConnModal extends React.Component<{}, {}> {
                                 { value: "startup", label: "startup" },
                                 { value: "auto", label: "auto" },
                                 { value: "manual", label: "manual" },
+                                { value: "reconnect", label: "reconnect" },
                             ]}
                             value={this.tempConnectMode.get()}
                             onChange={(val: string) => {
@@ -353,6 +354,7 @@ class CreateRemoteConnModal extends React.Component<{}, {}> {
                                 { value: "detect", label: "detect" },
                                 { value: "bash", label: "bash" },
                                 { value: "zsh", label: "zsh" },
+                                { value: "fish", label: "fish" },
                             ]}
                             value={this.tempShellPref.get()}
                             onChange={(val: string) => {
@@ -235,6 +235,7 @@ class EditRemoteConnModal extends React.Component<{}, {}> {
                         { value: "startup", label: "startup" },
                         { value: "auto", label: "auto" },
                         { value: "manual", label: "manual" },
+                        { value: "reconnect", label: "reconnect" },
                     ]}
                     value={this.tempConnectMode.get()}
                     onChange={this.handleChangeConnectMode}
@@ -252,6 +253,7 @@ class EditRemoteConnModal extends React.Component<{}, {}> {
                         { value: "detect", label: "detect" },
                         { value: "bash", label: "bash" },
                         { value: "zsh", label: "zsh" },
+                        { value: "fish", label: "fish" },
                     ]}
                     value={this.tempShellPref.get()}
                     onChange={this.handleChangeShellPref}
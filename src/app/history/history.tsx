@@ -29,6 +29,9 @@ import { MainView } from "../common/elements/mainview";
 dayjs.extend(customParseFormat);
 dayjs.extend(localizedFormat);
 
+// remoteid of history items added by /history:import (history.ImportRemoteId), the remote name is the format
+const ImportRemoteId = "75d9f619-fdee-418b-9b3d-13438a9e7262";
+
 function isBlank(s: string) {
     return s == null || s == "";
 }
@@ -49,6 +52,9 @@ function formatRemoteName(rnames: Record<string, string>, rptr: RemotePtrType):
         return "";
     }
     let rname = rnames[rptr.remoteid];
+    if (rname == null && rptr.remoteid == ImportRemoteId) {
+        rname = "import";
+    }
     if (rname == null) {
         rname = rptr.remoteid.substring(0, 8);
     }
@@ -65,6 +71,16 @@ function formatSSName(snames: Record<string, string>, scrnames: Record<string, s
     return "#" + (snames[item.sessionid] ?? item.sessionid.substring(0, 8));
 }
 
+// tags set with /history:tag, sorted
+function getHistoryTags(item: HistoryItem): string[] {
+    if (item.tags == null) {
+        return [];
+    }
+    const tags = Object.keys(item.tags).filter((tag) => item.tags[tag]);
+    tags.sort();
+    return tags;
+}
+
 function formatSessionName(snames: Record<string, string>, sessionId: string): string {
     if (isBlank(sessionId)) {
         return "";
@@ -427,6 +443,7 @@ class HistoryView extends React.Component<{}, {}> {
         }
         const hvm = GlobalModel.historyViewModel;
         let item: HistoryItem = null;
+        let tag: string = null;
         const items = hvm.items.slice();
         const nowDate = new Date();
         const snames = GlobalModel.getSessionNames();
@@ -553,6 +570,20 @@ class HistoryView extends React.Component<{}, {}> {
                                         fontSize="normal"
                                         limitHeight={true}
                                     />
+                                    <If condition={getHistoryTags(item).length > 0 || !isBlank(item.note)}>
+                                        <div className="history-annotations">
+                                            <For each="tag" of={getHistoryTags(item)}>
+                                                <span key={tag} className="history-tag" title={"tag:" + tag}>
+                                                    {tag}
+                                                </span>
+                                            </For>
+                                            <If condition={!isBlank(item.note)}>
+                                                <span className="history-note" title={item.note}>
+                                                    {item.note}
+                                                </span>
+                                            </If>
+                                        </div>
+                                    </If>
                                     <div
                                         className="flex-spacer activate-item-spacer"
                                         onClick={() => this.activateItem(item.historyid)}
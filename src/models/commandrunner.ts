@@ -530,7 +530,7 @@ class CommandRunner {
         return GlobalModel.submitCommand("client", "setglobalshortcut", [shortcut], { nohist: "1" }, false);
     }
 
-    // Set AI Providers options (gemini, openai, azure)
+    // Set AI Providers options (gemini, openai, azure, ollama, anthropic)
     setAIOpts(opts: any): Promise<CommandRtnType> {
         let kwargs: Record<string, string> = { nohist: "1" };
         // default provider
@@ -552,6 +552,18 @@ class CommandRunner {
         if (opts.azure?.apitoken != null) {
             kwargs["azureapitoken"] = opts.azure.apitoken;
         }
+        if (opts.ollama?.baseurl != null) {
+            kwargs["ollamabaseurl"] = opts.ollama.baseurl;
+        }
+        if (opts.ollama?.model != null) {
+            kwargs["ollamamodel"] = opts.ollama.model;
+        }
+        if (opts.anthropic?.apitoken != null) {
+            kwargs["anthropicapitoken"] = opts.anthropic.apitoken;
+        }
+        if (opts.anthropic?.model != null) {
+            kwargs["anthropicmodel"] = opts.anthropic.model;
+        }
         return GlobalModel.submitCommand("client", "set", null, kwargs, false);
     }
 
@@ -62,6 +62,7 @@ declare global {
         tabcolor?: string;
         tabicon?: string;
         pterm?: string;
+        autoexplain?: boolean;
     };
 
     type WebShareOpts = {
@@ -101,6 +102,19 @@ declare global {
 
     type RemoteOptsType = {
         color: string;
+        forwards?: PortForwardType[];
+    };
+
+    type PortForwardType = {
+        type: "local" | "remote";
+        bindaddr: string;
+        targetaddr: string;
+    };
+
+    type PortForwardStateType = PortForwardType & {
+        status: "active" | "inactive" | "error";
+        errorstr?: string;
+        numconns: number;
     };
 
     type RemoteType = {
@@ -132,6 +146,9 @@ declare global {
         remove?: boolean;
         shellpref: string;
         defaultshelltype: string;
+        forwards?: PortForwardStateType[];
+        reconnectattempt?: number;
+        reconnectts?: number;
     };
 
     type RemoteStateType = {
@@ -172,6 +189,8 @@ declare global {
         ismetacmd: boolean;
         historynum: string;
         linenum: number;
+        tags?: Record<string, boolean>;
+        note?: string;
     };
 
     type CmdRemoteStateType = {
@@ -630,6 +649,16 @@ declare global {
         autocompleteenabled: boolean = true;
         inputposition: "top" | "bottom";
         aiprovider?: string;
+        retention?: RetentionOptsType;
+    };
+
+    type RetentionOptsType = {
+        maxagedays?: number;
+        maxitemsperscreen?: number;
+        archivedscreendays?: number;
+        nokeepstarred?: boolean;
+        nokeepbookmarked?: boolean;
+        nokeeptagged?: boolean;
     };
 
     type ReleaseInfoType = {
@@ -665,10 +694,35 @@ declare global {
     };
 
     type AIOptsType = {
-        default: "openai" | "azure" | "gemini";
+        default: "openai" | "azure" | "gemini" | "ollama" | "anthropic";
         gemini?: GeminiOptsType;
         openai?: OpenAIOptsType;
         azure?: AzureOpenAIOptsType;
+        ollama?: OllamaOptsType;
+        anthropic?: AnthropicOptsType;
+        context?: AIContextOptsType;
+        dailytokenbudget?: number;
+    };
+
+    type AIContextOptsType = {
+        enabled?: boolean;
+        includeenv?: boolean;
+        numcmds?: number;           // unset = default, 0 = off
+        maxoutputbytes?: number;    // unset = default, 0 = off
+        redactpatterns?: string[];
+    };
+
+    type AnthropicOptsType = {
+        model?: string;
+        apitoken?: string;
+        baseurl?: string;
+        maxtokens?: number;
+    };
+
+    type OllamaOptsType = {
+        baseurl?: string;
+        model?: string;
+        maxtokens?: number;
     };
 
     type GeminiOptsType = {
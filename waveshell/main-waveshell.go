@@ -103,12 +103,15 @@ Options:
     --help                 - prints this message
     --version              - print version
     --server               - multiplexer to run multiple commands
+	--server --listen [unix:/path|tcp:host:port] [--keyfile path]
+	                       - run the multiplexer on a socket (key from --keyfile or $WAVESHELL_KEY, required for tcp)
+	                         tcp connections use tls, both sides prove they have the key
 	--single               - run a single command (connected to multiplexer)
 	--single --version     - return an init packet with version info
 
-waveshell does not open any external ports and does not require any additional permissions.
-it communicates exclusively through stdin/stdout with an attached process
-via a JSON packet format.
+waveshell does not open any external ports (unless started with --listen) and does not require any
+additional permissions.  it communicates through stdin/stdout with an attached process (or over the
+--listen socket) via a JSON packet format.
 `
 	fmt.Printf("%s\n\n", strings.TrimSpace(usage))
 }
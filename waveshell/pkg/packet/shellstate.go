@@ -84,7 +84,7 @@ func ParseShellStateVersion(fullVersionStr string) (string, string, error) {
 	}
 	shell := fields[0]
 	version := fields[1]
-	if shell != ShellType_zsh && shell != ShellType_bash {
+	if shell != ShellType_zsh && shell != ShellType_bash && shell != ShellType_fish {
 		return "", "", fmt.Errorf("invalid shellstate shell type: %q", fullVersionStr)
 	}
 	if !semver.IsValid(version) {
@@ -122,7 +122,7 @@ func (diff ShellStateDiff) GetShellType() string {
 }
 
 func (state ShellState) GetLineDiffSplitString() string {
-	if state.GetShellType() == ShellType_zsh {
+	if state.GetShellType() == ShellType_zsh || state.GetShellType() == ShellType_fish {
 		return "\x00"
 	}
 	return "\n"
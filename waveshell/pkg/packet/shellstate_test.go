@@ -39,7 +39,17 @@ func TestShellVersions(t *testing.T) {
 	if version != "v5.0.17" {
 		t.Errorf("version should be v5.0.17")
 	}
-	_, _, err = ParseShellStateVersion("fish v5.0.17")
+	shell, version, err = ParseShellStateVersion("fish v3.7.1")
+	if err != nil {
+		t.Errorf("version should be valid, got error %v", err)
+	}
+	if shell != ShellType_fish {
+		t.Errorf("shell should be fish")
+	}
+	if version != "v3.7.1" {
+		t.Errorf("version should be v3.7.1")
+	}
+	_, _, err = ParseShellStateVersion("tcsh v5.0.17")
 	if err == nil {
 		t.Errorf("version should be invalid")
 	}
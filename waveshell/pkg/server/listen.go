@@ -0,0 +1,134 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/abhishek944/waveterm/waveshell/pkg/shexec"
+)
+
+// the pre-shared key can be given in a file (--keyfile) or in this environment variable (never on the command line)
+const ListenKeyEnvVar = "WAVESHELL_KEY"
+
+type serverOptsType struct {
+	Debug      bool
+	ListenAddr string // unix:/path or tcp:host:port
+	Key        []byte
+}
+
+// waveshell --server [--debug] [--listen unix:/path|tcp:host:port [--keyfile path]]
+func parseServerArgs(args []string) (*serverOptsType, error) {
+	opts := &serverOptsType{}
+	var keyFile string
+	for idx := 0; idx < len(args); idx++ {
+		switch args[idx] {
+		case "--debug":
+			opts.Debug = true
+		case "--listen", "--keyfile":
+			if idx+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires an argument", args[idx])
+			}
+			if args[idx] == "--listen" {
+				opts.ListenAddr = args[idx+1]
+			} else {
+				keyFile = args[idx+1]
+			}
+			idx++
+		default:
+			return nil, fmt.Errorf("invalid --server argument %q", args[idx])
+		}
+	}
+	if opts.ListenAddr == "" {
+		if keyFile != "" {
+			return nil, fmt.Errorf("--keyfile requires --listen")
+		}
+		return opts, nil
+	}
+	network, _, err := shexec.ParseSockAddr(opts.ListenAddr)
+	if err != nil {
+		return nil, err
+	}
+	if keyFile != "" {
+		keyData, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read keyfile: %v", err)
+		}
+		opts.Key = []byte(strings.TrimSpace(string(keyData)))
+	} else {
+		opts.Key = []byte(os.Getenv(ListenKeyEnvVar))
+	}
+	if len(opts.Key) == 0 && network != shexec.SockNetwork_Unix {
+		return nil, fmt.Errorf("listening on %s requires a key (--keyfile or $%s)", network, ListenKeyEnvVar)
+	}
+	return opts, nil
+}
+
+// serves one client at a time (each connection is a full server session, like a waveshell started over ssh).
+// tcp connections are tls, see the handshake comment in shexec/sockconn.go.
+// commands started by a client are killed when its connection closes
+func RunListenServer(opts *serverOptsType) (int, error) {
+	network, address, err := shexec.ParseSockAddr(opts.ListenAddr)
+	if err != nil {
+		return 1, err
+	}
+	if network == shexec.SockNetwork_Unix {
+		// remove a stale socket from a previous run
+		if finfo, err := os.Lstat(address); err == nil && finfo.Mode()&os.ModeSocket != 0 {
+			os.Remove(address)
+		}
+	}
+	var tlsConfig *tls.Config
+	if network == shexec.SockNetwork_Tcp {
+		tlsConfig, err = shexec.MakeSockServerTLSConfig()
+		if err != nil {
+			return 1, err
+		}
+	}
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return 1, err
+	}
+	defer listener.Close()
+	if network == shexec.SockNetwork_Unix {
+		os.Chmod(address, 0600)
+	}
+	fmt.Fprintf(os.Stderr, "waveshell listening on %s\n", opts.ListenAddr)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return 1, err
+		}
+		if tlsConfig != nil {
+			conn = tls.Server(conn, tlsConfig)
+		}
+		reader, err := shexec.SockAuthServer(conn, opts.Key)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[error] connection refused: %v\n", err)
+			conn.Close()
+			continue
+		}
+		_, err = runServerSession(reader, conn, opts.Debug)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[error] %v\n", err)
+		}
+		conn.Close()
+	}
+}
+
+func (m *MServer) closeClientProcs() {
+	m.Lock.Lock()
+	var cprocs []*shexec.ClientProc
+	for _, cproc := range m.ClientMap {
+		cprocs = append(cprocs, cproc)
+	}
+	m.Lock.Unlock()
+	for _, cproc := range cprocs {
+		cproc.Close()
+	}
+}
@@ -839,10 +839,18 @@ func (server *MServer) runReadLoop() {
 }
 
 func RunServer() (int, error) {
-	debug := false
-	if len(os.Args) >= 3 && os.Args[2] == "--debug" {
-		debug = true
+	opts, err := parseServerArgs(os.Args[2:])
+	if err != nil {
+		return 1, err
+	}
+	if opts.ListenAddr != "" {
+		return RunListenServer(opts)
 	}
+	return runServerSession(os.Stdin, os.Stdout, opts.Debug)
+}
+
+// runs the packet protocol over input/output until input is closed (or a write fails)
+func runServerSession(input io.Reader, output io.Writer, debug bool) (int, error) {
 	server := &MServer{
 		Lock:                &sync.Mutex{},
 		ClientMap:           make(map[base.CommandKey]*shexec.ClientProc),
@@ -855,9 +863,10 @@ func RunServer() (int, error) {
 	if debug {
 		packet.GlobalDebug = true
 	}
-	server.MainInput = packet.MakePacketParser(os.Stdin, nil)
-	server.Sender = packet.MakePacketSender(os.Stdout, server.packetSenderErrorHandler)
+	server.MainInput = packet.MakePacketParser(input, nil)
+	server.Sender = packet.MakePacketSender(output, server.packetSenderErrorHandler)
 	defer server.Close()
+	defer server.closeClientProcs()
 	wlog.LogConsumer = server.Sender.SendLogPacket
 	go func() {
 		for {
@@ -875,12 +884,20 @@ func RunServer() (int, error) {
 	}
 	server.Sender.SendPacket(initPacket)
 	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+	// a listen server runs many sessions, the ping loop must end with its session
+	pingDoneCh := make(chan struct{})
+	defer close(pingDoneCh)
 	go func() {
-		for range ticker.C {
-			server.Sender.SendPacket(packet.MakePingPacket())
+		for {
+			select {
+			case <-ticker.C:
+				server.Sender.SendPacket(packet.MakePingPacket())
+			case <-pingDoneCh:
+				return
+			}
 		}
 	}()
-	defer ticker.Stop()
 	readLoopDoneCh := make(chan bool)
 	go func() {
 		defer close(readLoopDoneCh)
@@ -0,0 +1,567 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellapi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/abhishek944/waveterm/waveshell/pkg/base"
+	"github.com/abhishek944/waveterm/waveshell/pkg/packet"
+	"github.com/abhishek944/waveterm/waveshell/pkg/shellenv"
+	"github.com/abhishek944/waveterm/waveshell/pkg/statediff"
+	"github.com/abhishek944/waveterm/waveshell/pkg/utilfn"
+	"github.com/alessio/shellescape"
+)
+
+const FishShellVersionCmdStr = `echo fish v$FISH_VERSION`
+const RemoteFishPath = "fish"
+
+const (
+	FishSection_Version = iota
+	FishSection_Cwd
+	FishSection_Vars
+	FishSection_Abbrs
+	FishSection_DataDir
+	FishSection_Funcs
+	FishSection_PVars
+	FishSection_EndBytes
+
+	FishSection_NumFieldsExpected // must be last
+)
+
+const RunFishSudoCommandFmt = `sudo -n -C %d fish /dev/fd/%d`
+const RunFishSudoPasswordCommandFmt = `cat /dev/fd/%d | sudo -k -S -C %d sh -c "echo '[from-mshell]'; exec %d>&-; fish /dev/fd/%d < /dev/fd/%d"`
+
+// read-only, electric, or per-session variables (not restored)
+var FishIgnoreVars = map[string]bool{
+	"_":                 true,
+	"argv":              true,
+	"status":            true,
+	"pipestatus":        true,
+	"status_generation": true,
+	"version":           true,
+	"FISH_VERSION":      true,
+	"fish_pid":          true,
+	"last_pid":          true,
+	"hostname":          true,
+	"history":           true,
+	"umask":             true,
+	"PWD":               true,
+	"SHLVL":             true,
+	"EUID":              true,
+	"CMD_DURATION":      true,
+	"COLUMNS":           true,
+	"LINES":             true,
+	"fish_kill_signal":  true,
+	"fish_killring":     true,
+	"fish_bind_mode":    true,
+	"fish_private_mode": true,
+}
+
+// "fish 3.7.1-123-gabcdef" => "v3.7.1"
+var fishVersionRe = regexp.MustCompile(`^fish v(\d+\.\d+(?:\.\d+)?)`)
+
+// do not use these directly, call GetLocalMajorVersion()
+var localFishMajorVersionOnce = &sync.Once{}
+var localFishMajorVersion = ""
+
+// aliases in fish are just functions, so the "Aliases" part of the state holds abbreviations.
+// both are stored as zsh maps (see EncodeZshMap) with these param types
+const (
+	FishParamType_Abbr     = "abbr"
+	FishParamType_Function = "functions"
+)
+
+type fishShellApi struct{}
+
+func (fishShellApi) GetShellType() string {
+	return packet.ShellType_fish
+}
+
+func (fishShellApi) MakeExitTrap(fdNum int) (string, []byte) {
+	return MakeFishExitTrap(fdNum)
+}
+
+func (fishShellApi) GetLocalMajorVersion() string {
+	return GetLocalFishMajorVersion()
+}
+
+func (fishShellApi) GetLocalShellPath() string {
+	return GetLocalFishPath()
+}
+
+func (fishShellApi) GetRemoteShellPath() string {
+	return RemoteFishPath
+}
+
+func (fishShellApi) ValidateCommandSyntax(cmdStr string) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), ValidateTimeout)
+	defer cancelFn()
+	cmd := exec.CommandContext(ctx, GetLocalFishPath(), "--no-config", "--no-execute", "-c", cmdStr)
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	errStr := utilfn.GetFirstLine(string(output))
+	errStr = strings.TrimPrefix(errStr, "fish: ")
+	if len(errStr) == 0 {
+		return errors.New("fish syntax error")
+	}
+	return errors.New(errStr)
+}
+
+func (fishShellApi) MakeRunCommand(cmdStr string, opts RunCommandOpts) string {
+	if !opts.Sudo {
+		return fmt.Sprintf(RunCommandFmt, cmdStr)
+	}
+	if opts.SudoWithPass {
+		return fmt.Sprintf(RunFishSudoPasswordCommandFmt, opts.PwFdNum, opts.MaxFdNum+1, opts.PwFdNum, opts.CommandFdNum, opts.CommandStdinFdNum)
+	} else {
+		return fmt.Sprintf(RunFishSudoCommandFmt, opts.MaxFdNum+1, opts.CommandFdNum)
+	}
+}
+
+// the user's config is not read (the rcfile restores the state), --init-command runs before -c
+func (fishShellApi) MakeShExecCommand(cmdStr string, rcFileName string, usePty bool) *exec.Cmd {
+	initCmd := "source " + FishQuote(rcFileName)
+	if usePty {
+		return exec.Command(GetLocalFishPath(), "--no-config", "--init-command", initCmd, "-i", "-c", cmdStr)
+	} else {
+		return exec.Command(GetLocalFishPath(), "--no-config", "--init-command", initCmd, "-c", cmdStr)
+	}
+}
+
+func (f fishShellApi) GetShellState(ctx context.Context, outCh chan ShellStateOutput, stdinDataCh chan []byte) {
+	defer close(outCh)
+	stateCmd, endBytes := GetFishShellStateCmd(StateOutputFdNum)
+	ecmd := exec.CommandContext(ctx, GetLocalFishPath(), "-l", "-i", "-c", stateCmd)
+	outputCh := make(chan []byte, 10)
+	var outputWg sync.WaitGroup
+	outputWg.Add(1)
+	go func() {
+		defer outputWg.Done()
+		for outputBytes := range outputCh {
+			outCh <- ShellStateOutput{Output: outputBytes}
+		}
+	}()
+	outputBytes, err := StreamCommandWithExtraFd(ctx, ecmd, outputCh, StateOutputFdNum, endBytes, stdinDataCh)
+	outputWg.Wait()
+	if err != nil {
+		outCh <- ShellStateOutput{Error: err.Error()}
+		return
+	}
+	rtn, stats, err := f.ParseShellStateOutput(outputBytes)
+	if err != nil {
+		outCh <- ShellStateOutput{Error: err.Error()}
+		return
+	}
+	outCh <- ShellStateOutput{ShellState: rtn, Stats: stats}
+}
+
+func (fishShellApi) GetBaseShellOpts() string {
+	return ""
+}
+
+// single quotes, inside of which fish only interprets \\ and \'
+func FishQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `'`, `\'`)
+	return "'" + s + "'"
+}
+
+func isFishIgnoreVar(name string) bool {
+	return FishIgnoreVars[name] || strings.HasPrefix(name, "__fish") || strings.HasPrefix(name, "_waveshell") || strings.HasPrefix(name, "_wavetemp_")
+}
+
+func makeFishSetStmt(varDecl *shellenv.DeclareDeclType) string {
+	flags := "-g"
+	if varDecl.IsExport() {
+		flags = "-gx"
+	}
+	if varDecl.IsFishDecl {
+		if varDecl.Value == "" {
+			return fmt.Sprintf("set %s -- %s", flags, varDecl.Name)
+		}
+		return fmt.Sprintf("set %s -- %s %s", flags, varDecl.Name, varDecl.Value)
+	}
+	// bash style decls (the wave vars added by wavesrv)
+	return fmt.Sprintf("set %s -- %s %s", flags, varDecl.Name, FishQuote(varDecl.UnescapedValue()))
+}
+
+func (f fishShellApi) MakeRcFileStr(pk *packet.RunPacketType) string {
+	var rcBuf bytes.Buffer
+	varDecls := shellenv.VarDeclsFromState(pk.State)
+	for _, varDecl := range varDecls {
+		if varDecl.IsExtVar || varDecl.IsZshDecl {
+			continue
+		}
+		if isFishIgnoreVar(varDecl.Name) {
+			continue
+		}
+		rcBuf.WriteString(makeFishSetStmt(varDecl))
+		rcBuf.WriteString("\n")
+	}
+	if pk.State == nil {
+		return rcBuf.String()
+	}
+	abbrMap, err := DecodeZshMap([]byte(pk.State.Aliases))
+	if err != nil {
+		base.Logf("error decoding fish abbreviations: %v\n", err)
+		rcBuf.WriteString("# error decoding fish abbreviations\n")
+	} else {
+		for _, abbrKey := range utilfn.GetOrderedStringerMapKeys(abbrMap) {
+			// the values are the lines from "abbr --show"
+			rcBuf.WriteString(abbrMap[abbrKey])
+			rcBuf.WriteString("\n")
+		}
+	}
+	fnMap, err := DecodeZshMap([]byte(pk.State.Funcs))
+	if err != nil {
+		base.Logf("error decoding fish functions: %v\n", err)
+		rcBuf.WriteString("# error decoding fish functions\n")
+	} else {
+		for _, fnKey := range utilfn.GetOrderedStringerMapKeys(fnMap) {
+			// the values are the output of "functions [name]" (a full definition)
+			rcBuf.WriteString(fnMap[fnKey])
+			rcBuf.WriteString("\n")
+		}
+	}
+	return rcBuf.String()
+}
+
+// returns (cmd-string, endbytes)
+func GetFishShellStateCmd(fdNum int) (string, []byte) {
+	sectionSeparator := utilfn.AppendNonZeroRandomBytes(nil, NumRandomEndBytes)
+	sectionSeparator = append(sectionSeparator, 0, 0)
+	endBytes := utilfn.AppendNonZeroRandomBytes(nil, NumRandomEndBytes)
+	endBytes = append(endBytes, '\n')
+	// fish strings cannot contain null bytes, so every field is written null terminated.
+	// this runs as a function so the loop variables are local (a top-level "for" sets globals).
+	// autoloadable functions (a file in $fish_function_path) are not captured, fish loads them on demand.
+	// "set -q" guards the "string replace" (with no arguments it would read stdin)
+	cmd := `
+function _waveshell_state
+    printf '%s\x00' "[%FISHVERSION%]"
+    printf '[%SECTIONSEP%]'
+    pwd
+    printf '[%SECTIONSEP%]'
+    for _waveshell_var in (set --global --names) (set --universal --names)
+        printf '%s\x00' $_waveshell_var
+        set -qx $_waveshell_var; and printf 'x'
+        set -l _waveshell_count (count $$_waveshell_var)
+        printf '\x00%s\x00' $_waveshell_count
+        if test $_waveshell_count -gt 0
+            printf '%s\x00' $$_waveshell_var
+        end
+    end
+    printf '[%SECTIONSEP%]'
+    for _waveshell_abbr in (abbr --show)
+        printf '%s\x00' $_waveshell_abbr
+    end
+    printf '[%SECTIONSEP%]'
+    printf '%s' $__fish_data_dir
+    printf '[%SECTIONSEP%]'
+    set -l _waveshell_files $fish_function_path/*.fish
+    set -l _waveshell_autoload
+    if set -q _waveshell_files[1]
+        set _waveshell_autoload (string replace -r '^.*/([^/]*)\.fish$' '$1' -- $_waveshell_files)
+    end
+    for _waveshell_fn in (functions --all --names)
+        string match -q -- '_waveshell*' $_waveshell_fn; and continue
+        contains -- $_waveshell_fn $_waveshell_autoload; and continue
+        printf '%s\x00' $_waveshell_fn (functions --details $_waveshell_fn)
+        functions $_waveshell_fn
+        printf '\x00'
+    end
+    printf '[%SECTIONSEP%]'
+    printf 'GITBRANCH %s\x00' (git rev-parse --abbrev-ref HEAD 2>/dev/null)
+    printf '[%SECTIONSEP%]'
+    printf '[%ENDBYTES%]'
+end
+_waveshell_state > [%OUTPUTFD%] 2> /dev/null
+`
+	cmd = strings.TrimSpace(cmd)
+	cmd = strings.ReplaceAll(cmd, "[%FISHVERSION%]", "fish v$FISH_VERSION")
+	cmd = strings.ReplaceAll(cmd, "[%SECTIONSEP%]", utilfn.ShellHexEscape(string(sectionSeparator)))
+	cmd = strings.ReplaceAll(cmd, "[%OUTPUTFD%]", fmt.Sprintf("/dev/fd/%d", fdNum))
+	cmd = strings.ReplaceAll(cmd, "[%ENDBYTES%]", utilfn.ShellHexEscape(string(endBytes)))
+	return cmd, endBytes
+}
+
+func MakeFishExitTrap(fdNum int) (string, []byte) {
+	stateCmd, endBytes := GetFishShellStateCmd(fdNum)
+	fmtStr := `
+function _waveshell_exittrap --on-event fish_exit
+%s
+end
+`
+	return fmt.Sprintf(fmtStr, stateCmd), endBytes
+}
+
+func GetLocalFishPath() string {
+	if runtime.GOOS == "darwin" {
+		macShell := GetMacUserShell()
+		if strings.Index(macShell, "fish") != -1 {
+			return shellescape.Quote(macShell)
+		}
+	}
+	return "fish"
+}
+
+func execGetLocalFishShellVersion() string {
+	ctx, cancelFn := context.WithTimeout(context.Background(), GetVersionTimeout)
+	defer cancelFn()
+	ecmd := exec.CommandContext(ctx, "fish", "--no-config", "-c", FishShellVersionCmdStr)
+	out, err := ecmd.Output()
+	if err != nil {
+		return ""
+	}
+	return normalizeFishVersion(string(out))
+}
+
+func GetLocalFishMajorVersion() string {
+	localFishMajorVersionOnce.Do(func() {
+		fullVersion := execGetLocalFishShellVersion()
+		localFishMajorVersion = packet.GetMajorVersion(fullVersion)
+	})
+	return localFishMajorVersion
+}
+
+// returns "" if not a valid fish version
+func normalizeFishVersion(versionStr string) string {
+	m := fishVersionRe.FindStringSubmatch(strings.TrimSpace(versionStr))
+	if m == nil {
+		return ""
+	}
+	return "fish v" + m[1]
+}
+
+// returns the fields (the output is null terminated, so the last empty field is dropped)
+func splitFishFields(section []byte) []string {
+	if len(section) == 0 {
+		return nil
+	}
+	fields := strings.Split(string(section), "\x00")
+	if fields[len(fields)-1] == "" {
+		fields = fields[:len(fields)-1]
+	}
+	return fields
+}
+
+// fields are: name, flags, count, values...
+func parseFishVars(section []byte) (map[string]*DeclareDeclType, error) {
+	fields := splitFishFields(section)
+	rtn := make(map[string]*DeclareDeclType)
+	idx := 0
+	for idx < len(fields) {
+		if idx+3 > len(fields) {
+			return nil, fmt.Errorf("invalid fish vars output, truncated var %q", fields[idx])
+		}
+		name, flags := fields[idx], fields[idx+1]
+		var count int
+		_, err := fmt.Sscanf(fields[idx+2], "%d", &count)
+		if err != nil || count < 0 || idx+3+count > len(fields) {
+			return nil, fmt.Errorf("invalid fish vars output, bad count for var %q", name)
+		}
+		values := fields[idx+3 : idx+3+count]
+		idx += 3 + count
+		if isFishIgnoreVar(name) || rtn[name] != nil {
+			// globals are listed before universals, and $$var is the same for both
+			continue
+		}
+		decl := &DeclareDeclType{IsFishDecl: true, Name: name}
+		if strings.Contains(flags, "x") {
+			decl.AddFlag("x")
+		}
+		envSep := " "
+		if strings.HasSuffix(name, "PATH") {
+			decl.AddFlag("p")
+			envSep = ":"
+		}
+		quotedValues := make([]string, len(values))
+		for valIdx, val := range values {
+			quotedValues[valIdx] = FishQuote(val)
+		}
+		decl.Value = strings.Join(quotedValues, " ")
+		decl.FishEnvValue = strings.Join(values, envSep)
+		rtn[name] = decl
+	}
+	return rtn, nil
+}
+
+// the abbreviation name from an "abbr --show" line: abbr -a [opts] -- name expansion
+func parseFishAbbrName(line string) string {
+	dashIdx := strings.Index(line, " -- ")
+	if dashIdx == -1 {
+		return line
+	}
+	rest := line[dashIdx+4:]
+	for idx := 0; idx < len(rest); idx++ {
+		if rest[idx] == '\\' {
+			idx++
+			continue
+		}
+		if rest[idx] == ' ' {
+			return rest[:idx]
+		}
+	}
+	return rest
+}
+
+func parseFishAbbrs(section []byte) map[ZshParamKey]string {
+	rtn := make(map[ZshParamKey]string)
+	for _, line := range splitFishFields(section) {
+		if line == "" {
+			continue
+		}
+		rtn[ZshParamKey{ParamType: FishParamType_Abbr, ParamName: parseFishAbbrName(line)}] = line
+	}
+	return rtn
+}
+
+// fields are: name, details (source file), definition
+// functions from fish's own data dir are skipped (they exist in every fish)
+func parseFishFuncs(section []byte, dataDir string) map[ZshParamKey]string {
+	fields := splitFishFields(section)
+	rtn := make(map[ZshParamKey]string)
+	for idx := 0; idx+2 < len(fields); idx += 3 {
+		name, details, body := fields[idx], fields[idx+1], fields[idx+2]
+		if dataDir != "" && strings.HasPrefix(details, dataDir+"/") {
+			continue
+		}
+		body = stripNewLineChars(body)
+		if name == "" || body == "" {
+			continue
+		}
+		rtn[ZshParamKey{ParamType: FishParamType_Function, ParamName: name}] = body
+	}
+	return rtn
+}
+
+func (fishShellApi) ParseShellStateOutput(outputBytes []byte) (*packet.ShellState, *packet.ShellStateStats, error) {
+	firstZeroIdx := bytes.Index(outputBytes, []byte{0})
+	firstDZeroIdx := bytes.Index(outputBytes, []byte{0, 0})
+	if firstZeroIdx == -1 || firstDZeroIdx == -1 {
+		return nil, nil, fmt.Errorf("invalid fish shell state output, could not parse separator bytes")
+	}
+	versionStr := string(outputBytes[0:firstZeroIdx])
+	sectionSeparator := outputBytes[firstZeroIdx+1 : firstDZeroIdx+2]
+	// sections: see FishSection_* consts
+	sections := bytes.Split(outputBytes, sectionSeparator)
+	if len(sections) != FishSection_NumFieldsExpected {
+		return nil, nil, fmt.Errorf("invalid fish shell state output, wrong number of sections, section=%d", len(sections))
+	}
+	rtn := &packet.ShellState{}
+	rtn.Version = normalizeFishVersion(versionStr)
+	if rtn.GetShellType() != packet.ShellType_fish {
+		return nil, nil, fmt.Errorf("invalid fish shell state output, wrong shell type")
+	}
+	if _, _, err := packet.ParseShellStateVersion(rtn.Version); err != nil {
+		return nil, nil, fmt.Errorf("invalid fish shell state output, invalid version: %v", err)
+	}
+	rtn.Cwd = stripNewLineChars(string(sections[FishSection_Cwd]))
+	fishDecls, err := parseFishVars(sections[FishSection_Vars])
+	if err != nil {
+		return nil, nil, err
+	}
+	abbrMap := parseFishAbbrs(sections[FishSection_Abbrs])
+	rtn.Aliases = string(EncodeZshMap(abbrMap))
+	fishFuncs := parseFishFuncs(sections[FishSection_Funcs], stripNewLineChars(string(sections[FishSection_DataDir])))
+	rtn.Funcs = string(EncodeZshMap(fishFuncs))
+	pvarMap := parseExtVarOutput(sections[FishSection_PVars], "", "")
+	utilfn.CombineMaps(fishDecls, pvarMap)
+	rtn.ShellVars = shellenv.SerializeDeclMap(fishDecls)
+	var envCount int
+	for _, decl := range fishDecls {
+		if decl.IsExport() {
+			envCount++
+		}
+	}
+	stats := &packet.ShellStateStats{
+		Version:    rtn.Version,
+		AliasCount: len(abbrMap),
+		FuncCount:  len(fishFuncs),
+		VarCount:   len(fishDecls),
+		EnvCount:   envCount,
+		HashVal:    rtn.GetHashVal(false),
+		OutputSize: int64(len(outputBytes)),
+		StateSize:  rtn.ApproximateSize(),
+	}
+	return rtn, stats, nil
+}
+
+func (fishShellApi) MakeShellStateDiff(oldState *packet.ShellState, oldStateHash string, newState *packet.ShellState) (*packet.ShellStateDiff, error) {
+	if oldState == nil {
+		return nil, fmt.Errorf("cannot diff, oldState is nil")
+	}
+	if newState == nil {
+		return nil, fmt.Errorf("cannot diff, newState is nil")
+	}
+	if !packet.StateVersionsCompatible(oldState.Version, newState.Version) {
+		return nil, fmt.Errorf("cannot diff, incompatible shell versions: %q %q", oldState.Version, newState.Version)
+	}
+	rtn := &packet.ShellStateDiff{}
+	rtn.BaseHash = oldStateHash
+	rtn.Version = newState.Version // always set version
+	if oldState.Cwd != newState.Cwd {
+		rtn.Cwd = newState.Cwd
+	}
+	rtn.Error = newState.Error
+	oldVars := shellenv.ShellStateVarsToMap(oldState.ShellVars)
+	newVars := shellenv.ShellStateVarsToMap(newState.ShellVars)
+	rtn.VarsDiff = statediff.MakeMapDiff(oldVars, newVars)
+	var err error
+	rtn.AliasesDiff, err = makeZshMapDiff(oldState.Aliases, newState.Aliases)
+	if err != nil {
+		return nil, err
+	}
+	rtn.FuncsDiff, err = makeZshMapDiff(oldState.Funcs, newState.Funcs)
+	if err != nil {
+		return nil, err
+	}
+	return rtn, nil
+}
+
+func (fishShellApi) ApplyShellStateDiff(oldState *packet.ShellState, diff *packet.ShellStateDiff) (*packet.ShellState, error) {
+	if oldState == nil {
+		return nil, fmt.Errorf("cannot apply diff, oldState is nil")
+	}
+	if diff == nil {
+		return oldState, nil
+	}
+	rtnState := &packet.ShellState{}
+	var err error
+	rtnState.Version = oldState.Version
+	if diff.Version != "" {
+		rtnState.Version = diff.Version
+	}
+	rtnState.Cwd = oldState.Cwd
+	if diff.Cwd != "" {
+		rtnState.Cwd = diff.Cwd
+	}
+	rtnState.Error = diff.Error
+	oldVars := shellenv.ShellStateVarsToMap(oldState.ShellVars)
+	newVars, err := statediff.ApplyMapDiff(oldVars, diff.VarsDiff)
+	if err != nil {
+		return nil, fmt.Errorf("applying mapdiff 'vars': %v", err)
+	}
+	rtnState.ShellVars = shellenv.StrMapToShellStateVars(newVars)
+	rtnState.Aliases, err = applyZshMapDiff(oldState.Aliases, diff.AliasesDiff)
+	if err != nil {
+		return nil, fmt.Errorf("applying diff 'abbrs': %v", err)
+	}
+	rtnState.Funcs, err = applyZshMapDiff(oldState.Funcs, diff.FuncsDiff)
+	if err != nil {
+		return nil, fmt.Errorf("applying diff 'funcs': %v", err)
+	}
+	return rtnState, nil
+}
@@ -0,0 +1,154 @@
+package shellapi
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/abhishek944/waveterm/waveshell/pkg/packet"
+	"github.com/abhishek944/waveterm/waveshell/pkg/shellenv"
+)
+
+const testFishSep = "\x01\x02\x03\x04\x05\x06\x07\x08\x00\x00"
+
+// builds output in the format written by GetFishShellStateCmd
+func makeTestFishOutput(cwd string, vars string, abbrs string, funcs string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("fish v3.7.1-87-gabc\x00" + testFishSep)
+	buf.WriteString(cwd + "\n" + testFishSep)
+	buf.WriteString(vars + testFishSep)
+	buf.WriteString(abbrs + testFishSep)
+	buf.WriteString("/usr/share/fish" + testFishSep)
+	buf.WriteString(funcs + testFishSep)
+	buf.WriteString("GITBRANCH main\x00" + testFishSep)
+	buf.WriteString("ENDBYTES\n")
+	return buf.Bytes()
+}
+
+func TestFishQuote(t *testing.T) {
+	if q := FishQuote(`it's a \ test`); q != `'it\'s a \\ test'` {
+		t.Errorf("bad quote: %s", q)
+	}
+	if q := FishQuote(""); q != "''" {
+		t.Errorf("bad quote: %s", q)
+	}
+}
+
+func TestParseFishAbbrName(t *testing.T) {
+	if name := parseFishAbbrName("abbr -a -- gco git checkout"); name != "gco" {
+		t.Errorf("bad name %q", name)
+	}
+	if name := parseFishAbbrName("abbr -a --position anywhere -- L\\ x '| less'"); name != "L\\ x" {
+		t.Errorf("bad name %q", name)
+	}
+}
+
+func TestParseFishShellState(t *testing.T) {
+	vars := "PATH\x00x\x003\x00/usr/local/bin\x00/usr/bin\x00/bin\x00" +
+		"EDITOR\x00x\x001\x00vim\x00" +
+		"mylist\x00\x002\x00a b\x00it's\x00" +
+		"empty\x00\x000\x00" +
+		"status\x00\x001\x000\x00" +
+		"__fish_data_dir\x00\x001\x00/usr/share/fish\x00" +
+		"EDITOR\x00x\x001\x00vim\x00"
+	abbrs := "abbr -a -- gco git checkout\x00abbr -a -- gst git status\x00"
+	funcs := "ll\x00/home/u/.config/fish/config.fish\x00# Defined in /home/u/.config/fish/config.fish @ line 2\nfunction ll\n    ls -l $argv\nend\n\x00" +
+		"fish_title\x00/usr/share/fish/config.fish\x00function fish_title\nend\n\x00"
+	output := makeTestFishOutput("/home/u/src", vars, abbrs, funcs)
+	state, stats, err := fishShellApi{}.ParseShellStateOutput(output)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if state.Version != "fish v3.7.1" || state.GetShellType() != packet.ShellType_fish {
+		t.Errorf("bad version %q", state.Version)
+	}
+	if state.Cwd != "/home/u/src" {
+		t.Errorf("bad cwd %q", state.Cwd)
+	}
+	if stats.VarCount != 5 || stats.EnvCount != 2 || stats.AliasCount != 2 || stats.FuncCount != 1 {
+		t.Errorf("bad stats %#v", stats)
+	}
+	envMap := shellenv.EnvMapFromState(state)
+	if envMap["PATH"] != "/usr/local/bin:/usr/bin:/bin" || envMap["EDITOR"] != "vim" {
+		t.Errorf("bad env map %v", envMap)
+	}
+	if _, found := envMap["mylist"]; found {
+		t.Errorf("mylist should not be exported")
+	}
+	declMap := shellenv.DeclMapFromState(state)
+	if declMap["mylist"] == nil || declMap["mylist"].Value != `'a b' 'it\'s'` {
+		t.Errorf("bad mylist decl %#v", declMap["mylist"])
+	}
+	if declMap["status"] != nil || declMap["__fish_data_dir"] != nil {
+		t.Errorf("ignored vars should be skipped")
+	}
+	if declMap["PROMPTVAR_GITBRANCH"] == nil || declMap["PROMPTVAR_GITBRANCH"].Value != "main" {
+		t.Errorf("bad gitbranch %#v", declMap["PROMPTVAR_GITBRANCH"])
+	}
+
+	rcFile := fishShellApi{}.MakeRcFileStr(&packet.RunPacketType{State: state})
+	for _, expected := range []string{
+		"set -gx -- PATH '/usr/local/bin' '/usr/bin' '/bin'\n",
+		"set -g -- mylist 'a b' 'it\\'s'\n",
+		"set -g -- empty\n",
+		"abbr -a -- gco git checkout\n",
+		"function ll\n    ls -l $argv\nend\n",
+	} {
+		if !strings.Contains(rcFile, expected) {
+			t.Errorf("rcfile missing %q:\n%s", expected, rcFile)
+		}
+	}
+	if strings.Contains(rcFile, "fish_title") || strings.Contains(rcFile, "PROMPTVAR") {
+		t.Errorf("rcfile has unexpected content:\n%s", rcFile)
+	}
+}
+
+func TestFishShellStateDiff(t *testing.T) {
+	sapi := fishShellApi{}
+	oldState, _, err := sapi.ParseShellStateOutput(makeTestFishOutput("/tmp", "A\x00x\x001\x001\x00", "abbr -a -- g git\x00", ""))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	newOutput := makeTestFishOutput("/home", "A\x00x\x001\x002\x00B\x00\x001\x00b\x00", "", "f\x00stdin\x00function f\n    echo f\nend\x00")
+	newState, _, err := sapi.ParseShellStateOutput(newOutput)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	diff, err := sapi.MakeShellStateDiff(oldState, oldState.GetHashVal(false), newState)
+	if err != nil {
+		t.Fatalf("diff error: %v", err)
+	}
+	applied, err := sapi.ApplyShellStateDiff(oldState, diff)
+	if err != nil {
+		t.Fatalf("apply error: %v", err)
+	}
+	if applied.GetHashVal(true) != newState.GetHashVal(true) {
+		t.Errorf("applied state does not match:\n%#v\n%#v", applied, newState)
+	}
+}
+
+func TestFishValidate(t *testing.T) {
+	if _, err := exec.LookPath("fish"); err != nil {
+		t.Skip("fish is not installed")
+	}
+	sapi := fishShellApi{}
+	if err := sapi.ValidateCommandSyntax("echo foo | grep foo"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := sapi.ValidateCommandSyntax("if true; echo foo"); err == nil {
+		t.Errorf("expected error for missing end")
+	}
+}
+
+func TestHasShell(t *testing.T) {
+	for _, shellType := range []string{packet.ShellType_bash, packet.ShellType_zsh, packet.ShellType_fish} {
+		_, err := exec.LookPath(shellType)
+		if HasShell(shellType) != (err == nil) {
+			t.Errorf("HasShell(%q) = %v, lookpath err: %v", shellType, HasShell(shellType), err)
+		}
+	}
+	if HasShell("csh") {
+		t.Errorf("HasShell should be false for unsupported shells")
+	}
+}
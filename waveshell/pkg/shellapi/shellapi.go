@@ -86,6 +86,7 @@ type ShellApi interface {
 
 var _ ShellApi = &bashShellApi{}
 var _ ShellApi = &zshShellApi{}
+var _ ShellApi = &fishShellApi{}
 
 func DetectLocalShellType() string {
 	shellPath := GetMacUserShell()
@@ -99,17 +100,24 @@ func DetectLocalShellType() string {
 	if strings.HasPrefix(file, "zsh") {
 		return packet.ShellType_zsh
 	}
+	if strings.HasPrefix(file, "fish") {
+		return packet.ShellType_fish
+	}
 	return packet.ShellType_bash
 }
 
 func HasShell(shellType string) bool {
 	if shellType == packet.ShellType_bash {
 		_, err := exec.LookPath("bash")
-		return err != nil
+		return err == nil
 	}
 	if shellType == packet.ShellType_zsh {
 		_, err := exec.LookPath("zsh")
-		return err != nil
+		return err == nil
+	}
+	if shellType == packet.ShellType_fish {
+		_, err := exec.LookPath("fish")
+		return err == nil
 	}
 	return false
 }
@@ -121,6 +129,9 @@ func MakeShellApi(shellType string) (ShellApi, error) {
 	if shellType == packet.ShellType_zsh {
 		return &zshShellApi{}, nil
 	}
+	if shellType == packet.ShellType_fish {
+		return &fishShellApi{}, nil
+	}
 	return nil, fmt.Errorf("shell type not supported: %s", shellType)
 }
 
@@ -22,8 +22,9 @@ const (
 )
 
 type DeclareDeclType struct {
-	IsZshDecl bool
-	IsExtVar  bool // set for "special" wave internal variables
+	IsZshDecl  bool
+	IsFishDecl bool
+	IsExtVar   bool // set for "special" wave internal variables
 
 	Args string
 	Name string
@@ -35,6 +36,10 @@ type DeclareDeclType struct {
 	// for bound scalars, "Value" hold everything after the "=" (including the separator character)
 	ZshBoundScalar string // the name of the "scalar" env variable
 	ZshEnvValue    string // unlike Value this *is* the expanded value of scalar env variable
+
+	// for fish, "Value" holds the quoted list elements (suitable for "set").  this holds the
+	// value as fish exports it (elements joined with ":" for path variables, " " otherwise)
+	FishEnvValue string
 }
 
 func (d *DeclareDeclType) IsExport() bool {
@@ -57,6 +62,11 @@ func (d *DeclareDeclType) IsAssocArray() bool {
 	return strings.Contains(d.Args, "A")
 }
 
+// fish path variables ("set --path", or any variable ending in PATH) are exported joined with ":"
+func (d *DeclareDeclType) IsFishPathVar() bool {
+	return d.IsFishDecl && strings.Contains(d.Args, "p")
+}
+
 func (d *DeclareDeclType) IsUniqueArray() bool {
 	return d.IsArray() && strings.Contains(d.Args, "U")
 }
@@ -145,6 +155,15 @@ func (d *DeclareDeclType) Serialize() []byte {
 			d.ZshEnvValue,
 		}
 		return utilfn.EncodeStringArray(parts)
+	} else if d.IsFishDecl {
+		parts := []string{
+			"f1",
+			d.Args,
+			d.Name,
+			d.Value,
+			d.FishEnvValue,
+		}
+		return utilfn.EncodeStringArray(parts)
 	} else {
 		parts := []string{
 			"b1",
@@ -163,6 +182,9 @@ func (d *DeclareDeclType) UnescapedValue() string {
 	if d.IsExtVar {
 		return d.Value
 	}
+	if d.IsFishDecl {
+		return d.FishEnvValue
+	}
 	ectx := simpleexpand.SimpleExpandContext{}
 	rtn, _ := simpleexpand.SimpleExpandPartialWord(ectx, d.Value, false)
 	return rtn
@@ -200,6 +222,21 @@ func parseDeclLine(envLineBytes []byte) *DeclareDeclType {
 			ZshBoundScalar: parts[4],
 			ZshEnvValue:    parts[5],
 		}
+	} else if esFirstVal == "f1" {
+		parts, err := utilfn.DecodeStringArray(envLineBytes)
+		if err != nil {
+			return nil
+		}
+		if len(parts) != 5 {
+			return nil
+		}
+		return &DeclareDeclType{
+			IsFishDecl:   true,
+			Args:         parts[1],
+			Name:         parts[2],
+			Value:        parts[3],
+			FishEnvValue: parts[4],
+		}
 	} else if esFirstVal == "b1" {
 		parts, err := utilfn.DecodeStringArray(envLineBytes)
 		if err != nil {
@@ -322,6 +359,10 @@ func EnvMapFromState(state *packet.ShellState) map[string]string {
 	for _, varLine := range vars {
 		decl := parseDeclLine(varLine)
 		if decl != nil && decl.IsExport() {
+			if decl.IsFishDecl {
+				rtn[decl.Name] = decl.FishEnvValue
+				continue
+			}
 			rtn[decl.Name], _ = simpleexpand.SimpleExpandPartialWord(ectx, decl.Value, false)
 		}
 	}
@@ -338,6 +379,10 @@ func ShellVarMapFromState(state *packet.ShellState) map[string]string {
 	for _, varLine := range vars {
 		decl := parseDeclLine(varLine)
 		if decl != nil {
+			if decl.IsFishDecl {
+				rtn[decl.Name] = decl.FishEnvValue
+				continue
+			}
 			rtn[decl.Name], _ = simpleexpand.SimpleExpandPartialWord(ectx, decl.Value, false)
 		}
 	}
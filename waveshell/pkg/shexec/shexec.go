@@ -75,11 +75,12 @@ func MakeClientCommandStr() string {
 	return strings.ReplaceAll(ClientCommandFmt, "[%VERSION%]", semver.MajorMinor(base.WaveshellVersion))
 }
 
+// POSIX sh (exec remotes run it with sh -c, ssh remotes with the login shell)
 const InstallCommandFmt = `
 printf "\n##N{\"type\": \"init\", \"notfound\": true, \"uname\": \"%s|%s\"}\n" "$(uname -s)" "$(uname -m)";
 mkdir -p ~/.mshell/;
 cat > ~/.mshell/mshell.temp;
-if [[ -s ~/.mshell/mshell.temp ]]
+if [ -s ~/.mshell/mshell.temp ]
 then
   mv ~/.mshell/mshell.temp ~/.mshell/mshell-[%VERSION%];
   chmod a+x ~/.mshell/mshell-[%VERSION%];
@@ -908,9 +909,14 @@ func RunCommandSimple(pk *packet.RunPacketType, sender *packet.PacketSender, fro
 		})
 	}
 	fullCmdStr := pk.Command
+	isFish := sapi.GetShellType() == packet.ShellType_fish
 	if pk.ReturnState {
 		// this ensures that the last command is a shell buitin so we always get our exit trap to run
-		fullCmdStr = fullCmdStr + "\nexit $? 2> /dev/null"
+		if isFish {
+			fullCmdStr = fullCmdStr + "\nexit $status 2> /dev/null"
+		} else {
+			fullCmdStr = fullCmdStr + "\nexit $? 2> /dev/null"
+		}
 	}
 
 	var sudoKey uuid.UUID
@@ -918,7 +924,12 @@ func RunCommandSimple(pk *packet.RunPacketType, sender *packet.PacketSender, fro
 	if pk.IsSudo {
 		sudoKey = uuid.New()
 		sudoErrKey = uuid.New()
-		fullCmdStr = fmt.Sprintf("sudo -p \"%s\" -S true 2>&7 <&6; if [ $? != 0 ]; then echo %s >&7 && exit; fi; exec 6>&-; exec 7>&-; %s", sudoKey, sudoErrKey, fullCmdStr)
+		if isFish {
+			// fish cannot close fds with exec, so the command runs in a block with 6 and 7 closed
+			fullCmdStr = fmt.Sprintf("if not sudo -p \"%s\" -S true 2>&7 <&6; echo %s >&7; exit; end; begin\n%s\nend 6<&- 7>&-", sudoKey, sudoErrKey, fullCmdStr)
+		} else {
+			fullCmdStr = fmt.Sprintf("sudo -p \"%s\" -S true 2>&7 <&6; if [ $? != 0 ]; then echo %s >&7 && exit; fi; exec 6>&-; exec 7>&-; %s", sudoKey, sudoErrKey, fullCmdStr)
+		}
 	}
 
 	cmd.Cmd = sapi.MakeShExecCommand(fullCmdStr, rcFileName, pk.UsePty)
@@ -0,0 +1,348 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shexec
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abhishek944/waveterm/waveshell/pkg/packet"
+)
+
+// a waveshell server started with --listen speaks the normal packet protocol over the socket, after a
+// short line based handshake:
+//
+//	server: WAVESHELL-AUTH <nonce|none>
+//	client: <client-nonce> hex(hmac-sha256(key, client proof))   (empty line for "none")
+//	server: OK hex(hmac-sha256(key, server proof)) | ERR <message>
+//
+// the key is never sent over the socket, and both sides prove they know it.  "none" is only allowed for
+// unix sockets (tcp requires a key, and a client with a key refuses a server that does not ask for it).
+// tcp connections are tls (the server has an ephemeral self-signed certificate), the proofs include the
+// tls exporter value of the session so a relayed handshake (a man in the middle terminating tls) fails
+
+const SockAuthGreeting = "WAVESHELL-AUTH"
+const SockAuthNone = "none"
+const SockAuthTimeout = 10 * time.Second
+const SockNonceLen = 32
+const MaxSockAuthLineLen = 300
+const SockTLSExporterLabel = "EXPORTER-waveshell-auth"
+const SockTLSCertLifetime = 365 * 24 * time.Hour
+
+const (
+	SockNetwork_Unix = "unix"
+	SockNetwork_Tcp  = "tcp"
+)
+
+// addr is "unix:/path/to/socket" or "tcp:host:port"
+func ParseSockAddr(addr string) (string, string, error) {
+	network, address, found := strings.Cut(addr, ":")
+	if !found || address == "" {
+		return "", "", fmt.Errorf("invalid address %q (must be unix:/path or tcp:host:port)", addr)
+	}
+	switch network {
+	case SockNetwork_Unix:
+		return network, address, nil
+	case SockNetwork_Tcp:
+		_, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid tcp address %q: %v", address, err)
+		}
+		return network, address, nil
+	}
+	return "", "", fmt.Errorf("invalid address %q (must be unix:/path or tcp:host:port)", addr)
+}
+
+// tls config for a tcp listener, with a new self-signed certificate.  clients do not verify the certificate,
+// the server is authenticated by its key proof (which is bound to the tls session)
+func MakeSockServerTLSConfig() (*tls.Config, error) {
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate tls key: %v", err)
+	}
+	serialNum, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate tls certificate serial: %v", err)
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serialNum,
+		Subject:      pkix.Name{CommonName: "waveshell"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(SockTLSCertLifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	certDer, err := x509.CreateCertificate(rand.Reader, template, template, &privKey.PublicKey, privKey)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create tls certificate: %v", err)
+	}
+	cert := tls.Certificate{Certificate: [][]byte{certDer}, PrivateKey: privKey}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS13}, nil
+}
+
+func makeSockClientTLSConfig() *tls.Config {
+	// the certificate is ephemeral, the server proves itself with the key (see the handshake comment above)
+	return &tls.Config{InsecureSkipVerify: true, MinVersion: tls.VersionTLS13}
+}
+
+// completes the tls handshake (for tls conns) and returns the exporter value the key proofs are bound to ("" for plain conns)
+func getSockChannelBinding(conn net.Conn) (string, error) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return "", nil
+	}
+	err := tlsConn.Handshake()
+	if err != nil {
+		return "", fmt.Errorf("tls handshake: %v", err)
+	}
+	state := tlsConn.ConnectionState()
+	ekm, err := state.ExportKeyingMaterial(SockTLSExporterLabel, nil, 32)
+	if err != nil {
+		return "", fmt.Errorf("cannot get tls exporter value: %v", err)
+	}
+	return hex.EncodeToString(ekm), nil
+}
+
+func sockAuthMac(key []byte, side string, nonce string, clientNonce string, binding string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("waveshell-auth-" + side + ":" + nonce + ":" + clientNonce + ":" + binding))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func makeSockNonce() (string, error) {
+	nonceBytes := make([]byte, SockNonceLen)
+	_, err := rand.Read(nonceBytes)
+	if err != nil {
+		return "", fmt.Errorf("cannot generate nonce: %v", err)
+	}
+	return hex.EncodeToString(nonceBytes), nil
+}
+
+func readSockAuthLine(reader *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		frag, isPrefix, err := reader.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		line = append(line, frag...)
+		if len(line) > MaxSockAuthLineLen {
+			return "", fmt.Errorf("handshake line too long")
+		}
+		if !isPrefix {
+			return string(line), nil
+		}
+	}
+}
+
+// server side of the handshake, returns the reader to use for packets (it may have buffered data)
+func SockAuthServer(conn net.Conn, key []byte) (*bufio.Reader, error) {
+	conn.SetDeadline(time.Now().Add(SockAuthTimeout))
+	defer conn.SetDeadline(time.Time{})
+	binding, err := getSockChannelBinding(conn)
+	if err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	nonce := SockAuthNone
+	if len(key) > 0 {
+		nonce, err = makeSockNonce()
+		if err != nil {
+			return nil, err
+		}
+	}
+	_, err = fmt.Fprintf(conn, "%s %s\n", SockAuthGreeting, nonce)
+	if err != nil {
+		return nil, err
+	}
+	response, err := readSockAuthLine(reader)
+	if err != nil {
+		return nil, fmt.Errorf("reading auth response: %v", err)
+	}
+	if len(key) == 0 {
+		_, err = fmt.Fprintf(conn, "OK\n")
+		if err != nil {
+			return nil, err
+		}
+		return reader, nil
+	}
+	clientNonce, clientMac, _ := strings.Cut(strings.TrimSpace(response), " ")
+	if len(clientNonce) != 2*SockNonceLen || !hmac.Equal([]byte(clientMac), []byte(sockAuthMac(key, "client", nonce, clientNonce, binding))) {
+		fmt.Fprintf(conn, "ERR invalid key\n")
+		return nil, fmt.Errorf("invalid key from %s", conn.RemoteAddr())
+	}
+	_, err = fmt.Fprintf(conn, "OK %s\n", sockAuthMac(key, "server", nonce, clientNonce, binding))
+	if err != nil {
+		return nil, err
+	}
+	return reader, nil
+}
+
+// client side of the handshake, returns the reader to use for packets (it may have buffered data)
+func SockAuthClient(conn net.Conn, key []byte) (*bufio.Reader, error) {
+	conn.SetDeadline(time.Now().Add(SockAuthTimeout))
+	defer conn.SetDeadline(time.Time{})
+	binding, err := getSockChannelBinding(conn)
+	if err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	greeting, err := readSockAuthLine(reader)
+	if err != nil {
+		return nil, fmt.Errorf("reading waveshell greeting: %v", err)
+	}
+	fields := strings.Fields(greeting)
+	if len(fields) != 2 || fields[0] != SockAuthGreeting {
+		return nil, fmt.Errorf("invalid greeting, not a waveshell server")
+	}
+	nonce := fields[1]
+	if nonce == SockAuthNone && len(key) > 0 {
+		// a server that knows the key always asks for it
+		return nil, fmt.Errorf("waveshell server did not ask for a key, refusing to connect")
+	}
+	if nonce != SockAuthNone && len(key) == 0 {
+		return nil, fmt.Errorf("waveshell server requires a key")
+	}
+	response := ""
+	var clientNonce string
+	if len(key) > 0 {
+		clientNonce, err = makeSockNonce()
+		if err != nil {
+			return nil, err
+		}
+		response = clientNonce + " " + sockAuthMac(key, "client", nonce, clientNonce, binding)
+	}
+	_, err = fmt.Fprintf(conn, "%s\n", response)
+	if err != nil {
+		return nil, err
+	}
+	result, err := readSockAuthLine(reader)
+	if err != nil {
+		return nil, fmt.Errorf("reading auth result: %v", err)
+	}
+	status, serverMac, _ := strings.Cut(result, " ")
+	if status != "OK" {
+		return nil, fmt.Errorf("waveshell server refused the connection: %s", strings.TrimSpace(strings.TrimPrefix(result, "ERR")))
+	}
+	if len(key) > 0 && !hmac.Equal([]byte(strings.TrimSpace(serverMac)), []byte(sockAuthMac(key, "server", nonce, clientNonce, binding))) {
+		return nil, fmt.Errorf("waveshell server did not prove it has the key")
+	}
+	return reader, nil
+}
+
+// dials addr (see ParseSockAddr, tcp connections use tls) and runs the client handshake
+func DialSock(ctx context.Context, addr string, key []byte) (*SockWrap, error) {
+	network, address, err := ParseSockAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	if network == SockNetwork_Tcp {
+		conn = tls.Client(conn, makeSockClientTLSConfig())
+	}
+	reader, err := SockAuthClient(conn, key)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return MakeSockWrap(conn, reader), nil
+}
+
+// ConnInterface over an (authenticated) socket.  Start is a no-op (the server is already running),
+// Wait returns when the socket is closed, Kill closes it
+type SockWrap struct {
+	Conn     net.Conn
+	Reader   io.Reader
+	DoneCh   chan struct{}
+	DoneOnce *sync.Once
+	Err      error
+}
+
+func MakeSockWrap(conn net.Conn, reader io.Reader) *SockWrap {
+	return &SockWrap{Conn: conn, Reader: reader, DoneCh: make(chan struct{}), DoneOnce: &sync.Once{}}
+}
+
+func (sw *SockWrap) setDone(err error) {
+	sw.DoneOnce.Do(func() {
+		if err != io.EOF {
+			sw.Err = err
+		}
+		close(sw.DoneCh)
+	})
+}
+
+func (sw *SockWrap) Kill() {
+	sw.Conn.Close()
+	sw.setDone(nil)
+}
+
+func (sw *SockWrap) Wait() error {
+	<-sw.DoneCh
+	return sw.Err
+}
+
+func (sw *SockWrap) Start() error {
+	return nil
+}
+
+func (sw *SockWrap) Read(buf []byte) (int, error) {
+	n, err := sw.Reader.Read(buf)
+	if err != nil {
+		sw.setDone(err)
+	}
+	return n, err
+}
+
+func (sw *SockWrap) Write(buf []byte) (int, error) {
+	return sw.Conn.Write(buf)
+}
+
+func (sw *SockWrap) Close() error {
+	sw.Kill()
+	return nil
+}
+
+func (sw *SockWrap) Sender() (*packet.PacketSender, io.WriteCloser, error) {
+	sender := packet.MakePacketSender(sw, nil)
+	return sender, sw, nil
+}
+
+// there is no stderr over a socket, errors come back as packets
+func (sw *SockWrap) Parser() (*packet.PacketParser, io.ReadCloser, io.ReadCloser, error) {
+	packetParser := packet.MakePacketParser(sw, &packet.PacketParserOpts{IgnoreUntilValid: true})
+	return packetParser, sw, nil, nil
+}
+
+func (sw *SockWrap) StdinPipe() (io.WriteCloser, error) {
+	return sw, nil
+}
+
+func (sw *SockWrap) StdoutPipe() (io.ReadCloser, error) {
+	return sw, nil
+}
+
+func (sw *SockWrap) StderrPipe() (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader("")), nil
+}
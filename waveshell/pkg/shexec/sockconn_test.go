@@ -0,0 +1,201 @@
+package shexec
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abhishek944/waveterm/waveshell/pkg/packet"
+)
+
+func TestParseSockAddr(t *testing.T) {
+	network, address, err := ParseSockAddr("unix:/tmp/ws.sock")
+	if err != nil || network != SockNetwork_Unix || address != "/tmp/ws.sock" {
+		t.Errorf("bad parse: %q %q %v", network, address, err)
+	}
+	network, address, err = ParseSockAddr("tcp:127.0.0.1:7777")
+	if err != nil || network != SockNetwork_Tcp || address != "127.0.0.1:7777" {
+		t.Errorf("bad parse: %q %q %v", network, address, err)
+	}
+	for _, addr := range []string{"tcp:localhost:7777", "tcp:[::1]:7777", "tcp:10.0.0.5:7777", "tcp:0.0.0.0:7777", "tcp::7777", "tcp:ci-runner.internal:7777"} {
+		if _, _, err := ParseSockAddr(addr); err != nil {
+			t.Errorf("address %q: %v", addr, err)
+		}
+	}
+	for _, addr := range []string{"", "unix:", "tcp:nohost", "udp:1.2.3.4:5", "/tmp/ws.sock"} {
+		if _, _, err := ParseSockAddr(addr); err == nil {
+			t.Errorf("expected error for %q", addr)
+		}
+	}
+}
+
+// the two ends of a loopback tcp conn (net.Pipe is unbuffered, so a tls close_notify would block), wrapped in tls when useTLS is set
+func makeTestSockConns(t *testing.T, useTLS bool) (net.Conn, net.Conn) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %v", err)
+	}
+	defer listener.Close()
+	clientConn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("cannot dial: %v", err)
+	}
+	serverConn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("cannot accept: %v", err)
+	}
+	if !useTLS {
+		return serverConn, clientConn
+	}
+	tlsConfig, err := MakeSockServerTLSConfig()
+	if err != nil {
+		t.Fatalf("cannot make tls config: %v", err)
+	}
+	return tls.Server(serverConn, tlsConfig), tls.Client(clientConn, makeSockClientTLSConfig())
+}
+
+func runSockAuth(t *testing.T, useTLS bool, serverKey string, clientKey string) (error, error) {
+	serverConn, clientConn := makeTestSockConns(t, useTLS)
+	defer serverConn.Close()
+	defer clientConn.Close()
+	serverErrCh := make(chan error, 1)
+	go func() {
+		_, err := SockAuthServer(serverConn, []byte(serverKey))
+		if err != nil {
+			serverConn.Close()
+		}
+		serverErrCh <- err
+	}()
+	_, clientErr := SockAuthClient(clientConn, []byte(clientKey))
+	clientConn.Close()
+	return <-serverErrCh, clientErr
+}
+
+func TestSockAuth(t *testing.T) {
+	for _, useTLS := range []bool{false, true} {
+		serverErr, clientErr := runSockAuth(t, useTLS, "secret", "secret")
+		if serverErr != nil || clientErr != nil {
+			t.Errorf("auth should succeed (tls:%v): %v %v", useTLS, serverErr, clientErr)
+		}
+		serverErr, clientErr = runSockAuth(t, useTLS, "secret", "wrong")
+		if serverErr == nil || clientErr == nil || !strings.Contains(clientErr.Error(), "invalid key") {
+			t.Errorf("auth should fail with a wrong key (tls:%v): %v %v", useTLS, serverErr, clientErr)
+		}
+		serverErr, clientErr = runSockAuth(t, useTLS, "secret", "")
+		if clientErr == nil || !strings.Contains(clientErr.Error(), "requires a key") {
+			t.Errorf("auth should fail without a key (tls:%v): %v %v", useTLS, serverErr, clientErr)
+		}
+		// a fake server that does not know the key can't get the client to skip auth
+		serverErr, clientErr = runSockAuth(t, useTLS, "", "secret")
+		if clientErr == nil || !strings.Contains(clientErr.Error(), "did not ask for a key") {
+			t.Errorf("client with a key should refuse a server without one (tls:%v): %v %v", useTLS, serverErr, clientErr)
+		}
+		serverErr, clientErr = runSockAuth(t, useTLS, "", "")
+		if serverErr != nil || clientErr != nil {
+			t.Errorf("auth without a key should succeed (tls:%v): %v %v", useTLS, serverErr, clientErr)
+		}
+	}
+}
+
+// a man in the middle terminates tls on both sides and relays the plaintext handshake, the proofs
+// are bound to the tls session so the server refuses the relayed client proof
+func TestSockAuthRelayRefused(t *testing.T) {
+	serverConn, mitmClientSide := makeTestSockConns(t, true)
+	mitmServerSide, clientConn := makeTestSockConns(t, true)
+	defer serverConn.Close()
+	defer clientConn.Close()
+	go func() {
+		defer mitmClientSide.Close()
+		defer mitmServerSide.Close()
+		go io.Copy(mitmClientSide, mitmServerSide)
+		io.Copy(mitmServerSide, mitmClientSide)
+	}()
+	serverErrCh := make(chan error, 1)
+	go func() {
+		_, err := SockAuthServer(serverConn, []byte("secret"))
+		serverConn.Close()
+		serverErrCh <- err
+	}()
+	_, clientErr := SockAuthClient(clientConn, []byte("secret"))
+	serverErr := <-serverErrCh
+	if serverErr == nil || clientErr == nil {
+		t.Errorf("relayed handshake should fail: %v %v", serverErr, clientErr)
+	}
+}
+
+// packets flow both ways over the socket after the handshake (tls for tcp), and Wait returns when the server goes away
+func TestSockWrap(t *testing.T) {
+	sockPath := filepath.Join(t.TempDir(), "ws.sock")
+	t.Run("unix", func(t *testing.T) { testSockWrap(t, "unix", sockPath) })
+	t.Run("tcp", func(t *testing.T) { testSockWrap(t, "tcp", "127.0.0.1:0") })
+}
+
+func testSockWrap(t *testing.T, network string, address string) {
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatalf("cannot listen: %v", err)
+	}
+	defer listener.Close()
+	var tlsConfig *tls.Config
+	if network == SockNetwork_Tcp {
+		tlsConfig, err = MakeSockServerTLSConfig()
+		if err != nil {
+			t.Fatalf("cannot make tls config: %v", err)
+		}
+	}
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		if tlsConfig != nil {
+			conn = tls.Server(conn, tlsConfig)
+		}
+		defer conn.Close()
+		reader, err := SockAuthServer(conn, []byte("k"))
+		if err != nil {
+			return
+		}
+		sender := packet.MakePacketSender(conn, nil)
+		sender.SendPacket(packet.MakeInitPacket())
+		parser := packet.MakePacketParser(reader, nil)
+		pk := <-parser.MainCh
+		if pk != nil && pk.GetType() == packet.MessagePacketStr {
+			sender.SendPacket(packet.MakeMessagePacket("pong"))
+		}
+		sender.Close()
+		sender.WaitForDone()
+	}()
+	ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFn()
+	sockWrap, err := DialSock(ctx, network+":"+listener.Addr().String(), []byte("k"))
+	if err != nil {
+		t.Fatalf("cannot dial: %v", err)
+	}
+	sender, _, _ := sockWrap.Sender()
+	parser, _, _, _ := sockWrap.Parser()
+	sockWrap.Start()
+	if pk := <-parser.MainCh; pk == nil || pk.GetType() != packet.InitPacketStr {
+		t.Fatalf("expected init packet, got %v", pk)
+	}
+	sender.SendPacket(packet.MakeMessagePacket("ping"))
+	if pk := <-parser.MainCh; pk == nil || pk.GetType() != packet.MessagePacketStr {
+		t.Fatalf("expected message packet, got %v", pk)
+	}
+	waitCh := make(chan error, 1)
+	go func() { waitCh <- sockWrap.Wait() }()
+	select {
+	case err := <-waitCh:
+		if err != nil {
+			t.Errorf("unexpected wait error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Wait did not return after the server closed the socket")
+	}
+	sender.Close()
+}
@@ -38,6 +38,7 @@ import (
 	"github.com/abhishek944/waveterm/wavesrv/pkg/cmdrunner"
 	"github.com/abhishek944/waveterm/wavesrv/pkg/configstore"
 	"github.com/abhishek944/waveterm/wavesrv/pkg/ephemeral"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/history"
 	"github.com/abhishek944/waveterm/wavesrv/pkg/pcloud"
 	"github.com/abhishek944/waveterm/wavesrv/pkg/releasechecker"
 	"github.com/abhishek944/waveterm/wavesrv/pkg/remote"
@@ -1127,6 +1128,10 @@ func main() {
 		log.Printf("[error] migrate up: %v\n", err)
 		return
 	}
+	err = history.InitSearchIndex(context.Background())
+	if err != nil {
+		log.Printf("[error] initializing history search index: %v\n", err)
+	}
 	// err = blockstore.MigrateBlockstore()
 	// if err != nil {
 	// 	log.Printf("[error] migrate blockstore: %v\n", err)
@@ -1163,6 +1168,8 @@ func main() {
 	installSignalHandlers()
 	// go telemetryLoop()
 	go configWatcher()
+	cmdrunner.StartSshConfigWatcher()
+	history.StartRetentionJanitor()
 	go stdinReadWatch()
 	go runWebSocketServer()
 	go func() {
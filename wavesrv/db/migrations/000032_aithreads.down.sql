@@ -0,0 +1,2 @@
+DROP TABLE ai_message;
+DROP TABLE ai_thread;
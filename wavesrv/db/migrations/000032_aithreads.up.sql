@@ -0,0 +1,23 @@
+CREATE TABLE ai_thread (
+    threadid varchar(36) PRIMARY KEY,
+    screenid varchar(36) NOT NULL,
+    lineid varchar(36) NOT NULL,
+    title varchar(200) NOT NULL,
+    createdts bigint NOT NULL,
+    updatedts bigint NOT NULL
+);
+CREATE INDEX idx_ai_thread_screenid ON ai_thread (screenid);
+
+CREATE TABLE ai_message (
+    threadid varchar(36) NOT NULL,
+    msgidx int NOT NULL,
+    lineid varchar(36) NOT NULL,
+    role varchar(20) NOT NULL,
+    content text NOT NULL,
+    model varchar(100) NOT NULL,
+    provider varchar(50) NOT NULL,
+    prompttokens int NOT NULL,
+    completiontokens int NOT NULL,
+    ts bigint NOT NULL,
+    PRIMARY KEY (threadid, msgidx)
+);
@@ -0,0 +1,10 @@
+CREATE TABLE ai_usage (
+    day varchar(10) NOT NULL,
+    provider varchar(50) NOT NULL,
+    model varchar(100) NOT NULL,
+    screenid varchar(36) NOT NULL,
+    numrequests int NOT NULL,
+    prompttokens int NOT NULL,
+    completiontokens int NOT NULL,
+    PRIMARY KEY (day, provider, model, screenid)
+);
@@ -0,0 +1 @@
+DROP TABLE remote_group;
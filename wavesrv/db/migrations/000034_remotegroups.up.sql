@@ -0,0 +1,5 @@
+CREATE TABLE remote_group (
+    groupname varchar(50) PRIMARY KEY,
+    remoteids json NOT NULL,
+    createdts bigint NOT NULL
+);
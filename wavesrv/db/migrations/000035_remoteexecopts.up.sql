@@ -0,0 +1 @@
+ALTER TABLE remote ADD COLUMN execopts json NOT NULL DEFAULT '{}';
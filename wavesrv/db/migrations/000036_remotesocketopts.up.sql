@@ -0,0 +1 @@
+ALTER TABLE remote ADD COLUMN socketopts json NOT NULL DEFAULT '{}';
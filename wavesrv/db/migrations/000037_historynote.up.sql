@@ -0,0 +1 @@
+ALTER TABLE history ADD COLUMN note text NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+DROP TABLE ai_audit;
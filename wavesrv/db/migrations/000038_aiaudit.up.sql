@@ -0,0 +1,13 @@
+CREATE TABLE ai_audit (
+    screenid varchar(36) NOT NULL,
+    lineid varchar(36) NOT NULL,
+    callidx int NOT NULL,
+    step int NOT NULL,
+    tool varchar(50) NOT NULL,
+    args text NOT NULL,
+    status varchar(20) NOT NULL,
+    error text NOT NULL,
+    bytes int NOT NULL,
+    ts bigint NOT NULL,
+    PRIMARY KEY (screenid, lineid, callidx)
+);
@@ -56,7 +56,7 @@ CREATE TABLE remote (
     local boolean NOT NULL,
     archived boolean NOT NULL,
     remoteidx int NOT NULL
-, statevars json NOT NULL DEFAULT '{}', openaiopts json NOT NULL DEFAULT '{}', sshconfigsrc varchar(36) NOT NULL DEFAULT 'waveterm-manual', shellpref varchar(20) NOT NULL DEFAULT 'detect');
+, statevars json NOT NULL DEFAULT '{}', openaiopts json NOT NULL DEFAULT '{}', sshconfigsrc varchar(36) NOT NULL DEFAULT 'waveterm-manual', shellpref varchar(20) NOT NULL DEFAULT 'detect', execopts json NOT NULL DEFAULT '{}', socketopts json NOT NULL DEFAULT '{}');
 CREATE TABLE history (
     historyid varchar(36) PRIMARY KEY,
     ts bigint NOT NULL,
@@ -70,7 +70,7 @@ CREATE TABLE history (
     haderror boolean NOT NULL,
     cmdstr text NOT NULL,
     ismetacmd boolean,
-    linenum int NOT NULL DEFAULT 0, exitcode int NULL DEFAULT NULL, durationms int NULL DEFAULT NULL, festate json NOT NULL DEFAULT '{}', tags json NOT NULL DEFAULT '{}', status varchar(10) NOT NULL DEFAULT 'unknown');
+    linenum int NOT NULL DEFAULT 0, exitcode int NULL DEFAULT NULL, durationms int NULL DEFAULT NULL, festate json NOT NULL DEFAULT '{}', tags json NOT NULL DEFAULT '{}', status varchar(10) NOT NULL DEFAULT 'unknown', note text NOT NULL DEFAULT '');
 CREATE TABLE activity (
     day varchar(20) PRIMARY KEY,
     uploaded boolean NOT NULL,
@@ -222,3 +222,53 @@ CREATE TABLE screen_tombstone (
     screenopts json NOT NULL,
     name varchar(50) NOT NULL
 );
+CREATE TABLE ai_thread (
+    threadid varchar(36) PRIMARY KEY,
+    screenid varchar(36) NOT NULL,
+    lineid varchar(36) NOT NULL,
+    title varchar(200) NOT NULL,
+    createdts bigint NOT NULL,
+    updatedts bigint NOT NULL
+);
+CREATE INDEX idx_ai_thread_screenid ON ai_thread (screenid);
+CREATE TABLE ai_message (
+    threadid varchar(36) NOT NULL,
+    msgidx int NOT NULL,
+    lineid varchar(36) NOT NULL,
+    role varchar(20) NOT NULL,
+    content text NOT NULL,
+    model varchar(100) NOT NULL,
+    provider varchar(50) NOT NULL,
+    prompttokens int NOT NULL,
+    completiontokens int NOT NULL,
+    ts bigint NOT NULL,
+    PRIMARY KEY (threadid, msgidx)
+);
+CREATE TABLE ai_usage (
+    day varchar(10) NOT NULL,
+    provider varchar(50) NOT NULL,
+    model varchar(100) NOT NULL,
+    screenid varchar(36) NOT NULL,
+    numrequests int NOT NULL,
+    prompttokens int NOT NULL,
+    completiontokens int NOT NULL,
+    PRIMARY KEY (day, provider, model, screenid)
+);
+CREATE TABLE remote_group (
+    groupname varchar(50) PRIMARY KEY,
+    remoteids json NOT NULL,
+    createdts bigint NOT NULL
+);
+CREATE TABLE ai_audit (
+    screenid varchar(36) NOT NULL,
+    lineid varchar(36) NOT NULL,
+    callidx int NOT NULL,
+    step int NOT NULL,
+    tool varchar(50) NOT NULL,
+    args text NOT NULL,
+    status varchar(20) NOT NULL,
+    error text NOT NULL,
+    bytes int NOT NULL,
+    ts bigint NOT NULL,
+    PRIMARY KEY (screenid, lineid, callidx)
+);
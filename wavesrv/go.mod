@@ -26,6 +26,7 @@ require (
 	golang.org/x/mod v0.17.0
 	golang.org/x/sys v0.29.0
 	google.golang.org/api v0.152.0
+	gopkg.in/yaml.v3 v3.0.1
 	mvdan.cc/sh/v3 v3.7.0
 )
 
@@ -126,6 +126,13 @@ func GetBookmarkIdsByCmdStr(ctx context.Context, cmdStr string) ([]string, error
 	})
 }
 
+func BookmarkAliasExists(ctx context.Context, alias string) (bool, error) {
+	return sstore.WithTxRtn(ctx, func(tx *sstore.TxWrap) (bool, error) {
+		query := `SELECT bookmarkid FROM bookmark WHERE alias = ?`
+		return tx.Exists(query, alias), nil
+	})
+}
+
 // ignores OrderIdx field
 func InsertBookmark(ctx context.Context, bm *BookmarkType) error {
 	if bm == nil || bm.BookmarkId == "" {
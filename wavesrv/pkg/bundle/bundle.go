@@ -0,0 +1,364 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// Portable (YAML/JSON) bundles of playbooks and bookmarks, used to share runbooks outside of the local DB.
+package bundle
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/abhishek944/waveterm/waveshell/pkg/shellapi"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/bookmarks"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/playbook"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+)
+
+const BundleVersion = 1
+const MaxBundleFileSize = 10 * 1024 * 1024
+const maxRenameTries = 100
+
+const (
+	FormatJson = "json"
+	FormatYaml = "yaml"
+)
+
+const (
+	MergeSkip      = "skip"
+	MergeOverwrite = "overwrite"
+	MergeRename    = "rename"
+)
+
+var MergeStrategies = []string{MergeSkip, MergeOverwrite, MergeRename}
+
+type BundleType struct {
+	Version    int               `json:"version" yaml:"version"`
+	ExportedTs int64             `json:"exportedts,omitempty" yaml:"exportedts,omitempty"`
+	Playbooks  []*PlaybookBundle `json:"playbooks,omitempty" yaml:"playbooks,omitempty"`
+	Bookmarks  []*BookmarkBundle `json:"bookmarks,omitempty" yaml:"bookmarks,omitempty"`
+}
+
+type PlaybookBundle struct {
+	Name        string                 `json:"name" yaml:"name"`
+	Description string                 `json:"description,omitempty" yaml:"description,omitempty"`
+	Entries     []*PlaybookEntryBundle `json:"entries" yaml:"entries"`
+}
+
+type PlaybookEntryBundle struct {
+	Alias       string `json:"alias,omitempty" yaml:"alias,omitempty"`
+	CmdStr      string `json:"cmdstr" yaml:"cmdstr"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+}
+
+type BookmarkBundle struct {
+	CmdStr      string   `json:"cmdstr" yaml:"cmdstr"`
+	Alias       string   `json:"alias,omitempty" yaml:"alias,omitempty"`
+	Description string   `json:"description,omitempty" yaml:"description,omitempty"`
+	Tags        []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+}
+
+type ImportResult struct {
+	Added       int
+	Overwritten int // existing items that were replaced
+	Renamed     int
+	Skipped     int
+}
+
+func (r ImportResult) String() string {
+	return fmt.Sprintf("%d added, %d overwritten, %d renamed, %d skipped", r.Added, r.Overwritten, r.Renamed, r.Skipped)
+}
+
+func ValidateMergeStrategy(strategy string) error {
+	for _, s := range MergeStrategies {
+		if s == strategy {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid merge strategy %q (must be %s)", strategy, strings.Join(MergeStrategies, ", "))
+}
+
+// resolves the bundle format from an explicit format arg, or the file extension (defaults to yaml)
+func ResolveFormat(fileName string, formatArg string) (string, error) {
+	if formatArg != "" {
+		formatArg = strings.ToLower(formatArg)
+		if formatArg == "yml" {
+			formatArg = FormatYaml
+		}
+		if formatArg != FormatJson && formatArg != FormatYaml {
+			return "", fmt.Errorf("invalid format %q (must be %s or %s)", formatArg, FormatJson, FormatYaml)
+		}
+		return formatArg, nil
+	}
+	if strings.ToLower(filepath.Ext(fileName)) == ".json" {
+		return FormatJson, nil
+	}
+	return FormatYaml, nil
+}
+
+func MarshalBundle(b *BundleType, format string) ([]byte, error) {
+	if format == FormatJson {
+		return json.MarshalIndent(b, "", "  ")
+	}
+	return yaml.Marshal(b)
+}
+
+func UnmarshalBundle(data []byte, format string) (*BundleType, error) {
+	var rtn BundleType
+	var err error
+	if format == FormatJson {
+		err = json.Unmarshal(data, &rtn)
+	} else {
+		err = yaml.Unmarshal(data, &rtn)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %s bundle: %v", format, err)
+	}
+	if rtn.Version == 0 {
+		return nil, fmt.Errorf("invalid bundle, no version")
+	}
+	if rtn.Version > BundleVersion {
+		return nil, fmt.Errorf("bundle version %d is not supported (max supported version is %d)", rtn.Version, BundleVersion)
+	}
+	return &rtn, nil
+}
+
+func WriteBundleFile(fileName string, format string, b *BundleType) error {
+	b.Version = BundleVersion
+	b.ExportedTs = time.Now().UnixMilli()
+	data, err := MarshalBundle(b, format)
+	if err != nil {
+		return fmt.Errorf("cannot encode bundle: %v", err)
+	}
+	err = os.WriteFile(fileName, data, 0644)
+	if err != nil {
+		return fmt.Errorf("cannot write bundle file: %v", err)
+	}
+	return nil
+}
+
+func ReadBundleFile(fileName string, format string) (*BundleType, error) {
+	finfo, err := os.Stat(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot stat bundle file: %v", err)
+	}
+	if finfo.Size() > MaxBundleFileSize {
+		return nil, fmt.Errorf("bundle file too large (%d bytes, max %d)", finfo.Size(), MaxBundleFileSize)
+	}
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read bundle file: %v", err)
+	}
+	return UnmarshalBundle(data, format)
+}
+
+func MakePlaybookBundle(pb *playbook.PlaybookType) *PlaybookBundle {
+	rtn := &PlaybookBundle{Name: pb.PlaybookName, Description: pb.Description}
+	for _, entry := range pb.Entries {
+		rtn.Entries = append(rtn.Entries, &PlaybookEntryBundle{Alias: entry.Alias, CmdStr: entry.CmdStr, Description: entry.Description})
+	}
+	return rtn
+}
+
+func MakeBookmarkBundle(bm *bookmarks.BookmarkType) *BookmarkBundle {
+	return &BookmarkBundle{CmdStr: bm.CmdStr, Alias: bm.Alias, Description: bm.Description, Tags: bm.Tags}
+}
+
+func validateCmdStr(sapi shellapi.ShellApi, cmdStr string) error {
+	if strings.TrimSpace(cmdStr) == "" {
+		return fmt.Errorf("empty command")
+	}
+	return sapi.ValidateCommandSyntax(cmdStr)
+}
+
+// validates every command in the playbook section of the bundle, returns all errors found
+func ValidatePlaybooks(b *BundleType, sapi shellapi.ShellApi) []error {
+	var rtn []error
+	for _, pb := range b.Playbooks {
+		if strings.TrimSpace(pb.Name) == "" {
+			rtn = append(rtn, fmt.Errorf("playbook with no name"))
+			continue
+		}
+		for idx, entry := range pb.Entries {
+			_, err := playbook.ParseTemplateVars(entry.CmdStr)
+			if err == nil {
+				err = validateCmdStr(sapi, entry.CmdStr)
+			}
+			if err != nil {
+				rtn = append(rtn, fmt.Errorf("playbook %q entry #%d: %v", pb.Name, idx+1, err))
+			}
+		}
+	}
+	return rtn
+}
+
+// validates every command in the bookmark section of the bundle, returns all errors found
+func ValidateBookmarks(b *BundleType, sapi shellapi.ShellApi) []error {
+	var rtn []error
+	for idx, bm := range b.Bookmarks {
+		err := validateCmdStr(sapi, bm.CmdStr)
+		if err != nil {
+			rtn = append(rtn, fmt.Errorf("bookmark #%d: %v", idx+1, err))
+		}
+	}
+	return rtn
+}
+
+func makePlaybookEntries(pbb *PlaybookBundle) []*playbook.PlaybookEntry {
+	nowTs := time.Now().UnixMilli()
+	var rtn []*playbook.PlaybookEntry
+	for _, eb := range pbb.Entries {
+		rtn = append(rtn, &playbook.PlaybookEntry{
+			EntryId:     uuid.New().String(),
+			Alias:       eb.Alias,
+			CmdStr:      eb.CmdStr,
+			Description: eb.Description,
+			CreatedTs:   nowTs,
+			UpdatedTs:   nowTs,
+		})
+	}
+	return rtn
+}
+
+// bundle must already be validated (see ValidatePlaybooks).  the import runs in one transaction, on error nothing is imported
+func ImportPlaybooks(ctx context.Context, b *BundleType, strategy string) (*ImportResult, error) {
+	return sstore.WithTxRtn(ctx, func(tx *sstore.TxWrap) (*ImportResult, error) {
+		txCtx := tx.Context()
+		rtn := &ImportResult{}
+		for _, pbb := range b.Playbooks {
+			existingId, err := playbook.GetPlaybookIdByName(txCtx, pbb.Name)
+			if err != nil {
+				return nil, err
+			}
+			newName := pbb.Name
+			if existingId != "" {
+				switch strategy {
+				case MergeSkip:
+					rtn.Skipped++
+					continue
+				case MergeOverwrite:
+					err = playbook.ReplacePlaybookEntries(txCtx, existingId, pbb.Description, makePlaybookEntries(pbb))
+					if err != nil {
+						return nil, fmt.Errorf("cannot overwrite playbook %q: %v", pbb.Name, err)
+					}
+					rtn.Overwritten++
+					continue
+				case MergeRename:
+					newName, err = findFreePlaybookName(txCtx, pbb.Name)
+					if err != nil {
+						return nil, err
+					}
+					rtn.Renamed++
+				}
+			} else {
+				rtn.Added++
+			}
+			newPb := &playbook.PlaybookType{
+				PlaybookId:   uuid.New().String(),
+				PlaybookName: newName,
+				Description:  pbb.Description,
+				Entries:      makePlaybookEntries(pbb),
+			}
+			err = playbook.InsertPlaybook(txCtx, newPb)
+			if err != nil {
+				return nil, fmt.Errorf("cannot import playbook %q: %v", pbb.Name, err)
+			}
+		}
+		return rtn, nil
+	})
+}
+
+func findFreePlaybookName(ctx context.Context, name string) (string, error) {
+	for i := 2; i < maxRenameTries; i++ {
+		newName := fmt.Sprintf("%s-%d", name, i)
+		existingId, err := playbook.GetPlaybookIdByName(ctx, newName)
+		if err != nil {
+			return "", err
+		}
+		if existingId == "" {
+			return newName, nil
+		}
+	}
+	return "", fmt.Errorf("cannot find a free name for playbook %q", name)
+}
+
+// alias-imported, alias-imported-2, ... (or imported, imported-2, ... for bookmarks without an alias)
+func findFreeBookmarkAlias(ctx context.Context, alias string) (string, error) {
+	baseAlias := "imported"
+	if alias != "" {
+		baseAlias = alias + "-imported"
+	}
+	for i := 1; i < maxRenameTries; i++ {
+		newAlias := baseAlias
+		if i > 1 {
+			newAlias = fmt.Sprintf("%s-%d", baseAlias, i)
+		}
+		exists, err := bookmarks.BookmarkAliasExists(ctx, newAlias)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return newAlias, nil
+		}
+	}
+	return "", fmt.Errorf("cannot find a free alias for bookmark %q", alias)
+}
+
+// bookmarks conflict when they have the same cmdstr, overwrite replaces all of them (each one counts as overwritten).
+// bundle must already be validated (see ValidateBookmarks).  the import runs in one transaction, on error nothing is imported
+func ImportBookmarks(ctx context.Context, b *BundleType, strategy string) (*ImportResult, error) {
+	return sstore.WithTxRtn(ctx, func(tx *sstore.TxWrap) (*ImportResult, error) {
+		txCtx := tx.Context()
+		rtn := &ImportResult{}
+		for _, bmb := range b.Bookmarks {
+			existingIds, err := bookmarks.GetBookmarkIdsByCmdStr(txCtx, bmb.CmdStr)
+			if err != nil {
+				return nil, err
+			}
+			newAlias := bmb.Alias
+			if len(existingIds) > 0 {
+				switch strategy {
+				case MergeSkip:
+					rtn.Skipped++
+					continue
+				case MergeOverwrite:
+					for _, bmId := range existingIds {
+						err = bookmarks.DeleteBookmark(txCtx, bmId)
+						if err != nil {
+							return nil, fmt.Errorf("cannot overwrite bookmark: %v", err)
+						}
+					}
+					rtn.Overwritten += len(existingIds)
+				case MergeRename:
+					// keep both, disambiguate with the alias
+					newAlias, err = findFreeBookmarkAlias(txCtx, bmb.Alias)
+					if err != nil {
+						return nil, err
+					}
+					rtn.Renamed++
+				}
+			} else {
+				rtn.Added++
+			}
+			newBm := &bookmarks.BookmarkType{
+				BookmarkId:  uuid.New().String(),
+				CreatedTs:   time.Now().UnixMilli(),
+				CmdStr:      bmb.CmdStr,
+				Alias:       newAlias,
+				Tags:        bmb.Tags,
+				Description: bmb.Description,
+			}
+			err = bookmarks.InsertBookmark(txCtx, newBm)
+			if err != nil {
+				return nil, fmt.Errorf("cannot import bookmark: %v", err)
+			}
+		}
+		return rtn, nil
+	})
+}
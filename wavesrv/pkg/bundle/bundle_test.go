@@ -0,0 +1,70 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package bundle
+
+import (
+	"testing"
+)
+
+func TestBundleRoundTrip(t *testing.T) {
+	b := &BundleType{
+		Version: BundleVersion,
+		Playbooks: []*PlaybookBundle{
+			{Name: "deploy", Description: "deploy app", Entries: []*PlaybookEntryBundle{
+				{Alias: "build", CmdStr: "make build"},
+				{CmdStr: "git checkout {{branch:default=main}}"},
+			}},
+		},
+		Bookmarks: []*BookmarkBundle{{CmdStr: "ls -l", Alias: "ll", Tags: []string{"fs"}}},
+	}
+	for _, format := range []string{FormatJson, FormatYaml} {
+		data, err := MarshalBundle(b, format)
+		if err != nil {
+			t.Fatalf("%s marshal error: %v", format, err)
+		}
+		b2, err := UnmarshalBundle(data, format)
+		if err != nil {
+			t.Fatalf("%s unmarshal error: %v", format, err)
+		}
+		if len(b2.Playbooks) != 1 || len(b2.Playbooks[0].Entries) != 2 || len(b2.Bookmarks) != 1 {
+			t.Fatalf("%s roundtrip mismatch: %#v", format, b2)
+		}
+		if b2.Playbooks[0].Entries[1].CmdStr != "git checkout {{branch:default=main}}" {
+			t.Errorf("%s bad cmdstr: %q", format, b2.Playbooks[0].Entries[1].CmdStr)
+		}
+		if b2.Bookmarks[0].Tags[0] != "fs" {
+			t.Errorf("%s bad tags: %v", format, b2.Bookmarks[0].Tags)
+		}
+	}
+}
+
+func TestUnmarshalBundleVersion(t *testing.T) {
+	_, err := UnmarshalBundle([]byte("playbooks: []\n"), FormatYaml)
+	if err == nil {
+		t.Errorf("expected error for missing version")
+	}
+	_, err = UnmarshalBundle([]byte(`{"version": 99}`), FormatJson)
+	if err == nil {
+		t.Errorf("expected error for unsupported version")
+	}
+}
+
+func TestResolveFormat(t *testing.T) {
+	checks := []struct{ fileName, arg, expected string }{
+		{"/tmp/x.json", "", FormatJson},
+		{"/tmp/x.yaml", "", FormatYaml},
+		{"/tmp/x", "", FormatYaml},
+		{"/tmp/x.json", "yml", FormatYaml},
+	}
+	for _, c := range checks {
+		format, err := ResolveFormat(c.fileName, c.arg)
+		if err != nil || format != c.expected {
+			t.Errorf("ResolveFormat(%q, %q) = %q, %v (expected %q)", c.fileName, c.arg, format, err, c.expected)
+		}
+	}
+	_, err := ResolveFormat("/tmp/x", "xml")
+	if err == nil {
+		t.Errorf("expected error for bad format")
+	}
+}
@@ -0,0 +1,299 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abhishek944/waveterm/waveshell/pkg/packet"
+	"github.com/abhishek944/waveterm/waveshell/pkg/utilfn"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/scbus"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/scpacket"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+	"github.com/google/uuid"
+)
+
+const AgentThreadTitleLen = 80
+const AgentThreadNone = "none"
+
+// records one /agent exchange (the user prompt + the assistant response) into a persisted thread
+type agentThreadRecorder struct {
+	Thread   *sstore.AIThreadType
+	LineId   string
+	Provider string
+	Model    string
+	Text     strings.Builder
+	Usage    packet.OpenAIUsageType
+	HadError bool
+}
+
+// threadId == "" starts a new thread.  returns the recorder and the prior messages of the thread (for the prompt)
+func startAgentThread(ctx context.Context, screenId string, lineId string, threadId string, provider string, promptStr string) (*agentThreadRecorder, []packet.OpenAIPromptMessageType, error) {
+	var thread *sstore.AIThreadType
+	var priorMsgs []packet.OpenAIPromptMessageType
+	if threadId != "" {
+		var err error
+		thread, err = sstore.GetAIThreadById(ctx, threadId)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot get agent thread: %v", err)
+		}
+		if thread == nil {
+			return nil, nil, fmt.Errorf("agent thread %q not found", threadId)
+		}
+		msgs, err := sstore.GetAIMessages(ctx, threadId)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot get agent thread messages: %v", err)
+		}
+		for _, msg := range msgs {
+			priorMsgs = append(priorMsgs, packet.OpenAIPromptMessageType{Role: msg.Role, Content: msg.Content})
+		}
+	} else {
+		now := time.Now().UnixMilli()
+		thread = &sstore.AIThreadType{
+			ThreadId:  uuid.New().String(),
+			ScreenId:  screenId,
+			LineId:    lineId,
+			Title:     utilfn.EllipsisStr(strings.TrimSpace(promptStr), AgentThreadTitleLen),
+			CreatedTs: now,
+			UpdatedTs: now,
+		}
+		err := sstore.CreateAIThread(ctx, thread)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot create agent thread: %v", err)
+		}
+	}
+	userMsg := &sstore.AIMessageType{
+		ThreadId: thread.ThreadId,
+		LineId:   lineId,
+		Role:     sstore.AIRoleUser,
+		Content:  promptStr,
+		Provider: provider,
+	}
+	err := sstore.AddAIMessage(ctx, userMsg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot save agent prompt: %v", err)
+	}
+	rec := &agentThreadRecorder{Thread: thread, LineId: lineId, Provider: provider}
+	return rec, priorMsgs, nil
+}
+
+func (rec *agentThreadRecorder) AddPacket(pk *packet.OpenAIPacketType) {
+	if pk.Error != "" {
+		rec.HadError = true
+	}
+	if pk.Model != "" {
+		rec.Model = pk.Model
+	}
+	rec.Text.WriteString(pk.Text)
+	if pk.Usage != nil {
+		rec.Usage = *pk.Usage
+	}
+}
+
+// for multi-step (tool calling) runs, usage is summed over the steps
+func (rec *agentThreadRecorder) AddResponse(model string, text string, usage *packet.OpenAIUsageType) {
+	if model != "" {
+		rec.Model = model
+	}
+	if text != "" {
+		if rec.Text.Len() > 0 {
+			rec.Text.WriteString("\n\n")
+		}
+		rec.Text.WriteString(text)
+	}
+	if usage != nil {
+		rec.Usage.PromptTokens += usage.PromptTokens
+		rec.Usage.CompletionTokens += usage.CompletionTokens
+		rec.Usage.TotalTokens += usage.TotalTokens
+	}
+}
+
+// saves the assistant response (failed responses are not saved, the user prompt is kept)
+func (rec *agentThreadRecorder) Finish() {
+	if rec == nil || rec.HadError || rec.Text.Len() == 0 {
+		return
+	}
+	ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFn()
+	asstMsg := &sstore.AIMessageType{
+		ThreadId:         rec.Thread.ThreadId,
+		LineId:           rec.LineId,
+		Role:             sstore.AIRoleAssistant,
+		Content:          rec.Text.String(),
+		Model:            rec.Model,
+		Provider:         rec.Provider,
+		PromptTokens:     rec.Usage.PromptTokens,
+		CompletionTokens: rec.Usage.CompletionTokens,
+	}
+	err := sstore.AddAIMessage(ctx, asstMsg)
+	if err != nil {
+		log.Printf("cannot save agent response to thread %s: %v\n", rec.Thread.ThreadId, err)
+	}
+}
+
+// threadArg can be a 1-based index into the screen's thread list (see /agent:history), a thread id, or an 8 character thread id prefix
+func resolveAgentThread(ctx context.Context, screenId string, threadArg string) (*sstore.AIThreadType, error) {
+	if threadArg == "" {
+		return nil, fmt.Errorf("no thread specified")
+	}
+	if isAllDigits(threadArg) {
+		threads, err := sstore.GetAIThreads(ctx, screenId)
+		if err != nil {
+			return nil, err
+		}
+		threadIdx, _ := strconv.Atoi(threadArg)
+		if threadIdx <= 0 || threadIdx > len(threads) {
+			return nil, fmt.Errorf("thread index %d out of range (screen has %d threads)", threadIdx, len(threads))
+		}
+		return threads[threadIdx-1], nil
+	}
+	threads, err := sstore.GetAIThreads(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, thread := range threads {
+		if thread.ThreadId == threadArg || (len(threadArg) == 8 && strings.HasPrefix(thread.ThreadId, threadArg)) {
+			return thread, nil
+		}
+	}
+	return nil, fmt.Errorf("thread %q not found", threadArg)
+}
+
+func AgentHistoryCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, fmt.Errorf("/%s error: %w", GetCmdStr(pk), err)
+	}
+	if firstArg(pk) != "" {
+		return agentShowThread(ctx, pk, ids)
+	}
+	screenId := ids.ScreenId
+	if resolveBool(pk.Kwargs["all"], false) {
+		screenId = ""
+	}
+	threads, err := sstore.GetAIThreads(ctx, screenId)
+	if err != nil {
+		return nil, fmt.Errorf("/%s error: %v", GetCmdStr(pk), err)
+	}
+	activeThreadId := sstore.ScreenMemGetAgentThread(ids.ScreenId)
+	var buf bytes.Buffer
+	for idx, thread := range threads {
+		activeStr := ""
+		if thread.ThreadId == activeThreadId {
+			activeStr = " (active)"
+		}
+		updatedTs := time.UnixMilli(thread.UpdatedTs)
+		buf.WriteString(fmt.Sprintf("  %3d  %s  %s  %3d msgs  %s%s\n", idx+1, thread.ThreadId[0:8], updatedTs.Format(TsFormatStr), thread.NumMessages, thread.Title, activeStr))
+	}
+	if len(threads) == 0 {
+		buf.WriteString("  (no agent threads)\n")
+	}
+	title := "agent threads"
+	if screenId == "" {
+		title = "agent threads (all screens)"
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{
+		InfoTitle: title,
+		InfoLines: splitLinesForInfo(buf.String()),
+	})
+	return update, nil
+}
+
+func agentShowThread(ctx context.Context, pk *scpacket.FeCommandPacketType, ids resolvedIds) (scbus.UpdatePacket, error) {
+	thread, err := resolveAgentThread(ctx, ids.ScreenId, firstArg(pk))
+	if err != nil {
+		return nil, fmt.Errorf("/%s error: %v", GetCmdStr(pk), err)
+	}
+	msgs, err := sstore.GetAIMessages(ctx, thread.ThreadId)
+	if err != nil {
+		return nil, fmt.Errorf("/%s error: %v", GetCmdStr(pk), err)
+	}
+	var buf bytes.Buffer
+	for _, msg := range msgs {
+		msgTs := time.UnixMilli(msg.Ts)
+		if msg.Role == sstore.AIRoleAssistant {
+			buf.WriteString(fmt.Sprintf("[%s] assistant (%s/%s, %d+%d tokens):\n", msgTs.Format(TsFormatStr), msg.Provider, msg.Model, msg.PromptTokens, msg.CompletionTokens))
+		} else {
+			buf.WriteString(fmt.Sprintf("[%s] %s:\n", msgTs.Format(TsFormatStr), msg.Role))
+		}
+		buf.WriteString(strings.TrimRight(msg.Content, "\n"))
+		buf.WriteString("\n\n")
+	}
+	if len(msgs) == 0 {
+		buf.WriteString("(no messages)\n")
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{
+		InfoTitle: fmt.Sprintf("agent thread %q [%s]", thread.Title, thread.ThreadId[0:8]),
+		InfoLines: splitLinesForInfo(buf.String()),
+	})
+	return update, nil
+}
+
+// /agent:resume [thread] [prompt]
+// makes thread the active thread for the screen (following /agent prompts continue it).
+// with a prompt, the prompt is run right away.  "none" clears the active thread.
+func AgentResumeCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, fmt.Errorf("/%s error: %w", GetCmdStr(pk), err)
+	}
+	threadArg := firstArg(pk)
+	if threadArg == "" {
+		activeThreadId := sstore.ScreenMemGetAgentThread(ids.ScreenId)
+		if activeThreadId == "" {
+			return sstore.InfoMsgUpdate("no active agent thread (new /agent prompts start a new thread)"), nil
+		}
+		return sstore.InfoMsgUpdate("active agent thread [%s]", activeThreadId[0:8]), nil
+	}
+	if threadArg == AgentThreadNone {
+		sstore.ScreenMemSetAgentThread(ids.ScreenId, "")
+		return sstore.InfoMsgUpdate("cleared active agent thread, new /agent prompts start a new thread"), nil
+	}
+	thread, err := resolveAgentThread(ctx, ids.ScreenId, threadArg)
+	if err != nil {
+		return nil, fmt.Errorf("/%s error: %v", GetCmdStr(pk), err)
+	}
+	sstore.ScreenMemSetAgentThread(ids.ScreenId, thread.ThreadId)
+	if len(pk.Args) < 2 {
+		return sstore.InfoMsgUpdate("resumed agent thread %q [%s] (%d messages)", thread.Title, thread.ThreadId[0:8], thread.NumMessages), nil
+	}
+	agentPk := *pk
+	agentPk.Args = pk.Args[1:]
+	agentPk.Kwargs = make(map[string]string)
+	for key, val := range pk.Kwargs {
+		agentPk.Kwargs[key] = val
+	}
+	agentPk.Kwargs["thread"] = thread.ThreadId
+	return AgentCommand(ctx, &agentPk)
+}
+
+func AgentDeleteCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, fmt.Errorf("/%s error: %w", GetCmdStr(pk), err)
+	}
+	if firstArg(pk) == "" {
+		return nil, fmt.Errorf("/%s requires an argument (thread number or id)", GetCmdStr(pk))
+	}
+	thread, err := resolveAgentThread(ctx, ids.ScreenId, firstArg(pk))
+	if err != nil {
+		return nil, fmt.Errorf("/%s error: %v", GetCmdStr(pk), err)
+	}
+	err = sstore.DeleteAIThread(ctx, thread.ThreadId)
+	if err != nil {
+		return nil, fmt.Errorf("/%s error: %v", GetCmdStr(pk), err)
+	}
+	if sstore.ScreenMemGetAgentThread(thread.ScreenId) == thread.ThreadId {
+		sstore.ScreenMemSetAgentThread(thread.ScreenId, "")
+	}
+	return sstore.InfoMsgUpdate("deleted agent thread %q [%s]", thread.Title, thread.ThreadId[0:8]), nil
+}
@@ -0,0 +1,517 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abhishek944/waveterm/waveshell/pkg/base"
+	"github.com/abhishek944/waveterm/waveshell/pkg/packet"
+	"github.com/abhishek944/waveterm/waveshell/pkg/utilfn"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/ephemeral"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/prompts"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/remote"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/remote/aiprovider"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/scbase"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/scbus"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/userinput"
+	"github.com/google/uuid"
+)
+
+const (
+	AgentTool_RunCommand = "run_command"
+	AgentTool_ReadFile   = "read_file"
+	AgentTool_ListDir    = "list_dir"
+)
+
+const MaxAgentSteps = 10
+const MaxAgentToolCalls = 16 // keeps the audit log within MaxLineStateSize
+const MaxAgentToolOutputBytes = 16 * 1024
+const MaxAgentAuditArgsLen = 100
+const AgentToolTimeout = 60 * time.Second
+const AgentApprovalTimeout = 5 * time.Minute
+const AgentLoopTimeout = 30 * time.Minute
+
+const (
+	AgentAuditStatus_Done   = "done"
+	AgentAuditStatus_Denied = "denied"
+	AgentAuditStatus_Error  = "error"
+)
+
+var agentToolDefs = []*aiprovider.ToolDef{
+	{
+		Name:        AgentTool_RunCommand,
+		Description: "Run a shell command on the user's current connection and return its output.  Requires user approval.",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"command": map[string]any{"type": "string", "description": "the shell command to run"},
+				"cwd":     map[string]any{"type": "string", "description": "optional working directory (defaults to the current directory)"},
+			},
+			"required": []string{"command"},
+		},
+	},
+	{
+		Name:        AgentTool_ReadFile,
+		Description: "Read a text file on the user's current connection.  Large files are truncated.  Requires user approval.",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"path": map[string]any{"type": "string", "description": "file path (absolute, or relative to the current directory)"},
+			},
+			"required": []string{"path"},
+		},
+	},
+	{
+		Name:        AgentTool_ListDir,
+		Description: "List the contents of a directory on the user's current connection.  Requires user approval.",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"path": map[string]any{"type": "string", "description": "directory path (absolute, or relative to the current directory)"},
+			},
+			"required": []string{"path"},
+		},
+	},
+}
+
+type agentToolArgs struct {
+	Command string `json:"command"`
+	Cwd     string `json:"cwd"`
+	Path    string `json:"path"`
+}
+
+// one entry per tool call, stored (truncated) in the line state under sstore.LineState_AgentAudit, and in full in the ai_audit table
+type agentAuditEntry struct {
+	Step   int    `json:"step"`
+	Tool   string `json:"tool"`
+	Args   string `json:"args"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Bytes  int    `json:"bytes"`
+	Ts     int64  `json:"ts"`
+}
+
+// state for a single tool-calling /agent run
+type agentToolRun struct {
+	Cmd        *sstore.CmdType
+	Ids        resolvedIds
+	ClientData *sstore.ClientData
+	Provider   aiprovider.ToolCallingProvider
+	LineState  map[string]any
+	OutputPos  int64
+	Audit      []*agentAuditEntry
+	NumCalls   int
+	SentHeader bool
+	ThreadRec  *agentThreadRecorder
+}
+
+// captures (capped) stdout+stderr of an ephemeral command, done is closed once both writers are closed
+type agentCmdOutput struct {
+	Lock      sync.Mutex
+	Buf       bytes.Buffer
+	Truncated bool
+	Wg        sync.WaitGroup
+}
+
+type agentOutputWriter struct {
+	Output    *agentCmdOutput
+	CloseOnce sync.Once
+}
+
+func (w *agentOutputWriter) Write(data []byte) (int, error) {
+	out := w.Output
+	out.Lock.Lock()
+	defer out.Lock.Unlock()
+	remaining := MaxAgentToolOutputBytes - out.Buf.Len()
+	if len(data) > remaining {
+		if remaining > 0 {
+			out.Buf.Write(data[:remaining])
+		}
+		out.Truncated = true
+	} else {
+		out.Buf.Write(data)
+	}
+	return len(data), nil
+}
+
+func (w *agentOutputWriter) Close() error {
+	w.CloseOnce.Do(w.Output.Wg.Done)
+	return nil
+}
+
+func makeAgentToolMessages(promptStr string, contextStr string, threadPrompt []packet.OpenAIPromptMessageType) []*aiprovider.ToolMessage {
+	messages := []*aiprovider.ToolMessage{
+		{Role: aiprovider.ToolRoleSystem, Content: prompts.AgentSystemPrompt},
+		{Role: aiprovider.ToolRoleSystem, Content: prompts.AgentToolsPrompt},
+	}
+	if contextStr != "" {
+		messages = append(messages, &aiprovider.ToolMessage{Role: aiprovider.ToolRoleSystem, Content: contextStr})
+	}
+	for _, msg := range threadPrompt {
+		messages = append(messages, &aiprovider.ToolMessage{Role: msg.Role, Content: msg.Content})
+	}
+	messages = append(messages, &aiprovider.ToolMessage{Role: aiprovider.ToolRoleUser, Content: promptStr})
+	return messages
+}
+
+func (run *agentToolRun) writeText(ctx context.Context, text string) {
+	err := writePacketToPty(ctx, run.Cmd, aiprovider.CreateTextPacket(text), &run.OutputPos)
+	if err != nil {
+		log.Printf("agent tools, error writing to ptybuffer: %v\n", err)
+	}
+}
+
+func (run *agentToolRun) writeError(errStr string) {
+	writeErrorToPty(run.Cmd, errStr, run.OutputPos)
+}
+
+// tool-calling variant of the /agent streaming loop (tools=1)
+func runAgentWithTools(cmd *sstore.CmdType, ids resolvedIds, clientData *sstore.ClientData, provider aiprovider.ToolCallingProvider, lineState map[string]any, threadRec *agentThreadRecorder, threadPrompt []packet.OpenAIPromptMessageType, promptStr string, contextStr string) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), AgentLoopTimeout)
+	defer cancelFn()
+	run := &agentToolRun{
+		Cmd:        cmd,
+		Ids:        ids,
+		ClientData: clientData,
+		Provider:   provider,
+		LineState:  lineState,
+		ThreadRec:  threadRec,
+	}
+	run.Run(ctx, makeAgentToolMessages(promptStr, contextStr, threadPrompt))
+	threadRec.Finish()
+}
+
+// runs the model <-> tool loop until the model stops calling tools, or the step limit is hit
+func (run *agentToolRun) Run(ctx context.Context, messages []*aiprovider.ToolMessage) {
+	for step := 1; step <= MaxAgentSteps; step++ {
+		err := checkAIBudget(ctx, run.ClientData)
+		if err != nil {
+			run.ThreadRec.HadError = true
+			run.writeError(fmt.Sprintf("agent error: %v", err))
+			return
+		}
+		resp, err := run.Provider.RunToolCompletion(ctx, run.ClientData.AIOpts, messages, agentToolDefs)
+		if err != nil {
+			run.ThreadRec.HadError = true
+			run.writeError(fmt.Sprintf("agent error: %v", err))
+			return
+		}
+		recordAIUsage(run.ThreadRec.Provider, resp.Model, run.Ids.ScreenId, resp.Usage)
+		run.ThreadRec.AddResponse(resp.Model, resp.Text, resp.Usage)
+		if !run.SentHeader {
+			headerPk := packet.MakeOpenAIPacket()
+			headerPk.Model = resp.Model
+			headerPk.Created = time.Now().Unix()
+			writePacketToPty(ctx, run.Cmd, headerPk, &run.OutputPos)
+			run.SentHeader = true
+		}
+		if resp.Text != "" {
+			run.writeText(ctx, resp.Text)
+		}
+		messages = append(messages, &aiprovider.ToolMessage{Role: aiprovider.ToolRoleAssistant, Content: resp.Text, ToolCalls: resp.ToolCalls})
+		if len(resp.ToolCalls) == 0 {
+			finishPk := packet.MakeOpenAIPacket()
+			finishPk.FinishReason = defaultStr(resp.FinishReason, "stop")
+			finishPk.Usage = resp.Usage
+			writePacketToPty(ctx, run.Cmd, finishPk, &run.OutputPos)
+			return
+		}
+		for _, tc := range resp.ToolCalls {
+			result := run.runToolCall(ctx, step, tc)
+			messages = append(messages, &aiprovider.ToolMessage{Role: aiprovider.ToolRoleTool, ToolCallId: tc.Id, Content: result})
+		}
+	}
+	run.writeError(fmt.Sprintf("agent stopped: step limit (%d) reached", MaxAgentSteps))
+}
+
+// returns the tool result that is sent back to the model
+func (run *agentToolRun) runToolCall(ctx context.Context, step int, tc *aiprovider.ToolCall) string {
+	if run.NumCalls >= MaxAgentToolCalls {
+		run.writeText(ctx, fmt.Sprintf("\n\n_Skipped `%s` (step %d), tool call limit reached._\n", tc.Name, step))
+		return fmt.Sprintf("error: tool call limit (%d) reached, answer with the information you have", MaxAgentToolCalls)
+	}
+	run.NumCalls++
+	entry := &agentAuditEntry{Step: step, Tool: tc.Name, Args: tc.Args, Ts: time.Now().UnixMilli()}
+	defer run.addAuditEntry(ctx, entry)
+	var args agentToolArgs
+	err := json.Unmarshal([]byte(tc.Args), &args)
+	if err != nil {
+		entry.Status = AgentAuditStatus_Error
+		entry.Error = fmt.Sprintf("invalid arguments: %v", err)
+		return fmt.Sprintf("error: invalid arguments: %v", err)
+	}
+	actionStr, err := formatAgentAction(tc.Name, &args)
+	if err != nil {
+		entry.Status = AgentAuditStatus_Error
+		entry.Error = err.Error()
+		return fmt.Sprintf("error: %v", err)
+	}
+	run.writeText(ctx, fmt.Sprintf("\n\n**Pending action** (step %d):\n%s\n", step, actionStr))
+	if !run.getApproval(ctx, actionStr) {
+		entry.Status = AgentAuditStatus_Denied
+		run.writeText(ctx, "\n_Denied by user._\n")
+		return "the user denied this action"
+	}
+	var output string
+	switch tc.Name {
+	case AgentTool_RunCommand:
+		output, err = run.toolRunCommand(ctx, &args)
+	case AgentTool_ReadFile:
+		output, err = run.toolReadFile(ctx, &args)
+	case AgentTool_ListDir:
+		output, err = run.toolListDir(ctx, &args)
+	}
+	if err != nil {
+		entry.Status = AgentAuditStatus_Error
+		entry.Error = err.Error()
+		run.writeText(ctx, fmt.Sprintf("\n_Approved, failed: %s_\n", err))
+		return fmt.Sprintf("error: %v", err)
+	}
+	entry.Status = AgentAuditStatus_Done
+	entry.Bytes = len(output)
+	run.writeText(ctx, fmt.Sprintf("\n_Approved, returned %d bytes of output._\n", len(output)))
+	return output
+}
+
+func formatAgentAction(toolName string, args *agentToolArgs) (string, error) {
+	switch toolName {
+	case AgentTool_RunCommand:
+		if strings.TrimSpace(args.Command) == "" {
+			return "", fmt.Errorf("command is required")
+		}
+		cwdStr := ""
+		if args.Cwd != "" {
+			cwdStr = fmt.Sprintf(" (in `%s`)", args.Cwd)
+		}
+		return fmt.Sprintf("Run command%s:\n```\n%s\n```", cwdStr, args.Command), nil
+	case AgentTool_ReadFile:
+		if args.Path == "" {
+			return "", fmt.Errorf("path is required")
+		}
+		return fmt.Sprintf("Read file `%s`", args.Path), nil
+	case AgentTool_ListDir:
+		if args.Path == "" {
+			return "", fmt.Errorf("path is required")
+		}
+		return fmt.Sprintf("List directory `%s`", args.Path), nil
+	default:
+		return "", fmt.Errorf("unknown tool %q", toolName)
+	}
+}
+
+func (run *agentToolRun) getApproval(ctx context.Context, actionStr string) bool {
+	request := &userinput.UserInputRequestType{
+		ResponseType: "confirm",
+		QueryText:    fmt.Sprintf("The agent wants to perform the following action on **%s**:\n\n%s\n\nDo you want to allow it?", run.Ids.Remote.DisplayName, actionStr),
+		Markdown:     true,
+		Title:        "Agent Action",
+	}
+	inputCtx, cancelFn := context.WithTimeout(ctx, AgentApprovalTimeout)
+	defer cancelFn()
+	response, err := userinput.GetUserInput(inputCtx, scbus.MainRpcBus, request)
+	if err != nil {
+		return false
+	}
+	return response.Confirm
+}
+
+func (run *agentToolRun) addAuditEntry(ctx context.Context, entry *agentAuditEntry) {
+	fullEntry := &sstore.AIAuditEntryType{
+		ScreenId: run.Cmd.ScreenId,
+		LineId:   run.Cmd.LineId,
+		CallIdx:  len(run.Audit),
+		Step:     entry.Step,
+		Tool:     entry.Tool,
+		Args:     entry.Args,
+		Status:   entry.Status,
+		Error:    entry.Error,
+		Bytes:    entry.Bytes,
+		Ts:       entry.Ts,
+	}
+	err := sstore.InsertAIAuditEntry(ctx, fullEntry)
+	if err != nil {
+		log.Printf("agent tools, cannot save audit entry: %v\n", err)
+	}
+	// the line state only gets a truncated copy (to stay within MaxLineStateSize), /line:show shows the full entries
+	displayEntry := *entry
+	displayEntry.Args = utilfn.EllipsisStr(entry.Args, MaxAgentAuditArgsLen)
+	displayEntry.Error = utilfn.EllipsisStr(entry.Error, MaxAgentAuditArgsLen)
+	run.Audit = append(run.Audit, &displayEntry)
+	if run.LineState == nil {
+		run.LineState = make(map[string]any)
+	}
+	run.LineState[sstore.LineState_AgentAudit] = run.Audit
+	err = sstore.UpdateLineState(ctx, run.Cmd.ScreenId, run.Cmd.LineId, run.LineState)
+	if err != nil {
+		log.Printf("agent tools, cannot update audit log: %v\n", err)
+		return
+	}
+	line, err := sstore.GetLineById(ctx, run.Cmd.ScreenId, run.Cmd.LineId)
+	if err != nil || line == nil {
+		return
+	}
+	update := scbus.MakeUpdatePacket()
+	sstore.AddLineUpdate(update, line, nil)
+	scbus.MainUpdateBus.DoScreenUpdate(run.Cmd.ScreenId, update)
+}
+
+func (run *agentToolRun) resolvePath(path string) string {
+	if filepath.IsAbs(path) || run.Ids.Remote.FeState == nil {
+		return path
+	}
+	return filepath.Join(run.Ids.Remote.FeState["cwd"], path)
+}
+
+func (run *agentToolRun) runEphemeral(ctx context.Context, cmdStr string, cwd string) (string, error) {
+	output := &agentCmdOutput{}
+	output.Wg.Add(2)
+	ephemeralOpts := &ephemeral.EphemeralRunOpts{
+		OverrideCwd:     cwd,
+		TimeoutMs:       AgentToolTimeout.Milliseconds(),
+		ExpectsResponse: true,
+		StdoutWriter:    &agentOutputWriter{Output: output},
+		StderrWriter:    &agentOutputWriter{Output: output},
+	}
+	runPacket := packet.MakeRunPacket()
+	runPacket.ReqId = uuid.New().String()
+	runPacket.CK = base.MakeCommandKey(run.Ids.ScreenId, scbase.GenWaveUUID())
+	runPacket.UsePty = false
+	runPacket.Command = cmdStr
+	rcOpts := remote.RunCommandOpts{
+		SessionId:     run.Ids.SessionId,
+		ScreenId:      run.Ids.ScreenId,
+		RemotePtr:     run.Ids.Remote.RemotePtr,
+		StatePtr:      run.Ids.Remote.StatePtr,
+		EphemeralOpts: ephemeralOpts,
+	}
+	_, callback, err := remote.RunCommand(ctx, rcOpts, runPacket)
+	if callback != nil {
+		defer callback()
+	}
+	if err != nil {
+		return "", err
+	}
+	doneCh := make(chan struct{})
+	go func() {
+		output.Wg.Wait()
+		close(doneCh)
+	}()
+	select {
+	case <-doneCh:
+	case <-time.After(AgentToolTimeout + 5*time.Second):
+		return "", fmt.Errorf("timeout waiting for command output")
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	output.Lock.Lock()
+	defer output.Lock.Unlock()
+	rtn := output.Buf.String()
+	if output.Truncated {
+		rtn += "\n...(output truncated)\n"
+	}
+	return rtn, nil
+}
+
+func (run *agentToolRun) toolRunCommand(ctx context.Context, args *agentToolArgs) (string, error) {
+	cwd := ""
+	if args.Cwd != "" {
+		cwd = run.resolvePath(args.Cwd)
+	}
+	return run.runEphemeral(ctx, args.Command, cwd)
+}
+
+func (run *agentToolRun) streamFile(ctx context.Context, path string, statOnly bool) (*packet.FileInfo, string, error) {
+	streamPk := packet.MakeStreamFilePacket()
+	streamPk.ReqId = uuid.New().String()
+	streamPk.Path = run.resolvePath(path)
+	streamPk.StatOnly = statOnly
+	if !statOnly {
+		streamPk.ByteRange = []int64{0, MaxAgentToolOutputBytes - 1}
+	}
+	iter, err := run.Ids.Remote.Waveshell.StreamFile(ctx, streamPk)
+	if err != nil {
+		return nil, "", err
+	}
+	defer iter.Close()
+	respIf, err := iter.Next(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, ok := respIf.(*packet.StreamFileResponseType)
+	if !ok {
+		return nil, "", fmt.Errorf("bad response packet type: %T", respIf)
+	}
+	if resp.Error != "" {
+		return nil, "", fmt.Errorf("%s", resp.Error)
+	}
+	if resp.Info == nil {
+		return nil, "", fmt.Errorf("no file info")
+	}
+	if resp.Info.NotFound {
+		return nil, "", fmt.Errorf("%q not found", path)
+	}
+	if statOnly || resp.Info.IsDir {
+		return resp.Info, "", nil
+	}
+	var buf bytes.Buffer
+	for {
+		dataPkIf, err := iter.Next(ctx)
+		if err != nil {
+			return nil, "", err
+		}
+		if dataPkIf == nil {
+			break
+		}
+		dataPk, ok := dataPkIf.(*packet.FileDataPacketType)
+		if !ok {
+			return nil, "", fmt.Errorf("invalid data packet type: %T", dataPkIf)
+		}
+		if dataPk.Error != "" {
+			return nil, "", fmt.Errorf("%s", dataPk.Error)
+		}
+		buf.Write(dataPk.Data)
+	}
+	return resp.Info, buf.String(), nil
+}
+
+func (run *agentToolRun) toolReadFile(ctx context.Context, args *agentToolArgs) (string, error) {
+	finfo, data, err := run.streamFile(ctx, args.Path, false)
+	if err != nil {
+		return "", err
+	}
+	if finfo.IsDir {
+		return "", fmt.Errorf("%q is a directory", args.Path)
+	}
+	if finfo.Size > MaxAgentToolOutputBytes {
+		data += fmt.Sprintf("\n...(truncated, file is %d bytes)\n", finfo.Size)
+	}
+	return data, nil
+}
+
+// StreamFile does not list directories, so this stats the path and then runs an ephemeral ls
+func (run *agentToolRun) toolListDir(ctx context.Context, args *agentToolArgs) (string, error) {
+	finfo, _, err := run.streamFile(ctx, args.Path, true)
+	if err != nil {
+		return "", err
+	}
+	if !finfo.IsDir {
+		return "", fmt.Errorf("%q is not a directory", args.Path)
+	}
+	return run.runEphemeral(ctx, makeListDirCmd(run.resolvePath(args.Path)), "")
+}
+
+// the path must reach ls exactly as approved, each single quote expands to 5 chars so this maxLen never truncates
+func makeListDirCmd(path string) string {
+	return "ls -la " + utilfn.ShellQuote(path, false, len(path)*5+2)
+}
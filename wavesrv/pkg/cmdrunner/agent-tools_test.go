@@ -0,0 +1,21 @@
+package cmdrunner
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestMakeListDirCmdQuoting(t *testing.T) {
+	for _, path := range []string{"/tmp/dir", "/tmp/it's", "/tmp/a'b'c'd", "/tmp/''''' $(rm -rf x) `id`"} {
+		cmdStr := makeListDirCmd(path)
+		quotedPath := strings.TrimPrefix(cmdStr, "ls -la ")
+		output, err := exec.Command("sh", "-c", "printf '%s' "+quotedPath).Output()
+		if err != nil {
+			t.Fatalf("cannot run quoted path %q: %v", quotedPath, err)
+		}
+		if string(output) != path {
+			t.Errorf("quoted path %q is %q, expected %q", quotedPath, string(output), path)
+		}
+	}
+}
@@ -8,25 +8,26 @@ import (
 	"fmt"
 	"log"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/abhishek944/waveterm/waveshell/pkg/base"
 	"github.com/abhishek944/waveterm/waveshell/pkg/packet"
+	"github.com/abhishek944/waveterm/waveshell/pkg/shellenv"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/history"
 	"github.com/abhishek944/waveterm/wavesrv/pkg/prompts"
-	"github.com/abhishek944/waveterm/wavesrv/pkg/remote/azureopenai"
-	"github.com/abhishek944/waveterm/wavesrv/pkg/remote/gemini"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/remote/aiprovider"
 	"github.com/abhishek944/waveterm/wavesrv/pkg/remote/openai"
 	"github.com/abhishek944/waveterm/wavesrv/pkg/scbus"
 	"github.com/abhishek944/waveterm/wavesrv/pkg/scpacket"
 	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
-)
 
-// AI Provider constants
-const (
-	AIProviderOpenAI = "openai"
-	AIProviderGemini = "gemini"
-	AIProviderAzure  = "azure"
+	// AI providers register themselves with aiprovider
+	_ "github.com/abhishek944/waveterm/wavesrv/pkg/remote/anthropic"
+	_ "github.com/abhishek944/waveterm/wavesrv/pkg/remote/azureopenai"
+	_ "github.com/abhishek944/waveterm/wavesrv/pkg/remote/gemini"
+	_ "github.com/abhishek944/waveterm/wavesrv/pkg/remote/ollama"
 )
 
 // AI mode constants
@@ -40,15 +41,17 @@ type AIRequest struct {
 	Mode      string                            // agent or thread
 	Prompt    []packet.OpenAIPromptMessageType
 	Streaming bool
-	Provider  string // openai, gemini, azure
+	Provider  string // registered provider name (openai, gemini, azure, ollama, anthropic)
 	Context   context.Context
+	ScreenId  string // for usage accounting
 }
 
 // AIResponse represents a generic AI response
 type AIResponse struct {
-	Packets []*packet.OpenAIPacketType
-	Stream  chan *packet.OpenAIPacketType
-	Error   error
+	Packets    []*packet.OpenAIPacketType
+	Stream     chan *packet.OpenAIPacketType
+	StopStream func() // set with Stream, must be called when the consumer stops reading Stream
+	Error      error
 }
 
 // GetAIProvider returns the configured AI provider from client data
@@ -59,136 +62,60 @@ func GetAIProvider(clientData *sstore.ClientData) (string, error) {
 	return "", fmt.Errorf("no AI provider configured")
 }
 
-// GetAIOptions returns the AI options for the specified provider
-func GetAIOptions(clientData *sstore.ClientData, provider string) (interface{}, error) {
-	switch provider {
-	case AIProviderOpenAI:
-		if clientData.AIOpts != nil && clientData.AIOpts.OpenAI != nil {
-			return clientData.AIOpts.OpenAI, nil
-		}
-		return nil, fmt.Errorf("OpenAI options not configured")
-	case AIProviderGemini:
-		if clientData.AIOpts != nil && clientData.AIOpts.Gemini != nil {
-			return clientData.AIOpts.Gemini, nil
-		}
-		return nil, fmt.Errorf("Gemini options not configured")
-	case AIProviderAzure:
-		if clientData.AIOpts != nil && clientData.AIOpts.Azure != nil {
-			return clientData.AIOpts.Azure, nil
-		}
-		return nil, fmt.Errorf("Azure OpenAI options not configured")
-	default:
-		return nil, fmt.Errorf("unsupported AI provider: %s", provider)
-	}
-}
-
 // RunAICompletion is the main entry point for AI completions
+// refuses the request once the daily token budget is used up, usage is recorded when the completion finishes
 func RunAICompletion(ctx context.Context, clientData *sstore.ClientData, request *AIRequest) (*AIResponse, error) {
-	provider := request.Provider
-	if provider == "" {
-		var err error
-		provider, err = GetAIProvider(clientData)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	opts, err := GetAIOptions(clientData, provider)
+	err := checkAIBudget(ctx, clientData)
 	if err != nil {
 		return nil, err
 	}
-
-	switch provider {
-	case AIProviderOpenAI:
-		return runOpenAICompletion(ctx, opts.(*sstore.OpenAIOptsType), request)
-	case AIProviderGemini:
-		return runGeminiCompletion(ctx, opts.(*sstore.GeminiOptsType), request)
-	case AIProviderAzure:
-		return runAzureOpenAICompletion(ctx, opts.(*sstore.AzureOpenAIOptsType), request)
-	default:
-		return nil, fmt.Errorf("unsupported AI provider: %s", provider)
-	}
-}
-
-// runOpenAICompletion handles OpenAI completions
-func runOpenAICompletion(ctx context.Context, opts *sstore.OpenAIOptsType, request *AIRequest) (*AIResponse, error) {
-	if opts.Model == "" {
-		opts.Model = openai.DefaultModel
-	}
-	if opts.MaxTokens == 0 {
-		opts.MaxTokens = openai.DefaultMaxTokens
-	}
-
-	if request.Streaming {
-		ch, err := openai.RunCompletionStream(ctx, opts, request.Prompt)
-		if err != nil {
-			return nil, err
-		}
-		return &AIResponse{Stream: ch}, nil
-	} else {
-		packets, err := openai.RunCompletion(ctx, opts, request.Prompt)
+	providerName := request.Provider
+	if providerName == "" {
+		providerName, err = GetAIProvider(clientData)
 		if err != nil {
 			return nil, err
 		}
-		return &AIResponse{Packets: packets}, nil
 	}
-}
-
-// runGeminiCompletion handles Gemini completions
-func runGeminiCompletion(ctx context.Context, opts *sstore.GeminiOptsType, request *AIRequest) (*AIResponse, error) {
-	if opts.Model == "" {
-		opts.Model = gemini.DefaultModel
-	}
-	if opts.MaxTokens == 0 {
-		opts.MaxTokens = gemini.DefaultMaxTokens
+	provider := aiprovider.GetProvider(providerName)
+	if provider == nil {
+		return nil, fmt.Errorf("unsupported AI provider: %s", providerName)
 	}
-
 	if request.Streaming {
-		ch, err := gemini.RunCompletionStream(ctx, opts, request.Prompt)
-		if err != nil {
-			return nil, err
-		}
-		return &AIResponse{Stream: ch}, nil
-	} else {
-		packets, err := gemini.RunCompletion(ctx, opts, request.Prompt)
+		ch, err := provider.RunCompletionStream(ctx, clientData.AIOpts, request.Prompt)
 		if err != nil {
 			return nil, err
 		}
-		return &AIResponse{Packets: packets}, nil
+		stream, stopFn := trackAIUsageStream(ctx, ch, providerName, request.ScreenId)
+		return &AIResponse{Stream: stream, StopStream: stopFn}, nil
 	}
-}
-
-// runAzureOpenAICompletion handles Azure OpenAI completions
-func runAzureOpenAICompletion(ctx context.Context, opts *sstore.AzureOpenAIOptsType, request *AIRequest) (*AIResponse, error) {
-	if request.Streaming {
-		ch, err := azureopenai.RunCompletionStream(ctx, opts, request.Prompt)
-		if err != nil {
-			return nil, err
-		}
-		return &AIResponse{Stream: ch}, nil
-	} else {
-		packets, err := azureopenai.RunCompletion(ctx, opts, request.Prompt)
-		if err != nil {
-			return nil, err
-		}
-		return &AIResponse{Packets: packets}, nil
+	packets, err := provider.RunCompletion(ctx, clientData.AIOpts, request.Prompt)
+	if err != nil {
+		return nil, err
 	}
+	var tracker aiUsageTracker
+	for _, pk := range packets {
+		tracker.AddPacket(pk)
+	}
+	recordAIUsage(providerName, tracker.Model, request.ScreenId, tracker.Usage)
+	return &AIResponse{Packets: packets}, nil
 }
 
 // Agent Mode Implementation
 
-// RunAgentMode handles agent mode AI requests
-func RunAgentMode(ctx context.Context, pk *scpacket.FeCommandPacketType, clientData *sstore.ClientData, prompt string, provider string) (*AIResponse, error) {
+// RunAgentMode handles agent mode AI requests (contextStr is optional, see buildAgentContext)
+// threadPrompt holds the prior messages when continuing a persisted agent thread
+func RunAgentMode(ctx context.Context, pk *scpacket.FeCommandPacketType, clientData *sstore.ClientData, prompt string, provider string, contextStr string, threadPrompt []packet.OpenAIPromptMessageType) (*AIResponse, error) {
 	agentPrompt := []packet.OpenAIPromptMessageType{
 		{
 			Role:    "system",
 			Content: prompts.AgentSystemPrompt,
 		},
-		{
-			Role:    "user",
-			Content: prompt,
-		},
 	}
+	if contextStr != "" {
+		agentPrompt = append(agentPrompt, packet.OpenAIPromptMessageType{Role: "system", Content: contextStr})
+	}
+	agentPrompt = append(agentPrompt, threadPrompt...)
+	agentPrompt = append(agentPrompt, packet.OpenAIPromptMessageType{Role: "user", Content: prompt})
 
 	request := &AIRequest{
 		Mode:      AIModeAgent,
@@ -197,10 +124,125 @@ func RunAgentMode(ctx context.Context, pk *scpacket.FeCommandPacketType, clientD
 		Context:   ctx,
 		Provider:  provider, // Use the provider from UI
 	}
+	if pk.UIContext != nil {
+		request.ScreenId = pk.UIContext.ScreenId
+	}
 
 	return RunAICompletion(ctx, clientData, request)
 }
 
+// Agent Context
+
+const DefaultAIContextNumCmds = 5
+const DefaultAIContextMaxOutputBytes = 4000
+const MaxAIContextNumCmds = 50
+const MaxAIContextOutputBytes = 64 * 1024
+
+// sstore.AIContextOptsType with the defaults applied (NumCmds or MaxOutputBytes of 0 means off)
+type aiContextOpts struct {
+	Enabled        bool
+	IncludeEnv     bool
+	NumCmds        int
+	MaxOutputBytes int
+	RedactPatterns []string
+}
+
+func getAIContextOpts(clientData *sstore.ClientData) aiContextOpts {
+	rtn := aiContextOpts{NumCmds: DefaultAIContextNumCmds, MaxOutputBytes: DefaultAIContextMaxOutputBytes}
+	if clientData.AIOpts == nil || clientData.AIOpts.Context == nil {
+		return rtn
+	}
+	copts := clientData.AIOpts.Context
+	rtn.Enabled = copts.Enabled
+	rtn.IncludeEnv = copts.IncludeEnv
+	rtn.RedactPatterns = copts.RedactPatterns
+	if copts.NumCmds != nil {
+		rtn.NumCmds = *copts.NumCmds
+	}
+	if copts.MaxOutputBytes != nil {
+		rtn.MaxOutputBytes = *copts.MaxOutputBytes
+	}
+	return rtn
+}
+
+// builds the (redacted) terminal context for an /agent prompt.  returns "" when context is not enabled.
+// context is opt-in via client settings, and can be overridden per command with context=0/1
+func buildAgentContext(ctx context.Context, pk *scpacket.FeCommandPacketType, ids resolvedIds, clientData *sstore.ClientData) (string, error) {
+	opts := getAIContextOpts(clientData)
+	if !resolveBool(pk.Kwargs["context"], opts.Enabled) {
+		return "", nil
+	}
+	redactor, err := prompts.MakeRedactor(opts.RedactPatterns)
+	if err != nil {
+		return "", err
+	}
+	actx := &prompts.AgentContextType{OsType: GetOsTypeFromRuntime()}
+	if ids.Remote != nil {
+		actx.ShellType = ids.Remote.ShellType
+		if ids.Remote.FeState != nil {
+			actx.Cwd = ids.Remote.FeState["cwd"]
+		}
+		if opts.IncludeEnv && ids.Remote.StatePtr != nil {
+			state, err := sstore.GetFullState(ctx, *ids.Remote.StatePtr)
+			if err != nil {
+				log.Printf("agent context: cannot get shell state: %v\n", err)
+			} else {
+				actx.Env = redactor.RedactEnv(shellenv.EnvMapFromState(state))
+			}
+		}
+	}
+	if opts.NumCmds > 0 {
+		hopts := history.HistoryQueryOpts{SessionId: ids.SessionId, ScreenId: ids.ScreenId, MaxItems: opts.NumCmds, NoMeta: true}
+		hresult, err := history.GetHistoryItems(ctx, hopts)
+		if err != nil {
+			log.Printf("agent context: cannot get history: %v\n", err)
+		} else {
+			// history is returned newest first
+			for idx := len(hresult.Items) - 1; idx >= 0; idx-- {
+				hitem := hresult.Items[idx]
+				actx.RecentCmds = append(actx.RecentCmds, prompts.AgentRecentCmd{CmdStr: hitem.CmdStr, ExitCode: hitem.ExitCode})
+			}
+		}
+	}
+	if opts.MaxOutputBytes > 0 {
+		cmdStr, output, err := getSelectedLineOutput(ctx, ids)
+		if err != nil {
+			log.Printf("agent context: cannot get selected line output: %v\n", err)
+		} else {
+			actx.LastCmdStr = cmdStr
+			actx.LastOutput = prompts.TailTruncate(prompts.StripAnsi(output), opts.MaxOutputBytes)
+		}
+	}
+	return actx.Format(redactor), nil
+}
+
+// returns the cmdstr and ptyout of the screen's selected line ("" if the selected line is not a command)
+func getSelectedLineOutput(ctx context.Context, ids resolvedIds) (string, string, error) {
+	screen, err := sstore.GetScreenById(ctx, ids.ScreenId)
+	if err != nil {
+		return "", "", err
+	}
+	if screen == nil || screen.SelectedLine <= 0 {
+		return "", "", nil
+	}
+	lineRItem, err := resolveLine(ctx, ids.SessionId, ids.ScreenId, strconv.FormatInt(screen.SelectedLine, 10), "")
+	if err != nil || lineRItem == nil {
+		return "", "", err
+	}
+	line, cmd, err := sstore.GetLineCmdByLineId(ctx, ids.ScreenId, lineRItem.Id)
+	if err != nil {
+		return "", "", err
+	}
+	if line == nil || cmd == nil || line.LineType != sstore.LineTypeCmd {
+		return "", "", nil
+	}
+	_, data, err := sstore.ReadFullPtyOutFile(ctx, ids.ScreenId, lineRItem.Id)
+	if err != nil {
+		return "", "", err
+	}
+	return cmd.CmdStr, string(data), nil
+}
+
 // Thread Mode Implementation
 
 // RunThreadMode handles thread mode AI requests
@@ -211,6 +253,9 @@ func RunThreadMode(ctx context.Context, pk *scpacket.FeCommandPacketType, client
 		Streaming: true,
 		Context:   ctx,
 	}
+	if pk.UIContext != nil {
+		request.ScreenId = pk.UIContext.ScreenId
+	}
 
 	return RunAICompletion(ctx, clientData, request)
 }
@@ -346,6 +391,15 @@ func DoOpenAICmdInfoCompletion(cmd *sstore.CmdType, clientId string, opts *sstor
 
 // DoOpenAIStreamCompletion performs streaming OpenAI completion
 func DoOpenAIStreamCompletion(cmd *sstore.CmdType, clientId string, opts *sstore.OpenAIOptsType, prompt []packet.OpenAIPromptMessageType) {
+	var packetTimeoutMs int
+	if opts != nil {
+		packetTimeoutMs = opts.Timeout
+	}
+	DoAIStreamCompletion(cmd, openai.Provider{}, &sstore.AIOptsType{OpenAI: opts}, packetTimeoutMs, prompt)
+}
+
+// DoAIStreamCompletion performs a streaming completion with any registered provider and writes to PTY
+func DoAIStreamCompletion(cmd *sstore.CmdType, provider aiprovider.AIProvider, aiOpts *sstore.AIOptsType, packetTimeoutMs int, prompt []packet.OpenAIPromptMessageType) {
 	var outputPos int64
 	var hadError bool
 	startTime := time.Now()
@@ -355,7 +409,7 @@ func DoOpenAIStreamCompletion(cmd *sstore.CmdType, clientId string, opts *sstore
 		r := recover()
 		if r != nil {
 			panicMsg := fmt.Sprintf("panic: %v", r)
-			log.Printf("panic in doOpenAICompletion: %s\n", panicMsg)
+			log.Printf("panic in doAIStreamCompletion: %s\n", panicMsg)
 			writeErrorToPty(cmd, panicMsg, outputPos)
 			hadError = true
 		}
@@ -376,21 +430,21 @@ func DoOpenAIStreamCompletion(cmd *sstore.CmdType, clientId string, opts *sstore
 		err := sstore.UpdateCmdDoneInfo(context.Background(), update, ck, doneInfo, cmdStatus)
 		if err != nil {
 			// nothing to do
-			log.Printf("error updating cmddoneinfo (in openai): %v\n", err)
+			log.Printf("error updating cmddoneinfo (in %s): %v\n", provider.Name(), err)
 			return
 		}
 		scbus.MainUpdateBus.DoScreenUpdate(cmd.ScreenId, update)
 	}()
 	var ch chan *packet.OpenAIPacketType
 	var err error
-	ch, err = openai.RunCompletionStream(ctx, opts, prompt)
+	ch, err = provider.RunCompletionStream(ctx, aiOpts, prompt)
 	if err != nil {
-		writeErrorToPty(cmd, fmt.Sprintf("error calling OpenAI API: %v", err), outputPos)
+		writeErrorToPty(cmd, fmt.Sprintf("error calling %s API: %v", provider.Name(), err), outputPos)
 		return
 	}
 	packetTimeout := OpenAIPacketTimeout
-	if opts.Timeout > 0 {
-		packetTimeout = time.Duration(opts.Timeout) * time.Millisecond
+	if packetTimeoutMs > 0 {
+		packetTimeout = time.Duration(packetTimeoutMs) * time.Millisecond
 	}
 	doneWaitingForPackets := false
 	for !doneWaitingForPackets {
@@ -398,7 +452,7 @@ func DoOpenAIStreamCompletion(cmd *sstore.CmdType, clientId string, opts *sstore
 		case <-time.After(packetTimeout):
 			// timeout reading from channel
 			hadError = true
-			pk := openai.CreateErrorPacket(fmt.Sprintf("timeout waiting for server response"))
+			pk := aiprovider.CreateErrorPacket(fmt.Sprintf("timeout waiting for server response"))
 			err = writePacketToPty(ctx, cmd, pk, &outputPos)
 			if err != nil {
 				log.Printf("error writing response to ptybuffer: %v", err)
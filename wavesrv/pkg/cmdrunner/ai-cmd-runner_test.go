@@ -0,0 +1,43 @@
+package cmdrunner
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/abhishek944/waveterm/wavesrv/pkg/scpacket"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+)
+
+func TestGetAIContextOpts(t *testing.T) {
+	opts := getAIContextOpts(&sstore.ClientData{})
+	if opts.NumCmds != DefaultAIContextNumCmds || opts.MaxOutputBytes != DefaultAIContextMaxOutputBytes {
+		t.Errorf("no context opts: got %d/%d, expected the defaults", opts.NumCmds, opts.MaxOutputBytes)
+	}
+	clientData := &sstore.ClientData{AIOpts: &sstore.AIOptsType{Context: &sstore.AIContextOptsType{Enabled: true}}}
+	opts = getAIContextOpts(clientData)
+	if opts.NumCmds != DefaultAIContextNumCmds || opts.MaxOutputBytes != DefaultAIContextMaxOutputBytes {
+		t.Errorf("unset numcmds/maxoutputbytes: got %d/%d, expected the defaults", opts.NumCmds, opts.MaxOutputBytes)
+	}
+	zero := 0
+	clientData.AIOpts.Context.NumCmds = &zero
+	clientData.AIOpts.Context.MaxOutputBytes = &zero
+	opts = getAIContextOpts(clientData)
+	if opts.NumCmds != 0 || opts.MaxOutputBytes != 0 {
+		t.Errorf("numcmds/maxoutputbytes set to 0: got %d/%d, expected 0/0", opts.NumCmds, opts.MaxOutputBytes)
+	}
+}
+
+func TestBuildAgentContextZeroLimits(t *testing.T) {
+	zero := 0
+	clientData := &sstore.ClientData{AIOpts: &sstore.AIOptsType{Context: &sstore.AIContextOptsType{Enabled: true, NumCmds: &zero, MaxOutputBytes: &zero}}}
+	pk := scpacket.MakeFeCommandPacket()
+	// with both limits at 0 no history or line output is read (the ids point to no session or screen)
+	actxStr, err := buildAgentContext(context.Background(), pk, resolvedIds{}, clientData)
+	if err != nil {
+		t.Fatalf("error building context: %v", err)
+	}
+	if strings.Contains(actxStr, "Recent commands") || strings.Contains(actxStr, "Output of the selected command") {
+		t.Errorf("expected no commands or output in context, got:\n%s", actxStr)
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/abhishek944/waveterm/waveshell/pkg/packet"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/configstore"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/scbus"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/scpacket"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+)
+
+const DefaultAIUsageDays = 30
+const MaxAIUsageDays = 366
+const AIUsageRecordTimeout = 5 * time.Second
+
+// returns an error if the client's daily token budget has been used up (no budget = no limit)
+func checkAIBudget(ctx context.Context, clientData *sstore.ClientData) error {
+	if clientData.AIOpts == nil || clientData.AIOpts.DailyTokenBudget <= 0 {
+		return nil
+	}
+	budget := clientData.AIOpts.DailyTokenBudget
+	used, err := sstore.GetAIUsageTokensForDay(ctx, sstore.AIUsageDay(time.Now()))
+	if err != nil {
+		return fmt.Errorf("cannot check AI token budget: %v", err)
+	}
+	if used >= budget {
+		return fmt.Errorf("daily AI token budget exceeded (%d of %d tokens used today), change it with /client:set aidailybudget=N", used, budget)
+	}
+	return nil
+}
+
+// usage is recorded after the request completes (the request context may already be done)
+func recordAIUsage(provider string, model string, screenId string, usage *packet.OpenAIUsageType) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), AIUsageRecordTimeout)
+	defer cancelFn()
+	err := sstore.RecordAIUsage(ctx, provider, model, screenId, usage)
+	if err != nil {
+		log.Printf("error recording ai usage: %v\n", err)
+	}
+}
+
+// picks up the model and usage from a completion's packets
+type aiUsageTracker struct {
+	Model string
+	Usage *packet.OpenAIUsageType
+}
+
+func (t *aiUsageTracker) AddPacket(pk *packet.OpenAIPacketType) {
+	if pk.Model != "" {
+		t.Model = pk.Model
+	}
+	if pk.Usage != nil {
+		t.Usage = pk.Usage
+	}
+}
+
+// forwards the stream, recording the usage once the provider closes it.  the returned stop func must be called
+// when the consumer stops reading (it is safe to call more than once).  after stop (or once ctx is done) packets
+// are no longer forwarded, but the provider's stream is still drained so the provider never blocks and the usage
+// is recorded on every exit path
+func trackAIUsageStream(ctx context.Context, ch chan *packet.OpenAIPacketType, provider string, screenId string) (chan *packet.OpenAIPacketType, func()) {
+	rtn := make(chan *packet.OpenAIPacketType)
+	stopCh := make(chan struct{})
+	var stopOnce sync.Once
+	stopFn := func() {
+		stopOnce.Do(func() { close(stopCh) })
+	}
+	go func() {
+		defer close(rtn)
+		var tracker aiUsageTracker
+		forwarding := true
+		for pk := range ch {
+			tracker.AddPacket(pk)
+			if !forwarding {
+				continue
+			}
+			select {
+			case rtn <- pk:
+			case <-stopCh:
+				forwarding = false
+			case <-ctx.Done():
+				forwarding = false
+			}
+		}
+		recordAIUsage(provider, tracker.Model, screenId, tracker.Usage)
+	}()
+	return rtn, stopFn
+}
+
+type aiUsageTotal struct {
+	Key              string
+	Provider         string
+	Model            string
+	NumRequests      int
+	PromptTokens     int
+	CompletionTokens int
+}
+
+func (t *aiUsageTotal) add(u *sstore.AIUsageType) {
+	t.NumRequests += u.NumRequests
+	t.PromptTokens += u.PromptTokens
+	t.CompletionTokens += u.CompletionTokens
+}
+
+func (t *aiUsageTotal) totalTokens() int {
+	return t.PromptTokens + t.CompletionTokens
+}
+
+func sumAIUsage(usage []*sstore.AIUsageType, keyFn func(u *sstore.AIUsageType) string) []*aiUsageTotal {
+	totalMap := make(map[string]*aiUsageTotal)
+	for _, u := range usage {
+		key := keyFn(u)
+		total := totalMap[key]
+		if total == nil {
+			total = &aiUsageTotal{Key: key, Provider: u.Provider, Model: u.Model}
+			totalMap[key] = total
+		}
+		total.add(u)
+	}
+	var rtn []*aiUsageTotal
+	for _, total := range totalMap {
+		rtn = append(rtn, total)
+	}
+	sort.Slice(rtn, func(i, j int) bool { return rtn[i].Key < rtn[j].Key })
+	return rtn
+}
+
+func resolveAIUsageDay(arg string) (string, error) {
+	day, err := time.ParseInLocation(sstore.AIUsageDayFormat, arg, time.Local)
+	if err != nil {
+		return "", fmt.Errorf("invalid date %q (must be YYYY-MM-DD)", arg)
+	}
+	return sstore.AIUsageDay(day), nil
+}
+
+// /ai:usage [from=YYYY-MM-DD] [to=YYYY-MM-DD] [days=N] [screen=1]
+func AIUsageCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	numDays, err := resolvePosInt(pk.Kwargs["days"], DefaultAIUsageDays)
+	if err != nil || numDays > MaxAIUsageDays {
+		return nil, fmt.Errorf("/%s error: invalid days value %q (must be between 1 and %d)", GetCmdStr(pk), pk.Kwargs["days"], MaxAIUsageDays)
+	}
+	now := time.Now()
+	toDay := sstore.AIUsageDay(now)
+	if pk.Kwargs["to"] != "" {
+		toDay, err = resolveAIUsageDay(pk.Kwargs["to"])
+		if err != nil {
+			return nil, fmt.Errorf("/%s error: %v", GetCmdStr(pk), err)
+		}
+	}
+	toTime, _ := time.ParseInLocation(sstore.AIUsageDayFormat, toDay, time.Local)
+	fromDay := sstore.AIUsageDay(toTime.AddDate(0, 0, -(numDays - 1)))
+	if pk.Kwargs["from"] != "" {
+		fromDay, err = resolveAIUsageDay(pk.Kwargs["from"])
+		if err != nil {
+			return nil, fmt.Errorf("/%s error: %v", GetCmdStr(pk), err)
+		}
+	}
+	if fromDay > toDay {
+		return nil, fmt.Errorf("/%s error: 'from' date %s is after 'to' date %s", GetCmdStr(pk), fromDay, toDay)
+	}
+	screenId := ""
+	if resolveBool(pk.Kwargs["screen"], false) {
+		ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+		if err != nil {
+			return nil, fmt.Errorf("/%s error: %w", GetCmdStr(pk), err)
+		}
+		screenId = ids.ScreenId
+	}
+	usage, err := sstore.GetAIUsage(ctx, fromDay, toDay, screenId)
+	if err != nil {
+		return nil, fmt.Errorf("/%s error: %v", GetCmdStr(pk), err)
+	}
+	prices, err := configstore.ReadAIPrices()
+	if err != nil {
+		return nil, fmt.Errorf("/%s error: %v", GetCmdStr(pk), err)
+	}
+	var buf bytes.Buffer
+	grandTotal := &aiUsageTotal{}
+	var totalCost float64
+	costComplete := true
+	buf.WriteString(fmt.Sprintf("  %-30s %8s %12s %12s %10s\n", "provider/model", "requests", "prompt", "completion", "cost"))
+	for _, total := range sumAIUsage(usage, func(u *sstore.AIUsageType) string { return u.Provider + "/" + u.Model }) {
+		costStr := "-"
+		if price := prices.Lookup(total.Provider, total.Model); price != nil {
+			cost := price.Cost(total.PromptTokens, total.CompletionTokens)
+			totalCost += cost
+			costStr = fmt.Sprintf("$%.4f", cost)
+		} else {
+			costComplete = false
+		}
+		buf.WriteString(fmt.Sprintf("  %-30s %8d %12d %12d %10s\n", total.Key, total.NumRequests, total.PromptTokens, total.CompletionTokens, costStr))
+		grandTotal.NumRequests += total.NumRequests
+		grandTotal.PromptTokens += total.PromptTokens
+		grandTotal.CompletionTokens += total.CompletionTokens
+	}
+	if len(usage) == 0 {
+		buf.WriteString("  (no AI usage)\n")
+	} else {
+		totalCostStr := fmt.Sprintf("$%.4f", totalCost)
+		if !costComplete {
+			totalCostStr = "-"
+			if totalCost > 0 {
+				totalCostStr = fmt.Sprintf(">$%.4f", totalCost)
+			}
+		}
+		buf.WriteString(fmt.Sprintf("  %-30s %8d %12d %12d %10s\n", "total", grandTotal.NumRequests, grandTotal.PromptTokens, grandTotal.CompletionTokens, totalCostStr))
+		buf.WriteString("\n")
+		buf.WriteString(fmt.Sprintf("  %-30s %8s %12s\n", "day", "requests", "tokens"))
+		for _, total := range sumAIUsage(usage, func(u *sstore.AIUsageType) string { return u.Day }) {
+			buf.WriteString(fmt.Sprintf("  %-30s %8d %12d\n", total.Key, total.NumRequests, total.totalTokens()))
+		}
+	}
+	buf.WriteString("\n")
+	clientData, err := sstore.EnsureClientData(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot retrieve client data: %v", err)
+	}
+	usedToday, err := sstore.GetAIUsageTokensForDay(ctx, sstore.AIUsageDay(now))
+	if err != nil {
+		return nil, fmt.Errorf("/%s error: %v", GetCmdStr(pk), err)
+	}
+	if clientData.AIOpts != nil && clientData.AIOpts.DailyTokenBudget > 0 {
+		buf.WriteString(fmt.Sprintf("  today: %d of %d token budget used (all screens)\n", usedToday, clientData.AIOpts.DailyTokenBudget))
+	} else {
+		buf.WriteString(fmt.Sprintf("  today: %d tokens used (all screens), no daily budget set (/client:set aidailybudget=N)\n", usedToday))
+	}
+	if prices == nil {
+		buf.WriteString(fmt.Sprintf("  no price table, add one at %s to estimate costs\n", configstore.GetAIPricesPath()))
+	}
+	title := fmt.Sprintf("AI usage %s to %s", fromDay, toDay)
+	if screenId != "" {
+		title += " (this screen)"
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{
+		InfoTitle: title,
+		InfoLines: splitLinesForInfo(buf.String()),
+	})
+	return update, nil
+}
@@ -7,7 +7,6 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"io/fs"
@@ -31,13 +30,16 @@ import (
 	"github.com/abhishek944/waveterm/waveshell/pkg/shexec"
 	"github.com/abhishek944/waveterm/waveshell/pkg/utilfn"
 	"github.com/abhishek944/waveterm/wavesrv/pkg/bookmarks"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/bundle"
 	"github.com/abhishek944/waveterm/wavesrv/pkg/comp"
 	"github.com/abhishek944/waveterm/wavesrv/pkg/dbutil"
 	"github.com/abhishek944/waveterm/wavesrv/pkg/ephemeral"
 	"github.com/abhishek944/waveterm/wavesrv/pkg/history"
 	"github.com/abhishek944/waveterm/wavesrv/pkg/pcloud"
 	"github.com/abhishek944/waveterm/wavesrv/pkg/releasechecker"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/prompts"
 	"github.com/abhishek944/waveterm/wavesrv/pkg/remote"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/remote/aiprovider"
 	"github.com/abhishek944/waveterm/wavesrv/pkg/remote/openai"
 	"github.com/abhishek944/waveterm/wavesrv/pkg/rtnstate"
 	"github.com/abhishek944/waveterm/wavesrv/pkg/scbase"
@@ -99,14 +101,14 @@ const (
 var ColorNames = []string{"yellow", "blue", "pink", "mint", "cyan", "violet", "orange", "green", "red", "white"}
 var TabIcons = []string{"square", "sparkle", "fire", "ghost", "cloud", "compass", "crown", "droplet", "graduation-cap", "heart", "file"}
 var RemoteColorNames = []string{"red", "green", "yellow", "blue", "magenta", "cyan", "white", "orange"}
-var RemoteSetArgs = []string{"alias", "connectmode", "key", "password", "autoinstall", "color"}
+var RemoteSetArgs = []string{"alias", "connectmode", "key", "password", "agentforward", "autoinstall", "color"}
 var ConfirmFlags = []string{"hideshellprompt"}
 var SidebarNames = []string{"main"}
 var ThemeSources = []string{"light", "dark", "system"}
 
 var ScreenCmds = []string{"run", "comment", "cd", "cr", "clear", "sw", "reset", "signal", "chat"}
 var NoHistCmds = []string{"_compgen", "line", "history", "_killserver"}
-var GlobalCmds = []string{"session", "screen", "remote", "set", "client", "telemetry", "bookmark", "bookmarks"}
+var GlobalCmds = []string{"session", "screen", "remote", "set", "client", "telemetry", "bookmark", "bookmarks", "playbook"}
 
 var SetVarNameMap map[string]string = map[string]string{
 	"tabcolor": "screen.tabcolor",
@@ -141,6 +143,7 @@ type contextType string
 
 var historyContextKey = contextType("history")
 var depthContextKey = contextType("depth")
+var lineStateContextKey = contextType("linestate")
 
 type SetVarScope struct {
 	ScopeName string
@@ -165,6 +168,7 @@ var MetaCmdFnMap = make(map[string]MetaCmdEntryType)
 
 func init() {
 	registerCmdFn("run", RunCommand)
+	registerCmdFn("run:all", RunAllCommand)
 	registerCmdFn("eval", EvalCommand)
 	registerCmdFn("comment", CommentCommand)
 	registerCmdFn("cr", CrCommand)
@@ -217,6 +221,10 @@ func init() {
 	registerCmdFn("remote:installcancel", RemoteInstallCancelCommand)
 	registerCmdFn("remote:reset", RemoteResetCommand)
 	registerCmdFn("remote:parse", RemoteConfigParseCommand)
+	registerCmdFn("remote:forward", RemoteForwardCommand)
+	registerCmdFn("remote:unforward", RemoteUnforwardCommand)
+	registerCmdFn("remote:hostkey", RemoteHostKeyCommand)
+	registerCmdFn("remote:group", RemoteGroupCommand)
 
 	registerCmdFn("copyfile", CopyFileCommand)
 
@@ -234,6 +242,7 @@ func init() {
 	registerCmdFn("line:set", LineSetCommand)
 	registerCmdFn("line:restart", LineRestartCommand)
 	registerCmdFn("line:minimize", LineMinimizeCommand)
+	registerCmdFn("line:explain", LineExplainCommand)
 
 	registerCmdFn("client", ClientCommand)
 	registerCmdFn("client:show", ClientShowCommand)
@@ -263,14 +272,35 @@ func init() {
 	registerCmdFn("history", HistoryCommand)
 	registerCmdFn("history:viewall", HistoryViewAllCommand)
 	registerCmdFn("history:purge", HistoryPurgeCommand)
+	registerCmdFn("history:import", HistoryImportCommand)
+	registerCmdFn("history:export", HistoryExportCommand)
+	registerCmdFn("history:tag", HistoryTagCommand)
+	registerCmdFn("history:untag", HistoryUntagCommand)
+	registerCmdFn("history:note", HistoryNoteCommand)
+	registerCmdFn("history:retention", HistoryRetentionCommand)
 
 	registerCmdFn("bookmarks:show", BookmarksShowCommand)
+	registerCmdFn("bookmarks:export", BookmarksExportCommand)
+	registerCmdFn("bookmarks:import", BookmarksImportCommand)
 
 	registerCmdFn("bookmark:set", BookmarkSetCommand)
 	registerCmdFn("bookmark:delete", BookmarkDeleteCommand)
 
+	registerCmdFn("playbook:new", PlaybookNewCommand)
+	registerCmdFn("playbook:add", PlaybookAddCommand)
+	registerCmdFn("playbook:remove", PlaybookRemoveCommand)
+	registerCmdFn("playbook:show", PlaybookShowCommand)
+	registerCmdFn("playbook:showall", PlaybookShowAllCommand)
+	registerCmdFn("playbook:run", PlaybookRunCommand)
+	registerCmdFn("playbook:export", PlaybookExportCommand)
+	registerCmdFn("playbook:import", PlaybookImportCommand)
+
 	// registerCmdFn("chat", OpenAICommand)
 	registerCmdFn("agent", AgentCommand)
+	registerCmdFn("agent:history", AgentHistoryCommand)
+	registerCmdFn("agent:resume", AgentResumeCommand)
+	registerCmdFn("agent:delete", AgentDeleteCommand)
+	registerCmdFn("ai:usage", AIUsageCommand)
 
 	registerCmdFn("_killserver", KillServerCommand)
 	registerCmdFn("_dumpstate", DumpStateCommand)
@@ -300,6 +330,8 @@ func init() {
 
 	registerCmdFn("autocomplete:on", AutocompleteOnCommand)
 	registerCmdFn("autocomplete:off", AutocompleteOffCommand)
+
+	remote.CmdFailedHandler = autoExplainFailedCmd
 }
 
 func getValidCommands() []string {
@@ -382,7 +414,7 @@ func resolveShellType(shellArg string, defaultShell string) (string, error) {
 			shellArg = defaultShell
 		}
 	}
-	if shellArg != packet.ShellType_bash && shellArg != packet.ShellType_zsh {
+	if shellArg != packet.ShellType_bash && shellArg != packet.ShellType_zsh && shellArg != packet.ShellType_fish {
 		return "", fmt.Errorf("invalid shell type %q", shellArg)
 	}
 	return shellArg, nil
@@ -677,6 +709,11 @@ func RunCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.Up
 	if langArg != "" {
 		lineState[sstore.LineState_Lang] = langArg
 	}
+	if extraState, ok := ctx.Value(lineStateContextKey).(map[string]any); ok {
+		for key, val := range extraState {
+			lineState[key] = val
+		}
+	}
 
 	// If we are running an ephemeral command, we don't want to add the line to the screen
 	if pk.EphemeralOpts == nil {
@@ -1011,6 +1048,11 @@ func ScreenSetCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sc
 		varsUpdated = append(varsUpdated, "pos")
 		setNonAnchor = true
 	}
+	if pk.Kwargs["autoexplain"] != "" {
+		updateMap[sstore.ScreenField_AutoExplain] = resolveBool(pk.Kwargs["autoexplain"], false)
+		varsUpdated = append(varsUpdated, "autoexplain")
+		setNonAnchor = true
+	}
 	if pk.Kwargs["focus"] != "" {
 		focusVal := pk.Kwargs["focus"]
 		if focusVal != sstore.ScreenFocusInput && focusVal != sstore.ScreenFocusCmd {
@@ -1056,7 +1098,7 @@ func ScreenSetCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sc
 		}
 	}
 	if len(varsUpdated) == 0 {
-		return nil, fmt.Errorf("/screen:set no updates, can set %s", formatStrs([]string{"name", "pos", "tabcolor", "tabicon", "focus", "anchor", "line", "sharename"}, "or", false))
+		return nil, fmt.Errorf("/screen:set no updates, can set %s", formatStrs([]string{"name", "pos", "tabcolor", "tabicon", "focus", "anchor", "line", "sharename", "autoexplain"}, "or", false))
 	}
 	screen, err := sstore.UpdateScreen(ctx, ids.ScreenId, updateMap)
 	if err != nil {
@@ -1281,6 +1323,7 @@ func deferWriteCmdStatus(ctx context.Context, cmd *sstore.CmdType, startTime tim
 		update.AddUpdate(*screen)
 	}
 	scbus.MainUpdateBus.DoScreenUpdate(cmd.ScreenId, update)
+	history.GoIndexCmdOutput(cmd.ScreenId, cmd.LineId)
 }
 
 func checkForWriteReady(ctx context.Context, iter *packet.RpcResponseIter) (string, error) {
@@ -1970,8 +2013,8 @@ func parseRemoteEditArgs(isNew bool, pk *scpacket.FeCommandPacketType, isLocal b
 	if pk.Kwargs["shellpref"] != "" {
 		shellPref = pk.Kwargs["shellpref"]
 	}
-	if shellPref != "" && shellPref != packet.ShellType_bash && shellPref != packet.ShellType_zsh && shellPref != sstore.ShellTypePref_Detect {
-		return nil, fmt.Errorf("invalid shellpref %q, must be %s", shellPref, formatStrs([]string{packet.ShellType_bash, packet.ShellType_zsh, sstore.ShellTypePref_Detect}, "or", false))
+	if shellPref != "" && shellPref != packet.ShellType_bash && shellPref != packet.ShellType_zsh && shellPref != packet.ShellType_fish && shellPref != sstore.ShellTypePref_Detect {
+		return nil, fmt.Errorf("invalid shellpref %q, must be %s", shellPref, formatStrs([]string{packet.ShellType_bash, packet.ShellType_zsh, packet.ShellType_fish, sstore.ShellTypePref_Detect}, "or", false))
 	}
 	var connectMode string
 	if isNew {
@@ -1981,7 +2024,7 @@ func parseRemoteEditArgs(isNew bool, pk *scpacket.FeCommandPacketType, isLocal b
 		connectMode = pk.Kwargs["connectmode"]
 	}
 	if connectMode != "" && !sstore.IsValidConnectMode(connectMode) {
-		err := fmt.Errorf("invalid connectmode %q: valid modes are %s", connectMode, formatStrs([]string{sstore.ConnectModeStartup, sstore.ConnectModeAuto, sstore.ConnectModeManual}, "or", false))
+		err := fmt.Errorf("invalid connectmode %q: valid modes are %s", connectMode, formatStrs([]string{sstore.ConnectModeStartup, sstore.ConnectModeAuto, sstore.ConnectModeManual, sstore.ConnectModeReconnect}, "or", false))
 		return nil, err
 	}
 	keyFile, err := resolveFile(pk.Kwargs["key"])
@@ -1996,9 +2039,19 @@ func parseRemoteEditArgs(isNew bool, pk *scpacket.FeCommandPacketType, isLocal b
 		}
 	}
 	sshPassword := pk.Kwargs["password"]
+	sshJump := strings.TrimSpace(pk.Kwargs["jump"])
+	if sshJump != "" {
+		_, err := remote.ParseProxyJump(sshJump)
+		if err != nil {
+			return nil, fmt.Errorf("invalid jump %q: %v", sshJump, err)
+		}
+	}
+	agentForward := resolveBool(pk.Kwargs["agentforward"], false)
 	if sshOpts != nil {
 		sshOpts.SSHIdentity = keyFile
 		sshOpts.SSHPassword = sshPassword
+		sshOpts.SSHJump = sshJump
+		sshOpts.SSHAgentForward = agentForward
 	}
 
 	// set up editmap
@@ -2027,6 +2080,18 @@ func parseRemoteEditArgs(isNew bool, pk *scpacket.FeCommandPacketType, isLocal b
 		}
 		editMap[sstore.RemoteField_SSHPassword] = sshPassword
 	}
+	if _, found := pk.Kwargs["jump"]; found {
+		if isLocal {
+			return nil, fmt.Errorf("Cannot edit jump hosts for 'local' remote")
+		}
+		editMap[sstore.RemoteField_SSHJump] = sshJump
+	}
+	if _, found := pk.Kwargs["agentforward"]; found {
+		if isLocal {
+			return nil, fmt.Errorf("Cannot edit agent forwarding for 'local' remote")
+		}
+		editMap[sstore.RemoteField_SSHAgentForward] = agentForward
+	}
 	if _, found := pk.Kwargs["shellpref"]; found {
 		editMap[sstore.RemoteField_ShellPref] = shellPref
 	}
@@ -2049,6 +2114,15 @@ func RemoteNewCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sc
 	if visualEdit && !isSubmitted && len(pk.Args) == 0 {
 		return makeRemoteEditUpdate_new(nil), nil
 	}
+	if remoteType := pk.Kwargs["type"]; remoteType != "" && remoteType != sstore.RemoteTypeSsh {
+		if remoteType == sstore.RemoteTypeSocket {
+			return remoteNewSocketCommand(ctx, pk)
+		}
+		if !sstore.IsExecRemoteType(remoteType) {
+			return nil, fmt.Errorf("/remote:new invalid type %q, must be %s", remoteType, formatStrs([]string{sstore.RemoteTypeSsh, sstore.RemoteTypeDocker, sstore.RemoteTypeKubectl, sstore.RemoteTypeSocket}, "or", false))
+		}
+		return remoteNewExecCommand(ctx, pk, remoteType)
+	}
 	editArgs, err := parseRemoteEditArgs(true, pk, false)
 	if err != nil {
 		return nil, fmt.Errorf("/remote:new %v", err)
@@ -2084,6 +2158,9 @@ func RemoteSetCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sc
 	}
 	visualEdit := resolveBool(pk.Kwargs["visual"], false)
 	isSubmitted := resolveBool(pk.Kwargs["submit"], false)
+	if _, found := pk.Kwargs["agentforward"]; found && ids.Remote.RemoteCopy.RemoteType != sstore.RemoteTypeSsh {
+		return nil, fmt.Errorf("/remote:set agentforward is only valid for ssh remotes")
+	}
 	editArgs, err := parseRemoteEditArgs(false, pk, ids.Remote.Waveshell.IsLocal())
 	if err != nil {
 		return makeRemoteEditErrorReturn_edit(ids, visualEdit, fmt.Errorf("/remote:new %v", err))
@@ -2137,30 +2214,13 @@ func RemoteShowAllCommand(ctx context.Context, pk *scpacket.FeCommandPacketType)
 	return update, nil
 }
 
-func resolveSshConfigPatterns(configFiles []string) ([]string, error) {
+func resolveSshConfigPatterns(sshConfigs []*ssh_config.Config) ([]string, error) {
 	// using two separate containers to track order and have O(1) lookups
 	// since go does not have an ordered map primitive
 	var discoveredPatterns []string
 	alreadyUsed := make(map[string]bool)
 	alreadyUsed[""] = true // this excludes the empty string from potential alias
-	var openedFiles []fs.File
-
-	defer func() {
-		for _, openedFile := range openedFiles {
-			openedFile.Close()
-		}
-	}()
-
-	var errs []error
-	for _, configFile := range configFiles {
-		fd, openErr := os.Open(configFile)
-		openedFiles = append(openedFiles, fd)
-		if fd == nil {
-			errs = append(errs, openErr)
-			continue
-		}
-
-		cfg, _ := ssh_config.Decode(fd)
+	for _, cfg := range sshConfigs {
 		for _, host := range cfg.Hosts {
 			// for each host, find the first good alias
 			for _, hostPattern := range host.Patterns {
@@ -2173,10 +2233,6 @@ func resolveSshConfigPatterns(configFiles []string) ([]string, error) {
 			}
 		}
 	}
-	if len(errs) == len(configFiles) {
-		errs = append([]error{fmt.Errorf("no ssh config files could be opened:\n")}, errs...)
-		return nil, errors.Join(errs...)
-	}
 	if len(discoveredPatterns) == 0 {
 		return nil, fmt.Errorf("no compatible hostnames found in ssh config files")
 	}
@@ -2236,8 +2292,8 @@ func createSshImportSummary(changeList map[string][]string) string {
 	return fmt.Sprintf("%d connection%s changed:\n\n%s", totalNumChanges, pluralize, strings.Join(outMsgs, "\n\n"))
 }
 
-func NewHostInfo(hostName string) (*HostInfoType, error) {
-	userName, _ := ssh_config.GetStrict(hostName, "User")
+func NewHostInfo(hostName string, sshConfigs []*ssh_config.Config) (*HostInfoType, error) {
+	userName := getSshConfigValue(sshConfigs, hostName, "User")
 	var canonicalName string
 	if userName != "" {
 		canonicalName = userName + "@" + hostName
@@ -2251,7 +2307,7 @@ func NewHostInfo(hostName string) (*HostInfoType, error) {
 		return nil, fmt.Errorf("could not parse \"%s\" - %s did not fit user@host requirement", hostName, canonicalName)
 	}
 
-	portStr, _ := ssh_config.GetStrict(hostName, "Port")
+	portStr := getSshConfigValue(sshConfigs, hostName, "Port")
 	var portVal int
 	if portStr != "" && portStr != "22" {
 		canonicalName = canonicalName + ":" + portStr
@@ -2265,10 +2321,10 @@ func NewHostInfo(hostName string) (*HostInfoType, error) {
 			return nil, fmt.Errorf("could not parse port \"%d\": number is not valid for a port", portVal)
 		}
 	}
-	identityFile, _ := ssh_config.GetStrict(hostName, "IdentityFile")
-	passwordAuth, _ := ssh_config.GetStrict(hostName, "PasswordAuthentication")
+	identityFile := getSshConfigValue(sshConfigs, hostName, "IdentityFile")
+	passwordAuth := getSshConfigValue(sshConfigs, hostName, "PasswordAuthentication")
 
-	cfgWaveOptionsStr, _ := ssh_config.GetStrict(hostName, "WaveOptions")
+	cfgWaveOptionsStr := getSshConfigValue(sshConfigs, hostName, "WaveOptions")
 	cfgWaveOptionsStr = strings.ToLower(cfgWaveOptionsStr)
 	cfgWaveOptions := make(map[string]string)
 	setBracketArgs(cfgWaveOptions, cfgWaveOptionsStr)
@@ -2293,6 +2349,8 @@ func NewHostInfo(hostName string) (*HostInfoType, error) {
 		shellPref = "bash"
 	} else if cfgWaveOptions["shellpref"] == "zsh" {
 		shellPref = "zsh"
+	} else if cfgWaveOptions["shellpref"] == "fish" {
+		shellPref = "fish"
 	}
 
 	outHostInfo := new(HostInfoType)
@@ -2307,25 +2365,25 @@ func NewHostInfo(hostName string) (*HostInfoType, error) {
 	return outHostInfo, nil
 }
 
-func RemoteConfigParseCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
-	home := base.GetHomeDir()
-	localConfig := filepath.Join(home, ".ssh", "config")
-	systemConfig := filepath.Join("/etc", "ssh", "config")
-	sshConfigFiles := []string{localConfig, systemConfig}
-	ssh_config.ReloadConfigs()
-	hostPatterns, hostPatternsErr := resolveSshConfigPatterns(sshConfigFiles)
+// computes the changes /remote:parse would make (also returns the parsed config files)
+func planSshConfigImport(ctx context.Context) (*sshImportPlanType, *sshConfigSet, error) {
+	configSet, err := loadSshConfigSet(getSshConfigFiles())
+	if err != nil {
+		return nil, nil, err
+	}
+	hostPatterns, hostPatternsErr := resolveSshConfigPatterns(configSet.Configs)
 	if hostPatternsErr != nil {
-		return nil, hostPatternsErr
+		return nil, configSet, hostPatternsErr
 	}
 	previouslyImportedRemotes, dbQueryErr := sstore.GetAllImportedRemotes(ctx)
 	if dbQueryErr != nil {
-		return nil, dbQueryErr
+		return nil, configSet, dbQueryErr
 	}
 
 	var parsedHostData []*HostInfoType
 	hostInfoInConfig := make(map[string]*HostInfoType)
 	for _, hostPattern := range hostPatterns {
-		hostInfo, hostInfoErr := NewHostInfo(hostPattern)
+		hostInfo, hostInfoErr := NewHostInfo(hostPattern, configSet.Configs)
 		if hostInfoErr != nil {
 			log.Printf("sshconfig-import: %s", hostInfoErr)
 			continue
@@ -2334,24 +2392,18 @@ func RemoteConfigParseCommand(ctx context.Context, pk *scpacket.FeCommandPacketT
 		hostInfoInConfig[hostInfo.CanonicalName] = hostInfo
 	}
 
-	remoteChangeList := make(map[string][]string)
-
+	plan := &sshImportPlanType{}
 	// remove all previously imported remotes that
 	// no longer have a canonical pattern in the config files
 	for importedRemoteCanonicalName, importedRemote := range previouslyImportedRemotes {
-		var err error
 		hostInfo := hostInfoInConfig[importedRemoteCanonicalName]
 		if !importedRemote.Archived && (hostInfo == nil || hostInfo.Ignore) {
-			err = remote.ArchiveRemote(ctx, importedRemote.RemoteId)
-			if err != nil {
-				remoteChangeList["deleteErr"] = append(remoteChangeList["deleteErr"], importedRemote.RemoteCanonicalName)
-				log.Printf("sshconfig-import: failed to remove remote \"%s\" (%s)\n", importedRemote.RemoteAlias, importedRemote.RemoteCanonicalName)
-			} else {
-				remoteChangeList["delete"] = append(remoteChangeList["delete"], importedRemote.RemoteCanonicalName)
-				log.Printf("sshconfig-import: archived remote \"%s\" (%s)\n", importedRemote.RemoteAlias, importedRemote.RemoteCanonicalName)
-			}
+			plan.Archive = append(plan.Archive, importedRemote)
 		}
 	}
+	sort.Slice(plan.Archive, func(i, j int) bool {
+		return plan.Archive[i].RemoteCanonicalName < plan.Archive[j].RemoteCanonicalName
+	})
 
 	for _, hostInfo := range parsedHostData {
 		previouslyImportedRemote := previouslyImportedRemotes[hostInfo.CanonicalName]
@@ -2362,72 +2414,145 @@ func RemoteConfigParseCommand(ctx context.Context, pk *scpacket.FeCommandPacketT
 		if previouslyImportedRemote != nil && !previouslyImportedRemote.Archived {
 			// this already existed and was created via import
 			// it needs to be updated instead of created
-			editMap := make(map[string]interface{})
-			editMap[sstore.RemoteField_Alias] = hostInfo.Host
-			editMap[sstore.RemoteField_ConnectMode] = hostInfo.ConnectMode
-			if hostInfo.SshKeyFile != "" {
-				editMap[sstore.RemoteField_SSHKey] = hostInfo.SshKeyFile
-			}
-			editMap[sstore.RemoteField_ShellPref] = hostInfo.ShellPref
 			wsh := remote.GetRemoteById(previouslyImportedRemote.RemoteId)
 			if wsh == nil {
-				remoteChangeList["updateErr"] = append(remoteChangeList["updateErr"], hostInfo.CanonicalName)
 				log.Printf("strange, wsh for remote %s [%s] not found\n", hostInfo.CanonicalName, previouslyImportedRemote.RemoteId)
 				continue
 			}
-
-			if wsh.Remote.ConnectMode == hostInfo.ConnectMode && wsh.Remote.SSHOpts.SSHIdentity == hostInfo.SshKeyFile && wsh.Remote.RemoteAlias == hostInfo.Host && wsh.Remote.ShellPref == hostInfo.ShellPref {
-				// silently skip this one. it didn't fail, but no changes were needed
-				continue
+			rcopy := wsh.GetRemoteCopy()
+			var changes []string
+			if rcopy.RemoteAlias != hostInfo.Host {
+				changes = append(changes, fmt.Sprintf("alias %q -> %q", rcopy.RemoteAlias, hostInfo.Host))
 			}
-
-			err := wsh.UpdateRemote(ctx, editMap)
-			if err != nil {
-				remoteChangeList["updateErr"] = append(remoteChangeList["updateErr"], hostInfo.CanonicalName)
-				log.Printf("error updating remote[%s]: %v\n", hostInfo.CanonicalName, err)
-				continue
-			}
-			remoteChangeList["update"] = append(remoteChangeList["update"], hostInfo.CanonicalName)
-			log.Printf("sshconfig-import: found previously imported remote with canonical name \"%s\": it has been updated\n", hostInfo.CanonicalName)
-		} else {
-			sshOpts := &sstore.SSHOpts{
-				Local:   false,
-				SSHHost: hostInfo.Host,
-				SSHUser: hostInfo.User,
-				IsSudo:  false,
-				SSHPort: hostInfo.Port,
+			if rcopy.ConnectMode != hostInfo.ConnectMode {
+				changes = append(changes, fmt.Sprintf("connectmode %s -> %s", rcopy.ConnectMode, hostInfo.ConnectMode))
 			}
-			if hostInfo.SshKeyFile != "" {
-				sshOpts.SSHIdentity = hostInfo.SshKeyFile
+			// the import never clears a key (a config without a usable IdentityFile keeps the remote's key)
+			if hostInfo.SshKeyFile != "" && rcopy.SSHOpts.SSHIdentity != hostInfo.SshKeyFile {
+				changes = append(changes, fmt.Sprintf("key %q -> %q", rcopy.SSHOpts.SSHIdentity, hostInfo.SshKeyFile))
 			}
-
-			// this is new and must be created for the first time
-			r := &sstore.RemoteType{
-				RemoteId:            scbase.GenWaveUUID(),
-				RemoteType:          sstore.RemoteTypeSsh,
-				RemoteAlias:         hostInfo.Host,
-				RemoteCanonicalName: hostInfo.CanonicalName,
-				RemoteUser:          hostInfo.User,
-				RemoteHost:          hostInfo.Host,
-				ConnectMode:         hostInfo.ConnectMode,
-				AutoInstall:         true,
-				SSHOpts:             sshOpts,
-				SSHConfigSrc:        sstore.SSHConfigSrcTypeImport,
-				ShellPref:           sstore.ShellTypePref_Detect,
+			if rcopy.ShellPref != hostInfo.ShellPref {
+				changes = append(changes, fmt.Sprintf("shellpref %s -> %s", rcopy.ShellPref, hostInfo.ShellPref))
 			}
-			err := remote.AddRemote(ctx, r, false)
-			if err != nil {
-				remoteChangeList["createErr"] = append(remoteChangeList["createErr"], hostInfo.CanonicalName)
-				log.Printf("sshconfig-import: failed to add remote \"%s\" (%s): it is being skipped\n", hostInfo.Host, hostInfo.CanonicalName)
+			if len(changes) == 0 {
+				// silently skip this one. it didn't fail, but no changes were needed
 				continue
 			}
-			remoteChangeList["create"] = append(remoteChangeList["create"], hostInfo.CanonicalName)
-			log.Printf("sshconfig-import: created remote \"%s\" (%s)\n", hostInfo.Host, hostInfo.CanonicalName)
+			plan.Update = append(plan.Update, &sshImportUpdateType{HostInfo: hostInfo, Remote: previouslyImportedRemote, Changes: changes})
+		} else {
+			plan.Create = append(plan.Create, hostInfo)
 		}
 	}
+	return plan, configSet, nil
+}
 
-	outMsg := createSshImportSummary(remoteChangeList)
+func applySshConfigImport(ctx context.Context, plan *sshImportPlanType) map[string][]string {
+	remoteChangeList := make(map[string][]string)
+	for _, importedRemote := range plan.Archive {
+		err := remote.ArchiveRemote(ctx, importedRemote.RemoteId)
+		if err != nil {
+			remoteChangeList["deleteErr"] = append(remoteChangeList["deleteErr"], importedRemote.RemoteCanonicalName)
+			log.Printf("sshconfig-import: failed to remove remote \"%s\" (%s)\n", importedRemote.RemoteAlias, importedRemote.RemoteCanonicalName)
+		} else {
+			remoteChangeList["delete"] = append(remoteChangeList["delete"], importedRemote.RemoteCanonicalName)
+			log.Printf("sshconfig-import: archived remote \"%s\" (%s)\n", importedRemote.RemoteAlias, importedRemote.RemoteCanonicalName)
+		}
+	}
+	for _, update := range plan.Update {
+		hostInfo := update.HostInfo
+		editMap := make(map[string]interface{})
+		editMap[sstore.RemoteField_Alias] = hostInfo.Host
+		editMap[sstore.RemoteField_ConnectMode] = hostInfo.ConnectMode
+		if hostInfo.SshKeyFile != "" {
+			editMap[sstore.RemoteField_SSHKey] = hostInfo.SshKeyFile
+		}
+		editMap[sstore.RemoteField_ShellPref] = hostInfo.ShellPref
+		wsh := remote.GetRemoteById(update.Remote.RemoteId)
+		if wsh == nil {
+			remoteChangeList["updateErr"] = append(remoteChangeList["updateErr"], hostInfo.CanonicalName)
+			log.Printf("strange, wsh for remote %s [%s] not found\n", hostInfo.CanonicalName, update.Remote.RemoteId)
+			continue
+		}
+		err := wsh.UpdateRemote(ctx, editMap)
+		if err != nil {
+			remoteChangeList["updateErr"] = append(remoteChangeList["updateErr"], hostInfo.CanonicalName)
+			log.Printf("error updating remote[%s]: %v\n", hostInfo.CanonicalName, err)
+			continue
+		}
+		remoteChangeList["update"] = append(remoteChangeList["update"], hostInfo.CanonicalName)
+		log.Printf("sshconfig-import: found previously imported remote with canonical name \"%s\": it has been updated\n", hostInfo.CanonicalName)
+	}
+	for _, hostInfo := range plan.Create {
+		sshOpts := &sstore.SSHOpts{
+			Local:   false,
+			SSHHost: hostInfo.Host,
+			SSHUser: hostInfo.User,
+			IsSudo:  false,
+			SSHPort: hostInfo.Port,
+		}
+		if hostInfo.SshKeyFile != "" {
+			sshOpts.SSHIdentity = hostInfo.SshKeyFile
+		}
+
+		// this is new and must be created for the first time
+		r := &sstore.RemoteType{
+			RemoteId:            scbase.GenWaveUUID(),
+			RemoteType:          sstore.RemoteTypeSsh,
+			RemoteAlias:         hostInfo.Host,
+			RemoteCanonicalName: hostInfo.CanonicalName,
+			RemoteUser:          hostInfo.User,
+			RemoteHost:          hostInfo.Host,
+			ConnectMode:         hostInfo.ConnectMode,
+			AutoInstall:         true,
+			SSHOpts:             sshOpts,
+			SSHConfigSrc:        sstore.SSHConfigSrcTypeImport,
+			ShellPref:           sstore.ShellTypePref_Detect,
+		}
+		err := remote.AddRemote(ctx, r, false)
+		if err != nil {
+			remoteChangeList["createErr"] = append(remoteChangeList["createErr"], hostInfo.CanonicalName)
+			log.Printf("sshconfig-import: failed to add remote \"%s\" (%s): it is being skipped\n", hostInfo.Host, hostInfo.CanonicalName)
+			continue
+		}
+		remoteChangeList["create"] = append(remoteChangeList["create"], hostInfo.CanonicalName)
+		log.Printf("sshconfig-import: created remote \"%s\" (%s)\n", hostInfo.Host, hostInfo.CanonicalName)
+	}
+	return remoteChangeList
+}
+
+// /remote:parse [dryrun=1] [force=1] [visual=1]
+// shows the changes (and asks before applying them), dryrun=1 only shows them, force=1 applies without asking
+func RemoteConfigParseCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ssh_config.ReloadConfigs()
+	plan, configSet, err := planSshConfigImport(ctx)
+	if configSet != nil {
+		// files may have been added (or Included) since the watcher started
+		updateSshConfigWatcher(configSet)
+	}
+	if err != nil {
+		return nil, err
+	}
 	visualEdit := resolveBool(pk.Kwargs["visual"], false)
+	if resolveBool(pk.Kwargs["dryrun"], false) {
+		update := scbus.MakeUpdatePacket()
+		update.AddUpdate(sstore.InfoMsgType{
+			InfoTitle: "ssh config import (dry run, + add, ~ update, - archive)",
+			InfoLines: splitLinesForInfo(plan.Diff()),
+		})
+		return update, nil
+	}
+	if !plan.IsEmpty() && !resolveBool(pk.Kwargs["force"], false) {
+		confirmed, err := confirmSshConfigImport(ctx, plan, "SSH Config Import")
+		if err != nil {
+			return nil, fmt.Errorf("/remote:parse error: %v", err)
+		}
+		if !confirmed {
+			return sstore.InfoMsgUpdate("ssh config import canceled, no changes made"), nil
+		}
+	}
+	remoteChangeList := applySshConfigImport(ctx, plan)
+
+	outMsg := createSshImportSummary(remoteChangeList)
 	if visualEdit {
 		update := scbus.MakeUpdatePacket()
 		update.AddUpdate(sstore.AlertMessageType{
@@ -2589,7 +2714,7 @@ func GetFullRemoteDisplayName(rptr *sstore.RemotePtrType, rstate *remote.RemoteR
 }
 
 func writeErrorToPty(cmd *sstore.CmdType, errStr string, outputPos int64) {
-	errPk := openai.CreateErrorPacket(errStr)
+	errPk := aiprovider.CreateErrorPacket(errStr)
 	errBytes, err := packet.MarshalPacket(errPk)
 	if err != nil {
 		log.Printf("error writing error packet to openai response: %v\n", err)
@@ -2734,6 +2859,28 @@ func AgentCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.
 	
 	// Get provider from UI (defaults to empty string to use configured default)
 	provider := pk.Kwargs["provider"]
+	if provider == "" {
+		provider, err = GetAIProvider(clientData)
+		if err != nil {
+			return nil, fmt.Errorf("agent error: %v", err)
+		}
+	}
+	err = checkAIBudget(ctx, clientData)
+	if err != nil {
+		return nil, fmt.Errorf("agent error: %v", err)
+	}
+
+	// tool calling is opt-in, every tool call still requires user approval
+	var toolProvider aiprovider.ToolCallingProvider
+	if resolveBool(pk.Kwargs["tools"], false) {
+		if ids.Remote == nil {
+			return nil, fmt.Errorf("agent error, tools require a connection")
+		}
+		toolProvider, err = aiprovider.GetToolCallingProvider(provider)
+		if err != nil {
+			return nil, fmt.Errorf("agent error: %v", err)
+		}
+	}
 	
 	// Get terminal options
 	ptermVal := defaultStr(pk.Kwargs["wterm"], DefaultPTERM)
@@ -2765,52 +2912,90 @@ func AgentCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.
 		return nil, fmt.Errorf("cannot create ptyout file for agent command: %w", err)
 	}
 	
-	// Add agent mode line
-	line, err := sstore.AddAgentModeLine(ctx, ids.ScreenId, DefaultUserId, cmd)
+	// gather terminal context before the agent line becomes the selected line
+	contextStr, err := buildAgentContext(ctx, pk, ids, clientData)
 	if err != nil {
-		return nil, fmt.Errorf("cannot add new line: %v", err)
+		return nil, fmt.Errorf("agent error: %v", err)
+	}
+
+	// conversations are persisted, /agent:resume (or thread=) continues an existing thread
+	threadId := defaultStr(pk.Kwargs["thread"], sstore.ScreenMemGetAgentThread(ids.ScreenId))
+	if threadId == AgentThreadNone {
+		threadId = ""
+	}
+
+	// Add agent mode line, the line and its thread are created in one transaction so a failure can't orphan either
+	var line *sstore.LineType
+	var threadRec *agentThreadRecorder
+	var threadPrompt []packet.OpenAIPromptMessageType
+	txErr := sstore.WithTx(ctx, func(tx *sstore.TxWrap) error {
+		var err error
+		line, err = sstore.AddAgentModeLine(tx.Context(), ids.ScreenId, DefaultUserId, cmd)
+		if err != nil {
+			return fmt.Errorf("cannot add new line: %v", err)
+		}
+		threadRec, threadPrompt, err = startAgentThread(tx.Context(), ids.ScreenId, cmd.LineId, threadId, provider, promptStr)
+		if err != nil {
+			return fmt.Errorf("agent error: %v", err)
+		}
+		return nil
+	})
+	if txErr != nil {
+		return nil, txErr
 	}
 	
 	// sendRendererActivityUpdate("agent_mode")
 	
 	// Run agent mode
-	go func() {
-		response, err := RunAgentMode(ctx, pk, clientData, promptStr, provider)
-		if err != nil {
-			writeErrorToPty(cmd, fmt.Sprintf("agent error: %v", err), 0)
-			return
-		}
-		
-		// Handle streaming response
-		if response.Stream != nil {
-			var outputPos int64
-			packetTimeout := OpenAIPacketTimeout
+	if toolProvider != nil {
+		go func() {
+			runAgentWithTools(cmd, ids, clientData, toolProvider, line.LineState, threadRec, threadPrompt, promptStr, contextStr)
+			update := scbus.MakeUpdatePacket()
+			update.AddUpdate(sstore.AgentModeToggleType{Enabled: false})
+			scbus.MainUpdateBus.DoUpdate(update)
+		}()
+	} else {
+		go func() {
+			response, err := RunAgentMode(ctx, pk, clientData, promptStr, provider, contextStr, threadPrompt)
+			if err != nil {
+				writeErrorToPty(cmd, fmt.Sprintf("agent error: %v", err), 0)
+				return
+			}
 			
-			for {
-				select {
-				case <-time.After(packetTimeout):
-					writeErrorToPty(cmd, "timeout waiting for response", outputPos)
-					return
-				case pk, ok := <-response.Stream:
-					if !ok {
-						// Channel closed, we're done
-						// Send update to toggle off agent mode
-						update := scbus.MakeUpdatePacket()
-						update.AddUpdate(sstore.AgentModeToggleType{Enabled: false})
-						scbus.MainUpdateBus.DoUpdate(update)
-						return
-					}
-					
-					// Write packet to PTY
-					err = writePacketToPty(ctx, cmd, pk, &outputPos)
-					if err != nil {
-						log.Printf("error writing response to ptybuffer: %v", err)
+			// Handle streaming response
+			if response.Stream != nil {
+				defer response.StopStream()
+				var outputPos int64
+				packetTimeout := OpenAIPacketTimeout
+				
+				for {
+					select {
+					case <-time.After(packetTimeout):
+						writeErrorToPty(cmd, "timeout waiting for response", outputPos)
 						return
+					case pk, ok := <-response.Stream:
+						if !ok {
+							// Channel closed, we're done
+							threadRec.Finish()
+							// Send update to toggle off agent mode
+							update := scbus.MakeUpdatePacket()
+							update.AddUpdate(sstore.AgentModeToggleType{Enabled: false})
+							scbus.MainUpdateBus.DoUpdate(update)
+							return
+						}
+						
+						threadRec.AddPacket(pk)
+						// Write packet to PTY
+						err = writePacketToPty(ctx, cmd, pk, &outputPos)
+						if err != nil {
+							log.Printf("error writing response to ptybuffer: %v", err)
+							return
+						}
 					}
 				}
 			}
-		}
-	}()
+		}()
+	}
 	
 	// Update screen
 	updateHistoryContext(ctx, line, cmd, nil)
@@ -4047,9 +4232,20 @@ func HistoryViewAllCommand(ctx context.Context, pk *scpacket.FeCommandPacketType
 		return nil, err
 	}
 	opts := history.HistoryQueryOpts{MaxItems: HistoryViewPageSize, Offset: offset, RawOffset: rawOffset}
-	if pk.Kwargs["text"] != "" {
-		opts.SearchText = pk.Kwargs["text"]
+	// filters can also be typed into the search box as key:value (kwargs take precedence)
+	searchText, filterKwargs := extractHistoryFilterTokens(pk.Kwargs["text"])
+	if searchText != "" {
+		opts.SearchText = searchText
+	}
+	for _, key := range HistoryFilterKeys {
+		if pk.Kwargs[key] != "" {
+			if filterKwargs == nil {
+				filterKwargs = make(map[string]string)
+			}
+			filterKwargs[key] = pk.Kwargs[key]
+		}
 	}
+	var searchWsh *remote.WaveshellProc
 	if pk.Kwargs["searchsession"] != "" {
 		sessionId, err := resolveSessionArg(pk.Kwargs["searchsession"])
 		if err != nil {
@@ -4064,8 +4260,13 @@ func HistoryViewAllCommand(ctx context.Context, pk *scpacket.FeCommandPacketType
 		}
 		if rptr != nil {
 			opts.RemoteId = rptr.RemoteId
+			searchWsh = remote.GetRemoteById(rptr.RemoteId)
 		}
 	}
+	opts.Filter, err = resolveHistoryFilterOpts(filterKwargs, makeHistoryExpandHomeFn(searchWsh))
+	if err != nil {
+		return nil, err
+	}
 	if pk.Kwargs["fromts"] != "" {
 		fromTs, err := resolvePosInt(pk.Kwargs["fromts"], 0)
 		if err != nil {
@@ -4125,6 +4326,10 @@ func HistoryCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbu
 		maxItems = DefaultMaxHistoryItems
 	}
 	htype := HistoryTypeScreen
+	if pk.Kwargs["search"] != "" {
+		// searches default to all history
+		htype = HistoryTypeGlobal
+	}
 	hSessionId := ids.SessionId
 	hScreenId := ids.ScreenId
 	if pk.Kwargs["type"] != "" {
@@ -4139,7 +4344,18 @@ func HistoryCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbu
 	} else if htype == HistoryTypeSession {
 		hScreenId = ""
 	}
-	hopts := history.HistoryQueryOpts{MaxItems: maxItems, SessionId: hSessionId, ScreenId: hScreenId}
+	var remoteWsh *remote.WaveshellProc
+	if ids.Remote != nil {
+		remoteWsh = ids.Remote.Waveshell
+	}
+	filterOpts, err := resolveHistoryFilterOpts(pk.Kwargs, makeHistoryExpandHomeFn(remoteWsh))
+	if err != nil {
+		return nil, fmt.Errorf("/history %v", err)
+	}
+	if pk.Kwargs["search"] != "" {
+		return historySearch(ctx, pk, hSessionId, hScreenId, filterOpts)
+	}
+	hopts := history.HistoryQueryOpts{MaxItems: maxItems, SessionId: hSessionId, ScreenId: hScreenId, Filter: filterOpts}
 	hresult, err := history.GetHistoryItems(ctx, hopts)
 	if err != nil {
 		return nil, err
@@ -4577,6 +4793,63 @@ func BookmarkDeleteCommand(ctx context.Context, pk *scpacket.FeCommandPacketType
 	return update, nil
 }
 
+func BookmarksExportCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	if len(pk.Args) == 0 {
+		return nil, fmt.Errorf("usage: /bookmarks:export [file] [tag=tagname]")
+	}
+	fileName, format, err := resolveBundleFileArgs(pk, pk.Args[0], false)
+	if err != nil {
+		return nil, fmt.Errorf("/bookmarks:export error: %v", err)
+	}
+	bms, err := bookmarks.GetBookmarks(ctx, pk.Kwargs["tag"])
+	if err != nil {
+		return nil, fmt.Errorf("cannot retrieve bookmarks: %v", err)
+	}
+	b := &bundle.BundleType{}
+	for _, bm := range bms {
+		b.Bookmarks = append(b.Bookmarks, bundle.MakeBookmarkBundle(bm))
+	}
+	err = bundle.WriteBundleFile(fileName, format, b)
+	if err != nil {
+		return nil, fmt.Errorf("/bookmarks:export error: %v", err)
+	}
+	return sstore.InfoMsgUpdate("exported %d bookmark(s) to %s", len(bms), fileName), nil
+}
+
+func BookmarksImportCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	fileName, format, err := resolveBundleFileArgs(pk, firstArg(pk), true)
+	if err != nil {
+		return nil, fmt.Errorf("/bookmarks:import error: %v", err)
+	}
+	strategy, sapi, err := resolveBundleMergeArgs(ctx, pk)
+	if err != nil {
+		return nil, fmt.Errorf("/bookmarks:import error: %v", err)
+	}
+	b, err := bundle.ReadBundleFile(fileName, format)
+	if err != nil {
+		return nil, fmt.Errorf("/bookmarks:import error: %v", err)
+	}
+	if len(b.Bookmarks) == 0 {
+		return nil, fmt.Errorf("/bookmarks:import no bookmarks found in %s", fileName)
+	}
+	validationErrs := bundle.ValidateBookmarks(b, sapi)
+	if len(validationErrs) > 0 {
+		return formatValidationErrors("bookmark import failed", validationErrs), nil
+	}
+	result, err := bundle.ImportBookmarks(ctx, b, strategy)
+	if err != nil {
+		return nil, fmt.Errorf("/bookmarks:import error, nothing was imported: %v", err)
+	}
+	bms, err := bookmarks.GetBookmarks(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("cannot retrieve bookmarks: %v", err)
+	}
+	update := scbus.MakeUpdatePacket()
+	bookmarks.AddBookmarksUpdate(update, bms, nil)
+	update.AddUpdate(sstore.InfoMsgType{InfoMsg: fmt.Sprintf("imported bookmarks from %s: %s", fileName, result)})
+	return update, nil
+}
+
 func LineBookmarkCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
 	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
 	if err != nil {
@@ -4877,6 +5150,17 @@ func LineShowCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scb
 		stateStr = stateStr[0:77] + "..."
 	}
 	buf.WriteString(fmt.Sprintf("  %-15s %s\n", "state", stateStr))
+	auditEntries, err := sstore.GetAIAuditEntries(ctx, line.ScreenId, line.LineId)
+	if err != nil {
+		return nil, fmt.Errorf("error getting agent audit entries: %v", err)
+	}
+	for _, entry := range auditEntries {
+		entryStr := fmt.Sprintf("step %d %s %s %s", entry.Step, entry.Tool, entry.Status, entry.Args)
+		if entry.Error != "" {
+			entryStr += " error: " + entry.Error
+		}
+		buf.WriteString(fmt.Sprintf("  %-15s %s\n", fmt.Sprintf("agent-call-%d", entry.CallIdx+1), entryStr))
+	}
 	update := scbus.MakeUpdatePacket()
 	update.AddUpdate(sstore.InfoMsgType{
 		InfoTitle: fmt.Sprintf("line %d info", line.LineNum),
@@ -5927,11 +6211,23 @@ func ClientSetCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sc
 			azureCopy := *aiOpts.Azure
 			aiOpts.Azure = &azureCopy
 		}
+		if aiOpts.Ollama != nil {
+			ollamaCopy := *aiOpts.Ollama
+			aiOpts.Ollama = &ollamaCopy
+		}
+		if aiOpts.Anthropic != nil {
+			anthropicCopy := *aiOpts.Anthropic
+			aiOpts.Anthropic = &anthropicCopy
+		}
+		if aiOpts.Context != nil {
+			contextCopy := *aiOpts.Context
+			aiOpts.Context = &contextCopy
+		}
 	}
 	// Handle default provider
 	if defaultProvider, found := pk.Kwargs["defaultprovider"]; found {
-		if defaultProvider != "openai" && defaultProvider != "gemini" && defaultProvider != "azure" {
-			return nil, fmt.Errorf("invalid default provider, must be 'openai', 'gemini', or 'azure'")
+		if aiprovider.GetProvider(defaultProvider) == nil {
+			return nil, fmt.Errorf("invalid default provider, must be one of: %s", strings.Join(aiprovider.GetProviderNames(), ", "))
 		}
 		aiOpts.Default = defaultProvider
 		aiOptsUpdated = true
@@ -5990,6 +6286,157 @@ func ClientSetCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sc
 		aiOptsUpdated = true
 		varsUpdated = append(varsUpdated, "azureapitoken")
 	}
+	// Handle Ollama options
+	if ollamaBaseURL, found := pk.Kwargs["ollamabaseurl"]; found {
+		if ollamaBaseURL != "" {
+			_, err = url.ParseRequestURI(ollamaBaseURL)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ollama base url: %v", err)
+			}
+		}
+		if aiOpts.Ollama == nil {
+			aiOpts.Ollama = &sstore.OllamaOptsType{}
+		}
+		aiOpts.Ollama.BaseURL = ollamaBaseURL
+		aiOptsUpdated = true
+		varsUpdated = append(varsUpdated, "ollamabaseurl")
+	}
+	if ollamaModel, found := pk.Kwargs["ollamamodel"]; found {
+		if aiOpts.Ollama == nil {
+			aiOpts.Ollama = &sstore.OllamaOptsType{}
+		}
+		aiOpts.Ollama.Model = ollamaModel
+		aiOptsUpdated = true
+		varsUpdated = append(varsUpdated, "ollamamodel")
+	}
+	if ollamaMaxTokensStr, found := pk.Kwargs["ollamamaxtokens"]; found {
+		maxTokens, err := strconv.Atoi(ollamaMaxTokensStr)
+		if err != nil || maxTokens < 0 {
+			return nil, fmt.Errorf("invalid ollama maxtokens value %q", ollamaMaxTokensStr)
+		}
+		if aiOpts.Ollama == nil {
+			aiOpts.Ollama = &sstore.OllamaOptsType{}
+		}
+		aiOpts.Ollama.MaxTokens = maxTokens
+		aiOptsUpdated = true
+		varsUpdated = append(varsUpdated, "ollamamaxtokens")
+	}
+	// Handle Anthropic options
+	if anthropicAPIToken, found := pk.Kwargs["anthropicapitoken"]; found {
+		err = validateOpenAIAPIToken(anthropicAPIToken) // reuse validation function
+		if err != nil {
+			return nil, fmt.Errorf("invalid anthropic api token: %v", err)
+		}
+		if aiOpts.Anthropic == nil {
+			aiOpts.Anthropic = &sstore.AnthropicOptsType{}
+		}
+		aiOpts.Anthropic.APIToken = anthropicAPIToken
+		aiOptsUpdated = true
+		varsUpdated = append(varsUpdated, "anthropicapitoken")
+	}
+	if anthropicModel, found := pk.Kwargs["anthropicmodel"]; found {
+		if aiOpts.Anthropic == nil {
+			aiOpts.Anthropic = &sstore.AnthropicOptsType{}
+		}
+		aiOpts.Anthropic.Model = anthropicModel
+		aiOptsUpdated = true
+		varsUpdated = append(varsUpdated, "anthropicmodel")
+	}
+	if anthropicBaseURL, found := pk.Kwargs["anthropicbaseurl"]; found {
+		if anthropicBaseURL != "" {
+			_, err = url.ParseRequestURI(anthropicBaseURL)
+			if err != nil {
+				return nil, fmt.Errorf("invalid anthropic base url: %v", err)
+			}
+		}
+		if aiOpts.Anthropic == nil {
+			aiOpts.Anthropic = &sstore.AnthropicOptsType{}
+		}
+		aiOpts.Anthropic.BaseURL = anthropicBaseURL
+		aiOptsUpdated = true
+		varsUpdated = append(varsUpdated, "anthropicbaseurl")
+	}
+	if anthropicMaxTokensStr, found := pk.Kwargs["anthropicmaxtokens"]; found {
+		maxTokens, err := strconv.Atoi(anthropicMaxTokensStr)
+		if err != nil || maxTokens < 0 {
+			return nil, fmt.Errorf("invalid anthropic maxtokens value %q", anthropicMaxTokensStr)
+		}
+		if aiOpts.Anthropic == nil {
+			aiOpts.Anthropic = &sstore.AnthropicOptsType{}
+		}
+		aiOpts.Anthropic.MaxTokens = maxTokens
+		aiOptsUpdated = true
+		varsUpdated = append(varsUpdated, "anthropicmaxtokens")
+	}
+	// Handle agent context options
+	if aiContextStr, found := pk.Kwargs["aicontext"]; found {
+		if aiOpts.Context == nil {
+			aiOpts.Context = &sstore.AIContextOptsType{}
+		}
+		aiOpts.Context.Enabled = resolveBool(aiContextStr, false)
+		aiOptsUpdated = true
+		varsUpdated = append(varsUpdated, "aicontext")
+	}
+	if aiContextEnvStr, found := pk.Kwargs["aicontextenv"]; found {
+		if aiOpts.Context == nil {
+			aiOpts.Context = &sstore.AIContextOptsType{}
+		}
+		aiOpts.Context.IncludeEnv = resolveBool(aiContextEnvStr, false)
+		aiOptsUpdated = true
+		varsUpdated = append(varsUpdated, "aicontextenv")
+	}
+	if aiContextCmdsStr, found := pk.Kwargs["aicontextcmds"]; found {
+		numCmds, err := resolveNonNegInt(aiContextCmdsStr, 0)
+		if err != nil || numCmds > MaxAIContextNumCmds {
+			return nil, fmt.Errorf("invalid aicontextcmds value %q (must be between 0 and %d)", aiContextCmdsStr, MaxAIContextNumCmds)
+		}
+		if aiOpts.Context == nil {
+			aiOpts.Context = &sstore.AIContextOptsType{}
+		}
+		aiOpts.Context.NumCmds = &numCmds
+		aiOptsUpdated = true
+		varsUpdated = append(varsUpdated, "aicontextcmds")
+	}
+	if aiContextOutputStr, found := pk.Kwargs["aicontextoutput"]; found {
+		maxOutput, err := resolveNonNegInt(aiContextOutputStr, 0)
+		if err != nil || maxOutput > MaxAIContextOutputBytes {
+			return nil, fmt.Errorf("invalid aicontextoutput value %q (must be between 0 and %d)", aiContextOutputStr, MaxAIContextOutputBytes)
+		}
+		if aiOpts.Context == nil {
+			aiOpts.Context = &sstore.AIContextOptsType{}
+		}
+		aiOpts.Context.MaxOutputBytes = &maxOutput
+		aiOptsUpdated = true
+		varsUpdated = append(varsUpdated, "aicontextoutput")
+	}
+	if aiRedactStr, found := pk.Kwargs["airedact"]; found {
+		// comma separated list of regexps (matched against env var names and context text)
+		var patterns []string
+		for _, pattern := range strings.Split(aiRedactStr, ",") {
+			if strings.TrimSpace(pattern) != "" {
+				patterns = append(patterns, strings.TrimSpace(pattern))
+			}
+		}
+		_, err = prompts.MakeRedactor(patterns)
+		if err != nil {
+			return nil, err
+		}
+		if aiOpts.Context == nil {
+			aiOpts.Context = &sstore.AIContextOptsType{}
+		}
+		aiOpts.Context.RedactPatterns = patterns
+		aiOptsUpdated = true
+		varsUpdated = append(varsUpdated, "airedact")
+	}
+	if aiBudgetStr, found := pk.Kwargs["aidailybudget"]; found {
+		budget, err := resolveNonNegInt(aiBudgetStr, 0)
+		if err != nil {
+			return nil, fmt.Errorf("invalid aidailybudget value %q (must be a number of tokens, 0 for no budget)", aiBudgetStr)
+		}
+		aiOpts.DailyTokenBudget = budget
+		aiOptsUpdated = true
+		varsUpdated = append(varsUpdated, "aidailybudget")
+	}
 	// Update AIOpts if any changes were made
 	if aiOptsUpdated {
 		err = sstore.UpdateClientAIOpts(ctx, *aiOpts)
@@ -6049,8 +6496,13 @@ func ClientSetCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sc
 		}
 		varsUpdated = append(varsUpdated, "sudopwclearonsleep")
 	}
+	retentionVars, err := setRetentionClientOpts(ctx, pk.Kwargs)
+	if err != nil {
+		return nil, err
+	}
+	varsUpdated = append(varsUpdated, retentionVars...)
 	if len(varsUpdated) == 0 {
-		return nil, fmt.Errorf("/client:set requires a value to set: %s", formatStrs([]string{"termfontsize", "termfontfamily", "inputposition", "openaiapitoken", "openaimodel", "openaibaseurl", "openaimaxtokens", "openaimaxchoices", "openaitimeout", "webgl", "defaultprovider", "geminiapitoken", "azurebaseurl", "azuredeploymentname", "azureapitoken"}, "or", false))
+		return nil, fmt.Errorf("/client:set requires a value to set: %s", formatStrs(append([]string{"termfontsize", "termfontfamily", "inputposition", "openaiapitoken", "openaimodel", "openaibaseurl", "openaimaxtokens", "openaimaxchoices", "openaitimeout", "webgl", "defaultprovider", "geminiapitoken", "azurebaseurl", "azuredeploymentname", "azureapitoken"}, RetentionKeys...), "or", false))
 	}
 	clientData, err = sstore.EnsureClientData(ctx)
 	if err != nil {
@@ -6116,6 +6568,10 @@ func ClientShowCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (s
 	buf.WriteString(fmt.Sprintf("  %-15s %s\n", "aimaxchoices", aiMaxChoices))
 	buf.WriteString(fmt.Sprintf("  %-15s %s\n", "aibaseurl", aiBaseUrl))
 	buf.WriteString(fmt.Sprintf("  %-15s %ss\n", "aitimeout", aiTimeout))
+	buf.WriteString(fmt.Sprintf("  %-15s %s\n", "retention", formatRetentionOpts(clientData.ClientOpts.Retention)))
+	if lastResult := history.GetLastRetentionResult(); lastResult != nil {
+		buf.WriteString(fmt.Sprintf("  %-15s %s %s\n", "retention-run", time.UnixMilli(lastResult.StartTs).Format(time.DateTime), formatRetentionResult(lastResult)))
+	}
 	update := scbus.MakeUpdatePacket()
 	update.AddUpdate(sstore.InfoMsgType{
 		InfoTitle: fmt.Sprintf("client info"),
@@ -0,0 +1,156 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abhishek944/waveterm/waveshell/pkg/base"
+	"github.com/abhishek944/waveterm/waveshell/pkg/utilfn"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/history"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/remote"
+)
+
+// kwargs accepted by /history and /history:viewall (and as key:value tokens in the history view's search text)
+var HistoryFilterKeys = []string{"failed", "minduration", "maxduration", "cwd", "since", "until", "tag"}
+
+const HistoryFilterDateFormat = "2006-01-02"
+const HistoryFilterDateTimeFormat = "2006-01-02T15:04"
+
+var historyDaysRe = regexp.MustCompile(`^(\d+)([dw])(.*)$`)
+
+// go durations (5s, 1h30m) plus days and weeks (2d, 1w, 1d12h)
+func parseHistoryDuration(arg string) (time.Duration, error) {
+	var rtn time.Duration
+	if m := historyDaysRe.FindStringSubmatch(arg); m != nil {
+		num, _ := strconv.Atoi(m[1])
+		unit := 24 * time.Hour
+		if m[2] == "w" {
+			unit = 7 * 24 * time.Hour
+		}
+		rtn = time.Duration(num) * unit
+		arg = m[3]
+		if arg == "" {
+			return rtn, nil
+		}
+	}
+	dur, err := time.ParseDuration(arg)
+	if err != nil || dur < 0 {
+		return 0, fmt.Errorf("invalid duration %q (use e.g. 30s, 5m, 2h, 3d, 1w)", arg)
+	}
+	return rtn + dur, nil
+}
+
+// a duration ago (2d, 3h), a date (2024-05-01) or a local date and time (2024-05-01T10:30).
+// a date by itself is the start of the day, or the end of the day when endOfDay is set (for until=)
+func parseHistoryTime(arg string, now time.Time, endOfDay bool) (int64, error) {
+	if day, err := time.ParseInLocation(HistoryFilterDateFormat, arg, time.Local); err == nil {
+		if endOfDay {
+			return day.AddDate(0, 0, 1).UnixMilli() - 1, nil
+		}
+		return day.UnixMilli(), nil
+	}
+	if ts, err := time.ParseInLocation(HistoryFilterDateTimeFormat, arg, time.Local); err == nil {
+		return ts.UnixMilli(), nil
+	}
+	dur, err := parseHistoryDuration(arg)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q (use a duration ago like 2d, or YYYY-MM-DD[THH:MM])", arg)
+	}
+	return now.Add(-dur).UnixMilli(), nil
+}
+
+// expandHomeFn expands ~ in cwd= (home directories differ between remotes)
+func resolveHistoryFilterOpts(kwargs map[string]string, expandHomeFn func(string) (string, error)) (history.HistoryFilterOpts, error) {
+	var rtn history.HistoryFilterOpts
+	if kwargs["failed"] != "" {
+		failed := resolveBool(kwargs["failed"], false)
+		rtn.Failed = &failed
+	}
+	for _, durKey := range []string{"minduration", "maxduration"} {
+		if kwargs[durKey] == "" {
+			continue
+		}
+		dur, err := parseHistoryDuration(kwargs[durKey])
+		if err != nil {
+			return rtn, fmt.Errorf("invalid %s: %v", durKey, err)
+		}
+		if durKey == "minduration" {
+			rtn.MinDurationMs = dur.Milliseconds()
+		} else {
+			rtn.MaxDurationMs = dur.Milliseconds()
+		}
+	}
+	if kwargs["cwd"] != "" {
+		cwd, err := expandHomeFn(kwargs["cwd"])
+		if err != nil {
+			return rtn, fmt.Errorf("invalid cwd: %v", err)
+		}
+		if !strings.HasPrefix(cwd, "/") {
+			return rtn, fmt.Errorf("invalid cwd %q (must be absolute or start with ~)", kwargs["cwd"])
+		}
+		rtn.Cwd = cwd
+	}
+	now := time.Now()
+	if kwargs["since"] != "" {
+		ts, err := parseHistoryTime(kwargs["since"], now, false)
+		if err != nil {
+			return rtn, fmt.Errorf("invalid since: %v", err)
+		}
+		rtn.SinceTs = ts
+	}
+	if kwargs["until"] != "" {
+		ts, err := parseHistoryTime(kwargs["until"], now, true)
+		if err != nil {
+			return rtn, fmt.Errorf("invalid until: %v", err)
+		}
+		rtn.UntilTs = ts
+	}
+	if kwargs["tag"] != "" {
+		for tag := range resolveCommaSepListToMap(kwargs["tag"]) {
+			if tag != "" {
+				rtn.Tags = append(rtn.Tags, tag)
+			}
+		}
+		sort.Strings(rtn.Tags)
+	}
+	return rtn, nil
+}
+
+// returns an expandHomeFn for resolveHistoryFilterOpts, uses the remote's home (or the local one if there is no remote)
+func makeHistoryExpandHomeFn(wsh *remote.WaveshellProc) func(string) (string, error) {
+	if wsh == nil {
+		return func(pathStr string) (string, error) {
+			return base.ExpandHomeDir(pathStr), nil
+		}
+	}
+	return wsh.GetRemoteRuntimeState().ExpandHomeDir
+}
+
+// pulls known key:value filter tokens (e.g. "failed:1 cwd:~/proj") out of search text.
+// returns the remaining text and the filters as kwargs
+func extractHistoryFilterTokens(text string) (string, map[string]string) {
+	var rest []string
+	kwargs := make(map[string]string)
+	for _, word := range strings.Fields(text) {
+		key, val, found := strings.Cut(word, ":")
+		if found && val != "" && utilfn.ContainsStr(HistoryFilterKeys, key) {
+			if key == "tag" && kwargs["tag"] != "" {
+				val = kwargs["tag"] + "," + val
+			}
+			kwargs[key] = val
+			continue
+		}
+		rest = append(rest, word)
+	}
+	if len(kwargs) == 0 {
+		return text, nil
+	}
+	return strings.Join(rest, " "), kwargs
+}
@@ -0,0 +1,95 @@
+package cmdrunner
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseHistoryDuration(t *testing.T) {
+	tests := []struct {
+		arg      string
+		expected time.Duration
+	}{
+		{"5s", 5 * time.Second},
+		{"1h30m", 90 * time.Minute},
+		{"2d", 48 * time.Hour},
+		{"1w", 7 * 24 * time.Hour},
+		{"1d12h", 36 * time.Hour},
+	}
+	for _, test := range tests {
+		dur, err := parseHistoryDuration(test.arg)
+		if err != nil || dur != test.expected {
+			t.Errorf("%q: got %v %v, expected %v", test.arg, dur, err, test.expected)
+		}
+	}
+	for _, arg := range []string{"", "5", "-5s", "2dx", "d"} {
+		if _, err := parseHistoryDuration(arg); err == nil {
+			t.Errorf("%q: expected error", arg)
+		}
+	}
+}
+
+func TestParseHistoryTime(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.Local)
+	ts, _ := parseHistoryTime("2d", now, false)
+	if ts != now.Add(-48*time.Hour).UnixMilli() {
+		t.Errorf("bad 2d ts %d", ts)
+	}
+	dayStart := time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local)
+	if ts, _ := parseHistoryTime("2024-05-01", now, false); ts != dayStart.UnixMilli() {
+		t.Errorf("bad since date ts %d", ts)
+	}
+	if ts, _ := parseHistoryTime("2024-05-01", now, true); ts != dayStart.AddDate(0, 0, 1).UnixMilli()-1 {
+		t.Errorf("bad until date ts %d", ts)
+	}
+	if ts, _ := parseHistoryTime("2024-05-01T10:30", now, true); ts != dayStart.Add(630*time.Minute).UnixMilli() {
+		t.Errorf("bad date time ts %d", ts)
+	}
+	if _, err := parseHistoryTime("yesterday", now, false); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestResolveHistoryFilterOpts(t *testing.T) {
+	expandFn := func(pathStr string) (string, error) {
+		if pathStr == "~/proj" {
+			return "/home/u/proj", nil
+		}
+		return pathStr, nil
+	}
+	kwargs := map[string]string{"failed": "1", "minduration": "5s", "cwd": "~/proj", "tag": "prod, deploy"}
+	opts, err := resolveHistoryFilterOpts(kwargs, expandFn)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if opts.Failed == nil || !*opts.Failed || opts.MinDurationMs != 5000 || opts.Cwd != "/home/u/proj" {
+		t.Errorf("bad opts %+v", opts)
+	}
+	if len(opts.Tags) != 2 || opts.Tags[0] != "deploy" || opts.Tags[1] != "prod" {
+		t.Errorf("bad tags %v", opts.Tags)
+	}
+	opts, _ = resolveHistoryFilterOpts(map[string]string{"failed": "0"}, expandFn)
+	if opts.Failed == nil || *opts.Failed {
+		t.Errorf("failed=0 should filter on success")
+	}
+	if opts, _ := resolveHistoryFilterOpts(nil, expandFn); !opts.IsEmpty() {
+		t.Errorf("no kwargs should not filter: %+v", opts)
+	}
+	if _, err := resolveHistoryFilterOpts(map[string]string{"cwd": "proj"}, expandFn); err == nil {
+		t.Errorf("relative cwd should be an error")
+	}
+	if _, err := resolveHistoryFilterOpts(map[string]string{"maxduration": "soon"}, expandFn); err == nil {
+		t.Errorf("bad maxduration should be an error")
+	}
+}
+
+func TestExtractHistoryFilterTokens(t *testing.T) {
+	text, kwargs := extractHistoryFilterTokens("git  failed:1 push tag:a tag:b http://x")
+	if text != "git push http://x" || kwargs["failed"] != "1" || kwargs["tag"] != "a,b" || len(kwargs) != 2 {
+		t.Errorf("bad extract %q %v", text, kwargs)
+	}
+	text, kwargs = extractHistoryFilterTokens("git  push")
+	if text != "git  push" || kwargs != nil {
+		t.Errorf("text without tokens should be unchanged: %q %v", text, kwargs)
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/abhishek944/waveterm/waveshell/pkg/base"
+	"github.com/abhishek944/waveterm/waveshell/pkg/utilfn"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/history"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/scbus"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/scpacket"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+)
+
+const HistoryImportUsageStr = "usage: /history:import [format=bash|zsh|jsonl] [file] (file defaults to ~/.bash_history or ~/.zsh_history)"
+const HistoryExportUsageStr = "usage: /history:export [format=bash|zsh|jsonl] [type=screen|session|global] [meta=1] [force=1] [filters] file"
+
+// history files bigger than this are not imported
+const MaxHistoryImportSize = 256 * 1024 * 1024
+
+var defaultHistoryFiles = map[string]string{
+	history.HistoryFormatBash: "~/.bash_history",
+	history.HistoryFormatZsh:  "~/.zsh_history",
+}
+
+func resolveHistoryFormat(arg string) (string, error) {
+	if arg != "" && !utilfn.ContainsStr(history.HistoryFormats, arg) {
+		return "", fmt.Errorf("invalid format %q, valid formats: %s", arg, formatStrs(history.HistoryFormats, "or", false))
+	}
+	return arg, nil
+}
+
+// resolves file= (or the first arg) to an absolute path on the wavesrv host
+func resolveHistoryFileArg(pk *scpacket.FeCommandPacketType, defaultFile string) (string, error) {
+	fileArg := pk.Kwargs["file"]
+	if fileArg == "" {
+		fileArg = firstArg(pk)
+	}
+	if fileArg == "" {
+		fileArg = defaultFile
+	}
+	if fileArg == "" {
+		return "", nil
+	}
+	fileName := base.ExpandHomeDir(fileArg)
+	if !filepath.IsAbs(fileName) {
+		return "", fmt.Errorf("file must be absolute or start with ~ (got %q)", fileArg)
+	}
+	return fileName, nil
+}
+
+// /history:import [format=bash|zsh|jsonl] [file]
+// reads a shell history file on the local machine into the history table (see history.ImportHistory)
+func HistoryImportCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	format, err := resolveHistoryFormat(pk.Kwargs["format"])
+	if err != nil {
+		return nil, fmt.Errorf("/history:import %v", err)
+	}
+	fileName, err := resolveHistoryFileArg(pk, defaultHistoryFiles[format])
+	if err != nil {
+		return nil, fmt.Errorf("/history:import %v", err)
+	}
+	if fileName == "" {
+		return nil, fmt.Errorf(HistoryImportUsageStr)
+	}
+	finfo, err := os.Stat(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("/history:import cannot read file: %v", err)
+	}
+	if finfo.Size() > MaxHistoryImportSize {
+		return nil, fmt.Errorf("/history:import %s is too large (%d bytes, max %d)", fileName, finfo.Size(), MaxHistoryImportSize)
+	}
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("/history:import cannot read file: %v", err)
+	}
+	if format == "" {
+		format = history.DetectHistoryFormat(fileName, data)
+	}
+	cmds, err := history.ParseHistoryFile(format, data)
+	if err != nil {
+		return nil, fmt.Errorf("/history:import error parsing %s (%s format): %v", fileName, format, err)
+	}
+	// commands without timestamps are placed just before the file's modification time
+	result, err := history.ImportHistory(ctx, DefaultUserId, format, cmds, finfo.ModTime().UnixMilli())
+	if err != nil {
+		return nil, fmt.Errorf("/history:import error importing %s: %v", fileName, err)
+	}
+	return sstore.InfoMsgUpdate("imported %d commands from %s (%s format, %d read, %d duplicates skipped)", result.Imported, fileName, format, result.Parsed, result.Duplicates), nil
+}
+
+// /history:export [format=bash|zsh|jsonl] [type=screen|session|global] [meta=1] [force=1] [filters] file
+// writes history (global by default, oldest first) to a file on the local machine
+func HistoryExportCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, err
+	}
+	format, err := resolveHistoryFormat(pk.Kwargs["format"])
+	if err != nil {
+		return nil, fmt.Errorf("/history:export %v", err)
+	}
+	fileName, err := resolveHistoryFileArg(pk, "")
+	if err != nil {
+		return nil, fmt.Errorf("/history:export %v", err)
+	}
+	if fileName == "" {
+		return nil, fmt.Errorf(HistoryExportUsageStr)
+	}
+	if format == "" {
+		format = history.DetectHistoryFormat(fileName, nil)
+	}
+	opts := history.HistoryQueryOpts{NoMeta: !resolveBool(pk.Kwargs["meta"], false)}
+	switch pk.Kwargs["type"] {
+	case "", HistoryTypeGlobal:
+	case HistoryTypeSession:
+		opts.SessionId = ids.SessionId
+	case HistoryTypeScreen:
+		opts.SessionId = ids.SessionId
+		opts.ScreenId = ids.ScreenId
+	default:
+		return nil, fmt.Errorf("invalid history type '%s', valid types: %s", pk.Kwargs["type"], formatStrs([]string{HistoryTypeScreen, HistoryTypeSession, HistoryTypeGlobal}, "or", false))
+	}
+	// the file is local, so ~ in cwd= is the local home dir as well
+	opts.Filter, err = resolveHistoryFilterOpts(pk.Kwargs, makeHistoryExpandHomeFn(nil))
+	if err != nil {
+		return nil, fmt.Errorf("/history:export %v", err)
+	}
+	force := resolveBool(pk.Kwargs["force"], false)
+	if _, err := os.Lstat(fileName); err == nil && !force {
+		return nil, fmt.Errorf("/history:export %s already exists (use force=1 to overwrite)", fileName)
+	}
+	// written to a temp file next to fileName, so a failed export leaves no partial file behind
+	tempFd, err := os.CreateTemp(filepath.Dir(fileName), filepath.Base(fileName)+".tmp.")
+	if err != nil {
+		return nil, fmt.Errorf("/history:export cannot open file: %v", err)
+	}
+	defer os.Remove(tempFd.Name())
+	numItems, err := history.ExportHistory(ctx, tempFd, format, opts)
+	if closeErr := tempFd.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("/history:export error writing %s: %v", fileName, err)
+	}
+	if force {
+		err = os.Rename(tempFd.Name(), fileName)
+	} else {
+		// unlike rename, link fails if the file was created during the export
+		err = os.Link(tempFd.Name(), fileName)
+	}
+	if os.IsExist(err) {
+		return nil, fmt.Errorf("/history:export %s already exists (use force=1 to overwrite)", fileName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("/history:export cannot write %s: %v", fileName, err)
+	}
+	return sstore.InfoMsgUpdate("exported %d commands to %s (%s format)", numItems, fileName, format), nil
+}
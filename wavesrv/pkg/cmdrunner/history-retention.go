@@ -0,0 +1,175 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/abhishek944/waveterm/wavesrv/pkg/history"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/scbus"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/scpacket"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+)
+
+// /client:set kwargs for sstore.RetentionOptsType
+var RetentionKeys = []string{"retentionmaxage", "retentionmaxitems", "retentionarchiveddays", "retentionkeepstarred", "retentionkeepbookmarked", "retentionkeeptagged"}
+
+// a number of days ("90"), or a duration in days or weeks ("90d", "12w").  0 turns the limit off
+func resolveRetentionDays(arg string) (int, error) {
+	if days, err := strconv.Atoi(arg); err == nil {
+		if days < 0 {
+			return 0, fmt.Errorf("invalid number of days %q (cannot be negative)", arg)
+		}
+		return days, nil
+	}
+	dur, err := parseHistoryDuration(arg)
+	if err != nil {
+		return 0, err
+	}
+	if dur%(24*time.Hour) != 0 {
+		return 0, fmt.Errorf("invalid duration %q (must be whole days, e.g. 90d or 12w)", arg)
+	}
+	return int(dur / (24 * time.Hour)), nil
+}
+
+// applies the retention kwargs of /client:set, returns the names of the vars that were updated
+func setRetentionClientOpts(ctx context.Context, kwargs map[string]string) ([]string, error) {
+	hasRetentionKey := false
+	for _, key := range RetentionKeys {
+		if _, found := kwargs[key]; found {
+			hasRetentionKey = true
+		}
+	}
+	if !hasRetentionKey {
+		return nil, nil
+	}
+	// re-read the client opts, other /client:set vars may have updated them already
+	clientData, err := sstore.EnsureClientData(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot retrieve client data: %v", err)
+	}
+	clientOpts := clientData.ClientOpts
+	var varsUpdated []string
+	var opts sstore.RetentionOptsType
+	if clientOpts.Retention != nil {
+		opts = *clientOpts.Retention
+	}
+	for _, daysKey := range []string{"retentionmaxage", "retentionarchiveddays"} {
+		if _, found := kwargs[daysKey]; !found {
+			continue
+		}
+		days, err := resolveRetentionDays(kwargs[daysKey])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", daysKey, err)
+		}
+		if daysKey == "retentionmaxage" {
+			opts.MaxAgeDays = days
+		} else {
+			opts.ArchivedScreenDays = days
+		}
+		varsUpdated = append(varsUpdated, daysKey)
+	}
+	if maxItemsStr, found := kwargs["retentionmaxitems"]; found {
+		maxItems, err := resolveNonNegInt(maxItemsStr, 0)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retentionmaxitems %q (must be a number of items per screen, 0 for no limit)", maxItemsStr)
+		}
+		opts.MaxItemsPerScreen = maxItems
+		varsUpdated = append(varsUpdated, "retentionmaxitems")
+	}
+	if keepStr, found := kwargs["retentionkeepstarred"]; found {
+		opts.NoKeepStarred = !resolveBool(keepStr, true)
+		varsUpdated = append(varsUpdated, "retentionkeepstarred")
+	}
+	if keepStr, found := kwargs["retentionkeepbookmarked"]; found {
+		opts.NoKeepBookmarked = !resolveBool(keepStr, true)
+		varsUpdated = append(varsUpdated, "retentionkeepbookmarked")
+	}
+	if keepStr, found := kwargs["retentionkeeptagged"]; found {
+		opts.NoKeepTagged = !resolveBool(keepStr, true)
+		varsUpdated = append(varsUpdated, "retentionkeeptagged")
+	}
+	clientOpts.Retention = &opts
+	if opts == (sstore.RetentionOptsType{}) {
+		clientOpts.Retention = nil
+	}
+	err = sstore.SetClientOpts(ctx, clientOpts)
+	if err != nil {
+		return nil, fmt.Errorf("error updating client retention: %v", err)
+	}
+	return varsUpdated, nil
+}
+
+func formatRetentionDays(days int) string {
+	if days == 0 {
+		return "off"
+	}
+	return fmt.Sprintf("%d days", days)
+}
+
+func formatRetentionOpts(opts *sstore.RetentionOptsType) string {
+	if !opts.IsEnabled() {
+		return "off"
+	}
+	maxItems := "off"
+	if opts.MaxItemsPerScreen > 0 {
+		maxItems = fmt.Sprintf("%d per screen", opts.MaxItemsPerScreen)
+	}
+	var keeps []string
+	if !opts.NoKeepStarred {
+		keeps = append(keeps, "starred")
+	}
+	if !opts.NoKeepBookmarked {
+		keeps = append(keeps, "bookmarked")
+	}
+	if !opts.NoKeepTagged {
+		keeps = append(keeps, "tagged")
+	}
+	keepStr := "nothing"
+	if len(keeps) > 0 {
+		keepStr = formatStrs(keeps, "and", false)
+	}
+	return fmt.Sprintf("maxage %s, maxitems %s, archived screens %s, keeps %s", formatRetentionDays(opts.MaxAgeDays), maxItems, formatRetentionDays(opts.ArchivedScreenDays), keepStr)
+}
+
+func formatRetentionResult(result *history.RetentionResult) string {
+	return fmt.Sprintf("removed %d history items, %d lines, %d archived screens and %d files, reclaimed %s", result.HistoryItems, result.Lines, result.Screens, result.Files, prettyPrintByteSize(result.BytesReclaimed))
+}
+
+// /history:retention
+// runs the retention janitor now (it also runs in the background every few hours), see history.RunRetention
+func HistoryRetentionCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	clientData, err := sstore.EnsureClientData(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot retrieve client data: %v", err)
+	}
+	opts := clientData.ClientOpts.Retention
+	if !opts.IsEnabled() {
+		return nil, fmt.Errorf("/history:retention no retention limits are set, use /client:set retentionmaxage=90d (or retentionmaxitems=, retentionarchiveddays=)")
+	}
+	// same limit as the background janitor, not tied to the request
+	retentionCtx, cancelFn := context.WithTimeout(context.Background(), history.RetentionTimeout)
+	defer cancelFn()
+	result, err := history.RunRetention(retentionCtx, *opts)
+	if err != nil {
+		return nil, fmt.Errorf("/history:retention %v", err)
+	}
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("  %-16s %s\n", "retention", formatRetentionOpts(opts)))
+	buf.WriteString(fmt.Sprintf("  %-16s %d\n", "history-items", result.HistoryItems))
+	buf.WriteString(fmt.Sprintf("  %-16s %d\n", "lines", result.Lines))
+	buf.WriteString(fmt.Sprintf("  %-16s %d\n", "archived-screens", result.Screens))
+	buf.WriteString(fmt.Sprintf("  %-16s %d\n", "files", result.Files))
+	buf.WriteString(fmt.Sprintf("  %-16s %s\n", "reclaimed", prettyPrintByteSize(result.BytesReclaimed)))
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{
+		InfoTitle: fmt.Sprintf("history retention (%dms)", result.DurationMs),
+		InfoLines: splitLinesForInfo(buf.String()),
+	})
+	return update, nil
+}
@@ -0,0 +1,29 @@
+package cmdrunner
+
+import (
+	"testing"
+)
+
+func TestResolveRetentionDays(t *testing.T) {
+	tests := []struct {
+		arg      string
+		expected int
+	}{
+		{"0", 0},
+		{"90", 90},
+		{"90d", 90},
+		{"12w", 84},
+		{"48h", 2},
+	}
+	for _, test := range tests {
+		days, err := resolveRetentionDays(test.arg)
+		if err != nil || days != test.expected {
+			t.Errorf("%q: got %d %v, expected %d", test.arg, days, err, test.expected)
+		}
+	}
+	for _, arg := range []string{"", "-1", "36h", "1d12h", "x"} {
+		if _, err := resolveRetentionDays(arg); err == nil {
+			t.Errorf("%q: expected error", arg)
+		}
+	}
+}
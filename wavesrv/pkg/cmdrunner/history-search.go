@@ -0,0 +1,88 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/abhishek944/waveterm/waveshell/pkg/utilfn"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/history"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/scbus"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/scpacket"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+)
+
+const MaxSearchCmdStrLen = 80
+const MaxSearchSnippetLen = 120
+
+// collapses newlines and runs of whitespace so each hit fits on one info line
+func oneLineStr(str string, maxLen int) string {
+	return utilfn.EllipsisStr(strings.Join(strings.Fields(str), " "), maxLen)
+}
+
+// for cmdstr searches the snippet (with the matches marked) replaces the cmdstr
+func formatSearchHitLine(hit *history.HistorySearchHit, searchOutput bool) string {
+	hitem := hit.Item
+	lineStr := "(line deleted)"
+	if hitem.LineId != "" {
+		screenName := hit.ScreenName
+		if screenName == "" {
+			screenName = "(unknown screen)"
+		}
+		lineStr = fmt.Sprintf("%s #%d", screenName, hitem.LineNum)
+	}
+	cmdStr := hitem.CmdStr
+	if !searchOutput && hit.Snippet != "" {
+		cmdStr = hit.Snippet
+	}
+	return fmt.Sprintf("%-24s %s", lineStr, oneLineStr(cmdStr, MaxSearchCmdStrLen))
+}
+
+// /history search=... [output=1] [type=screen|session|global] [maxitems=n] [filters]
+// ranked full-text search over cmdstrs (or command output with output=1), see history.SearchHistory
+func historySearch(ctx context.Context, pk *scpacket.FeCommandPacketType, sessionId string, screenId string, filterOpts history.HistoryFilterOpts) (scbus.UpdatePacket, error) {
+	maxItems, err := resolvePosInt(pk.Kwargs["maxitems"], history.DefaultSearchResults)
+	if err != nil {
+		return nil, fmt.Errorf("invalid maxitems value '%s' (must be a number): %v", pk.Kwargs["maxitems"], err)
+	}
+	searchOutput := resolveBool(pk.Kwargs["output"], false)
+	opts := history.HistorySearchOpts{
+		Query:     pk.Kwargs["search"],
+		Output:    searchOutput,
+		SessionId: sessionId,
+		ScreenId:  screenId,
+		MaxItems:  maxItems,
+		Filter:    filterOpts,
+	}
+	hits, err := history.SearchHistory(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("/history %v", err)
+	}
+	searchType := "commands"
+	if searchOutput {
+		searchType = "output"
+	}
+	if len(hits) == 0 {
+		return sstore.InfoMsgUpdate("no %s matching %q", searchType, opts.Query), nil
+	}
+	var buf bytes.Buffer
+	for idx, hit := range hits {
+		buf.WriteString(fmt.Sprintf("%3d. %s\n", idx+1, formatSearchHitLine(hit, searchOutput)))
+		if hit.Snippet != "" && searchOutput {
+			buf.WriteString(fmt.Sprintf("       %s\n", oneLineStr(hit.Snippet, MaxSearchSnippetLen)))
+		}
+	}
+	if !history.IsSearchIndexAvailable() {
+		buf.WriteString("(full-text index not available, showing most recent substring matches)\n")
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{
+		InfoTitle: fmt.Sprintf("history search %s for %q (%d hits)", searchType, opts.Query, len(hits)),
+		InfoLines: splitLinesForInfo(buf.String()),
+	})
+	return update, nil
+}
@@ -0,0 +1,137 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/abhishek944/waveterm/wavesrv/pkg/history"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/scbus"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/scpacket"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+)
+
+const HistoryTagUsageStr = "usage: /history:tag <historynums> tag1[,tag2...] (historynums are e.g. 12, s12, g12, 10-14, comma separated)"
+const HistoryUntagUsageStr = "usage: /history:untag <historynums> [tag1,tag2...] (no tags removes all tags)"
+const HistoryNoteUsageStr = "usage: /history:note <historynums> [text] (no text clears the note)"
+
+const MaxHistoryTagLen = 50
+
+var historyTagRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.:-]*$`)
+
+// parses a comma separated tag list, tags are sorted and must be unique
+func resolveHistoryTags(arg string) ([]string, error) {
+	var rtn []string
+	for tag := range resolveCommaSepListToMap(arg) {
+		if tag == "" {
+			continue
+		}
+		if len(tag) > MaxHistoryTagLen {
+			return nil, fmt.Errorf("tag %q is too long (max %d chars)", tag, MaxHistoryTagLen)
+		}
+		if !historyTagRe.MatchString(tag) {
+			return nil, fmt.Errorf("invalid tag %q (tags are letters, digits, '-', '_', '.' and ':', starting with a letter or digit)", tag)
+		}
+		rtn = append(rtn, tag)
+	}
+	sort.Strings(rtn)
+	return rtn, nil
+}
+
+// resolves the first arg (comma separated historynums) to historyids, see history.ResolveHistoryNums
+func resolveHistoryNumsArg(ctx context.Context, pk *scpacket.FeCommandPacketType) ([]string, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, err
+	}
+	var hnumArgs []string
+	for _, arg := range strings.Split(pk.Args[0], ",") {
+		arg = strings.TrimSpace(arg)
+		if arg != "" {
+			hnumArgs = append(hnumArgs, arg)
+		}
+	}
+	if len(hnumArgs) == 0 {
+		return nil, fmt.Errorf("no historynums given")
+	}
+	return history.ResolveHistoryNums(ctx, ids.SessionId, ids.ScreenId, hnumArgs)
+}
+
+// /history:tag <historynums> tag1[,tag2...]
+func HistoryTagCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	if len(pk.Args) != 2 {
+		return nil, fmt.Errorf(HistoryTagUsageStr)
+	}
+	tags, err := resolveHistoryTags(pk.Args[1])
+	if err != nil {
+		return nil, fmt.Errorf("/history:tag %v", err)
+	}
+	if len(tags) == 0 {
+		return nil, fmt.Errorf(HistoryTagUsageStr)
+	}
+	historyIds, err := resolveHistoryNumsArg(ctx, pk)
+	if err != nil {
+		return nil, fmt.Errorf("/history:tag %v", err)
+	}
+	numChanged, err := history.UpdateHistoryTags(ctx, historyIds, tags, nil, false)
+	if err != nil {
+		return nil, fmt.Errorf("/history:tag error updating tags: %v", err)
+	}
+	return sstore.InfoMsgUpdate("tagged %d history items with %s (%d changed)", len(historyIds), strings.Join(tags, ", "), numChanged), nil
+}
+
+// /history:untag <historynums> [tag1,tag2...]
+func HistoryUntagCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	if len(pk.Args) != 1 && len(pk.Args) != 2 {
+		return nil, fmt.Errorf(HistoryUntagUsageStr)
+	}
+	var tags []string
+	if len(pk.Args) == 2 {
+		var err error
+		tags, err = resolveHistoryTags(pk.Args[1])
+		if err != nil {
+			return nil, fmt.Errorf("/history:untag %v", err)
+		}
+	}
+	historyIds, err := resolveHistoryNumsArg(ctx, pk)
+	if err != nil {
+		return nil, fmt.Errorf("/history:untag %v", err)
+	}
+	removeAll := len(tags) == 0
+	numChanged, err := history.UpdateHistoryTags(ctx, historyIds, nil, tags, removeAll)
+	if err != nil {
+		return nil, fmt.Errorf("/history:untag error updating tags: %v", err)
+	}
+	if removeAll {
+		return sstore.InfoMsgUpdate("removed all tags from %d history items (%d changed)", len(historyIds), numChanged), nil
+	}
+	return sstore.InfoMsgUpdate("removed %s from %d history items (%d changed)", strings.Join(tags, ", "), len(historyIds), numChanged), nil
+}
+
+// /history:note <historynums> [text]
+func HistoryNoteCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	if len(pk.Args) == 0 {
+		return nil, fmt.Errorf(HistoryNoteUsageStr)
+	}
+	note := strings.TrimSpace(strings.Join(pk.Args[1:], " "))
+	if len(note) > history.MaxHistoryNoteLen {
+		return nil, fmt.Errorf("/history:note note is too long (max %d bytes)", history.MaxHistoryNoteLen)
+	}
+	historyIds, err := resolveHistoryNumsArg(ctx, pk)
+	if err != nil {
+		return nil, fmt.Errorf("/history:note %v", err)
+	}
+	numChanged, err := history.SetHistoryNote(ctx, historyIds, note)
+	if err != nil {
+		return nil, fmt.Errorf("/history:note error setting note: %v", err)
+	}
+	if note == "" {
+		return sstore.InfoMsgUpdate("cleared the note on %d history items (%d changed)", len(historyIds), numChanged), nil
+	}
+	return sstore.InfoMsgUpdate("set the note on %d history items (%d changed)", len(historyIds), numChanged), nil
+}
@@ -0,0 +1,211 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abhishek944/waveterm/waveshell/pkg/packet"
+	"github.com/abhishek944/waveterm/waveshell/pkg/utilfn"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/prompts"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/remote"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/scbus"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/scpacket"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+)
+
+const AIModeExplain = "explain"
+
+const ExplainTimeout = 60 * time.Second
+const MaxExplainOutputBytes = 4000
+const MaxExplainLen = 2000
+const MaxExplainFixLen = 200
+
+// output can still be arriving when cmddone is processed
+const AutoExplainDelay = 500 * time.Millisecond
+
+// exit code of a command interrupted with Ctrl-C (not explained automatically)
+const ExitCodeInterrupted = 130
+
+// the annotation stored in the line state under sstore.LineState_Explain
+type lineExplainType struct {
+	Explanation string   `json:"explanation"`
+	Fixes       []string `json:"fixes,omitempty"`
+	ExitCode    int      `json:"exitcode"`
+	Provider    string   `json:"provider"`
+	Model       string   `json:"model,omitempty"`
+	Ts          int64    `json:"ts"`
+}
+
+// asks the AI provider why the command on the line failed, and stores the answer on the line
+// provider == "" uses the default provider.  returns the updated line
+func explainLine(ctx context.Context, screenId string, lineId string, provider string) (*lineExplainType, *sstore.LineType, error) {
+	line, cmd, err := sstore.GetLineCmdByLineId(ctx, screenId, lineId)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot get line: %v", err)
+	}
+	if line == nil || cmd == nil || line.LineType != sstore.LineTypeCmd {
+		return nil, nil, fmt.Errorf("line is not a command")
+	}
+	if cmd.Status != sstore.CmdStatusDone && cmd.Status != sstore.CmdStatusError {
+		return nil, nil, fmt.Errorf("command has not finished (status %s)", cmd.Status)
+	}
+	if cmd.ExitCode == 0 {
+		return nil, nil, fmt.Errorf("command did not fail (exit code 0)")
+	}
+	clientData, err := sstore.EnsureClientData(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot retrieve client data: %v", err)
+	}
+	if provider == "" {
+		provider, err = GetAIProvider(clientData)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	redactor, err := prompts.MakeRedactor(getAIContextOpts(clientData).RedactPatterns)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, data, err := sstore.ReadFullPtyOutFile(ctx, screenId, lineId)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot read command output: %v", err)
+	}
+	fctx := &prompts.FailedCmdContextType{
+		CmdStr:   cmd.CmdStr,
+		ExitCode: cmd.ExitCode,
+		Cwd:      cmd.FeState["cwd"],
+		OsType:   GetOsTypeFromRuntime(),
+		Output:   prompts.TailTruncate(prompts.StripAnsi(string(data)), MaxExplainOutputBytes),
+	}
+	if wsh := remote.GetRemoteById(cmd.Remote.RemoteId); wsh != nil {
+		fctx.ShellType = wsh.GetShellType()
+	}
+	request := &AIRequest{
+		Mode: AIModeExplain,
+		Prompt: []packet.OpenAIPromptMessageType{
+			{Role: "system", Content: prompts.ExplainFailedCmdPrompt},
+			{Role: "user", Content: fctx.Format(redactor)},
+		},
+		Provider: provider,
+		Context:  ctx,
+		ScreenId: screenId,
+	}
+	resp, err := RunAICompletion(ctx, clientData, request)
+	if err != nil {
+		return nil, nil, err
+	}
+	explain := &lineExplainType{ExitCode: cmd.ExitCode, Provider: provider, Ts: time.Now().UnixMilli()}
+	var text strings.Builder
+	for _, pk := range resp.Packets {
+		if pk.Error != "" {
+			return nil, nil, fmt.Errorf("%s error: %s", provider, pk.Error)
+		}
+		if pk.Model != "" {
+			explain.Model = pk.Model
+		}
+		text.WriteString(pk.Text)
+	}
+	explanation, fixes := prompts.ParseExplainResponse(text.String())
+	if explanation == "" {
+		return nil, nil, fmt.Errorf("%s returned an empty response", provider)
+	}
+	explain.Explanation = utilfn.EllipsisStr(explanation, MaxExplainLen)
+	for _, fix := range fixes {
+		explain.Fixes = append(explain.Fixes, utilfn.EllipsisStr(fix, MaxExplainFixLen))
+	}
+	// only the explain key is written, the line state can change while the AI request runs
+	err = sstore.SetLineStateKey(ctx, screenId, lineId, sstore.LineState_Explain, explain)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot update linestate: %v", err)
+	}
+	line, err = sstore.GetLineById(ctx, screenId, lineId)
+	if err != nil || line == nil {
+		return nil, nil, fmt.Errorf("cannot retrieve updated line: %v", err)
+	}
+	return explain, line, nil
+}
+
+func formatLineExplain(explain *lineExplainType) string {
+	var buf bytes.Buffer
+	buf.WriteString(explain.Explanation)
+	buf.WriteString("\n")
+	if len(explain.Fixes) > 0 {
+		buf.WriteString("\nsuggested fixes:\n")
+		for _, fix := range explain.Fixes {
+			buf.WriteString(fmt.Sprintf("  %s\n", fix))
+		}
+	}
+	return buf.String()
+}
+
+// /line:explain [line] [provider=name]
+func LineExplainCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, err
+	}
+	if len(pk.Args) > 1 {
+		return nil, fmt.Errorf("/line:explain only takes 1 argument (line number or id)")
+	}
+	lineArg := firstArg(pk)
+	if lineArg == "" {
+		screen, err := sstore.GetScreenById(ctx, ids.ScreenId)
+		if err != nil {
+			return nil, fmt.Errorf("/line:explain cannot get screen: %v", err)
+		}
+		if screen == nil || screen.SelectedLine <= 0 {
+			return nil, fmt.Errorf("/line:explain requires an argument (line number or id), no line is selected")
+		}
+		lineArg = strconv.FormatInt(screen.SelectedLine, 10)
+	}
+	lineId, err := sstore.FindLineIdByArg(ctx, ids.ScreenId, lineArg)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up lineid: %v", err)
+	}
+	if lineId == "" {
+		return nil, fmt.Errorf("line %q not found", lineArg)
+	}
+	explainCtx, cancelFn := context.WithTimeout(ctx, ExplainTimeout)
+	defer cancelFn()
+	explain, line, err := explainLine(explainCtx, ids.ScreenId, lineId, pk.Kwargs["provider"])
+	if err != nil {
+		return nil, fmt.Errorf("/line:explain error: %v", err)
+	}
+	update := scbus.MakeUpdatePacket()
+	sstore.AddLineUpdate(update, line, nil)
+	update.AddUpdate(sstore.InfoMsgType{
+		InfoTitle: fmt.Sprintf("line %d failed with exit code %d", line.LineNum, explain.ExitCode),
+		InfoLines: splitLinesForInfo(formatLineExplain(explain)),
+	})
+	return update, nil
+}
+
+// remote.CmdFailedHandler, explains failed commands on screens with autoexplain set
+func autoExplainFailedCmd(screenId string, lineId string, exitCode int) {
+	if exitCode == ExitCodeInterrupted {
+		return
+	}
+	ctx, cancelFn := context.WithTimeout(context.Background(), ExplainTimeout)
+	defer cancelFn()
+	screen, err := sstore.GetScreenById(ctx, screenId)
+	if err != nil || screen == nil || !screen.ScreenOpts.AutoExplain {
+		return
+	}
+	time.Sleep(AutoExplainDelay)
+	_, line, err := explainLine(ctx, screenId, lineId, "")
+	if err != nil {
+		log.Printf("autoexplain error (line %s): %v\n", lineId, err)
+		return
+	}
+	update := scbus.MakeUpdatePacket()
+	sstore.AddLineUpdate(update, line, nil)
+	scbus.MainUpdateBus.DoScreenUpdate(screenId, update)
+}
@@ -0,0 +1,523 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abhishek944/waveterm/waveshell/pkg/base"
+	"github.com/abhishek944/waveterm/waveshell/pkg/shellapi"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/bundle"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/playbook"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/remote"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/scbus"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/scpacket"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/userinput"
+	"github.com/google/uuid"
+)
+
+const MaxPlaybookAliasLen = 50
+const PlaybookCmdPollInterval = 200 * time.Millisecond
+
+func resolvePlaybook(ctx context.Context, playbookArg string) (*playbook.PlaybookType, error) {
+	if playbookArg == "" {
+		return nil, fmt.Errorf("no playbook specified")
+	}
+	playbookId, err := playbook.GetPlaybookIdByArg(ctx, playbookArg)
+	if err != nil {
+		return nil, fmt.Errorf("error trying to resolve playbook: %v", err)
+	}
+	if playbookId == "" {
+		return nil, fmt.Errorf("playbook %q not found", playbookArg)
+	}
+	pb, err := playbook.GetPlaybookById(ctx, playbookId)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving playbook: %v", err)
+	}
+	if pb == nil {
+		return nil, fmt.Errorf("playbook %q not found", playbookArg)
+	}
+	return pb, nil
+}
+
+// entryArg can be a 1-based entry index, an entry alias, or an entry id
+func resolvePlaybookEntry(pb *playbook.PlaybookType, entryArg string) (*playbook.PlaybookEntry, error) {
+	if isAllDigits(entryArg) {
+		entryIdx, _ := strconv.Atoi(entryArg)
+		if entryIdx <= 0 || entryIdx > len(pb.Entries) {
+			return nil, fmt.Errorf("entry index %d out of range (playbook has %d entries)", entryIdx, len(pb.Entries))
+		}
+		return pb.Entries[entryIdx-1], nil
+	}
+	for _, entry := range pb.Entries {
+		if entry.Alias != "" && entry.Alias == entryArg {
+			return entry, nil
+		}
+		if entry.EntryId == entryArg || (len(entryArg) == 8 && strings.HasPrefix(entry.EntryId, entryArg)) {
+			return entry, nil
+		}
+	}
+	return nil, fmt.Errorf("entry %q not found in playbook %q", entryArg, pb.PlaybookName)
+}
+
+func PlaybookNewCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	name := firstArg(pk)
+	if name == "" {
+		return nil, fmt.Errorf("usage: /playbook:new [name]")
+	}
+	err := validateName(name, "playbook")
+	if err != nil {
+		return nil, err
+	}
+	pb, err := playbook.CreatePlaybook(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("/playbook:new error: %v", err)
+	}
+	return sstore.InfoMsgUpdate("created playbook %q [%s]", pb.PlaybookName, pb.PlaybookId[0:8]), nil
+}
+
+func PlaybookAddCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	if len(pk.Args) < 2 && pk.Kwargs["line"] == "" {
+		return nil, fmt.Errorf("usage: /playbook:add [playbook] [line-number|cmdstr]")
+	}
+	pb, err := resolvePlaybook(ctx, firstArg(pk))
+	if err != nil {
+		return nil, fmt.Errorf("/playbook:add error: %v", err)
+	}
+	lineArg := pk.Kwargs["line"]
+	cmdStr := strings.TrimSpace(strings.Join(pk.Args[1:], " "))
+	if lineArg == "" && isAllDigits(cmdStr) {
+		lineArg = cmdStr
+	}
+	if lineArg != "" {
+		ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+		if err != nil {
+			return nil, fmt.Errorf("/playbook:add error: %w", err)
+		}
+		lineId, err := sstore.FindLineIdByArg(ctx, ids.ScreenId, lineArg)
+		if err != nil {
+			return nil, fmt.Errorf("error looking up lineid: %v", err)
+		}
+		if lineId == "" {
+			return nil, fmt.Errorf("line %q not found", lineArg)
+		}
+		_, cmdObj, err := sstore.GetLineCmdByLineId(ctx, ids.ScreenId, lineId)
+		if err != nil {
+			return nil, fmt.Errorf("/playbook:add error getting line: %v", err)
+		}
+		if cmdObj == nil {
+			return nil, fmt.Errorf("cannot add non-cmd line to playbook")
+		}
+		cmdStr = cmdObj.CmdStr
+	}
+	if cmdStr == "" {
+		return nil, fmt.Errorf("/playbook:add cannot add an empty command")
+	}
+	if len(cmdStr) > MaxCommandLen {
+		return nil, fmt.Errorf("command length too long len:%d, max:%d", len(cmdStr), MaxCommandLen)
+	}
+	_, err = playbook.ParseTemplateVars(cmdStr)
+	if err != nil {
+		return nil, fmt.Errorf("/playbook:add invalid template: %v", err)
+	}
+	alias := pk.Kwargs["alias"]
+	if len(alias) > MaxPlaybookAliasLen {
+		return nil, fmt.Errorf("alias too long, max length = %d", MaxPlaybookAliasLen)
+	}
+	nowTs := time.Now().UnixMilli()
+	entry := &playbook.PlaybookEntry{
+		PlaybookId:  pb.PlaybookId,
+		EntryId:     uuid.New().String(),
+		Alias:       alias,
+		CmdStr:      cmdStr,
+		CreatedTs:   nowTs,
+		UpdatedTs:   nowTs,
+		Description: pk.Kwargs["desc"],
+	}
+	err = playbook.AddPlaybookEntry(ctx, entry)
+	if err != nil {
+		return nil, fmt.Errorf("/playbook:add error: %v", err)
+	}
+	return sstore.InfoMsgUpdate("added entry #%d to playbook %q", len(pb.Entries)+1, pb.PlaybookName), nil
+}
+
+func PlaybookRemoveCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	if len(pk.Args) < 2 {
+		return nil, fmt.Errorf("usage: /playbook:remove [playbook] [entry-index|alias|entryid]")
+	}
+	pb, err := resolvePlaybook(ctx, firstArg(pk))
+	if err != nil {
+		return nil, fmt.Errorf("/playbook:remove error: %v", err)
+	}
+	entry, err := resolvePlaybookEntry(pb, pk.Args[1])
+	if err != nil {
+		return nil, fmt.Errorf("/playbook:remove error: %v", err)
+	}
+	err = playbook.RemovePlaybookEntry(ctx, pb.PlaybookId, entry.EntryId)
+	if err != nil {
+		return nil, fmt.Errorf("/playbook:remove error: %v", err)
+	}
+	return sstore.InfoMsgUpdate("removed entry from playbook %q", pb.PlaybookName), nil
+}
+
+func PlaybookShowAllCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	pbs, err := playbook.GetAllPlaybooks(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("/playbook:showall error getting playbooks: %v", err)
+	}
+	var buf bytes.Buffer
+	for _, pb := range pbs {
+		buf.WriteString(fmt.Sprintf("%-30s %s  %d entries\n", pb.PlaybookName, pb.PlaybookId[0:8], len(pb.EntryIds)))
+	}
+	if len(pbs) == 0 {
+		buf.WriteString("(no playbooks)\n")
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{
+		InfoTitle: "all playbooks",
+		InfoLines: splitLinesForInfo(buf.String()),
+	})
+	return update, nil
+}
+
+func PlaybookShowCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	if firstArg(pk) == "" {
+		return PlaybookShowAllCommand(ctx, pk)
+	}
+	pb, err := resolvePlaybook(ctx, firstArg(pk))
+	if err != nil {
+		return nil, fmt.Errorf("/playbook:show error: %v", err)
+	}
+	var buf bytes.Buffer
+	if pb.Description != "" {
+		buf.WriteString(fmt.Sprintf("  %s\n", pb.Description))
+	}
+	for idx, entry := range pb.Entries {
+		aliasStr := ""
+		if entry.Alias != "" {
+			aliasStr = fmt.Sprintf(" (%s)", entry.Alias)
+		}
+		buf.WriteString(fmt.Sprintf("  %3d%s  %s\n", idx+1, aliasStr, entry.CmdStr))
+		if entry.Description != "" {
+			buf.WriteString(fmt.Sprintf("       # %s\n", entry.Description))
+		}
+	}
+	if len(pb.Entries) == 0 {
+		buf.WriteString("  (no entries)\n")
+	}
+	tvars, _ := pb.GetTemplateVars()
+	for _, tv := range tvars {
+		defaultStr := ""
+		if tv.HasDefault {
+			defaultStr = fmt.Sprintf(" (default %s)", tv.Default)
+		}
+		buf.WriteString(fmt.Sprintf("  var %s:%s%s\n", tv.Name, tv.Type, defaultStr))
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{
+		InfoTitle: fmt.Sprintf("playbook %q [%s]", pb.PlaybookName, pb.PlaybookId[0:8]),
+		InfoLines: splitLinesForInfo(buf.String()),
+	})
+	return update, nil
+}
+
+func PlaybookRunCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen|R_RemoteConnected)
+	if err != nil {
+		return nil, fmt.Errorf("/playbook:run error: %w", err)
+	}
+	pb, err := resolvePlaybook(ctx, firstArg(pk))
+	if err != nil {
+		return nil, fmt.Errorf("/playbook:run error: %v", err)
+	}
+	if len(pb.Entries) == 0 {
+		return nil, fmt.Errorf("/playbook:run playbook %q has no entries", pb.PlaybookName)
+	}
+	_, err = pb.GetTemplateVars()
+	if err != nil {
+		return nil, fmt.Errorf("/playbook:run invalid template: %v", err)
+	}
+	go runPlaybookEntries(pk, ids, pb)
+	return sstore.InfoMsgUpdate("running playbook %q (%d entries)", pb.PlaybookName, len(pb.Entries)), nil
+}
+
+// no context because it is called as a goroutine
+func runPlaybookEntries(pk *scpacket.FeCommandPacketType, ids resolvedIds, pb *playbook.PlaybookType) {
+	defer func() {
+		r := recover()
+		if r != nil {
+			log.Printf("panic in runPlaybookEntries: %v\n", r)
+		}
+	}()
+	vals, err := resolvePlaybookVars(pk, pb)
+	if err != nil {
+		sendPlaybookInfoMsg(ids.ScreenId, true, "playbook %q not run: %v", pb.PlaybookName, err)
+		return
+	}
+	cmdStrs := make([]string, len(pb.Entries))
+	for idx, entry := range pb.Entries {
+		cmdStrs[idx], err = playbook.ExpandTemplate(entry.CmdStr, vals)
+		if err != nil {
+			sendPlaybookInfoMsg(ids.ScreenId, true, "playbook %q not run, entry #%d: %v", pb.PlaybookName, idx+1, err)
+			return
+		}
+	}
+	for idx, cmdStr := range cmdStrs {
+		cmd, err := runPlaybookEntry(pk, cmdStr)
+		if err != nil {
+			sendPlaybookInfoMsg(ids.ScreenId, true, "playbook %q stopped at entry #%d: %v", pb.PlaybookName, idx+1, err)
+			return
+		}
+		doneCmd, err := waitForCmdDone(ids.Remote.Waveshell, cmd.ScreenId, cmd.LineId)
+		if err != nil {
+			sendPlaybookInfoMsg(ids.ScreenId, true, "playbook %q stopped at entry #%d: %v", pb.PlaybookName, idx+1, err)
+			return
+		}
+		if doneCmd.Status != sstore.CmdStatusDone || doneCmd.ExitCode != 0 {
+			sendPlaybookInfoMsg(ids.ScreenId, true, "playbook %q stopped at entry #%d (status=%s, exitcode=%d)", pb.PlaybookName, idx+1, doneCmd.Status, doneCmd.ExitCode)
+			return
+		}
+	}
+	sendPlaybookInfoMsg(ids.ScreenId, false, "playbook %q finished (%d entries)", pb.PlaybookName, len(pb.Entries))
+}
+
+// values come from the command's kwargs (bracket args), variables with no value and
+// no default are prompted for (once per variable)
+func resolvePlaybookVars(pk *scpacket.FeCommandPacketType, pb *playbook.PlaybookType) (map[string]string, error) {
+	tvars, err := pb.GetTemplateVars()
+	if err != nil {
+		return nil, err
+	}
+	rtn := make(map[string]string)
+	for _, tv := range tvars {
+		if val, found := pk.Kwargs[tv.Name]; found {
+			rtn[tv.Name] = val
+			continue
+		}
+		if tv.HasDefault {
+			continue
+		}
+		request := &userinput.UserInputRequestType{
+			ResponseType: "text",
+			QueryText:    fmt.Sprintf("Enter a value for `%s` (%s):", tv.Name, tv.Type),
+			Markdown:     true,
+			Title:        fmt.Sprintf("Playbook %s", pb.PlaybookName),
+			PublicText:   true,
+		}
+		ctx, cancelFn := context.WithTimeout(context.Background(), 60*time.Second)
+		response, err := userinput.GetUserInput(ctx, scbus.MainRpcBus, request)
+		cancelFn()
+		if err != nil {
+			return nil, fmt.Errorf("no value for %q: %v", tv.Name, err)
+		}
+		_, err = tv.NormalizeValue(response.Text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %q: %v", tv.Name, err)
+		}
+		rtn[tv.Name] = response.Text
+	}
+	return rtn, nil
+}
+
+// runs cmdStr as if it were typed by the user (adds a line and a history item), returns the new cmd
+func runPlaybookEntry(pk *scpacket.FeCommandPacketType, cmdStr string) (*sstore.CmdType, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelFn()
+	runPk := scpacket.MakeFeCommandPacket()
+	runPk.MetaCmd = "run"
+	runPk.Args = []string{cmdStr}
+	runPk.RawStr = cmdStr
+	runPk.UIContext = pk.UIContext
+	var historyContext historyContextType
+	ctxWithHistory := context.WithValue(ctx, historyContextKey, &historyContext)
+	_, runErr := RunCommand(ctxWithHistory, runPk)
+	err := addToHistory(ctx, runPk, historyContext, false, (runErr != nil))
+	if err != nil {
+		log.Printf("[error] adding playbook entry to history: %v\n", err)
+		// fall through (non-fatal error)
+	}
+	if runErr != nil {
+		return nil, runErr
+	}
+	if historyContext.LineId == "" {
+		return nil, fmt.Errorf("command did not create a line")
+	}
+	cmd, err := sstore.GetCmdByScreenId(ctx, pk.UIContext.ScreenId, historyContext.LineId)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get cmd: %v", err)
+	}
+	if cmd == nil {
+		return nil, fmt.Errorf("cmd not found")
+	}
+	return cmd, nil
+}
+
+// polls the DB until the given command is no longer running
+func waitForCmdDone(wsh *remote.WaveshellProc, screenId string, lineId string) (*sstore.CmdType, error) {
+	for {
+		ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Second)
+		cmd, err := sstore.GetCmdByScreenId(ctx, screenId, lineId)
+		cancelFn()
+		if err != nil {
+			return nil, fmt.Errorf("cannot get cmd: %v", err)
+		}
+		if cmd == nil {
+			return nil, fmt.Errorf("cmd not found (line was deleted)")
+		}
+		if cmd.Status != sstore.CmdStatusRunning && cmd.Status != sstore.CmdStatusDetached {
+			return cmd, nil
+		}
+		if wsh != nil && !wsh.IsConnected() {
+			return nil, fmt.Errorf("remote disconnected")
+		}
+		time.Sleep(PlaybookCmdPollInterval)
+	}
+}
+
+func sendPlaybookInfoMsg(screenId string, isError bool, msgFmt string, args ...interface{}) {
+	msg := fmt.Sprintf(msgFmt, args...)
+	update := scbus.MakeUpdatePacket()
+	if isError {
+		update.AddUpdate(sstore.InfoMsgType{InfoError: msg})
+	} else {
+		update.AddUpdate(sstore.InfoMsgType{InfoMsg: msg, TimeoutMs: 2000})
+	}
+	scbus.MainUpdateBus.DoScreenUpdate(screenId, update)
+}
+
+func resolveBundleFileArgs(pk *scpacket.FeCommandPacketType, fileArg string, mustExist bool) (string, string, error) {
+	if fileArg == "" {
+		return "", "", fmt.Errorf("no file specified")
+	}
+	var fileName string
+	var err error
+	if mustExist {
+		fileName, err = resolveFile(fileArg)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid file %q: %v", fileArg, err)
+		}
+	} else {
+		fileName = base.ExpandHomeDir(fileArg)
+		if !strings.HasPrefix(fileName, "/") {
+			return "", "", fmt.Errorf("invalid file %q: must be absolute, cannot be a relative path", fileArg)
+		}
+	}
+	format, err := bundle.ResolveFormat(fileName, pk.Kwargs["format"])
+	if err != nil {
+		return "", "", err
+	}
+	return fileName, format, nil
+}
+
+func resolveBundleMergeArgs(ctx context.Context, pk *scpacket.FeCommandPacketType) (string, shellapi.ShellApi, error) {
+	strategy := defaultStr(pk.Kwargs["merge"], bundle.MergeSkip)
+	err := bundle.ValidateMergeStrategy(strategy)
+	if err != nil {
+		return "", nil, err
+	}
+	// commands are validated against the current remote's shell (if any), falling back to bash
+	var defaultShell string
+	ids, err := resolveUiIds(ctx, pk, 0)
+	if err == nil && ids.Remote != nil {
+		defaultShell = ids.Remote.ShellType
+	}
+	shellType, err := resolveShellType(pk.Kwargs["shell"], defaultShell)
+	if err != nil {
+		return "", nil, err
+	}
+	sapi, err := shellapi.MakeShellApi(shellType)
+	if err != nil {
+		return "", nil, err
+	}
+	return strategy, sapi, nil
+}
+
+func formatValidationErrors(title string, errs []error) *scbus.ModelUpdatePacketType {
+	var lines []string
+	for _, err := range errs {
+		lines = append(lines, err.Error())
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{
+		InfoTitle: title,
+		InfoError: fmt.Sprintf("%d invalid command(s), nothing was imported", len(errs)),
+		InfoLines: lines,
+	})
+	return update
+}
+
+func PlaybookExportCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	if len(pk.Args) < 2 {
+		return nil, fmt.Errorf("usage: /playbook:export [playbook|*] [file]")
+	}
+	fileName, format, err := resolveBundleFileArgs(pk, pk.Args[1], false)
+	if err != nil {
+		return nil, fmt.Errorf("/playbook:export error: %v", err)
+	}
+	var pbs []*playbook.PlaybookType
+	if pk.Args[0] == "*" {
+		allPbs, err := playbook.GetAllPlaybooks(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("/playbook:export error getting playbooks: %v", err)
+		}
+		for _, pb := range allPbs {
+			fullPb, err := playbook.GetPlaybookById(ctx, pb.PlaybookId)
+			if err != nil {
+				return nil, fmt.Errorf("/playbook:export error getting playbook: %v", err)
+			}
+			if fullPb != nil {
+				pbs = append(pbs, fullPb)
+			}
+		}
+	} else {
+		pb, err := resolvePlaybook(ctx, pk.Args[0])
+		if err != nil {
+			return nil, fmt.Errorf("/playbook:export error: %v", err)
+		}
+		pbs = append(pbs, pb)
+	}
+	b := &bundle.BundleType{}
+	for _, pb := range pbs {
+		b.Playbooks = append(b.Playbooks, bundle.MakePlaybookBundle(pb))
+	}
+	err = bundle.WriteBundleFile(fileName, format, b)
+	if err != nil {
+		return nil, fmt.Errorf("/playbook:export error: %v", err)
+	}
+	return sstore.InfoMsgUpdate("exported %d playbook(s) to %s", len(pbs), fileName), nil
+}
+
+func PlaybookImportCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	fileName, format, err := resolveBundleFileArgs(pk, firstArg(pk), true)
+	if err != nil {
+		return nil, fmt.Errorf("/playbook:import error: %v", err)
+	}
+	strategy, sapi, err := resolveBundleMergeArgs(ctx, pk)
+	if err != nil {
+		return nil, fmt.Errorf("/playbook:import error: %v", err)
+	}
+	b, err := bundle.ReadBundleFile(fileName, format)
+	if err != nil {
+		return nil, fmt.Errorf("/playbook:import error: %v", err)
+	}
+	if len(b.Playbooks) == 0 {
+		return nil, fmt.Errorf("/playbook:import no playbooks found in %s", fileName)
+	}
+	validationErrs := bundle.ValidatePlaybooks(b, sapi)
+	if len(validationErrs) > 0 {
+		return formatValidationErrors("playbook import failed", validationErrs), nil
+	}
+	result, err := bundle.ImportPlaybooks(ctx, b, strategy)
+	if err != nil {
+		return nil, fmt.Errorf("/playbook:import error, nothing was imported: %v", err)
+	}
+	return sstore.InfoMsgUpdate("imported playbooks from %s: %s", fileName, result), nil
+}
@@ -0,0 +1,154 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/abhishek944/waveterm/waveshell/pkg/shexec"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/remote"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/scbase"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/scbus"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/scpacket"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+)
+
+const MaxExecRemoteNameLen = 100
+
+// container / pod / namespace / context / user names (docker and kubernetes allow a subset of this)
+var execRemoteNameRe = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.:@-]*$`)
+
+var execRemoteSshArgs = []string{"key", "password", "jump", "agentforward", "port", "sudo"}
+
+func validateExecRemoteName(val string, typeStr string) error {
+	if len(val) > MaxExecRemoteNameLen {
+		return fmt.Errorf("%s too long, max length is %d", typeStr, MaxExecRemoteNameLen)
+	}
+	if !execRemoteNameRe.MatchString(val) {
+		return fmt.Errorf("invalid %s %q", typeStr, val)
+	}
+	return nil
+}
+
+func parseExecOpts(remoteType string, pk *scpacket.FeCommandPacketType) (*sstore.ExecOptsType, error) {
+	if len(pk.Args) == 0 {
+		return nil, fmt.Errorf("must specify a container or pod (type=%s)", remoteType)
+	}
+	opts := &sstore.ExecOptsType{Target: pk.Args[0]}
+	if remoteType == sstore.RemoteTypeDocker {
+		opts.User = pk.Kwargs["user"]
+		for _, kwarg := range []string{"namespace", "context", "container"} {
+			if pk.Kwargs[kwarg] != "" {
+				return nil, fmt.Errorf("%q is not valid for docker remotes", kwarg)
+			}
+		}
+	} else {
+		opts.Namespace = pk.Kwargs["namespace"]
+		opts.KubeContext = pk.Kwargs["context"]
+		opts.Container = pk.Kwargs["container"]
+		if pk.Kwargs["user"] != "" {
+			return nil, fmt.Errorf("\"user\" is not valid for kubectl remotes")
+		}
+	}
+	checks := [][2]string{{opts.Target, "target"}, {opts.User, "user"}, {opts.Namespace, "namespace"}, {opts.KubeContext, "context"}, {opts.Container, "container"}}
+	for _, check := range checks {
+		if check[0] == "" {
+			continue
+		}
+		err := validateExecRemoteName(check[0], check[1])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return opts, nil
+}
+
+// the common fields of a new non-ssh remote (alias, connectmode, shellpref, color), rejects the ssh-only args
+func makeNonSshRemote(pk *scpacket.FeCommandPacketType, remoteType string) (*sstore.RemoteType, error) {
+	for _, sshArg := range execRemoteSshArgs {
+		if _, found := pk.Kwargs[sshArg]; found {
+			return nil, fmt.Errorf("%q is not valid for %s remotes", sshArg, remoteType)
+		}
+	}
+	editArgs, err := parseRemoteEditArgs(false, pk, false)
+	if err != nil {
+		return nil, err
+	}
+	r := &sstore.RemoteType{
+		RemoteId:     scbase.GenWaveUUID(),
+		RemoteType:   remoteType,
+		RemoteAlias:  editArgs.Alias,
+		ConnectMode:  defaultStr(editArgs.ConnectMode, sstore.ConnectModeAuto),
+		AutoInstall:  true,
+		SSHOpts:      &sstore.SSHOpts{},
+		SSHConfigSrc: sstore.SSHConfigSrcTypeManual,
+		ShellPref:    defaultStr(editArgs.ShellPref, sstore.ShellTypePref_Detect),
+	}
+	if editArgs.Color != "" {
+		r.RemoteOpts = &sstore.RemoteOptsType{Color: editArgs.Color}
+	}
+	return r, nil
+}
+
+// /remote:new type=docker [container] [user=name]
+// /remote:new type=kubectl [pod] [namespace=ns] [context=ctx] [container=name]
+func remoteNewExecCommand(ctx context.Context, pk *scpacket.FeCommandPacketType, remoteType string) (scbus.UpdatePacket, error) {
+	execOpts, err := parseExecOpts(remoteType, pk)
+	if err != nil {
+		return nil, fmt.Errorf("/remote:new %v", err)
+	}
+	r, err := makeNonSshRemote(pk, remoteType)
+	if err != nil {
+		return nil, fmt.Errorf("/remote:new %v", err)
+	}
+	r.RemoteCanonicalName = remote.MakeExecRemoteCanonicalName(remoteType, execOpts)
+	r.RemoteUser = execOpts.User
+	r.RemoteHost = execOpts.Target
+	r.ExecOpts = execOpts
+	err = remote.AddRemote(ctx, r, true)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create remote %q: %v", r.RemoteCanonicalName, err)
+	}
+	return createRemoteViewRemoteIdUpdate(r.RemoteId), nil
+}
+
+// /remote:new type=socket [unix:/path | tcp:host:port] [keyfile=path | psk=key]
+func remoteNewSocketCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	if len(pk.Args) == 0 {
+		return nil, fmt.Errorf("/remote:new must specify an address (unix:/path or tcp:host:port) for socket remotes")
+	}
+	addr := pk.Args[0]
+	network, _, err := shexec.ParseSockAddr(addr)
+	if err != nil {
+		return nil, fmt.Errorf("/remote:new %v", err)
+	}
+	sockOpts := &sstore.SocketOptsType{Addr: addr, Key: pk.Kwargs["psk"]}
+	if pk.Kwargs["keyfile"] != "" {
+		if sockOpts.Key != "" {
+			return nil, fmt.Errorf("/remote:new cannot set both keyfile and psk")
+		}
+		sockOpts.KeyFile, err = resolveFile(pk.Kwargs["keyfile"])
+		if err != nil {
+			return nil, fmt.Errorf("/remote:new invalid keyfile %q: %v", pk.Kwargs["keyfile"], err)
+		}
+	}
+	if network == shexec.SockNetwork_Tcp && sockOpts.Key == "" && sockOpts.KeyFile == "" {
+		return nil, fmt.Errorf("/remote:new tcp socket remotes require a key (keyfile or psk)")
+	}
+	r, err := makeNonSshRemote(pk, sstore.RemoteTypeSocket)
+	if err != nil {
+		return nil, fmt.Errorf("/remote:new %v", err)
+	}
+	r.RemoteCanonicalName = addr
+	r.RemoteHost = addr
+	r.AutoInstall = false
+	r.SocketOpts = sockOpts
+	err = remote.AddRemote(ctx, r, true)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create remote %q: %v", r.RemoteCanonicalName, err)
+	}
+	return createRemoteViewRemoteIdUpdate(r.RemoteId), nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/abhishek944/waveterm/wavesrv/pkg/remote"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/scbus"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/scpacket"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+)
+
+func parseForwardType(typeStr string) (string, error) {
+	switch strings.ToLower(strings.TrimPrefix(typeStr, "-")) {
+	case "l", sstore.PortForwardType_Local:
+		return sstore.PortForwardType_Local, nil
+	case "r", sstore.PortForwardType_Remote:
+		return sstore.PortForwardType_Remote, nil
+	}
+	return "", fmt.Errorf("invalid forward type %q (must be L or R)", typeStr)
+}
+
+func makeForwardListUpdate(rstate remote.RemoteRuntimeState) scbus.UpdatePacket {
+	var buf bytes.Buffer
+	for idx, fwd := range rstate.Forwards {
+		statusStr := fwd.Status
+		if fwd.Status == sstore.PortForwardStatus_Active {
+			statusStr = fmt.Sprintf("active (%d conns)", fwd.NumConns)
+		} else if fwd.Status == sstore.PortForwardStatus_Error {
+			statusStr = fmt.Sprintf("error: %s", fwd.ErrorStr)
+		}
+		buf.WriteString(fmt.Sprintf("%2d  %-50s  %s\n", idx+1, fwd.PortForwardType.String(), statusStr))
+	}
+	if len(rstate.Forwards) == 0 {
+		buf.WriteString("no port forwards (add one with /remote:forward L [bind:]port:host:hostport)\n")
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{
+		InfoTitle: fmt.Sprintf("port forwards for %s", rstate.GetBaseDisplayName()),
+		InfoLines: splitLinesForInfo(buf.String()),
+	})
+	return update
+}
+
+// /remote:forward (lists forwards)
+// /remote:forward [L|R] [bind_address:]port:host:hostport
+func RemoteForwardCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen|R_Remote)
+	if err != nil {
+		return nil, err
+	}
+	if len(pk.Args) == 0 {
+		return makeForwardListUpdate(ids.Remote.Waveshell.GetRemoteRuntimeState()), nil
+	}
+	if len(pk.Args) != 2 {
+		return nil, fmt.Errorf("usage: /remote:forward [L|R] [bind_address:]port:host:hostport")
+	}
+	fwdType, err := parseForwardType(pk.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("/remote:forward %v", err)
+	}
+	spec, err := remote.ParsePortForwardSpec(fwdType, pk.Args[1])
+	if err != nil {
+		return nil, fmt.Errorf("/remote:forward %v", err)
+	}
+	err = ids.Remote.Waveshell.AddPortForward(ctx, spec)
+	if err != nil {
+		return nil, fmt.Errorf("/remote:forward %s: %v", spec, err)
+	}
+	if !ids.Remote.Waveshell.IsConnected() {
+		return sstore.InfoMsgUpdate("added port forward %s, it will start when %s connects", spec, ids.Remote.DisplayName), nil
+	}
+	return sstore.InfoMsgUpdate("started port forward %s", spec), nil
+}
+
+// /remote:unforward [n | L|R [bind_address:]port:host:hostport | all]
+func RemoteUnforwardCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen|R_Remote)
+	if err != nil {
+		return nil, err
+	}
+	wsh := ids.Remote.Waveshell
+	forwards := wsh.GetRemoteRuntimeState().Forwards
+	var toRemove []sstore.PortForwardType
+	if len(pk.Args) == 1 && pk.Args[0] == "all" {
+		for _, fwd := range forwards {
+			toRemove = append(toRemove, fwd.PortForwardType)
+		}
+	} else if len(pk.Args) == 1 {
+		fwdNum, err := strconv.Atoi(pk.Args[0])
+		if err != nil || fwdNum <= 0 || fwdNum > len(forwards) {
+			return nil, fmt.Errorf("/remote:unforward invalid forward number %q (see /remote:forward)", pk.Args[0])
+		}
+		toRemove = append(toRemove, forwards[fwdNum-1].PortForwardType)
+	} else if len(pk.Args) == 2 {
+		fwdType, err := parseForwardType(pk.Args[0])
+		if err != nil {
+			return nil, fmt.Errorf("/remote:unforward %v", err)
+		}
+		spec, err := remote.ParsePortForwardSpec(fwdType, pk.Args[1])
+		if err != nil {
+			return nil, fmt.Errorf("/remote:unforward %v", err)
+		}
+		for _, fwd := range forwards {
+			if fwd.Type == spec.Type && fwd.BindAddr == spec.BindAddr {
+				toRemove = append(toRemove, fwd.PortForwardType)
+			}
+		}
+		if len(toRemove) == 0 {
+			return nil, fmt.Errorf("/remote:unforward no forward found for %s", spec)
+		}
+	} else {
+		return nil, fmt.Errorf("usage: /remote:unforward [n | L|R [bind_address:]port:host:hostport | all]")
+	}
+	for _, spec := range toRemove {
+		err = wsh.RemovePortForward(ctx, spec)
+		if err != nil {
+			return nil, fmt.Errorf("/remote:unforward %s: %v", spec, err)
+		}
+	}
+	return sstore.InfoMsgUpdate("stopped %d port forward(s) on %s", len(toRemove), ids.Remote.DisplayName), nil
+}
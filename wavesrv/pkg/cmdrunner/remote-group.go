@@ -0,0 +1,395 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abhishek944/waveterm/waveshell/pkg/base"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/remote"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/scbus"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/scpacket"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+	"github.com/google/uuid"
+)
+
+const DefaultRunAllConcurrency = 5
+const MaxRunAllConcurrency = 20
+const RunAllCmdTimeout = 10 * time.Second
+
+const (
+	RunAllStatus_Done    = "done"
+	RunAllStatus_Failed  = "failed"
+	RunAllStatus_Error   = "error"
+	RunAllStatus_Skipped = "skipped"
+)
+
+// /run:all is a raw-args command (like /run), so its kwargs are parsed off the front of the command
+var runAllKwargRe = regexp.MustCompile(`^(group|concurrency)=(\S+)$`)
+
+// stored in the line state (sstore.LineState_RunAll) of every line started by a /run:all
+type runAllLineStateType struct {
+	RunId string `json:"runid"`
+	Group string `json:"group"`
+	Host  string `json:"host"`
+}
+
+type runAllResultType struct {
+	Host       string
+	LineNum    int64
+	Status     string
+	ExitCode   int
+	DurationMs int64
+	ErrStr     string
+}
+
+// returns the leading group= and concurrency= kwargs and the rest of the string (the command)
+func parseRunAllArgs(argStr string) (map[string]string, string) {
+	kwargs := make(map[string]string)
+	rest := strings.TrimSpace(argStr)
+	for rest != "" {
+		field, remaining, _ := strings.Cut(rest, " ")
+		m := runAllKwargRe.FindStringSubmatch(field)
+		if m == nil {
+			break
+		}
+		kwargs[m[1]] = m[2]
+		rest = strings.TrimSpace(remaining)
+	}
+	return kwargs, rest
+}
+
+func getRemoteGroupMemberName(remoteId string) string {
+	wsh := remote.GetRemoteById(remoteId)
+	if wsh == nil {
+		return fmt.Sprintf("[%s] (deleted)", remoteId[0:8])
+	}
+	return wsh.GetRemoteRuntimeState().GetBaseDisplayName()
+}
+
+func resolveRemoteGroupMembers(remoteArgs []string) ([]string, error) {
+	var rtn []string
+	for _, remoteArg := range remoteArgs {
+		wsh := remote.GetRemoteByArg(remoteArg)
+		if wsh == nil {
+			return nil, fmt.Errorf("remote %q not found", remoteArg)
+		}
+		rtn = append(rtn, wsh.GetRemoteCopy().RemoteId)
+	}
+	return rtn, nil
+}
+
+func makeRemoteGroupListUpdate(groups []*sstore.RemoteGroupType, title string) scbus.UpdatePacket {
+	var buf bytes.Buffer
+	for _, group := range groups {
+		buf.WriteString(fmt.Sprintf("%s (%d)\n", group.GroupName, len(group.RemoteIds)))
+		for _, remoteId := range group.RemoteIds {
+			statusStr := "not found"
+			if wsh := remote.GetRemoteById(remoteId); wsh != nil {
+				statusStr = wsh.GetStatus()
+			}
+			buf.WriteString(fmt.Sprintf("  %-30s  %s\n", getRemoteGroupMemberName(remoteId), statusStr))
+		}
+	}
+	if len(groups) == 0 {
+		buf.WriteString("no remote groups (create one with /remote:group add [group] [remote...])\n")
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{
+		InfoTitle: title,
+		InfoLines: splitLinesForInfo(buf.String()),
+	})
+	return update
+}
+
+// /remote:group [list]
+// /remote:group show [group]
+// /remote:group add [group] [remote...]
+// /remote:group remove [group] [remote...]
+// /remote:group delete [group]
+func RemoteGroupCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	subCmd := firstArg(pk)
+	var groupName string
+	if len(pk.Args) > 1 {
+		groupName = pk.Args[1]
+	}
+	switch subCmd {
+	case "", "list":
+		groups, err := sstore.GetAllRemoteGroups(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("/remote:group error: %v", err)
+		}
+		return makeRemoteGroupListUpdate(groups, "remote groups"), nil
+
+	case "show":
+		if groupName == "" {
+			return nil, fmt.Errorf("usage: /remote:group show [group]")
+		}
+		group, err := sstore.GetRemoteGroup(ctx, groupName)
+		if err != nil {
+			return nil, fmt.Errorf("/remote:group error: %v", err)
+		}
+		if group == nil {
+			return nil, fmt.Errorf("/remote:group remote group %q not found", groupName)
+		}
+		return makeRemoteGroupListUpdate([]*sstore.RemoteGroupType{group}, fmt.Sprintf("remote group %q", groupName)), nil
+
+	case "add":
+		if groupName == "" || len(pk.Args) < 3 {
+			return nil, fmt.Errorf("usage: /remote:group add [group] [remote...]")
+		}
+		err := validateName(groupName, "remote group")
+		if err != nil {
+			return nil, err
+		}
+		remoteIds, err := resolveRemoteGroupMembers(pk.Args[2:])
+		if err != nil {
+			return nil, fmt.Errorf("/remote:group %v", err)
+		}
+		group, err := sstore.AddRemoteGroupMembers(ctx, groupName, remoteIds)
+		if err != nil {
+			return nil, fmt.Errorf("/remote:group error: %v", err)
+		}
+		return sstore.InfoMsgUpdate("remote group %q now has %d member(s)", groupName, len(group.RemoteIds)), nil
+
+	case "remove":
+		if groupName == "" || len(pk.Args) < 3 {
+			return nil, fmt.Errorf("usage: /remote:group remove [group] [remote...]")
+		}
+		remoteIds, err := resolveRemoteGroupMembers(pk.Args[2:])
+		if err != nil {
+			return nil, fmt.Errorf("/remote:group %v", err)
+		}
+		numRemoved, err := sstore.RemoveRemoteGroupMembers(ctx, groupName, remoteIds)
+		if err != nil {
+			return nil, fmt.Errorf("/remote:group error: %v", err)
+		}
+		return sstore.InfoMsgUpdate("removed %d member(s) from remote group %q", numRemoved, groupName), nil
+
+	case "delete":
+		if groupName == "" {
+			return nil, fmt.Errorf("usage: /remote:group delete [group]")
+		}
+		err := sstore.DeleteRemoteGroup(ctx, groupName)
+		if err != nil {
+			return nil, fmt.Errorf("/remote:group error: %v", err)
+		}
+		return sstore.InfoMsgUpdate("deleted remote group %q", groupName), nil
+	}
+	return nil, fmt.Errorf("/remote:group invalid subcommand %q (must be list, show, add, remove, or delete)", subCmd)
+}
+
+// /run:all group=[group] [concurrency=n] [cmd]
+func RunAllCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen|R_Remote)
+	if err != nil {
+		return nil, fmt.Errorf("/run:all error: %w", err)
+	}
+	kwargs, cmdStr := parseRunAllArgs(firstArg(pk))
+	groupName := defaultStr(kwargs["group"], pk.Kwargs["group"])
+	if groupName == "" || cmdStr == "" {
+		return nil, fmt.Errorf("usage: /run:all group=[group] [concurrency=n] [cmd]")
+	}
+	concurrency, err := resolvePosInt(defaultStr(kwargs["concurrency"], pk.Kwargs["concurrency"]), DefaultRunAllConcurrency)
+	if err != nil {
+		return nil, fmt.Errorf("/run:all invalid concurrency: %v", err)
+	}
+	if concurrency > MaxRunAllConcurrency {
+		concurrency = MaxRunAllConcurrency
+	}
+	group, err := sstore.GetRemoteGroup(ctx, groupName)
+	if err != nil {
+		return nil, fmt.Errorf("/run:all error: %v", err)
+	}
+	if group == nil {
+		return nil, fmt.Errorf("/run:all remote group %q not found", groupName)
+	}
+	if len(group.RemoteIds) == 0 {
+		return nil, fmt.Errorf("/run:all remote group %q has no members", groupName)
+	}
+	go runAllHosts(pk, ids, group, cmdStr, concurrency)
+	return sstore.InfoMsgUpdate("running on %d host(s) in group %q (concurrency %d)", len(group.RemoteIds), groupName, concurrency), nil
+}
+
+// no context because it is called as a goroutine.  disconnected members are skipped (not auto-connected,
+// so a broadcast never triggers a pile of password prompts)
+func runAllHosts(pk *scpacket.FeCommandPacketType, ids resolvedIds, group *sstore.RemoteGroupType, cmdStr string, concurrency int) {
+	defer func() {
+		r := recover()
+		if r != nil {
+			log.Printf("panic in runAllHosts: %v\n", r)
+		}
+	}()
+	runId := uuid.New().String()
+	results := make([]*runAllResultType, len(group.RemoteIds))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for idx, remoteId := range group.RemoteIds {
+		host := getRemoteGroupMemberName(remoteId)
+		wsh := remote.GetRemoteById(remoteId)
+		if wsh == nil || !wsh.IsConnected() {
+			results[idx] = &runAllResultType{Host: host, Status: RunAllStatus_Skipped, ErrStr: "not connected"}
+			continue
+		}
+		lineState := runAllLineStateType{RunId: runId, Group: group.GroupName, Host: host}
+		wg.Add(1)
+		go func(idx int, wsh *remote.WaveshellProc) {
+			defer wg.Done()
+			defer func() {
+				r := recover()
+				if r != nil {
+					log.Printf("panic in runAllHosts [%s]: %v\n", lineState.Host, r)
+					results[idx] = &runAllResultType{Host: lineState.Host, Status: RunAllStatus_Error, ErrStr: "internal error"}
+				}
+			}()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[idx] = runAllHost(pk, ids, wsh, cmdStr, lineState)
+		}(idx, wsh)
+	}
+	wg.Wait()
+	err := writeRunAllSummary(pk, ids, group.GroupName, results)
+	if err != nil {
+		sendPlaybookInfoMsg(ids.ScreenId, true, "/run:all group %q finished, but the summary could not be written: %v", group.GroupName, err)
+	}
+}
+
+// creates the remote instance state on this screen if the remote has never been used here
+func ensureRunAllRemoteState(ctx context.Context, ids resolvedIds, wsh *remote.WaveshellProc, rptr sstore.RemotePtrType) error {
+	statePtr, err := sstore.GetRemoteStatePtr(ctx, ids.SessionId, ids.ScreenId, rptr)
+	if err != nil {
+		return err
+	}
+	if statePtr != nil {
+		return nil
+	}
+	ssPk, err := wsh.ReInit(ctx, base.CommandKey(""), wsh.GetShellPref(), nil, false)
+	if err != nil {
+		return err
+	}
+	if ssPk == nil || ssPk.State == nil {
+		return fmt.Errorf("no state received from connection (nil)")
+	}
+	_, err = sstore.UpdateRemoteState(ctx, ids.SessionId, ids.ScreenId, rptr, sstore.FeStateFromShellState(ssPk.State), ssPk.State, nil)
+	return err
+}
+
+func runAllHost(pk *scpacket.FeCommandPacketType, ids resolvedIds, wsh *remote.WaveshellProc, cmdStr string, lineState runAllLineStateType) *runAllResultType {
+	rtn := &runAllResultType{Host: lineState.Host}
+	startTime := time.Now()
+	lineId, err := startRunAllCmd(pk, ids, wsh, cmdStr, lineState)
+	if err != nil {
+		rtn.Status = RunAllStatus_Error
+		rtn.ErrStr = err.Error()
+		return rtn
+	}
+	doneCmd, err := waitForCmdDone(wsh, ids.ScreenId, lineId)
+	rtn.DurationMs = time.Since(startTime).Milliseconds()
+	if err != nil {
+		rtn.Status = RunAllStatus_Error
+		rtn.ErrStr = err.Error()
+		return rtn
+	}
+	if line, _ := sstore.GetLineById(context.Background(), ids.ScreenId, lineId); line != nil {
+		rtn.LineNum = line.LineNum
+	}
+	rtn.ExitCode = doneCmd.ExitCode
+	if doneCmd.DurationMs > 0 {
+		rtn.DurationMs = int64(doneCmd.DurationMs)
+	}
+	if doneCmd.Status == sstore.CmdStatusDone && doneCmd.ExitCode == 0 {
+		rtn.Status = RunAllStatus_Done
+	} else {
+		rtn.Status = RunAllStatus_Failed
+		if doneCmd.Status != sstore.CmdStatusDone {
+			rtn.ErrStr = doneCmd.Status
+		}
+	}
+	return rtn
+}
+
+// runs cmdStr on the given remote (through RunCommand), returns the new lineid
+func startRunAllCmd(pk *scpacket.FeCommandPacketType, ids resolvedIds, wsh *remote.WaveshellProc, cmdStr string, lineState runAllLineStateType) (string, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), RunAllCmdTimeout)
+	defer cancelFn()
+	rptr := sstore.RemotePtrType{RemoteId: wsh.GetRemoteCopy().RemoteId}
+	err := ensureRunAllRemoteState(ctx, ids, wsh, rptr)
+	if err != nil {
+		return "", fmt.Errorf("cannot initialize shell state: %v", err)
+	}
+	uiContext := *pk.UIContext
+	uiContext.Remote = &rptr
+	runPk := scpacket.MakeFeCommandPacket()
+	runPk.MetaCmd = "run"
+	runPk.Args = []string{cmdStr}
+	runPk.RawStr = cmdStr
+	runPk.UIContext = &uiContext
+	var historyContext historyContextType
+	runCtx := context.WithValue(ctx, historyContextKey, &historyContext)
+	runCtx = context.WithValue(runCtx, lineStateContextKey, map[string]any{sstore.LineState_RunAll: lineState})
+	_, err = RunCommand(runCtx, runPk)
+	if err != nil {
+		return "", err
+	}
+	if historyContext.LineId == "" {
+		return "", fmt.Errorf("command did not create a line")
+	}
+	return historyContext.LineId, nil
+}
+
+func formatRunAllSummary(groupName string, results []*runAllResultType) string {
+	var buf bytes.Buffer
+	var numOk, numFailed, numSkipped int
+	buf.WriteString(fmt.Sprintf("%-30s  %-6s  %-8s  %-8s  %s\n", "host", "line", "status", "exitcode", "duration"))
+	for _, result := range results {
+		lineStr, exitCodeStr, durationStr := "-", "-", "-"
+		if result.LineNum > 0 {
+			lineStr = fmt.Sprintf("%d", result.LineNum)
+		}
+		if result.Status == RunAllStatus_Done || result.Status == RunAllStatus_Failed {
+			exitCodeStr = fmt.Sprintf("%d", result.ExitCode)
+		}
+		if result.DurationMs > 0 {
+			durationStr = (time.Duration(result.DurationMs) * time.Millisecond).String()
+		}
+		switch result.Status {
+		case RunAllStatus_Done:
+			numOk++
+		case RunAllStatus_Skipped:
+			numSkipped++
+		default:
+			numFailed++
+		}
+		statusStr := result.Status
+		if result.ErrStr != "" {
+			statusStr = fmt.Sprintf("%s (%s)", result.Status, result.ErrStr)
+		}
+		buf.WriteString(fmt.Sprintf("%-30s  %-6s  %-8s  %-8s  %s\n", result.Host, lineStr, statusStr, exitCodeStr, durationStr))
+	}
+	buf.WriteString(fmt.Sprintf("\ngroup %q: %d host(s), %d ok, %d failed, %d skipped\n", groupName, len(results), numOk, numFailed, numSkipped))
+	return buf.String()
+}
+
+func writeRunAllSummary(pk *scpacket.FeCommandPacketType, ids resolvedIds, groupName string, results []*runAllResultType) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), RunAllCmdTimeout)
+	defer cancelFn()
+	outputStr := strings.ReplaceAll(formatRunAllSummary(groupName, results), "\n", "\r\n")
+	cmd, err := makeStaticCmd(ctx, "run:all", ids, pk.GetRawStr(), []byte(outputStr))
+	if err != nil {
+		return err
+	}
+	update, err := addLineForCmd(ctx, "/run:all", false, ids, cmd, "", nil)
+	if err != nil {
+		return err
+	}
+	scbus.MainUpdateBus.DoScreenUpdate(ids.ScreenId, update)
+	return nil
+}
@@ -0,0 +1,41 @@
+package cmdrunner
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseRunAllArgs(t *testing.T) {
+	kwargs, cmdStr := parseRunAllArgs("  group=web concurrency=3 uptime -p")
+	if kwargs["group"] != "web" || kwargs["concurrency"] != "3" || cmdStr != "uptime -p" {
+		t.Errorf("bad parse: %v %q", kwargs, cmdStr)
+	}
+	// only leading kwargs are parsed, the rest belongs to the command
+	kwargs, cmdStr = parseRunAllArgs("group=db env FOO=1 group=x ls")
+	if kwargs["group"] != "db" || len(kwargs) != 1 || cmdStr != "env FOO=1 group=x ls" {
+		t.Errorf("bad parse: %v %q", kwargs, cmdStr)
+	}
+	kwargs, cmdStr = parseRunAllArgs("ls -l")
+	if len(kwargs) != 0 || cmdStr != "ls -l" {
+		t.Errorf("bad parse: %v %q", kwargs, cmdStr)
+	}
+	kwargs, cmdStr = parseRunAllArgs("group=web")
+	if kwargs["group"] != "web" || cmdStr != "" {
+		t.Errorf("bad parse: %v %q", kwargs, cmdStr)
+	}
+}
+
+func TestFormatRunAllSummary(t *testing.T) {
+	results := []*runAllResultType{
+		{Host: "web1", LineNum: 10, Status: RunAllStatus_Done, ExitCode: 0, DurationMs: 1200},
+		{Host: "web2", LineNum: 11, Status: RunAllStatus_Failed, ExitCode: 2, DurationMs: 800},
+		{Host: "web3", Status: RunAllStatus_Skipped, ErrStr: "not connected"},
+	}
+	summary := formatRunAllSummary("web", results)
+	if !strings.Contains(summary, `group "web": 3 host(s), 1 ok, 1 failed, 1 skipped`) {
+		t.Errorf("bad summary totals:\n%s", summary)
+	}
+	if !strings.Contains(summary, "skipped (not connected)") || !strings.Contains(summary, "1.2s") {
+		t.Errorf("bad summary rows:\n%s", summary)
+	}
+}
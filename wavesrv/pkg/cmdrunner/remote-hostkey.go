@@ -0,0 +1,171 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/abhishek944/waveterm/wavesrv/pkg/remote"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/scbus"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/scpacket"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/userinput"
+	"golang.org/x/crypto/ssh"
+)
+
+const HostKeyUsageStr = "usage: /remote:hostkey [show | forget | trust [fingerprint=SHA256:...] [force=1]]"
+
+func formatKnownHostsEntries(buf *bytes.Buffer, entries []remote.KnownHostsEntry) {
+	for _, entry := range entries {
+		buf.WriteString(fmt.Sprintf("  %s\n", entry.String()))
+	}
+}
+
+// lines in known_hosts files that cannot be written (e.g. the global /etc/ssh/ssh_known_hosts)
+func formatSkippedKnownHostsEntries(buf *bytes.Buffer, entries []remote.KnownHostsEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	buf.WriteString(fmt.Sprintf("kept %d line(s) in known_hosts files that cannot be written (remove them manually):\n", len(entries)))
+	formatKnownHostsEntries(buf, entries)
+}
+
+func makeHostKeyShowUpdate(displayName string, info *remote.HostKeyInfo) scbus.UpdatePacket {
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("host         %s\n", info.Host))
+	buf.WriteString(fmt.Sprintf("key          %s %s\n", info.Key.Type(), ssh.FingerprintSHA256(info.Key)))
+	buf.WriteString(fmt.Sprintf("status       %s\n", info.Status))
+	buf.WriteString(fmt.Sprintf("known_hosts  %s\n", strings.Join(info.KnownHostsFiles, ", ")))
+	if len(info.Entries) == 0 {
+		buf.WriteString("no known_hosts entries for this host\n")
+	} else {
+		buf.WriteString("entries:\n")
+		formatKnownHostsEntries(&buf, info.Entries)
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{
+		InfoTitle: fmt.Sprintf("host key for %s", displayName),
+		InfoLines: splitLinesForInfo(buf.String()),
+	})
+	return update
+}
+
+func confirmTrustHostKey(ctx context.Context, displayName string, info *remote.HostKeyInfo) (bool, error) {
+	var buf bytes.Buffer
+	formatKnownHostsEntries(&buf, info.Entries)
+	entriesStr := buf.String()
+	if entriesStr == "" {
+		entriesStr = "  (none)\n"
+	}
+	request := &userinput.UserInputRequestType{
+		ResponseType: "confirm",
+		QueryText: fmt.Sprintf("%s (%s) presents this %s key:  \n%s\n\n"+
+			"Current known_hosts entries:\n\n```\n%s```\n\n"+
+			"Only trust this key if you have verified the fingerprint (e.g. with `ssh-keygen -lf` on the host).  "+
+			"Stale keys of the same type are removed.  **Trust this key?**",
+			displayName, info.Host, info.Key.Type(), ssh.FingerprintSHA256(info.Key), entriesStr),
+		Markdown: true,
+		Title:    "Trust Host Key",
+	}
+	response, err := userinput.GetUserInput(ctx, scbus.MainRpcBus, request)
+	if err != nil {
+		return false, err
+	}
+	return response.Confirm, nil
+}
+
+// /remote:hostkey [show] - fetches the key the host presents and compares it with known_hosts
+// /remote:hostkey forget - removes the host's lines from known_hosts (including hashed ones)
+// /remote:hostkey trust [fingerprint=SHA256:...] - pins the key the host presents
+func RemoteHostKeyCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen|R_Remote)
+	if err != nil {
+		return nil, err
+	}
+	if len(pk.Args) > 1 {
+		return nil, fmt.Errorf(HostKeyUsageStr)
+	}
+	wsh := ids.Remote.Waveshell
+	displayName := ids.Remote.DisplayName
+	switch firstArg(pk) {
+	case "", "show":
+		info, err := wsh.GetHostKeyInfo(ctx, true)
+		if err != nil {
+			return nil, fmt.Errorf("/remote:hostkey %v", err)
+		}
+		return makeHostKeyShowUpdate(displayName, info), nil
+
+	case "forget":
+		info, err := wsh.GetHostKeyInfo(ctx, false)
+		if err != nil {
+			return nil, fmt.Errorf("/remote:hostkey %v", err)
+		}
+		removed, skipped, err := remote.ForgetKnownHost(info.KnownHostsFiles, info.Address)
+		if err != nil {
+			return nil, fmt.Errorf("/remote:hostkey %v", err)
+		}
+		if len(removed) == 0 && len(skipped) == 0 {
+			return sstore.InfoMsgUpdate("no known_hosts lines for %s", info.Host), nil
+		}
+		var buf bytes.Buffer
+		buf.WriteString(fmt.Sprintf("removed %d known_hosts line(s) for %s:\n", len(removed), info.Host))
+		formatKnownHostsEntries(&buf, removed)
+		formatSkippedKnownHostsEntries(&buf, skipped)
+		update := scbus.MakeUpdatePacket()
+		update.AddUpdate(sstore.InfoMsgType{
+			InfoTitle: fmt.Sprintf("host key for %s", displayName),
+			InfoLines: splitLinesForInfo(buf.String()),
+		})
+		return update, nil
+
+	case "trust":
+		info, err := wsh.GetHostKeyInfo(ctx, true)
+		if err != nil {
+			return nil, fmt.Errorf("/remote:hostkey %v", err)
+		}
+		fingerprint := ssh.FingerprintSHA256(info.Key)
+		if info.Status == remote.HostKeyStatus_Trusted {
+			return sstore.InfoMsgUpdate("%s key %s is already trusted for %s", info.Key.Type(), fingerprint, info.Host), nil
+		}
+		if info.Status == remote.HostKeyStatus_Revoked {
+			return nil, fmt.Errorf("/remote:hostkey %s key %s is revoked in known_hosts, remove the @revoked line first", info.Key.Type(), fingerprint)
+		}
+		if _, ok := info.Key.(*ssh.Certificate); ok {
+			return nil, fmt.Errorf("/remote:hostkey %s presents a host certificate, trust its CA with a @cert-authority line instead", info.Host)
+		}
+		if expected := pk.Kwargs["fingerprint"]; expected != "" {
+			if expected != fingerprint {
+				return nil, fmt.Errorf("/remote:hostkey %s presents %s key %s, which does not match fingerprint=%s", info.Host, info.Key.Type(), fingerprint, expected)
+			}
+		} else if !resolveBool(pk.Kwargs["force"], false) {
+			confirmed, err := confirmTrustHostKey(ctx, displayName, info)
+			if err != nil {
+				return nil, fmt.Errorf("/remote:hostkey %v", err)
+			}
+			if !confirmed {
+				return sstore.InfoMsgUpdate("host key not trusted"), nil
+			}
+		}
+		removed, skipped, err := remote.TrustKnownHost(info.KnownHostsFiles, info.Address, info.Key)
+		if err != nil {
+			return nil, fmt.Errorf("/remote:hostkey %v", err)
+		}
+		if len(skipped) > 0 {
+			var buf bytes.Buffer
+			buf.WriteString(fmt.Sprintf("trusted %s key %s for %s (removed %d stale line(s))\n", info.Key.Type(), fingerprint, info.Host, len(removed)))
+			formatSkippedKnownHostsEntries(&buf, skipped)
+			update := scbus.MakeUpdatePacket()
+			update.AddUpdate(sstore.InfoMsgType{
+				InfoTitle: fmt.Sprintf("host key for %s", displayName),
+				InfoLines: splitLinesForInfo(buf.String()),
+			})
+			return update, nil
+		}
+		return sstore.InfoMsgUpdate("trusted %s key %s for %s (removed %d stale line(s))", info.Key.Type(), fingerprint, info.Host, len(removed)), nil
+	}
+	return nil, fmt.Errorf(HostKeyUsageStr)
+}
@@ -0,0 +1,259 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abhishek944/waveterm/waveshell/pkg/base"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/configstore"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/scbus"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/userinput"
+	"github.com/kevinburke/ssh_config"
+)
+
+const MaxSshConfigIncludeDepth = 5
+const SshConfigSyncTimeout = 5 * time.Minute
+const SshConfigSystemDir = "/etc/ssh"
+
+var sshIncludeRe = regexp.MustCompile(`(?i)^\s*include(?:\s*=\s*|\s+)(.*)$`)
+
+var sshConfigWatcher *configstore.SshConfigWatcher
+var sshConfigSyncLock = &sync.Mutex{}
+
+func getSshConfigFiles() []string {
+	home := base.GetHomeDir()
+	localConfig := filepath.Join(home, ".ssh", "config")
+	systemConfig := filepath.Join("/etc", "ssh", "config")
+	return []string{localConfig, systemConfig}
+}
+
+// the parsed ssh config files.  Include directives are inlined (ssh_config does not expand ~ in Include,
+// and does not expose the included files)
+type sshConfigSet struct {
+	Files   []string             // every file read, including the Included ones
+	Globs   []string             // Include patterns (absolute)
+	Configs []*ssh_config.Config // one per top level file
+}
+
+func loadSshConfigSet(configFiles []string) (*sshConfigSet, error) {
+	rtn := &sshConfigSet{}
+	var errs []error
+	for _, configFile := range configFiles {
+		data, err := rtn.readConfigFile(configFile, 0)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		cfg, err := ssh_config.DecodeBytes(data)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cannot parse %s: %w", configFile, err))
+			continue
+		}
+		rtn.Configs = append(rtn.Configs, cfg)
+	}
+	if len(rtn.Configs) == 0 {
+		errs = append([]error{fmt.Errorf("no ssh config files could be opened:\n")}, errs...)
+		return nil, errors.Join(errs...)
+	}
+	return rtn, nil
+}
+
+// returns the contents of the file with its Include directives replaced by the contents of the matching files
+func (set *sshConfigSet) readConfigFile(fileName string, depth int) ([]byte, error) {
+	set.Files = appendIfMissing(set.Files, fileName)
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		m := sshIncludeRe.FindStringSubmatch(strings.TrimRight(line, "\r\n"))
+		if m == nil {
+			buf.WriteString(line)
+			continue
+		}
+		if depth >= MaxSshConfigIncludeDepth {
+			log.Printf("sshconfig: Include in %s exceeds max depth %d, skipping\n", fileName, MaxSshConfigIncludeDepth)
+			continue
+		}
+		includeStr, _, _ := strings.Cut(m[1], "#")
+		for _, pattern := range strings.Fields(includeStr) {
+			pattern = resolveSshIncludePath(fileName, pattern)
+			set.Globs = appendIfMissing(set.Globs, pattern)
+			matches, err := filepath.Glob(pattern)
+			if err != nil {
+				log.Printf("sshconfig: invalid Include pattern %q in %s: %v\n", pattern, fileName, err)
+				continue
+			}
+			for _, match := range matches {
+				incData, err := set.readConfigFile(match, depth+1)
+				if err != nil {
+					log.Printf("sshconfig: cannot read Include file %s: %v\n", match, err)
+					continue
+				}
+				buf.Write(incData)
+				buf.WriteString("\n")
+			}
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// relative Include paths are relative to ~/.ssh (or /etc/ssh for system files), like ssh
+func resolveSshIncludePath(fromFile string, pattern string) string {
+	pattern = base.ExpandHomeDir(pattern)
+	if filepath.IsAbs(pattern) {
+		return pattern
+	}
+	if strings.HasPrefix(filepath.Clean(fromFile), SshConfigSystemDir) {
+		return filepath.Join(SshConfigSystemDir, pattern)
+	}
+	return filepath.Join(base.GetHomeDir(), ".ssh", pattern)
+}
+
+func appendIfMissing(arr []string, val string) []string {
+	for _, existing := range arr {
+		if existing == val {
+			return arr
+		}
+	}
+	return append(arr, val)
+}
+
+// first value for the key in any of the configs (ssh semantics, the first match wins).
+// the ssh default for the key (e.g. PasswordAuthentication yes) when no config sets it
+func getSshConfigValue(sshConfigs []*ssh_config.Config, hostName string, key string) string {
+	for _, cfg := range sshConfigs {
+		val, err := cfg.Get(hostName, key)
+		if err == nil && val != "" {
+			return val
+		}
+	}
+	return ssh_config.Default(key)
+}
+
+type sshImportUpdateType struct {
+	HostInfo *HostInfoType
+	Remote   *sstore.RemoteType
+	Changes  []string
+}
+
+// the changes an ssh config import would make
+type sshImportPlanType struct {
+	Create  []*HostInfoType
+	Update  []*sshImportUpdateType
+	Archive []*sstore.RemoteType
+}
+
+func (plan *sshImportPlanType) IsEmpty() bool {
+	return len(plan.Create) == 0 && len(plan.Update) == 0 && len(plan.Archive) == 0
+}
+
+func (plan *sshImportPlanType) Diff() string {
+	if plan.IsEmpty() {
+		return "no changes"
+	}
+	var buf bytes.Buffer
+	for _, hostInfo := range plan.Create {
+		buf.WriteString(fmt.Sprintf("+ %s (%s)\n", hostInfo.CanonicalName, hostInfo.Host))
+	}
+	for _, update := range plan.Update {
+		buf.WriteString(fmt.Sprintf("~ %s: %s\n", update.HostInfo.CanonicalName, strings.Join(update.Changes, ", ")))
+	}
+	for _, r := range plan.Archive {
+		buf.WriteString(fmt.Sprintf("- %s (archive)\n", r.RemoteCanonicalName))
+	}
+	return buf.String()
+}
+
+func confirmSshConfigImport(ctx context.Context, plan *sshImportPlanType, title string) (bool, error) {
+	request := &userinput.UserInputRequestType{
+		ResponseType: "confirm",
+		QueryText:    fmt.Sprintf("The following connections will be changed:\n\n```\n%s```\n\n(+ add, ~ update, - archive)  Apply these changes?", plan.Diff()),
+		Markdown:     true,
+		Title:        title,
+	}
+	response, err := userinput.GetUserInput(ctx, scbus.MainRpcBus, request)
+	if err != nil {
+		return false, err
+	}
+	return response.Confirm, nil
+}
+
+// watches the ssh config files (and their Includes), and re-syncs the imported remotes when they change
+func StartSshConfigWatcher() {
+	watcher, err := configstore.MakeSshConfigWatcher(func() { go syncSshConfigImport() })
+	if err != nil {
+		log.Printf("sshconfig: cannot create watcher: %v\n", err)
+		return
+	}
+	sshConfigWatcher = watcher
+	updateSshConfigWatcher(nil)
+	go watcher.Start()
+}
+
+func updateSshConfigWatcher(configSet *sshConfigSet) {
+	if sshConfigWatcher == nil {
+		return
+	}
+	if configSet == nil {
+		configSet = &sshConfigSet{}
+		for _, configFile := range getSshConfigFiles() {
+			configSet.readConfigFile(configFile, 0)
+		}
+	}
+	sshConfigWatcher.SetFiles(configSet.Files, configSet.Globs)
+}
+
+// only runs if remotes were imported before (/remote:parse), and asks before applying the changes
+func syncSshConfigImport() {
+	sshConfigSyncLock.Lock()
+	defer sshConfigSyncLock.Unlock()
+	ctx, cancelFn := context.WithTimeout(context.Background(), SshConfigSyncTimeout)
+	defer cancelFn()
+	importedRemotes, err := sstore.GetAllImportedRemotes(ctx)
+	if err != nil {
+		log.Printf("sshconfig-sync: %v\n", err)
+		return
+	}
+	if len(importedRemotes) == 0 {
+		updateSshConfigWatcher(nil)
+		return
+	}
+	ssh_config.ReloadConfigs()
+	plan, configSet, err := planSshConfigImport(ctx)
+	updateSshConfigWatcher(configSet)
+	if err != nil {
+		log.Printf("sshconfig-sync: %v\n", err)
+		return
+	}
+	if plan.IsEmpty() {
+		return
+	}
+	confirmed, err := confirmSshConfigImport(ctx, plan, "SSH Config Changed")
+	if err != nil || !confirmed {
+		log.Printf("sshconfig-sync: changes not applied (err=%v)\n", err)
+		return
+	}
+	outMsg := createSshImportSummary(applySshConfigImport(ctx, plan))
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.AlertMessageType{
+		Title:    "SSH Config Import",
+		Message:  outMsg,
+		Markdown: true,
+	})
+	scbus.MainUpdateBus.DoUpdate(update)
+}
@@ -0,0 +1,73 @@
+package cmdrunner
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/abhishek944/waveterm/waveshell/pkg/base"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+)
+
+func TestLoadSshConfigSet(t *testing.T) {
+	dir := t.TempDir()
+	confDir := filepath.Join(dir, "config.d")
+	os.MkdirAll(confDir, 0700)
+	mainConfig := filepath.Join(dir, "config")
+	os.WriteFile(mainConfig, []byte("Include "+confDir+"/* # extra hosts\n\nHost main\n  User mainuser\n\nHost *\n  User defaultuser\n"), 0600)
+	os.WriteFile(filepath.Join(confDir, "a.conf"), []byte("Host inc-a\n  User auser\n  Port 2222\n"), 0600)
+	os.WriteFile(filepath.Join(confDir, "b.conf"), []byte("Host inc-b\n"), 0600)
+	configSet, err := loadSshConfigSet([]string{mainConfig, filepath.Join(dir, "missing")})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(configSet.Files) != 4 || len(configSet.Globs) != 1 || configSet.Globs[0] != confDir+"/*" {
+		t.Errorf("bad files/globs: %v %v", configSet.Files, configSet.Globs)
+	}
+	patterns, err := resolveSshConfigPatterns(configSet.Configs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(patterns, ",") != "inc-a,inc-b,main" {
+		t.Errorf("bad patterns: %v", patterns)
+	}
+	// the included (host specific) values win over the Host * defaults after the Include
+	if val := getSshConfigValue(configSet.Configs, "inc-a", "User"); val != "auser" {
+		t.Errorf("bad inc-a user %q", val)
+	}
+	if val := getSshConfigValue(configSet.Configs, "inc-b", "User"); val != "defaultuser" {
+		t.Errorf("bad inc-b user %q", val)
+	}
+	if val := getSshConfigValue(configSet.Configs, "inc-a", "Port"); val != "2222" {
+		t.Errorf("bad inc-a port %q", val)
+	}
+	// keys no config sets fall back to the ssh defaults
+	if val := getSshConfigValue(configSet.Configs, "inc-b", "PasswordAuthentication"); val != "yes" {
+		t.Errorf("bad inc-b passwordauthentication default %q", val)
+	}
+	hostInfo, err := NewHostInfo("inc-b", configSet.Configs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if hostInfo.Port != 0 || (hostInfo.SshKeyFile == "" && hostInfo.ConnectMode != sstore.ConnectModeManual) {
+		t.Errorf("bad inc-b host info %+v", hostInfo)
+	}
+	_, err = loadSshConfigSet([]string{filepath.Join(dir, "missing")})
+	if err == nil {
+		t.Errorf("missing config files should be an error")
+	}
+}
+
+func TestResolveSshIncludePath(t *testing.T) {
+	home := base.GetHomeDir()
+	if val := resolveSshIncludePath(filepath.Join(home, ".ssh", "config"), "~/.ssh/config.d/*"); val != filepath.Join(home, ".ssh", "config.d", "*") {
+		t.Errorf("bad ~ path %q", val)
+	}
+	if val := resolveSshIncludePath(filepath.Join(home, ".ssh", "config"), "config.d/*"); val != filepath.Join(home, ".ssh", "config.d", "*") {
+		t.Errorf("bad relative path %q", val)
+	}
+	if val := resolveSshIncludePath("/etc/ssh/ssh_config", "ssh_config.d/*.conf"); val != "/etc/ssh/ssh_config.d/*.conf" {
+		t.Errorf("bad system path %q", val)
+	}
+}
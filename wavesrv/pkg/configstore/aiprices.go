@@ -0,0 +1,73 @@
+package configstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/abhishek944/waveterm/wavesrv/pkg/scbase"
+)
+
+const aiPricesFile = "config/ai-prices.json"
+
+// prices are in USD per 1M tokens
+type AIPriceType struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+func (p *AIPriceType) Cost(promptTokens int, completionTokens int) float64 {
+	return (float64(promptTokens)*p.Input + float64(completionTokens)*p.Output) / 1000000
+}
+
+// keys are "provider/model", "model", or "provider/*" (checked in that order)
+type AIPriceTable map[string]*AIPriceType
+
+func (pt AIPriceTable) Lookup(provider string, model string) *AIPriceType {
+	if pt == nil {
+		return nil
+	}
+	if price := pt[provider+"/"+model]; price != nil {
+		return price
+	}
+	if price := pt[model]; price != nil {
+		return price
+	}
+	return pt[provider+"/*"]
+}
+
+func GetAIPricesPath() string {
+	return path.Join(scbase.GetWaveHomeDir(), aiPricesFile)
+}
+
+func ParseAIPrices(data []byte) (AIPriceTable, error) {
+	var rtn AIPriceTable
+	err := json.Unmarshal(data, &rtn)
+	if err != nil {
+		return nil, err
+	}
+	for key, price := range rtn {
+		if price == nil || price.Input < 0 || price.Output < 0 {
+			return nil, fmt.Errorf("invalid price for %q", key)
+		}
+	}
+	return rtn, nil
+}
+
+// returns nil, nil if the price table does not exist
+func ReadAIPrices() (AIPriceTable, error) {
+	data, err := os.ReadFile(GetAIPricesPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	rtn, err := ParseAIPrices(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %v", aiPricesFile, err)
+	}
+	return rtn, nil
+}
@@ -0,0 +1,38 @@
+package configstore
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAIPrices(t *testing.T) {
+	data := []byte(`{"openai/gpt-4o": {"input": 2.5, "output": 10}, "llama3": {"input": 0, "output": 0}, "anthropic/*": {"input": 3, "output": 15}}`)
+	prices, err := ParseAIPrices(data)
+	if err != nil {
+		t.Fatalf("error parsing prices: %v", err)
+	}
+	price := prices.Lookup("openai", "gpt-4o")
+	if price == nil || price.Input != 2.5 {
+		t.Fatalf("bad lookup for openai/gpt-4o: %v", price)
+	}
+	if cost := price.Cost(1000000, 500000); math.Abs(cost-7.5) > 1e-9 {
+		t.Errorf("bad cost: %v", cost)
+	}
+	if prices.Lookup("ollama", "llama3") == nil {
+		t.Errorf("model-only key should match any provider")
+	}
+	if price := prices.Lookup("anthropic", "claude-x"); price == nil || price.Output != 15 {
+		t.Errorf("provider wildcard should match: %v", price)
+	}
+	if prices.Lookup("openai", "gpt-3.5") != nil {
+		t.Errorf("unexpected match for openai/gpt-3.5")
+	}
+	var nilTable AIPriceTable
+	if nilTable.Lookup("openai", "gpt-4o") != nil {
+		t.Errorf("nil table should not match")
+	}
+	_, err = ParseAIPrices([]byte(`{"openai/*": {"input": -1}}`))
+	if err == nil {
+		t.Errorf("negative price should be an error")
+	}
+}
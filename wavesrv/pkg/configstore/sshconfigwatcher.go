@@ -0,0 +1,138 @@
+package configstore
+
+import (
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// editors often write a file several times (or replace it), so changes are batched
+const SshConfigDebounce = time.Second
+
+// watches the ssh config files (and the directories of their Include globs) and calls onChange
+// (debounced) when any of them are written, created, removed or renamed.  the directories are
+// watched rather than the files so that files replaced by editors and new Include matches are seen
+type SshConfigWatcher struct {
+	watcher  *fsnotify.Watcher
+	mutex    sync.Mutex
+	files    map[string]bool
+	globs    []string
+	dirs     map[string]bool
+	onChange func()
+	timer    *time.Timer
+}
+
+func MakeSshConfigWatcher(onChange func()) (*SshConfigWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return &SshConfigWatcher{
+		watcher:  watcher,
+		files:    make(map[string]bool),
+		dirs:     make(map[string]bool),
+		onChange: onChange,
+	}, nil
+}
+
+// sets the watched files and Include globs (replaces the previous set)
+func (w *SshConfigWatcher) SetFiles(files []string, globs []string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.watcher == nil {
+		return
+	}
+	newDirs := make(map[string]bool)
+	w.files = make(map[string]bool)
+	for _, file := range files {
+		file = filepath.Clean(file)
+		w.files[file] = true
+		newDirs[filepath.Dir(file)] = true
+	}
+	w.globs = nil
+	for _, glob := range globs {
+		glob = filepath.Clean(glob)
+		w.globs = append(w.globs, glob)
+		globDir := filepath.Dir(glob)
+		if !strings.ContainsAny(globDir, "*?[") {
+			newDirs[globDir] = true
+		}
+	}
+	for dir := range w.dirs {
+		if !newDirs[dir] {
+			w.watcher.Remove(dir)
+			delete(w.dirs, dir)
+		}
+	}
+	for dir := range newDirs {
+		if w.dirs[dir] {
+			continue
+		}
+		// directories that don't exist are skipped (picked up on the next SetFiles)
+		if err := w.watcher.Add(dir); err == nil {
+			w.dirs[dir] = true
+		}
+	}
+}
+
+func (w *SshConfigWatcher) matches(path string) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	path = filepath.Clean(path)
+	if w.files[path] {
+		return true
+	}
+	for _, glob := range w.globs {
+		if matched, _ := filepath.Match(glob, path); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *SshConfigWatcher) Start() {
+	w.mutex.Lock()
+	watcher := w.watcher
+	w.mutex.Unlock()
+	if watcher == nil {
+		return
+	}
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op == fsnotify.Chmod || !w.matches(event.Name) {
+				continue
+			}
+			w.mutex.Lock()
+			if w.timer != nil {
+				w.timer.Stop()
+			}
+			w.timer = time.AfterFunc(SshConfigDebounce, w.onChange)
+			w.mutex.Unlock()
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Println("ssh config watcher error:", err)
+		}
+	}
+}
+
+func (w *SshConfigWatcher) Close() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	if w.watcher != nil {
+		w.watcher.Close()
+		w.watcher = nil
+	}
+}
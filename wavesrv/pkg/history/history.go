@@ -30,6 +30,7 @@ type HistoryItemType struct {
 	Tags       map[string]bool      `json:"tags,omitempty"`
 	LineNum    int64                `json:"linenum" dbmap:"-"`
 	Status     string               `json:"status"`
+	Note       string               `json:"note,omitempty"`
 
 	// only for updates
 	Remove bool `json:"remove" dbmap:"-"`
@@ -58,6 +59,7 @@ func (h *HistoryItemType) ToMap() map[string]interface{} {
 	rtn["festate"] = dbutil.QuickJson(h.FeState)
 	rtn["tags"] = dbutil.QuickJson(h.Tags)
 	rtn["status"] = h.Status
+	rtn["note"] = h.Note
 	return rtn
 }
 
@@ -81,6 +83,7 @@ func (h *HistoryItemType) FromMap(m map[string]interface{}) bool {
 	dbutil.QuickSetJson(&h.FeState, m, "festate")
 	dbutil.QuickSetJson(&h.Tags, m, "tags")
 	dbutil.QuickSetStr(&h.Status, m, "status")
+	dbutil.QuickSetStr(&h.Note, m, "note")
 	return true
 }
 
@@ -95,6 +98,71 @@ type HistoryQueryOpts struct {
 	NoMeta     bool
 	RawOffset  int
 	FilterFn   func(*HistoryItemType) bool
+	Filter     HistoryFilterOpts
+}
+
+// structured filters, shared by history queries and searches (zero values do not filter)
+type HistoryFilterOpts struct {
+	Failed        *bool // true for a non-zero exit code, false for exit code 0
+	MinDurationMs int64
+	MaxDurationMs int64
+	Cwd           string // matches the cwd and directories under it
+	SinceTs       int64
+	UntilTs       int64
+	Tags          []string // all of them must be set
+}
+
+func (f HistoryFilterOpts) IsEmpty() bool {
+	return f.Failed == nil && f.MinDurationMs == 0 && f.MaxDurationMs == 0 && f.Cwd == "" && f.SinceTs == 0 && f.UntilTs == 0 && len(f.Tags) == 0
+}
+
+func escapeLikeArg(arg string) string {
+	arg = strings.ReplaceAll(arg, "\\", "\\\\")
+	arg = strings.ReplaceAll(arg, "%", "\\%")
+	arg = strings.ReplaceAll(arg, "_", "\\_")
+	return arg
+}
+
+// returns " AND ..." conditions on history h (and their args)
+func (f HistoryFilterOpts) makeWhereClause() (string, []interface{}) {
+	var whereClause string
+	var args []interface{}
+	if f.Failed != nil {
+		if *f.Failed {
+			whereClause += " AND (h.exitcode <> 0 OR (h.exitcode IS NULL AND h.haderror))"
+		} else {
+			whereClause += " AND h.exitcode = 0"
+		}
+	}
+	if f.MinDurationMs > 0 {
+		whereClause += " AND h.durationms >= ?"
+		args = append(args, f.MinDurationMs)
+	}
+	if f.MaxDurationMs > 0 {
+		whereClause += " AND h.durationms <= ?"
+		args = append(args, f.MaxDurationMs)
+	}
+	if f.Cwd != "" {
+		cwd := strings.TrimSuffix(f.Cwd, "/")
+		if cwd == "" {
+			cwd = "/"
+		}
+		whereClause += " AND (json_extract(h.festate, '$.cwd') = ? OR json_extract(h.festate, '$.cwd') LIKE ? ESCAPE '\\')"
+		args = append(args, cwd, escapeLikeArg(strings.TrimSuffix(cwd, "/"))+"/%")
+	}
+	if f.SinceTs > 0 {
+		whereClause += " AND h.ts >= ?"
+		args = append(args, f.SinceTs)
+	}
+	if f.UntilTs > 0 {
+		whereClause += " AND h.ts <= ?"
+		args = append(args, f.UntilTs)
+	}
+	for _, tag := range f.Tags {
+		whereClause += " AND EXISTS (SELECT 1 FROM json_each(h.tags) WHERE json_each.key = ? AND json_each.value)"
+		args = append(args, tag)
+	}
+	return whereClause, args
 }
 
 type HistoryQueryResult struct {
@@ -118,7 +186,7 @@ type HistoryViewData struct {
 	Cmds          []*sstore.CmdType  `json:"cmds"`
 }
 
-const HistoryCols = "h.historyid, h.ts, h.userid, h.sessionid, h.screenid, h.lineid, h.haderror, h.cmdstr, h.remoteownerid, h.remoteid, h.remotename, h.ismetacmd, h.linenum, h.exitcode, h.durationms, h.festate, h.tags, h.status"
+const HistoryCols = "h.historyid, h.ts, h.userid, h.sessionid, h.screenid, h.lineid, h.haderror, h.cmdstr, h.remoteownerid, h.remoteid, h.remotename, h.ismetacmd, h.linenum, h.exitcode, h.durationms, h.festate, h.tags, h.status, h.note"
 const DefaultMaxHistoryItems = 1000
 
 func InsertHistoryItem(ctx context.Context, hitem *HistoryItemType) error {
@@ -126,15 +194,20 @@ func InsertHistoryItem(ctx context.Context, hitem *HistoryItemType) error {
 		return fmt.Errorf("cannot insert nil history item")
 	}
 	txErr := sstore.WithTx(ctx, func(tx *sstore.TxWrap) error {
-		query := `INSERT INTO history 
-                  ( historyid, ts, userid, sessionid, screenid, lineid, haderror, cmdstr, remoteownerid, remoteid, remotename, ismetacmd, linenum, exitcode, durationms, festate, tags, status) VALUES
-                  (:historyid,:ts,:userid,:sessionid,:screenid,:lineid,:haderror,:cmdstr,:remoteownerid,:remoteid,:remotename,:ismetacmd,:linenum,:exitcode,:durationms,:festate,:tags,:status)`
-		tx.NamedExec(query, hitem.ToMap())
+		insertHistoryItemTx(tx, hitem)
 		return nil
 	})
 	return txErr
 }
 
+func insertHistoryItemTx(tx *sstore.TxWrap, hitem *HistoryItemType) {
+	query := `INSERT INTO history 
+              ( historyid, ts, userid, sessionid, screenid, lineid, haderror, cmdstr, remoteownerid, remoteid, remotename, ismetacmd, linenum, exitcode, durationms, festate, tags, status, note) VALUES
+              (:historyid,:ts,:userid,:sessionid,:screenid,:lineid,:haderror,:cmdstr,:remoteownerid,:remoteid,:remotename,:ismetacmd,:linenum,:exitcode,:durationms,:festate,:tags,:status,:note)`
+	tx.NamedExec(query, hitem.ToMap())
+	indexHistoryCmdStr(tx, hitem)
+}
+
 const HistoryQueryChunkSize = 1000
 
 func _getNextHistoryItem(items []*HistoryItemType, index int, filterFn func(*HistoryItemType) bool) (*HistoryItemType, int) {
@@ -203,55 +276,91 @@ func runHistoryQueryWithFilter(tx *sstore.TxWrap, opts HistoryQueryOpts) (*Histo
 	return rtn, nil
 }
 
-func runHistoryQuery(tx *sstore.TxWrap, opts HistoryQueryOpts, realOffset int, itemLimit int) ([]*HistoryItemType, error) {
-	// check sessionid/screenid format because we are directly inserting them into the SQL
+// returns the WHERE clause for opts on history h and its args (the scope and the filters)
+func makeHistoryWhereClause(opts HistoryQueryOpts) (string, []interface{}, error) {
+	whereClause, queryArgs, _, err := makeHistoryScopeClause(opts)
+	if err != nil {
+		return "", nil, err
+	}
+	filterClause, filterArgs := makeHistoryFilterClause(opts)
+	return whereClause + filterClause, append(queryArgs, filterArgs...), nil
+}
+
+// returns the WHERE clause for the session/screen scope of opts on history h, its args, and the historynum prefix.
+// historynums are numbered within the scope only (see runHistoryQuery and ResolveHistoryNums)
+func makeHistoryScopeClause(opts HistoryQueryOpts) (string, []interface{}, string, error) {
+	// check sessionid/screenid format (a malformed id is a caller error, not an empty result)
 	if opts.SessionId != "" {
 		_, err := uuid.Parse(opts.SessionId)
 		if err != nil {
-			return nil, fmt.Errorf("malformed sessionid")
+			return "", nil, "", fmt.Errorf("malformed sessionid")
 		}
 	}
 	if opts.ScreenId != "" {
 		_, err := uuid.Parse(opts.ScreenId)
 		if err != nil {
-			return nil, fmt.Errorf("malformed screenid")
+			return "", nil, "", fmt.Errorf("malformed screenid")
 		}
 	}
 	if opts.RemoteId != "" {
 		_, err := uuid.Parse(opts.RemoteId)
 		if err != nil {
-			return nil, fmt.Errorf("malformed remoteid")
+			return "", nil, "", fmt.Errorf("malformed remoteid")
 		}
 	}
 	whereClause := "WHERE 1"
 	var queryArgs []interface{}
 	hNumStr := ""
 	if opts.SessionId != "" && opts.ScreenId != "" {
-		whereClause += fmt.Sprintf(" AND h.sessionid = '%s' AND h.screenid = '%s'", opts.SessionId, opts.ScreenId)
+		whereClause += " AND h.sessionid = ? AND h.screenid = ?"
+		queryArgs = append(queryArgs, opts.SessionId, opts.ScreenId)
 		hNumStr = ""
 	} else if opts.SessionId != "" {
-		whereClause += fmt.Sprintf(" AND h.sessionid = '%s'", opts.SessionId)
+		whereClause += " AND h.sessionid = ?"
+		queryArgs = append(queryArgs, opts.SessionId)
 		hNumStr = "s"
 	} else {
 		hNumStr = "g"
 	}
+	return whereClause, queryArgs, hNumStr, nil
+}
+
+// returns " AND ..." conditions on history h for the search text and filters of opts (and their args)
+func makeHistoryFilterClause(opts HistoryQueryOpts) (string, []interface{}) {
+	var whereClause string
+	var queryArgs []interface{}
 	if opts.SearchText != "" {
 		whereClause += " AND h.cmdstr LIKE ? ESCAPE '\\'"
-		likeArg := opts.SearchText
-		likeArg = strings.ReplaceAll(likeArg, "%", "\\%")
-		likeArg = strings.ReplaceAll(likeArg, "_", "\\_")
-		queryArgs = append(queryArgs, "%"+likeArg+"%")
+		queryArgs = append(queryArgs, "%"+escapeLikeArg(opts.SearchText)+"%")
 	}
 	if opts.FromTs > 0 {
-		whereClause += fmt.Sprintf(" AND h.ts <= %d", opts.FromTs)
+		whereClause += " AND h.ts <= ?"
+		queryArgs = append(queryArgs, opts.FromTs)
 	}
 	if opts.RemoteId != "" {
-		whereClause += fmt.Sprintf(" AND h.remoteid = '%s'", opts.RemoteId)
+		whereClause += " AND h.remoteid = ?"
+		queryArgs = append(queryArgs, opts.RemoteId)
 	}
 	if opts.NoMeta {
 		whereClause += " AND NOT h.ismetacmd"
 	}
-	query := fmt.Sprintf("SELECT %s, ('%s' || CAST((row_number() OVER win) as text)) historynum FROM history h %s WINDOW win AS (ORDER BY h.ts, h.historyid) ORDER BY h.ts DESC, h.historyid DESC LIMIT %d OFFSET %d", HistoryCols, hNumStr, whereClause, itemLimit, realOffset)
+	filterClause, filterArgs := opts.Filter.makeWhereClause()
+	whereClause += filterClause
+	queryArgs = append(queryArgs, filterArgs...)
+	return whereClause, queryArgs
+}
+
+func runHistoryQuery(tx *sstore.TxWrap, opts HistoryQueryOpts, realOffset int, itemLimit int) ([]*HistoryItemType, error) {
+	scopeClause, scopeArgs, hNumStr, err := makeHistoryScopeClause(opts)
+	if err != nil {
+		return nil, err
+	}
+	filterClause, filterArgs := makeHistoryFilterClause(opts)
+	// historynums are computed before the filters are applied, so a filtered list shows the same numbers as the full one
+	query := fmt.Sprintf("SELECT %s, h.historynum FROM (SELECT h.*, (? || CAST((row_number() OVER win) as text)) historynum FROM history h %s WINDOW win AS (ORDER BY h.ts, h.historyid)) h WHERE 1%s ORDER BY h.ts DESC, h.historyid DESC LIMIT ? OFFSET ?", HistoryCols, scopeClause, filterClause)
+	queryArgs := append([]interface{}{hNumStr}, scopeArgs...)
+	queryArgs = append(queryArgs, filterArgs...)
+	queryArgs = append(queryArgs, itemLimit, realOffset)
 	marr := tx.SelectMaps(query, queryArgs...)
 	rtn := make([]*HistoryItemType, len(marr))
 	for idx, m := range marr {
@@ -319,8 +428,10 @@ func GetLineCmdsFromHistoryItems(ctx context.Context, historyItems []*HistoryIte
 
 func PurgeHistoryByIds(ctx context.Context, historyIds []string) error {
 	return sstore.WithTx(ctx, func(tx *sstore.TxWrap) error {
+		historyIdsJsonArr := dbutil.QuickJsonArr(historyIds)
 		query := `DELETE FROM history WHERE historyid IN (SELECT value FROM json_each(?))`
-		tx.Exec(query, dbutil.QuickJsonArr(historyIds))
+		tx.Exec(query, historyIdsJsonArr)
+		deleteSearchIndexByIds(tx, historyIdsJsonArr)
 		return nil
 	})
 }
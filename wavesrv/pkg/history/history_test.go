@@ -0,0 +1,86 @@
+package history
+
+import (
+	"context"
+	"testing"
+
+	"github.com/abhishek944/waveterm/wavesrv/pkg/scbase"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+	"github.com/google/uuid"
+)
+
+// migrates a new database in a temp wave home, closed when the test ends
+func initTestDb(t *testing.T) context.Context {
+	t.Setenv(scbase.WaveHomeVarName, t.TempDir())
+	_, err := scbase.EnsureConfigDirs()
+	if err != nil {
+		t.Fatalf("cannot create wave home: %v", err)
+	}
+	err = sstore.TryMigrateUp()
+	if err != nil {
+		t.Fatalf("cannot migrate db: %v", err)
+	}
+	t.Cleanup(sstore.CloseDB)
+	return context.Background()
+}
+
+func makeTestHistoryItem(sessionId string, screenId string, ts int64, cmdStr string, exitCode int64) *HistoryItemType {
+	return &HistoryItemType{
+		HistoryId: uuid.New().String(),
+		Ts:        ts,
+		SessionId: sessionId,
+		ScreenId:  screenId,
+		CmdStr:    cmdStr,
+		ExitCode:  &exitCode,
+		Status:    sstore.CmdStatusDone,
+	}
+}
+
+func TestFilteredHistoryNums(t *testing.T) {
+	ctx := initTestDb(t)
+	sessionId, screenId := uuid.New().String(), uuid.New().String()
+	var items []*HistoryItemType
+	for idx, cmdStr := range []string{"ls", "false", "pwd", "make", "echo ok"} {
+		exitCode := int64(0)
+		if cmdStr == "false" || cmdStr == "make" {
+			exitCode = 1
+		}
+		hitem := makeTestHistoryItem(sessionId, screenId, int64(1000+idx), cmdStr, exitCode)
+		err := InsertHistoryItem(ctx, hitem)
+		if err != nil {
+			t.Fatalf("cannot insert history item: %v", err)
+		}
+		items = append(items, hitem)
+	}
+	failed := true
+	result, err := GetHistoryItems(ctx, HistoryQueryOpts{MaxItems: DefaultMaxHistoryItems, SessionId: sessionId, ScreenId: screenId, Filter: HistoryFilterOpts{Failed: &failed}})
+	if err != nil {
+		t.Fatalf("history query error: %v", err)
+	}
+	if len(result.Items) != 2 || result.Items[0].HistoryNum != "4" || result.Items[1].HistoryNum != "2" {
+		t.Fatalf("filtered items should keep their historynums, got %v", result.Items)
+	}
+	result, err = GetHistoryItems(ctx, HistoryQueryOpts{MaxItems: DefaultMaxHistoryItems, SessionId: sessionId, SearchText: "pwd"})
+	if err != nil {
+		t.Fatalf("history query error: %v", err)
+	}
+	if len(result.Items) != 1 || result.Items[0].HistoryNum != "s3" {
+		t.Fatalf("searched item should keep its historynum, got %v", result.Items)
+	}
+	// tag #4 as shown by the filtered list
+	historyIds, err := ResolveHistoryNums(ctx, sessionId, screenId, []string{"4"})
+	if err != nil {
+		t.Fatalf("cannot resolve historynum: %v", err)
+	}
+	numChanged, err := UpdateHistoryTags(ctx, historyIds, []string{"broken"}, nil, false)
+	if err != nil || numChanged != 1 {
+		t.Fatalf("cannot tag history item: %d %v", numChanged, err)
+	}
+	result, err = GetHistoryItems(ctx, HistoryQueryOpts{MaxItems: DefaultMaxHistoryItems, SessionId: sessionId, ScreenId: screenId, Filter: HistoryFilterOpts{Tags: []string{"broken"}}})
+	if err != nil {
+		t.Fatalf("history query error: %v", err)
+	}
+	if len(result.Items) != 1 || result.Items[0].HistoryId != items[3].HistoryId || result.Items[0].HistoryNum != "4" {
+		t.Fatalf("the wrong item was tagged, got %v", result.Items)
+	}
+}
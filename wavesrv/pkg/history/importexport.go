@@ -0,0 +1,422 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abhishek944/waveterm/waveshell/pkg/utilfn"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/dbutil"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+	"github.com/google/uuid"
+)
+
+const (
+	HistoryFormatBash  = "bash"  // ~/.bash_history, with "#<unixtime>" lines when HISTTIMEFORMAT is set
+	HistoryFormatZsh   = "zsh"   // zsh EXTENDED_HISTORY, ": <start>:<elapsed>;<command>"
+	HistoryFormatJsonl = "jsonl" // one HistoryJsonLine per line
+)
+
+var HistoryFormats = []string{HistoryFormatBash, HistoryFormatZsh, HistoryFormatJsonl}
+
+// imported items point at this remote (which does not exist), the remote name is the import format
+const ImportRemoteId = "75d9f619-fdee-418b-9b3d-13438a9e7262"
+
+// namespace for the (deterministic) historyids of imported items, so importing a file twice does not duplicate it
+var importNamespace = uuid.MustParse("d9a70410-23f3-4e4d-b4b0-5265c64b04ee")
+
+const MaxHistoryLineSize = 1024 * 1024
+
+// zsh metafies 0x00 and 0x83-0xa2 as 0x83 followed by the byte xor 32
+const zshMeta = 0x83
+
+var bashTsRe = regexp.MustCompile(`^#(\d{9,11})$`)
+var zshExtendedRe = regexp.MustCompile(`^: *(\d+):(\d+);`)
+
+// atuin-style json line.  timestamp is RFC3339 (a unix time in s, ms or ns is also accepted on import),
+// duration is in nanoseconds
+type HistoryJsonLine struct {
+	Command   string `json:"command"`
+	Timestamp any    `json:"timestamp"`
+	Duration  *int64 `json:"duration,omitempty"`
+	Exit      *int64 `json:"exit,omitempty"`
+	Cwd       string `json:"cwd,omitempty"`
+}
+
+// a command read from a history file
+type ImportedCmd struct {
+	CmdStr     string
+	Ts         int64 // 0 if the file has no timestamp for the command
+	DurationMs *int64
+	ExitCode   *int64
+	Cwd        string
+}
+
+type ImportResult struct {
+	Parsed     int
+	Imported   int
+	Duplicates int
+}
+
+// uses the file name, then the first line of the file
+func DetectHistoryFormat(fileName string, data []byte) string {
+	baseName := filepath.Base(fileName)
+	switch {
+	case strings.HasSuffix(baseName, ".jsonl") || strings.HasSuffix(baseName, ".json"):
+		return HistoryFormatJsonl
+	case strings.Contains(baseName, "zsh") || baseName == ".histfile":
+		return HistoryFormatZsh
+	case strings.Contains(baseName, "bash"):
+		return HistoryFormatBash
+	}
+	firstLine, _, _ := bytes.Cut(bytes.TrimSpace(data), []byte("\n"))
+	if bytes.HasPrefix(firstLine, []byte("{")) {
+		return HistoryFormatJsonl
+	}
+	if zshExtendedRe.Match(firstLine) {
+		return HistoryFormatZsh
+	}
+	return HistoryFormatBash
+}
+
+func scanHistoryLines(data []byte, lineFn func(line string) error) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), MaxHistoryLineSize)
+	for scanner.Scan() {
+		err := lineFn(strings.TrimSuffix(scanner.Text(), "\r"))
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func ParseHistoryFile(format string, data []byte) ([]*ImportedCmd, error) {
+	switch format {
+	case HistoryFormatBash:
+		return parseBashHistory(data)
+	case HistoryFormatZsh:
+		return parseZshHistory(data)
+	case HistoryFormatJsonl:
+		return parseJsonlHistory(data)
+	}
+	return nil, fmt.Errorf("invalid history format %q", format)
+}
+
+// without timestamps every line is a command.  after a "#<unixtime>" line, all lines up to the next
+// timestamp belong to one command (multi-line commands saved with lithist)
+func parseBashHistory(data []byte) ([]*ImportedCmd, error) {
+	var rtn []*ImportedCmd
+	var cur *ImportedCmd
+	err := scanHistoryLines(data, func(line string) error {
+		if m := bashTsRe.FindStringSubmatch(line); m != nil {
+			tsSec, _ := strconv.ParseInt(m[1], 10, 64)
+			cur = &ImportedCmd{Ts: tsSec * 1000}
+			rtn = append(rtn, cur)
+			return nil
+		}
+		if cur != nil && cur.Ts > 0 {
+			if cur.CmdStr == "" {
+				cur.CmdStr = line
+			} else {
+				cur.CmdStr += "\n" + line
+			}
+			return nil
+		}
+		rtn = append(rtn, &ImportedCmd{CmdStr: line})
+		return nil
+	})
+	return filterEmptyCmds(rtn), err
+}
+
+func zshUnmetafy(data []byte) []byte {
+	if bytes.IndexByte(data, zshMeta) == -1 {
+		return data
+	}
+	rtn := make([]byte, 0, len(data))
+	for idx := 0; idx < len(data); idx++ {
+		if data[idx] == zshMeta && idx+1 < len(data) {
+			idx++
+			rtn = append(rtn, data[idx]^32)
+			continue
+		}
+		rtn = append(rtn, data[idx])
+	}
+	return rtn
+}
+
+func zshMetafy(str string) string {
+	var buf bytes.Buffer
+	for idx := 0; idx < len(str); idx++ {
+		ch := str[idx]
+		if ch == 0 || (ch >= zshMeta && ch <= 0xa2) {
+			buf.WriteByte(zshMeta)
+			buf.WriteByte(ch ^ 32)
+			continue
+		}
+		buf.WriteByte(ch)
+	}
+	return buf.String()
+}
+
+// zsh writes newlines inside a command as "\" + newline.  lines without the extended prefix are plain commands
+func parseZshHistory(data []byte) ([]*ImportedCmd, error) {
+	var rtn []*ImportedCmd
+	var cur *ImportedCmd
+	continued := false
+	err := scanHistoryLines(zshUnmetafy(data), func(line string) error {
+		if continued && cur != nil {
+			cur.CmdStr += "\n"
+		} else {
+			cur = &ImportedCmd{}
+			if m := zshExtendedRe.FindStringSubmatch(line); m != nil {
+				tsSec, _ := strconv.ParseInt(m[1], 10, 64)
+				durSec, _ := strconv.ParseInt(m[2], 10, 64)
+				durMs := durSec * 1000
+				cur.Ts = tsSec * 1000
+				cur.DurationMs = &durMs
+				line = line[len(m[0]):]
+			}
+			rtn = append(rtn, cur)
+		}
+		continued = strings.HasSuffix(line, "\\")
+		if continued {
+			line = line[:len(line)-1]
+		}
+		cur.CmdStr += line
+		return nil
+	})
+	return filterEmptyCmds(rtn), err
+}
+
+// numbers are unix times in seconds, milliseconds or nanoseconds (by magnitude)
+func parseJsonTimestamp(tsVal any) (int64, error) {
+	switch ts := tsVal.(type) {
+	case nil:
+		return 0, nil
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, ts)
+		if err != nil {
+			return 0, fmt.Errorf("invalid timestamp %q", ts)
+		}
+		return t.UnixMilli(), nil
+	case float64:
+		if ts < 1e11 {
+			return int64(ts * 1000), nil
+		} else if ts < 1e14 {
+			return int64(ts), nil
+		}
+		return int64(ts / 1e6), nil
+	}
+	return 0, fmt.Errorf("invalid timestamp %v", tsVal)
+}
+
+func parseJsonlHistory(data []byte) ([]*ImportedCmd, error) {
+	var rtn []*ImportedCmd
+	lineNum := 0
+	err := scanHistoryLines(data, func(line string) error {
+		lineNum++
+		if strings.TrimSpace(line) == "" {
+			return nil
+		}
+		var jline HistoryJsonLine
+		err := json.Unmarshal([]byte(line), &jline)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", lineNum, err)
+		}
+		ts, err := parseJsonTimestamp(jline.Timestamp)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", lineNum, err)
+		}
+		cmd := &ImportedCmd{CmdStr: jline.Command, Ts: ts, Cwd: jline.Cwd}
+		if jline.Duration != nil && *jline.Duration >= 0 {
+			durMs := *jline.Duration / int64(time.Millisecond)
+			cmd.DurationMs = &durMs
+		}
+		if jline.Exit != nil && *jline.Exit >= 0 {
+			cmd.ExitCode = jline.Exit
+		}
+		rtn = append(rtn, cmd)
+		return nil
+	})
+	return filterEmptyCmds(rtn), err
+}
+
+func filterEmptyCmds(cmds []*ImportedCmd) []*ImportedCmd {
+	rtn := cmds[:0]
+	for _, cmd := range cmds {
+		if strings.TrimSpace(cmd.CmdStr) != "" {
+			rtn = append(rtn, cmd)
+		}
+	}
+	return rtn
+}
+
+// commands are the same if they have the same cmdstr and start in the same second (bash and zsh only store seconds).
+// commands without a timestamp are the same if they have the same cmdstr
+func importDedupKey(cmdStr string, ts int64, hasTs bool) string {
+	if !hasTs {
+		return "\x00" + cmdStr
+	}
+	return strconv.FormatInt(ts/1000, 10) + "\x00" + cmdStr
+}
+
+// converts the commands to history items, dropping duplicates within the file (the last one is kept).
+// commands without a timestamp are given one just before baseTs (keeping their order)
+func makeImportItems(userId string, format string, cmds []*ImportedCmd, baseTs int64) ([]*HistoryItemType, []string) {
+	keys := make([]string, len(cmds))
+	lastIdx := make(map[string]int)
+	for idx, cmd := range cmds {
+		keys[idx] = importDedupKey(cmd.CmdStr, cmd.Ts, cmd.Ts > 0)
+		lastIdx[keys[idx]] = idx
+	}
+	var items []*HistoryItemType
+	var itemKeys []string
+	for idx, cmd := range cmds {
+		if lastIdx[keys[idx]] != idx {
+			continue
+		}
+		ts := cmd.Ts
+		if ts <= 0 {
+			ts = baseTs - int64(len(cmds)-idx)
+		}
+		hitem := &HistoryItemType{
+			HistoryId:  uuid.NewSHA1(importNamespace, []byte(keys[idx])).String(),
+			Ts:         ts,
+			UserId:     userId,
+			CmdStr:     cmd.CmdStr,
+			Remote:     sstore.RemotePtrType{RemoteId: ImportRemoteId, Name: format},
+			ExitCode:   cmd.ExitCode,
+			DurationMs: cmd.DurationMs,
+			HadError:   cmd.ExitCode != nil && *cmd.ExitCode != 0,
+			Status:     sstore.CmdStatusUnknown,
+		}
+		if cmd.Cwd != "" {
+			hitem.FeState = sstore.FeStateType{"cwd": cmd.Cwd}
+		}
+		items = append(items, hitem)
+		itemKeys = append(itemKeys, keys[idx])
+	}
+	return items, itemKeys
+}
+
+// inserts the commands into history (with the ImportRemoteId marker remote and CmdStatusUnknown), skipping
+// duplicates within the file, earlier imports and existing history items with the same cmdstr and second
+func ImportHistory(ctx context.Context, userId string, format string, cmds []*ImportedCmd, baseTs int64) (*ImportResult, error) {
+	rtn := &ImportResult{Parsed: len(cmds)}
+	items, itemKeys := makeImportItems(userId, format, cmds, baseTs)
+	rtn.Duplicates = len(cmds) - len(items)
+	txErr := sstore.WithTx(ctx, func(tx *sstore.TxWrap) error {
+		var minTs, maxTs int64
+		for idx, hitem := range items {
+			if !strings.HasPrefix(itemKeys[idx], "\x00") {
+				if minTs == 0 || hitem.Ts < minTs {
+					minTs = hitem.Ts
+				}
+				maxTs = max(maxTs, hitem.Ts)
+			}
+		}
+		existingKeys := make(map[string]bool)
+		if maxTs > 0 {
+			var rows []struct {
+				Ts     int64  `db:"ts"`
+				CmdStr string `db:"cmdstr"`
+			}
+			query := `SELECT ts, cmdstr FROM history WHERE ts >= ? AND ts < ?`
+			tx.Select(&rows, query, minTs-minTs%1000, maxTs-maxTs%1000+1000)
+			for _, row := range rows {
+				existingKeys[importDedupKey(row.CmdStr, row.Ts, true)] = true
+			}
+		}
+		for idx, hitem := range items {
+			if existingKeys[itemKeys[idx]] || tx.Exists(`SELECT historyid FROM history WHERE historyid = ?`, hitem.HistoryId) {
+				rtn.Duplicates++
+				continue
+			}
+			insertHistoryItemTx(tx, hitem)
+			rtn.Imported++
+		}
+		return nil
+	})
+	if txErr != nil {
+		return nil, txErr
+	}
+	return rtn, nil
+}
+
+func formatHistoryItem(format string, hitem *HistoryItemType) (string, error) {
+	tsSec := hitem.Ts / 1000
+	switch format {
+	case HistoryFormatBash:
+		return fmt.Sprintf("#%d\n%s\n", tsSec, hitem.CmdStr), nil
+	case HistoryFormatZsh:
+		var durSec int64
+		if hitem.DurationMs != nil {
+			durSec = *hitem.DurationMs / 1000
+		}
+		cmdStr := strings.ReplaceAll(hitem.CmdStr, "\n", "\\\n")
+		return zshMetafy(fmt.Sprintf(": %d:%d;%s\n", tsSec, durSec, cmdStr)), nil
+	case HistoryFormatJsonl:
+		jline := HistoryJsonLine{
+			Command:   hitem.CmdStr,
+			Timestamp: time.UnixMilli(hitem.Ts).UTC().Format(time.RFC3339Nano),
+			Exit:      hitem.ExitCode,
+			Cwd:       hitem.FeState["cwd"],
+		}
+		if hitem.DurationMs != nil {
+			durNs := *hitem.DurationMs * int64(time.Millisecond)
+			jline.Duration = &durNs
+		}
+		barr, err := json.Marshal(jline)
+		if err != nil {
+			return "", err
+		}
+		return string(barr) + "\n", nil
+	}
+	return "", fmt.Errorf("invalid history format %q", format)
+}
+
+// writes the history items matching opts (oldest first, opts.MaxItems is ignored).  returns the number of items written
+func ExportHistory(ctx context.Context, w io.Writer, format string, opts HistoryQueryOpts) (int, error) {
+	if !utilfn.ContainsStr(HistoryFormats, format) {
+		return 0, fmt.Errorf("invalid history format %q", format)
+	}
+	whereClause, queryArgs, err := makeHistoryWhereClause(opts)
+	if err != nil {
+		return 0, err
+	}
+	items, err := sstore.WithTxRtn(ctx, func(tx *sstore.TxWrap) ([]*HistoryItemType, error) {
+		query := fmt.Sprintf("SELECT %s FROM history h %s ORDER BY h.ts, h.historyid", HistoryCols, whereClause)
+		return dbutil.SelectMapsGen[*HistoryItemType](tx, query, queryArgs...), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	bufWriter := bufio.NewWriter(w)
+	for _, hitem := range items {
+		str, err := formatHistoryItem(format, hitem)
+		if err != nil {
+			return 0, err
+		}
+		_, err = bufWriter.WriteString(str)
+		if err != nil {
+			return 0, err
+		}
+	}
+	err = bufWriter.Flush()
+	if err != nil {
+		return 0, err
+	}
+	return len(items), nil
+}
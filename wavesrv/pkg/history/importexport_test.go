@@ -0,0 +1,129 @@
+package history
+
+import (
+	"strings"
+	"testing"
+)
+
+func cmdStrs(cmds []*ImportedCmd) []string {
+	var rtn []string
+	for _, cmd := range cmds {
+		rtn = append(rtn, cmd.CmdStr)
+	}
+	return rtn
+}
+
+func TestParseBashHistory(t *testing.T) {
+	data := "ls\ncd /tmp\n\n#1700000000\nmake\n#1700000060\nfor x in 1 2; do\n  echo $x\ndone\n"
+	cmds, err := parseBashHistory([]byte(data))
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if strings.Join(cmdStrs(cmds), "|") != "ls|cd /tmp|make|for x in 1 2; do\n  echo $x\ndone" {
+		t.Fatalf("bad cmds %q", cmdStrs(cmds))
+	}
+	if cmds[0].Ts != 0 || cmds[2].Ts != 1700000000000 || cmds[3].Ts != 1700000060000 {
+		t.Errorf("bad timestamps %d %d %d", cmds[0].Ts, cmds[2].Ts, cmds[3].Ts)
+	}
+}
+
+func TestParseZshHistory(t *testing.T) {
+	data := ": 1700000000:5;git status\n: 1700000010:0;echo a\\\nb\nplain cmd\n: 1700000020:0;echo " + zshMetafy("héllo") + "\n"
+	cmds, err := parseZshHistory([]byte(data))
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if strings.Join(cmdStrs(cmds), "|") != "git status|echo a\nb|plain cmd|echo héllo" {
+		t.Fatalf("bad cmds %q", cmdStrs(cmds))
+	}
+	if cmds[0].Ts != 1700000000000 || cmds[0].DurationMs == nil || *cmds[0].DurationMs != 5000 || cmds[2].Ts != 0 {
+		t.Errorf("bad zsh times %+v %+v", cmds[0], cmds[2])
+	}
+}
+
+func TestParseJsonlHistory(t *testing.T) {
+	data := `{"command":"ls","timestamp":"2023-11-14T22:13:20Z","duration":2000000,"exit":1,"cwd":"/tmp"}
+{"command":"pwd","timestamp":1700000000}
+{"command":"id","timestamp":1700000000123}
+
+`
+	cmds, err := parseJsonlHistory([]byte(data))
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if len(cmds) != 3 || cmds[0].Ts != 1700000000000 || *cmds[0].DurationMs != 2 || *cmds[0].ExitCode != 1 || cmds[0].Cwd != "/tmp" {
+		t.Fatalf("bad first cmd %+v", cmds[0])
+	}
+	if cmds[1].Ts != 1700000000000 || cmds[2].Ts != 1700000000123 {
+		t.Errorf("bad numeric timestamps %d %d", cmds[1].Ts, cmds[2].Ts)
+	}
+	if _, err := parseJsonlHistory([]byte("{\"command\":\"ls\"}\nnot json\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected line 2 error, got %v", err)
+	}
+}
+
+func TestDetectHistoryFormat(t *testing.T) {
+	tests := []struct {
+		fileName string
+		data     string
+		expected string
+	}{
+		{"/home/u/.bash_history", "", HistoryFormatBash},
+		{"/home/u/.zsh_history", "", HistoryFormatZsh},
+		{"/tmp/export.jsonl", "", HistoryFormatJsonl},
+		{"/tmp/hist", ": 1700000000:0;ls\n", HistoryFormatZsh},
+		{"/tmp/hist", "{\"command\":\"ls\"}\n", HistoryFormatJsonl},
+		{"/tmp/hist", "ls\n", HistoryFormatBash},
+	}
+	for _, test := range tests {
+		if format := DetectHistoryFormat(test.fileName, []byte(test.data)); format != test.expected {
+			t.Errorf("%s %q: got %s, expected %s", test.fileName, test.data, format, test.expected)
+		}
+	}
+}
+
+func TestMakeImportItems(t *testing.T) {
+	cmds := []*ImportedCmd{
+		{CmdStr: "ls"},
+		{CmdStr: "make", Ts: 1700000000000},
+		{CmdStr: "ls"},
+		{CmdStr: "make", Ts: 1700000000500},
+		{CmdStr: "make", Ts: 1700000005000},
+	}
+	items, _ := makeImportItems("user", HistoryFormatBash, cmds, 1800000000000)
+	if len(items) != 3 {
+		t.Fatalf("expected 3 items after dedup, got %d", len(items))
+	}
+	if items[0].CmdStr != "ls" || items[0].Ts != 1800000000000-3 {
+		t.Errorf("untimestamped item should keep its last position: %+v", items[0])
+	}
+	if items[0].Remote.RemoteId != ImportRemoteId || items[0].Remote.Name != HistoryFormatBash || items[0].Status != "unknown" {
+		t.Errorf("bad import marker %+v", items[0])
+	}
+	again, _ := makeImportItems("user", HistoryFormatBash, cmds, 1900000000000)
+	if again[1].HistoryId != items[1].HistoryId || again[0].HistoryId != items[0].HistoryId {
+		t.Errorf("historyids should be stable across imports")
+	}
+}
+
+func TestFormatHistoryItemRoundTrip(t *testing.T) {
+	durMs := int64(3000)
+	exitCode := int64(2)
+	hitem := &HistoryItemType{CmdStr: "echo a\necho héllo", Ts: 1700000000000, DurationMs: &durMs, ExitCode: &exitCode}
+	for _, format := range HistoryFormats {
+		str, err := formatHistoryItem(format, hitem)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		cmds, err := ParseHistoryFile(format, []byte(str))
+		if err != nil || len(cmds) != 1 {
+			t.Fatalf("%s: parse error %v %d\n%s", format, err, len(cmds), str)
+		}
+		if cmds[0].CmdStr != hitem.CmdStr || cmds[0].Ts != hitem.Ts {
+			t.Errorf("%s: round trip mismatch %q %d", format, cmds[0].CmdStr, cmds[0].Ts)
+		}
+		if format != HistoryFormatBash && (cmds[0].DurationMs == nil || *cmds[0].DurationMs != durMs) {
+			t.Errorf("%s: duration not kept", format)
+		}
+	}
+}
@@ -0,0 +1,425 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package history
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abhishek944/waveterm/wavesrv/pkg/dbutil"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/scbase"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/scbus"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+	"github.com/google/uuid"
+)
+
+const RetentionFirstRunDelay = 5 * time.Minute
+const RetentionInterval = 6 * time.Hour
+const RetentionTimeout = 10 * time.Minute
+
+// rows are deleted in batches so a large first run does not hold one long write transaction
+const RetentionBatchSize = 500
+
+// ptyout files (and screen dirs) newer than this are never treated as orphans, their line may not be committed yet
+const OrphanFileMinAge = time.Hour
+
+const ptyOutFileSuffix = ".ptyout.cf"
+
+type RetentionResult struct {
+	StartTs        int64
+	DurationMs     int64
+	HistoryItems   int
+	Lines          int
+	Screens        int
+	Files          int
+	BytesReclaimed int64
+}
+
+var retentionLock = &sync.Mutex{}
+var lastRetentionResult *RetentionResult
+
+// returns the result of the last janitor run (nil if it has not run since startup)
+func GetLastRetentionResult() *RetentionResult {
+	retentionLock.Lock()
+	defer retentionLock.Unlock()
+	return lastRetentionResult
+}
+
+// runs the retention janitor every RetentionInterval, with the client's retention options (does nothing if they are not set)
+func StartRetentionJanitor() {
+	go func() {
+		time.Sleep(RetentionFirstRunDelay)
+		for {
+			runRetentionFromClientOpts()
+			time.Sleep(RetentionInterval)
+		}
+	}()
+}
+
+func runRetentionFromClientOpts() {
+	ctx, cancelFn := context.WithTimeout(context.Background(), RetentionTimeout)
+	defer cancelFn()
+	clientData, err := sstore.EnsureClientData(ctx)
+	if err != nil {
+		log.Printf("[history] retention: cannot get client data: %v\n", err)
+		return
+	}
+	opts := clientData.ClientOpts.Retention
+	if !opts.IsEnabled() {
+		return
+	}
+	result, err := RunRetention(ctx, *opts)
+	if err != nil {
+		log.Printf("[history] retention: %v\n", err)
+		return
+	}
+	log.Printf("[history] retention: removed %d history items, %d lines, %d archived screens, %d files, reclaimed %d bytes (%dms)\n", result.HistoryItems, result.Lines, result.Screens, result.Files, result.BytesReclaimed, result.DurationMs)
+}
+
+// purges archived screens, history items (with their lines, cmds and ptyout files) and orphaned ptyout files according to opts.
+// items of running commands and web-shared screens are never removed
+func RunRetention(ctx context.Context, opts sstore.RetentionOptsType) (*RetentionResult, error) {
+	retentionLock.Lock()
+	defer retentionLock.Unlock()
+	now := time.Now()
+	result := &RetentionResult{StartTs: now.UnixMilli()}
+	if opts.ArchivedScreenDays > 0 {
+		err := purgeArchivedScreens(ctx, opts, now, result)
+		if err != nil {
+			return nil, fmt.Errorf("error purging archived screens: %w", err)
+		}
+	}
+	if opts.MaxAgeDays > 0 || opts.MaxItemsPerScreen > 0 {
+		err := purgeHistory(ctx, opts, now, result)
+		if err != nil {
+			return nil, fmt.Errorf("error purging history: %w", err)
+		}
+	}
+	err := purgeOrphanFiles(ctx, now, result)
+	if err != nil {
+		return nil, fmt.Errorf("error removing orphaned files: %w", err)
+	}
+	result.DurationMs = time.Since(now).Milliseconds()
+	lastRetentionResult = result
+	return result, nil
+}
+
+func daysBefore(now time.Time, days int) int64 {
+	return now.AddDate(0, 0, -days).UnixMilli()
+}
+
+func purgeArchivedScreens(ctx context.Context, opts sstore.RetentionOptsType, now time.Time, result *RetentionResult) error {
+	screenIds, err := sstore.WithTxRtn(ctx, func(tx *sstore.TxWrap) ([]string, error) {
+		// archivedts is 0 for screens archived before it was recorded, those are kept
+		query := `SELECT s.screenid FROM screen s
+		          WHERE s.archived AND s.archivedts > 0 AND s.archivedts < ?
+		            AND NOT EXISTS (SELECT 1 FROM cmd c WHERE c.screenid = s.screenid AND c.status = ?)`
+		if !opts.NoKeepStarred {
+			query += ` AND NOT EXISTS (SELECT 1 FROM line l WHERE l.screenid = s.screenid AND l.star)`
+		}
+		return tx.SelectStrings(query, daysBefore(now, opts.ArchivedScreenDays), sstore.CmdStatusRunning), nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, screenId := range screenIds {
+		numLines, err := sstore.WithTxRtn(ctx, func(tx *sstore.TxWrap) (int, error) {
+			return tx.GetInt(`SELECT count(*) FROM line WHERE screenid = ?`, screenId), nil
+		})
+		if err != nil {
+			return err
+		}
+		diskSize, _ := sstore.ScreenDiskSize(screenId)
+		update, err := sstore.DeleteScreen(ctx, screenId, false, nil)
+		if err != nil {
+			log.Printf("[history] retention: cannot delete archived screen %s: %v\n", screenId, err)
+			continue
+		}
+		scbus.MainUpdateBus.DoUpdate(update)
+		result.Screens++
+		result.Lines += numLines
+		result.Files += diskSize.NumFiles
+		result.BytesReclaimed += diskSize.TotalSize
+	}
+	return nil
+}
+
+type retentionItem struct {
+	HistoryId string
+	ScreenId  string
+	LineId    string
+}
+
+// the history items to purge, the age and per-screen limits are ORed, the keep exemptions apply to both
+func makeRetentionQuery(opts sstore.RetentionOptsType, now time.Time) (string, []interface{}) {
+	var limitConds []string
+	var queryArgs []interface{}
+	if opts.MaxAgeDays > 0 {
+		limitConds = append(limitConds, "h.ts < ?")
+		queryArgs = append(queryArgs, daysBefore(now, opts.MaxAgeDays))
+	}
+	if opts.MaxItemsPerScreen > 0 {
+		// imported items (no screen) only expire by age
+		limitConds = append(limitConds, "(h.screenid <> '' AND h.screenrank > ?)")
+		queryArgs = append(queryArgs, opts.MaxItemsPerScreen)
+	}
+	query := `SELECT h.historyid, h.screenid, h.lineid
+	          FROM (SELECT historyid, ts, screenid, lineid, cmdstr, tags,
+	                       row_number() OVER (PARTITION BY screenid ORDER BY ts DESC, historyid DESC) screenrank
+	                FROM history) h
+	          WHERE (` + strings.Join(limitConds, " OR ") + `)
+	            AND NOT EXISTS (SELECT 1 FROM cmd c WHERE c.screenid = h.screenid AND c.lineid = h.lineid AND c.status = ?)`
+	queryArgs = append(queryArgs, sstore.CmdStatusRunning)
+	if !opts.NoKeepStarred {
+		query += ` AND NOT EXISTS (SELECT 1 FROM line l WHERE l.screenid = h.screenid AND l.lineid = h.lineid AND l.star)`
+	}
+	if !opts.NoKeepBookmarked {
+		query += ` AND h.cmdstr NOT IN (SELECT cmdstr FROM bookmark)`
+	}
+	if !opts.NoKeepTagged {
+		query += ` AND NOT EXISTS (SELECT 1 FROM json_each(h.tags) WHERE json_each.value)`
+	}
+	query += ` LIMIT ?`
+	queryArgs = append(queryArgs, RetentionBatchSize)
+	return query, queryArgs
+}
+
+// lines without a history item (nohist commands, lines whose history item was purged before) only expire by age
+func makeOrphanLineQuery(opts sstore.RetentionOptsType, now time.Time) (string, []interface{}) {
+	query := `SELECT l.screenid, l.lineid FROM line l
+	          WHERE l.ts < ?
+	            AND NOT EXISTS (SELECT 1 FROM history h WHERE h.screenid = l.screenid AND h.lineid = l.lineid)
+	            AND NOT EXISTS (SELECT 1 FROM cmd c WHERE c.screenid = l.screenid AND c.lineid = l.lineid AND c.status = ?)`
+	if !opts.NoKeepStarred {
+		query += ` AND NOT l.star`
+	}
+	query += ` AND l.screenid NOT IN (SELECT screenid FROM screen WHERE sharemode = ?) LIMIT ?`
+	return query, []interface{}{daysBefore(now, opts.MaxAgeDays), sstore.CmdStatusRunning, sstore.ShareModeWeb, RetentionBatchSize}
+}
+
+// deletes the lines and cmds (in a web-shared screen only the history item is deleted), returns the lines that were removed
+func deleteRetentionLinesTx(tx *sstore.TxWrap, items []retentionItem) []retentionItem {
+	var rtn []retentionItem
+	webShareScreens := make(map[string]bool)
+	for _, screenId := range tx.SelectStrings(`SELECT screenid FROM screen WHERE sharemode = ?`, sstore.ShareModeWeb) {
+		webShareScreens[screenId] = true
+	}
+	for _, item := range items {
+		if item.LineId == "" || webShareScreens[item.ScreenId] {
+			continue
+		}
+		if !tx.Exists(`SELECT lineid FROM line WHERE screenid = ? AND lineid = ?`, item.ScreenId, item.LineId) {
+			continue
+		}
+		tx.Exec(`DELETE FROM line WHERE screenid = ? AND lineid = ?`, item.ScreenId, item.LineId)
+		tx.Exec(`DELETE FROM cmd WHERE screenid = ? AND lineid = ?`, item.ScreenId, item.LineId)
+		tx.Exec(`DELETE FROM ai_audit WHERE screenid = ? AND lineid = ?`, item.ScreenId, item.LineId)
+		rtn = append(rtn, item)
+	}
+	return rtn
+}
+
+func purgeHistory(ctx context.Context, opts sstore.RetentionOptsType, now time.Time, result *RetentionResult) error {
+	var removedLines []retentionItem
+	for {
+		numItems, lines, err := sstore.WithTxRtn3(ctx, func(tx *sstore.TxWrap) (int, []retentionItem, error) {
+			query, queryArgs := makeRetentionQuery(opts, now)
+			var items []retentionItem
+			for _, m := range tx.SelectMaps(query, queryArgs...) {
+				var item retentionItem
+				dbutil.QuickSetStr(&item.HistoryId, m, "historyid")
+				dbutil.QuickSetStr(&item.ScreenId, m, "screenid")
+				dbutil.QuickSetStr(&item.LineId, m, "lineid")
+				items = append(items, item)
+			}
+			if len(items) == 0 {
+				return 0, nil, nil
+			}
+			historyIds := make([]string, len(items))
+			for idx, item := range items {
+				historyIds[idx] = item.HistoryId
+			}
+			historyIdsJsonArr := dbutil.QuickJsonArr(historyIds)
+			tx.Exec(`DELETE FROM history WHERE historyid IN (SELECT value FROM json_each(?))`, historyIdsJsonArr)
+			deleteSearchIndexByIds(tx, historyIdsJsonArr)
+			return len(items), deleteRetentionLinesTx(tx, items), nil
+		})
+		if err != nil {
+			return err
+		}
+		result.HistoryItems += numItems
+		removedLines = append(removedLines, lines...)
+		if numItems < RetentionBatchSize {
+			break
+		}
+	}
+	if opts.MaxAgeDays > 0 {
+		for {
+			lines, err := sstore.WithTxRtn(ctx, func(tx *sstore.TxWrap) ([]retentionItem, error) {
+				query, queryArgs := makeOrphanLineQuery(opts, now)
+				var items []retentionItem
+				for _, m := range tx.SelectMaps(query, queryArgs...) {
+					var item retentionItem
+					dbutil.QuickSetStr(&item.ScreenId, m, "screenid")
+					dbutil.QuickSetStr(&item.LineId, m, "lineid")
+					items = append(items, item)
+				}
+				return deleteRetentionLinesTx(tx, items), nil
+			})
+			if err != nil {
+				return err
+			}
+			removedLines = append(removedLines, lines...)
+			if len(lines) < RetentionBatchSize {
+				break
+			}
+		}
+	}
+	result.Lines += len(removedLines)
+	for _, item := range removedLines {
+		size, err := removePtyOutFile(item.ScreenId, item.LineId)
+		if err != nil {
+			log.Printf("[history] retention: cannot remove ptyout file %s/%s: %v\n", item.ScreenId, item.LineId, err)
+			continue
+		}
+		if size >= 0 {
+			result.Files++
+			result.BytesReclaimed += size
+		}
+	}
+	sendRemovedLineUpdates(ctx, removedLines)
+	return nil
+}
+
+// returns the size of the removed file, or -1 if there was no file
+func removePtyOutFile(screenId string, lineId string) (int64, error) {
+	fileName, err := scbase.PtyOutFile(screenId, lineId)
+	if err != nil {
+		return -1, err
+	}
+	return removeFileWithSize(fileName)
+}
+
+func removeFileWithSize(fileName string) (int64, error) {
+	finfo, err := os.Stat(fileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return -1, nil
+	}
+	if err != nil {
+		return -1, err
+	}
+	err = os.Remove(fileName)
+	if err != nil {
+		return -1, err
+	}
+	return finfo.Size(), nil
+}
+
+// removes the lines from open screens (and fixes up the selected line)
+func sendRemovedLineUpdates(ctx context.Context, removedLines []retentionItem) {
+	screenLines := make(map[string][]string)
+	for _, item := range removedLines {
+		screenLines[item.ScreenId] = append(screenLines[item.ScreenId], item.LineId)
+	}
+	for screenId, lineIds := range screenLines {
+		update := scbus.MakeUpdatePacket()
+		for _, lineId := range lineIds {
+			sstore.AddLineUpdate(update, &sstore.LineType{ScreenId: screenId, LineId: lineId, Remove: true}, nil)
+		}
+		screen, err := sstore.FixupScreenSelectedLine(ctx, screenId)
+		if err != nil {
+			log.Printf("[history] retention: error fixing up screen %s: %v\n", screenId, err)
+		} else if screen != nil {
+			update.AddUpdate(*screen)
+		}
+		scbus.MainUpdateBus.DoScreenUpdate(screenId, update)
+	}
+}
+
+// removes ptyout files whose line no longer exists (/line:delete keeps them) and dirs of deleted screens
+func purgeOrphanFiles(ctx context.Context, now time.Time, result *RetentionResult) error {
+	screensDir := scbase.GetScreensDir()
+	entries, err := os.ReadDir(screensDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	minModTime := now.Add(-OrphanFileMinAge)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		screenId := entry.Name()
+		if _, err := uuid.Parse(screenId); err != nil {
+			continue
+		}
+		lineIds, screenExists, err := getScreenLineIds(ctx, screenId)
+		if err != nil {
+			return err
+		}
+		screenDir := filepath.Join(screensDir, screenId)
+		if !screenExists {
+			finfo, err := entry.Info()
+			if err != nil || finfo.ModTime().After(minModTime) {
+				continue
+			}
+			diskSize, _ := sstore.ScreenDiskSize(screenId)
+			err = os.RemoveAll(screenDir)
+			if err != nil {
+				log.Printf("[history] retention: cannot remove screen dir %s: %v\n", screenDir, err)
+				continue
+			}
+			result.Files += diskSize.NumFiles
+			result.BytesReclaimed += diskSize.TotalSize
+			continue
+		}
+		files, err := os.ReadDir(screenDir)
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			lineId, isPtyOut := strings.CutSuffix(file.Name(), ptyOutFileSuffix)
+			if !isPtyOut || file.IsDir() || lineIds[lineId] {
+				continue
+			}
+			finfo, err := file.Info()
+			if err != nil || finfo.ModTime().After(minModTime) {
+				continue
+			}
+			size, err := removeFileWithSize(filepath.Join(screenDir, file.Name()))
+			if err != nil {
+				log.Printf("[history] retention: cannot remove orphaned ptyout file %s: %v\n", file.Name(), err)
+				continue
+			}
+			if size >= 0 {
+				result.Files++
+				result.BytesReclaimed += size
+			}
+		}
+	}
+	return nil
+}
+
+func getScreenLineIds(ctx context.Context, screenId string) (map[string]bool, bool, error) {
+	return sstore.WithTxRtn3(ctx, func(tx *sstore.TxWrap) (map[string]bool, bool, error) {
+		if !tx.Exists(`SELECT screenid FROM screen WHERE screenid = ?`, screenId) {
+			return nil, false, nil
+		}
+		rtn := make(map[string]bool)
+		for _, lineId := range tx.SelectStrings(`SELECT lineid FROM line WHERE screenid = ?`, screenId) {
+			rtn[lineId] = true
+		}
+		return rtn, true, nil
+	})
+}
@@ -0,0 +1,161 @@
+package history
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+	"github.com/google/uuid"
+)
+
+// inserts a line (with a cmd in cmdStatus) for the history item and sets its lineid
+func insertTestLine(t *testing.T, ctx context.Context, hitem *HistoryItemType, star bool, cmdStatus string) {
+	hitem.LineId = uuid.New().String()
+	err := sstore.WithTx(ctx, func(tx *sstore.TxWrap) error {
+		query := `INSERT INTO line (screenid, userid, lineid, ts, linenum, linenumtemp, linetype, linelocal, text, ephemeral, contentheight, star, archived, renderer)
+		          VALUES (?, '', ?, ?, 1, false, ?, true, '', false, 0, ?, false, '')`
+		tx.Exec(query, hitem.ScreenId, hitem.LineId, hitem.Ts, sstore.LineTypeCmd, star)
+		query = `INSERT INTO cmd (screenid, lineid, remoteownerid, remoteid, remotename, cmdstr, rawcmdstr, festate, statebasehash, statediffhasharr,
+		                          termopts, origtermopts, status, cmdpid, remotepid, donets, exitcode, durationms, rtnstate, rtnbasehash, rtndiffhasharr, runout)
+		          VALUES (?, ?, '', '', '', ?, ?, '{}', '', '[]', '{}', '{}', ?, 0, 0, 0, 0, 0, false, '', '[]', '[]')`
+		tx.Exec(query, hitem.ScreenId, hitem.LineId, hitem.CmdStr, hitem.CmdStr, cmdStatus)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("cannot insert line: %v", err)
+	}
+}
+
+func insertTestHistoryItems(t *testing.T, ctx context.Context, hitems ...*HistoryItemType) {
+	for _, hitem := range hitems {
+		err := InsertHistoryItem(ctx, hitem)
+		if err != nil {
+			t.Fatalf("cannot insert history item: %v", err)
+		}
+	}
+}
+
+func getTestHistoryIds(t *testing.T, ctx context.Context) map[string]bool {
+	historyIds, err := sstore.WithTxRtn(ctx, func(tx *sstore.TxWrap) ([]string, error) {
+		return tx.SelectStrings(`SELECT historyid FROM history`), nil
+	})
+	if err != nil {
+		t.Fatalf("cannot get history ids: %v", err)
+	}
+	rtn := make(map[string]bool)
+	for _, historyId := range historyIds {
+		rtn[historyId] = true
+	}
+	return rtn
+}
+
+func testLineExists(t *testing.T, ctx context.Context, hitem *HistoryItemType) bool {
+	exists, err := sstore.WithTxRtn(ctx, func(tx *sstore.TxWrap) (bool, error) {
+		return tx.Exists(`SELECT lineid FROM line WHERE screenid = ? AND lineid = ?`, hitem.ScreenId, hitem.LineId), nil
+	})
+	if err != nil {
+		t.Fatalf("cannot get line: %v", err)
+	}
+	return exists
+}
+
+func TestRunRetention(t *testing.T) {
+	ctx := initTestDb(t)
+	now := time.Now()
+	oldTs := now.AddDate(0, 0, -40).UnixMilli()
+	recentTs := now.Add(-time.Hour).UnixMilli()
+	sessionId := uuid.New().String()
+	screenId, otherScreenId, webScreenId := uuid.New().String(), uuid.New().String(), uuid.New().String()
+	err := sstore.WithTx(ctx, func(tx *sstore.TxWrap) error {
+		query := `INSERT INTO screen (screenid, sessionid, name, screenidx, screenopts, ownerid, sharemode, curremoteownerid, curremoteid, curremotename,
+		                              nextlinenum, selectedline, anchor, focustype, archived, archivedts)
+		          VALUES (?, ?, ?, 1, '{}', '', ?, '', '', '', 2, 0, '{}', '', false, 0)`
+		tx.Exec(query, screenId, sessionId, "s1", sstore.ShareModeLocal)
+		tx.Exec(query, otherScreenId, sessionId, "s2", sstore.ShareModeLocal)
+		tx.Exec(query, webScreenId, sessionId, "web", sstore.ShareModeWeb)
+		tx.Exec(`INSERT INTO bookmark (bookmarkid, createdts, cmdstr, alias, tags, description) VALUES (?, 0, 'make deploy', '', '[]', '')`, uuid.New().String())
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("cannot insert screens: %v", err)
+	}
+
+	// expired by age
+	oldItem := makeTestHistoryItem(sessionId, screenId, oldTs, "ls", 0)
+	insertTestLine(t, ctx, oldItem, false, sstore.CmdStatusDone)
+	oldImported := makeTestHistoryItem("", "", oldTs, "imported old", 0)
+	// exempt
+	starred := makeTestHistoryItem(sessionId, screenId, oldTs, "starred", 0)
+	insertTestLine(t, ctx, starred, true, sstore.CmdStatusDone)
+	bookmarked := makeTestHistoryItem(sessionId, screenId, oldTs, "make deploy", 0)
+	tagged := makeTestHistoryItem(sessionId, screenId, oldTs, "tagged", 0)
+	tagged.Tags = map[string]bool{"keep": true}
+	running := makeTestHistoryItem(sessionId, screenId, oldTs, "sleep 1000", 0)
+	insertTestLine(t, ctx, running, false, sstore.CmdStatusRunning)
+	// the history item is removed, the line of a web-shared screen is kept
+	webShared := makeTestHistoryItem(sessionId, webScreenId, oldTs, "web", 0)
+	insertTestLine(t, ctx, webShared, false, sstore.CmdStatusDone)
+	insertTestHistoryItems(t, ctx, oldItem, oldImported, starred, bookmarked, tagged, running, webShared)
+	// over the per-screen limit (2) on their own screen, imported items only expire by age
+	var recentItems []*HistoryItemType
+	for idx := 0; idx < 3; idx++ {
+		recentItems = append(recentItems, makeTestHistoryItem(sessionId, otherScreenId, recentTs+int64(idx), fmt.Sprintf("recent %d", idx), 0))
+		recentItems = append(recentItems, makeTestHistoryItem("", "", recentTs+int64(idx), fmt.Sprintf("imported %d", idx), 0))
+	}
+	insertTestHistoryItems(t, ctx, recentItems...)
+
+	result, err := RunRetention(ctx, sstore.RetentionOptsType{MaxAgeDays: 30, MaxItemsPerScreen: 2})
+	if err != nil {
+		t.Fatalf("retention error: %v", err)
+	}
+	historyIds := getTestHistoryIds(t, ctx)
+	for _, hitem := range []*HistoryItemType{oldItem, oldImported, webShared, recentItems[0]} {
+		if historyIds[hitem.HistoryId] {
+			t.Errorf("history item %q should be removed", hitem.CmdStr)
+		}
+	}
+	for _, hitem := range append([]*HistoryItemType{starred, bookmarked, tagged, running}, recentItems[1:]...) {
+		if !historyIds[hitem.HistoryId] {
+			t.Errorf("history item %q should be kept", hitem.CmdStr)
+		}
+	}
+	if result.HistoryItems != 4 || result.Lines != 1 {
+		t.Errorf("expected 4 history items and 1 line removed, got %d %d", result.HistoryItems, result.Lines)
+	}
+	if testLineExists(t, ctx, oldItem) {
+		t.Errorf("the line of the removed history item should be removed")
+	}
+	for _, hitem := range []*HistoryItemType{starred, running, webShared} {
+		if !testLineExists(t, ctx, hitem) {
+			t.Errorf("the line of %q should be kept", hitem.CmdStr)
+		}
+	}
+}
+
+func TestRunRetentionBatches(t *testing.T) {
+	ctx := initTestDb(t)
+	sessionId, screenId := uuid.New().String(), uuid.New().String()
+	oldTs := time.Now().AddDate(0, 0, -40).UnixMilli()
+	numItems := RetentionBatchSize*2 + 10
+	err := sstore.WithTx(ctx, func(tx *sstore.TxWrap) error {
+		for idx := 0; idx < numItems; idx++ {
+			insertHistoryItemTx(tx, makeTestHistoryItem(sessionId, screenId, oldTs+int64(idx), fmt.Sprintf("cmd %d", idx), 0))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("cannot insert history items: %v", err)
+	}
+	kept := makeTestHistoryItem(sessionId, screenId, time.Now().UnixMilli(), "recent", 0)
+	insertTestHistoryItems(t, ctx, kept)
+	result, err := RunRetention(ctx, sstore.RetentionOptsType{MaxAgeDays: 30})
+	if err != nil {
+		t.Fatalf("retention error: %v", err)
+	}
+	historyIds := getTestHistoryIds(t, ctx)
+	if result.HistoryItems != numItems || len(historyIds) != 1 || !historyIds[kept.HistoryId] {
+		t.Errorf("expected %d history items removed and the recent one kept, got %d removed, %d left", numItems, result.HistoryItems, len(historyIds))
+	}
+}
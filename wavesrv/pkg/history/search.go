@@ -0,0 +1,303 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package history
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/abhishek944/waveterm/wavesrv/pkg/dbutil"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/prompts"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+	"github.com/google/uuid"
+)
+
+// full-text search over history cmdstrs and command output.  the fts5 tables are created at startup
+// (not in a migration) because fts5 is only compiled into go-sqlite3 with the "sqlite_fts5" build tag.
+// without it, cmdstr search falls back to LIKE and output search is unavailable.
+const (
+	HistoryFtsTable       = "history_fts"
+	HistoryOutputFtsTable = "history_output_fts"
+)
+
+const MaxIndexedOutputBytes = 256 * 1024
+const DefaultSearchResults = 50
+const MaxSearchResults = 500
+const SearchSnippetTokens = 12
+const IndexOutputTimeout = 10 * time.Second
+
+// output can still be arriving when cmddone is processed
+const IndexOutputDelay = 500 * time.Millisecond
+
+var searchIndexAvailable atomic.Bool
+
+func init() {
+	sstore.HistoryLinesDeletedHandler = deleteSearchIndexForLines
+}
+
+type HistorySearchOpts struct {
+	Query     string
+	Output    bool // search command output instead of cmdstrs
+	SessionId string
+	ScreenId  string
+	RemoteId  string
+	MaxItems  int
+	Filter    HistoryFilterOpts
+}
+
+type HistorySearchHit struct {
+	Item       *HistoryItemType
+	ScreenName string
+	Snippet    string  // matched terms are wrapped in [ ] (empty for LIKE fallback results)
+	Score      float64 // bm25, lower is better
+}
+
+func IsSearchIndexAvailable() bool {
+	return searchIndexAvailable.Load()
+}
+
+func isNoFts5Err(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "no such module: fts5")
+}
+
+// creates the fts tables if needed, indexes cmdstrs that are missing from the index (all of them the first
+// time, or ones added by a build without fts5) and drops indexed output for lines that have since been deleted
+func InitSearchIndex(ctx context.Context) error {
+	txErr := sstore.WithTx(ctx, func(tx *sstore.TxWrap) error {
+		tx.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS history_fts USING fts5(cmdstr, historyid UNINDEXED)`)
+		tx.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS history_output_fts USING fts5(output, historyid UNINDEXED)`)
+		query := `INSERT INTO history_fts (cmdstr, historyid)
+                  SELECT cmdstr, historyid FROM history WHERE historyid NOT IN (SELECT historyid FROM history_fts)`
+		tx.Exec(query)
+		query = `DELETE FROM history_output_fts WHERE historyid NOT IN (SELECT historyid FROM history WHERE lineid <> '')`
+		tx.Exec(query)
+		return nil
+	})
+	if isNoFts5Err(txErr) {
+		log.Printf("[history] sqlite fts5 not available (build with -tags sqlite_fts5), history search will use LIKE\n")
+		return nil
+	}
+	if txErr != nil {
+		return txErr
+	}
+	searchIndexAvailable.Store(true)
+	return nil
+}
+
+// called inside InsertHistoryItem's transaction
+func indexHistoryCmdStr(tx *sstore.TxWrap, hitem *HistoryItemType) {
+	if !IsSearchIndexAvailable() {
+		return
+	}
+	tx.Exec(`INSERT INTO history_fts (cmdstr, historyid) VALUES (?, ?)`, hitem.CmdStr, hitem.HistoryId)
+}
+
+func deleteSearchIndexByIds(tx *sstore.TxWrap, historyIdsJsonArr string) {
+	if !IsSearchIndexAvailable() {
+		return
+	}
+	tx.Exec(`DELETE FROM history_fts WHERE historyid IN (SELECT value FROM json_each(?))`, historyIdsJsonArr)
+	tx.Exec(`DELETE FROM history_output_fts WHERE historyid IN (SELECT value FROM json_each(?))`, historyIdsJsonArr)
+}
+
+// sstore.HistoryLinesDeletedHandler.  the history items stay (and stay searchable by cmdstr), their indexed output
+// goes away with the line's ptyout
+func deleteSearchIndexForLines(tx *sstore.TxWrap, screenId string, lineIds []string) {
+	if !IsSearchIndexAvailable() {
+		return
+	}
+	if lineIds == nil {
+		query := `DELETE FROM history_output_fts WHERE historyid IN (SELECT historyid FROM history WHERE screenid = ? AND lineid <> '')`
+		tx.Exec(query, screenId)
+		return
+	}
+	query := `DELETE FROM history_output_fts
+	          WHERE historyid IN (SELECT historyid FROM history WHERE screenid = ? AND lineid IN (SELECT value FROM json_each(?)))`
+	tx.Exec(query, screenId, dbutil.QuickJsonArr(lineIds))
+}
+
+// strips ansi escapes and carriage-return redraws (progress bars) so only the final text is indexed
+func cleanOutputForIndex(data []byte) string {
+	str := prompts.StripAnsi(string(data))
+	str = strings.ReplaceAll(str, "\r\n", "\n")
+	lines := strings.Split(str, "\n")
+	for idx, line := range lines {
+		if crIdx := strings.LastIndexByte(line, '\r'); crIdx >= 0 {
+			lines[idx] = line[crIdx+1:]
+		}
+	}
+	str = strings.Join(lines, "\n")
+	str = strings.ToValidUTF8(str, "")
+	if len(str) > MaxIndexedOutputBytes {
+		str = prompts.TailTruncate(str, MaxIndexedOutputBytes)
+	}
+	return str
+}
+
+// (re)indexes the ptyout of a finished command.  a no-op when fts5 is not available
+func IndexCmdOutput(ctx context.Context, screenId string, lineId string) error {
+	if !IsSearchIndexAvailable() {
+		return nil
+	}
+	_, data, err := sstore.ReadFullPtyOutFile(ctx, screenId, lineId)
+	if err != nil {
+		return err
+	}
+	output := cleanOutputForIndex(data)
+	return sstore.WithTx(ctx, func(tx *sstore.TxWrap) error {
+		historyId := tx.GetString(`SELECT historyid FROM history WHERE screenid = ? AND lineid = ?`, screenId, lineId)
+		if historyId == "" {
+			return nil
+		}
+		tx.Exec(`DELETE FROM history_output_fts WHERE historyid = ?`, historyId)
+		if strings.TrimSpace(output) != "" {
+			tx.Exec(`INSERT INTO history_output_fts (output, historyid) VALUES (?, ?)`, output, historyId)
+		}
+		return nil
+	})
+}
+
+// runs IndexCmdOutput in its own goroutine (called when commands finish)
+func GoIndexCmdOutput(screenId string, lineId string) {
+	if !IsSearchIndexAvailable() {
+		return
+	}
+	go func() {
+		time.Sleep(IndexOutputDelay)
+		ctx, cancelFn := context.WithTimeout(context.Background(), IndexOutputTimeout)
+		defer cancelFn()
+		err := IndexCmdOutput(ctx, screenId, lineId)
+		if err != nil {
+			log.Printf("[history] error indexing output for %s/%s: %v\n", screenId, lineId, err)
+		}
+	}()
+}
+
+// converts user search text to an fts5 query.  each whitespace separated word becomes a quoted
+// prefix term (so fts5 operators and punctuation like "-" or "." are matched literally), terms are AND'ed
+func makeFtsMatchQuery(searchText string) string {
+	var terms []string
+	for _, word := range strings.Fields(searchText) {
+		terms = append(terms, "\""+strings.ReplaceAll(word, "\"", "\"\"")+"\"*")
+	}
+	return strings.Join(terms, " ")
+}
+
+func validateSearchOpts(opts *HistorySearchOpts) error {
+	if strings.TrimSpace(opts.Query) == "" {
+		return fmt.Errorf("empty search query")
+	}
+	for _, id := range []string{opts.SessionId, opts.ScreenId, opts.RemoteId} {
+		if id == "" {
+			continue
+		}
+		if _, err := uuid.Parse(id); err != nil {
+			return fmt.Errorf("malformed id '%s'", id)
+		}
+	}
+	if opts.MaxItems <= 0 {
+		opts.MaxItems = DefaultSearchResults
+	}
+	if opts.MaxItems > MaxSearchResults {
+		opts.MaxItems = MaxSearchResults
+	}
+	return nil
+}
+
+func makeSearchFilters(opts HistorySearchOpts) (string, []interface{}) {
+	var whereClause string
+	var args []interface{}
+	if opts.SessionId != "" {
+		whereClause += " AND h.sessionid = ?"
+		args = append(args, opts.SessionId)
+	}
+	if opts.ScreenId != "" {
+		whereClause += " AND h.screenid = ?"
+		args = append(args, opts.ScreenId)
+	}
+	if opts.RemoteId != "" {
+		whereClause += " AND h.remoteid = ?"
+		args = append(args, opts.RemoteId)
+	}
+	if opts.Output {
+		// output of deleted lines is gone
+		whereClause += " AND h.lineid <> ''"
+	}
+	filterClause, filterArgs := opts.Filter.makeWhereClause()
+	return whereClause + filterClause, append(args, filterArgs...)
+}
+
+// returns hits ordered by rank (best first).  without fts5, cmdstr searches fall back to a LIKE
+// query (most recent first, no snippets) and output searches return an error
+func SearchHistory(ctx context.Context, opts HistorySearchOpts) ([]*HistorySearchHit, error) {
+	err := validateSearchOpts(&opts)
+	if err != nil {
+		return nil, err
+	}
+	if !IsSearchIndexAvailable() {
+		if opts.Output {
+			return nil, fmt.Errorf("output search requires sqlite fts5 (wavesrv must be built with -tags sqlite_fts5)")
+		}
+		return searchHistoryLike(ctx, opts)
+	}
+	ftsTable := HistoryFtsTable
+	if opts.Output {
+		ftsTable = HistoryOutputFtsTable
+	}
+	filterClause, filterArgs := makeSearchFilters(opts)
+	return sstore.WithTxRtn(ctx, func(tx *sstore.TxWrap) ([]*HistorySearchHit, error) {
+		query := fmt.Sprintf(`SELECT %s, COALESCE(s.name, '') screenname, snippet(%s, 0, '[', ']', '...', %d) snippet, bm25(%s) score
+                              FROM %s f
+                              JOIN history h ON h.historyid = f.historyid
+                              LEFT JOIN screen s ON s.screenid = h.screenid
+                              WHERE %s MATCH ?%s
+                              ORDER BY score
+                              LIMIT ?`, HistoryCols, ftsTable, SearchSnippetTokens, ftsTable, ftsTable, ftsTable, filterClause)
+		var args []interface{}
+		args = append(args, makeFtsMatchQuery(opts.Query))
+		args = append(args, filterArgs...)
+		args = append(args, opts.MaxItems)
+		marr := tx.SelectMaps(query, args...)
+		rtn := make([]*HistorySearchHit, 0, len(marr))
+		for _, m := range marr {
+			hit := &HistorySearchHit{Item: dbutil.FromMap[*HistoryItemType](m)}
+			dbutil.QuickSetStr(&hit.ScreenName, m, "screenname")
+			dbutil.QuickSetStr(&hit.Snippet, m, "snippet")
+			if score, ok := m["score"].(float64); ok {
+				hit.Score = score
+			}
+			rtn = append(rtn, hit)
+		}
+		return rtn, nil
+	})
+}
+
+func searchHistoryLike(ctx context.Context, opts HistorySearchOpts) ([]*HistorySearchHit, error) {
+	filterClause, filterArgs := makeSearchFilters(opts)
+	return sstore.WithTxRtn(ctx, func(tx *sstore.TxWrap) ([]*HistorySearchHit, error) {
+		query := fmt.Sprintf(`SELECT %s, COALESCE(s.name, '') screenname
+                              FROM history h
+                              LEFT JOIN screen s ON s.screenid = h.screenid
+                              WHERE 1%s%s
+                              ORDER BY h.ts DESC, h.historyid DESC
+                              LIMIT ?`, HistoryCols, filterClause, strings.Repeat(" AND h.cmdstr LIKE ? ESCAPE '\\'", len(strings.Fields(opts.Query))))
+		args := filterArgs
+		for _, word := range strings.Fields(opts.Query) {
+			args = append(args, "%"+escapeLikeArg(word)+"%")
+		}
+		args = append(args, opts.MaxItems)
+		marr := tx.SelectMaps(query, args...)
+		rtn := make([]*HistorySearchHit, 0, len(marr))
+		for _, m := range marr {
+			hit := &HistorySearchHit{Item: dbutil.FromMap[*HistoryItemType](m)}
+			dbutil.QuickSetStr(&hit.ScreenName, m, "screenname")
+			rtn = append(rtn, hit)
+		}
+		return rtn, nil
+	})
+}
@@ -0,0 +1,48 @@
+package history
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMakeFtsMatchQuery(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{"git", `"git"*`},
+		{"  git   commit ", `"git"* "commit"*`},
+		{"foo-bar OR", `"foo-bar"* "OR"*`},
+		{`say "hi"`, `"say"* """hi"""*`},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if rtn := makeFtsMatchQuery(test.text); rtn != test.expected {
+			t.Errorf("%q: got %q, expected %q", test.text, rtn, test.expected)
+		}
+	}
+}
+
+func TestCleanOutputForIndex(t *testing.T) {
+	output := cleanOutputForIndex([]byte("\x1b[1;31merror:\x1b[0m bad\r\nprogress 10%\rprogress 100%\r\ndone\n"))
+	if output != "error: bad\nprogress 100%\ndone\n" {
+		t.Errorf("bad cleaned output %q", output)
+	}
+	output = cleanOutputForIndex([]byte(strings.Repeat("line\n", MaxIndexedOutputBytes)))
+	if len(output) > MaxIndexedOutputBytes+100 || !strings.HasSuffix(output, "line\n") {
+		t.Errorf("output not truncated (len %d)", len(output))
+	}
+}
+
+func TestSearchOptsValidation(t *testing.T) {
+	opts := HistorySearchOpts{Query: "ls", MaxItems: MaxSearchResults + 1}
+	if err := validateSearchOpts(&opts); err != nil || opts.MaxItems != MaxSearchResults {
+		t.Errorf("maxitems should be capped: %v %d", err, opts.MaxItems)
+	}
+	if err := validateSearchOpts(&HistorySearchOpts{Query: "  "}); err == nil {
+		t.Errorf("expected error for empty query")
+	}
+	if err := validateSearchOpts(&HistorySearchOpts{Query: "ls", ScreenId: "x' OR 1"}); err == nil {
+		t.Errorf("expected error for malformed screenid")
+	}
+}
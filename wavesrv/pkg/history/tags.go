@@ -0,0 +1,199 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package history
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/abhishek944/waveterm/wavesrv/pkg/dbutil"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+	"github.com/google/uuid"
+)
+
+// max number of history items a single tag/untag/note command can change
+const MaxHistoryNumItems = 1000
+
+const MaxHistoryNoteLen = 1000
+
+// historynums are numbered per scope (oldest first), like the historynum column returned by history queries:
+// "12" is in the current screen, "s12" in the current session, "g12" in all history
+var historyNumRe = regexp.MustCompile(`^([sg]?)(\d+)(?:-([sg]?)(\d+))?$`)
+
+type historyNumArg struct {
+	HistoryId string // set for a historyid (uuid) arg
+	Prefix    string
+	Start     int
+	End       int
+}
+
+// parses a historynum ("12", "s12", "g12"), a range with one prefix ("10-14", "g10-g14") or a historyid
+func parseHistoryNumArg(arg string) (historyNumArg, error) {
+	if _, err := uuid.Parse(arg); err == nil {
+		return historyNumArg{HistoryId: arg}, nil
+	}
+	m := historyNumRe.FindStringSubmatch(arg)
+	if m == nil {
+		return historyNumArg{}, fmt.Errorf("invalid historynum %q (use 12, s12, g12, a range like 10-14, or a historyid)", arg)
+	}
+	start, _ := strconv.Atoi(m[2])
+	end := start
+	if m[4] != "" {
+		if m[3] != "" && m[3] != m[1] {
+			return historyNumArg{}, fmt.Errorf("invalid historynum range %q (both ends must have the same prefix)", arg)
+		}
+		end, _ = strconv.Atoi(m[4])
+	}
+	if start <= 0 || end < start {
+		return historyNumArg{}, fmt.Errorf("invalid historynum %q", arg)
+	}
+	if end-start+1 > MaxHistoryNumItems {
+		return historyNumArg{}, fmt.Errorf("historynum range %q is too large (max %d items)", arg, MaxHistoryNumItems)
+	}
+	return historyNumArg{Prefix: m[1], Start: start, End: end}, nil
+}
+
+func (hn historyNumArg) numItems() int {
+	if hn.HistoryId != "" {
+		return 1
+	}
+	return hn.End - hn.Start + 1
+}
+
+func (hn historyNumArg) scopeOpts(sessionId string, screenId string) (HistoryQueryOpts, error) {
+	switch hn.Prefix {
+	case "g":
+		return HistoryQueryOpts{}, nil
+	case "s":
+		if sessionId == "" {
+			return HistoryQueryOpts{}, fmt.Errorf("no current session for historynum s%d", hn.Start)
+		}
+		return HistoryQueryOpts{SessionId: sessionId}, nil
+	default:
+		if sessionId == "" || screenId == "" {
+			return HistoryQueryOpts{}, fmt.Errorf("no current screen for historynum %d", hn.Start)
+		}
+		return HistoryQueryOpts{SessionId: sessionId, ScreenId: screenId}, nil
+	}
+}
+
+// resolves historynum args (see parseHistoryNumArg) to historyids, in the given order without duplicates.
+// it is an error if any of the items does not exist
+func ResolveHistoryNums(ctx context.Context, sessionId string, screenId string, args []string) ([]string, error) {
+	var hnArgs []historyNumArg
+	numItems := 0
+	for _, arg := range args {
+		hn, err := parseHistoryNumArg(arg)
+		if err != nil {
+			return nil, err
+		}
+		numItems += hn.numItems()
+		if numItems > MaxHistoryNumItems {
+			return nil, fmt.Errorf("too many history items (max %d)", MaxHistoryNumItems)
+		}
+		hnArgs = append(hnArgs, hn)
+	}
+	return sstore.WithTxRtn(ctx, func(tx *sstore.TxWrap) ([]string, error) {
+		var rtn []string
+		seen := make(map[string]bool)
+		for idx, hn := range hnArgs {
+			var ids []string
+			if hn.HistoryId != "" {
+				if tx.Exists(`SELECT historyid FROM history WHERE historyid = ?`, hn.HistoryId) {
+					ids = []string{hn.HistoryId}
+				}
+			} else {
+				opts, err := hn.scopeOpts(sessionId, screenId)
+				if err != nil {
+					return nil, err
+				}
+				whereClause, queryArgs, _, err := makeHistoryScopeClause(opts)
+				if err != nil {
+					return nil, err
+				}
+				query := fmt.Sprintf(`SELECT historyid FROM (SELECT h.historyid, row_number() OVER (ORDER BY h.ts, h.historyid) hnum FROM history h %s) WHERE hnum BETWEEN ? AND ? ORDER BY hnum`, whereClause)
+				queryArgs = append(queryArgs, hn.Start, hn.End)
+				ids = tx.SelectStrings(query, queryArgs...)
+			}
+			if len(ids) != hn.numItems() {
+				return nil, fmt.Errorf("history item %q not found", args[idx])
+			}
+			for _, id := range ids {
+				if !seen[id] {
+					seen[id] = true
+					rtn = append(rtn, id)
+				}
+			}
+		}
+		return rtn, nil
+	})
+}
+
+// adds and removes tags (removeAll clears all tags first) on the given history items, returns the number of items changed
+func UpdateHistoryTags(ctx context.Context, historyIds []string, addTags []string, removeTags []string, removeAll bool) (int, error) {
+	return sstore.WithTxRtn(ctx, func(tx *sstore.TxWrap) (int, error) {
+		query := `SELECT historyid, tags FROM history WHERE historyid IN (SELECT value FROM json_each(?))`
+		marr := tx.SelectMaps(query, dbutil.QuickJsonArr(historyIds))
+		numChanged := 0
+		for _, m := range marr {
+			var hitem HistoryItemType
+			dbutil.QuickSetStr(&hitem.HistoryId, m, "historyid")
+			dbutil.QuickSetJson(&hitem.Tags, m, "tags")
+			newTags := make(map[string]bool)
+			if !removeAll {
+				for tag, val := range hitem.Tags {
+					if val {
+						newTags[tag] = true
+					}
+				}
+			}
+			for _, tag := range removeTags {
+				delete(newTags, tag)
+			}
+			for _, tag := range addTags {
+				newTags[tag] = true
+			}
+			if tagsEqual(hitem.Tags, newTags) {
+				continue
+			}
+			query = `UPDATE history SET tags = ? WHERE historyid = ?`
+			tx.Exec(query, dbutil.QuickJson(newTags), hitem.HistoryId)
+			numChanged++
+		}
+		return numChanged, nil
+	})
+}
+
+func tagsEqual(oldTags map[string]bool, newTags map[string]bool) bool {
+	numOld := 0
+	for tag, val := range oldTags {
+		if !val {
+			continue
+		}
+		numOld++
+		if !newTags[tag] {
+			return false
+		}
+	}
+	return numOld == len(newTags)
+}
+
+// sets (or with an empty note, clears) the note on the given history items, returns the number of items changed
+func SetHistoryNote(ctx context.Context, historyIds []string, note string) (int, error) {
+	if len(note) > MaxHistoryNoteLen {
+		return 0, fmt.Errorf("note is too long (max %d bytes)", MaxHistoryNoteLen)
+	}
+	return sstore.WithTxRtn(ctx, func(tx *sstore.TxWrap) (int, error) {
+		query := `UPDATE history SET note = ? WHERE historyid IN (SELECT value FROM json_each(?)) AND note <> ?`
+		result := tx.Exec(query, note, dbutil.QuickJsonArr(historyIds), note)
+		if result == nil {
+			// tx.Err is set and returned by WithTxRtn
+			return 0, nil
+		}
+		numChanged, _ := result.RowsAffected()
+		return int(numChanged), nil
+	})
+}
@@ -0,0 +1,43 @@
+package history
+
+import (
+	"testing"
+)
+
+func TestParseHistoryNumArg(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want historyNumArg
+	}{
+		{"12", historyNumArg{Prefix: "", Start: 12, End: 12}},
+		{"s3", historyNumArg{Prefix: "s", Start: 3, End: 3}},
+		{"g10-14", historyNumArg{Prefix: "g", Start: 10, End: 14}},
+		{"g10-g14", historyNumArg{Prefix: "g", Start: 10, End: 14}},
+		{"5-5", historyNumArg{Prefix: "", Start: 5, End: 5}},
+		{"9a3bd7c2-5b9f-4c4f-8a53-0e4e0b0c3c11", historyNumArg{HistoryId: "9a3bd7c2-5b9f-4c4f-8a53-0e4e0b0c3c11"}},
+	}
+	for _, test := range tests {
+		hn, err := parseHistoryNumArg(test.arg)
+		if err != nil {
+			t.Errorf("%q: error: %v", test.arg, err)
+			continue
+		}
+		if hn != test.want {
+			t.Errorf("%q: got %+v, want %+v", test.arg, hn, test.want)
+		}
+	}
+	for _, arg := range []string{"", "0", "x12", "g10-s14", "14-10", "1-2000", "12,13", "-3"} {
+		if _, err := parseHistoryNumArg(arg); err == nil {
+			t.Errorf("%q: expected error", arg)
+		}
+	}
+}
+
+func TestTagsEqual(t *testing.T) {
+	if !tagsEqual(nil, map[string]bool{}) || !tagsEqual(map[string]bool{"a": true, "b": false}, map[string]bool{"a": true}) {
+		t.Errorf("tags should be equal")
+	}
+	if tagsEqual(map[string]bool{"a": true}, map[string]bool{"a": true, "b": true}) || tagsEqual(map[string]bool{"a": true}, map[string]bool{}) {
+		t.Errorf("tags should not be equal")
+	}
+}
@@ -35,7 +35,7 @@ func (p *PlaybookType) FromMap(m map[string]interface{}) bool {
 	dbutil.QuickSetStr(&p.PlaybookId, m, "playbookid")
 	dbutil.QuickSetStr(&p.PlaybookName, m, "playbookname")
 	dbutil.QuickSetStr(&p.Description, m, "description")
-	dbutil.QuickSetJsonArr(&p.Entries, m, "entries")
+	dbutil.QuickSetJsonArr(&p.EntryIds, m, "entryids")
 	return true
 }
 
@@ -86,7 +86,7 @@ type PlaybookEntry struct {
 
 func CreatePlaybook(ctx context.Context, name string) (*PlaybookType, error) {
 	return sstore.WithTxRtn(ctx, func(tx *sstore.TxWrap) (*PlaybookType, error) {
-		query := `SELECT playbookid FROM playbook WHERE name = ?`
+		query := `SELECT playbookid FROM playbook WHERE playbookname = ?`
 		if tx.Exists(query, name) {
 			return nil, fmt.Errorf("playbook %q already exists", name)
 		}
@@ -95,7 +95,7 @@ func CreatePlaybook(ctx context.Context, name string) (*PlaybookType, error) {
 		rtn.PlaybookName = name
 		query = `INSERT INTO playbook ( playbookid, playbookname, description, entryids)
                                VALUES (:playbookid,:playbookname,:description,:entryids)`
-		tx.Exec(query, rtn.ToMap())
+		tx.NamedExec(query, rtn.ToMap())
 		return rtn, nil
 	})
 }
@@ -121,7 +121,7 @@ func AddPlaybookEntry(ctx context.Context, entry *PlaybookEntry) error {
 		}
 		query = `INSERT INTO playbook_entry ( entryid, playbookid, description, alias, cmdstr, createdts, updatedts)
                                      VALUES (:entryid,:playbookid,:description,:alias,:cmdstr,:createdts,:updatedts)`
-		tx.Exec(query, entry)
+		tx.NamedExec(query, entry)
 		playbook.EntryIds = append(playbook.EntryIds, entry.EntryId)
 		query = `UPDATE playbook SET entryids = ? WHERE playbookid = ?`
 		tx.Exec(query, dbutil.QuickJsonArr(playbook.EntryIds), entry.PlaybookId)
@@ -160,3 +160,83 @@ func GetPlaybookById(ctx context.Context, playbookId string) (*PlaybookType, err
 		return rtn, nil
 	})
 }
+
+func GetAllPlaybooks(ctx context.Context) ([]*PlaybookType, error) {
+	return sstore.WithTxRtn(ctx, func(tx *sstore.TxWrap) ([]*PlaybookType, error) {
+		query := `SELECT * FROM playbook ORDER BY playbookname`
+		rtn := dbutil.SelectMapsGen[*PlaybookType](tx, query)
+		return rtn, nil
+	})
+}
+
+// resolves a playbook by name, full id, or 8-character partial id
+func GetPlaybookIdByArg(ctx context.Context, playbookArg string) (string, error) {
+	return sstore.WithTxRtn(ctx, func(tx *sstore.TxWrap) (string, error) {
+		query := `SELECT playbookid FROM playbook WHERE playbookname = ?`
+		rtnId := tx.GetString(query, playbookArg)
+		if rtnId != "" {
+			return rtnId, nil
+		}
+		if len(playbookArg) == 8 {
+			query = `SELECT playbookid FROM playbook WHERE playbookid LIKE (? || '%')`
+			return tx.GetString(query, playbookArg), nil
+		}
+		query = `SELECT playbookid FROM playbook WHERE playbookid = ?`
+		return tx.GetString(query, playbookArg), nil
+	})
+}
+
+// inserts a new playbook along with all of its entries (p.Entries), sets p.EntryIds
+func InsertPlaybook(ctx context.Context, p *PlaybookType) error {
+	if p.PlaybookId == "" {
+		return fmt.Errorf("invalid empty playbook id")
+	}
+	return sstore.WithTx(ctx, func(tx *sstore.TxWrap) error {
+		query := `SELECT playbookid FROM playbook WHERE playbookname = ?`
+		if tx.Exists(query, p.PlaybookName) {
+			return fmt.Errorf("playbook %q already exists", p.PlaybookName)
+		}
+		p.EntryIds = nil
+		for _, entry := range p.Entries {
+			entry.PlaybookId = p.PlaybookId
+			query = `INSERT INTO playbook_entry ( entryid, playbookid, description, alias, cmdstr, createdts, updatedts)
+                                         VALUES (:entryid,:playbookid,:description,:alias,:cmdstr,:createdts,:updatedts)`
+			tx.NamedExec(query, entry)
+			p.EntryIds = append(p.EntryIds, entry.EntryId)
+		}
+		query = `INSERT INTO playbook ( playbookid, playbookname, description, entryids)
+                               VALUES (:playbookid,:playbookname,:description,:entryids)`
+		tx.NamedExec(query, p.ToMap())
+		return nil
+	})
+}
+
+// removes all existing entries from the playbook and replaces them with newEntries
+func ReplacePlaybookEntries(ctx context.Context, playbookId string, description string, newEntries []*PlaybookEntry) error {
+	return sstore.WithTx(ctx, func(tx *sstore.TxWrap) error {
+		playbook := selectPlaybook(tx, playbookId)
+		if playbook == nil {
+			return fmt.Errorf("cannot replace entries, playbook does not exist")
+		}
+		query := `DELETE FROM playbook_entry WHERE playbookid = ?`
+		tx.Exec(query, playbookId)
+		var entryIds []string
+		for _, entry := range newEntries {
+			entry.PlaybookId = playbookId
+			query = `INSERT INTO playbook_entry ( entryid, playbookid, description, alias, cmdstr, createdts, updatedts)
+                                         VALUES (:entryid,:playbookid,:description,:alias,:cmdstr,:createdts,:updatedts)`
+			tx.NamedExec(query, entry)
+			entryIds = append(entryIds, entry.EntryId)
+		}
+		query = `UPDATE playbook SET entryids = ?, description = ? WHERE playbookid = ?`
+		tx.Exec(query, dbutil.QuickJsonArr(entryIds), description, playbookId)
+		return nil
+	})
+}
+
+func GetPlaybookIdByName(ctx context.Context, name string) (string, error) {
+	return sstore.WithTxRtn(ctx, func(tx *sstore.TxWrap) (string, error) {
+		query := `SELECT playbookid FROM playbook WHERE playbookname = ?`
+		return tx.GetString(query, name), nil
+	})
+}
@@ -0,0 +1,170 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package playbook
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/abhishek944/waveterm/waveshell/pkg/utilfn"
+)
+
+const (
+	TemplateVarTypeStr  = "str"
+	TemplateVarTypeInt  = "int"
+	TemplateVarTypeBool = "bool"
+)
+
+const templateDefaultPrefix = "default="
+
+// matches {{name}}, {{name:type}}, {{name:default=val}}, {{name:type:default=val}}.  only identifier names are
+// placeholders, so go/helm/jq templates ({{.Field}}, {{ json . }}, {{- end }}) are left alone.  a placeholder
+// preceded by a backslash (\{{name}}) is escaped, it expands to the literal {{name}} (for {{end}} or {{else}})
+var templateVarRe = regexp.MustCompile(`(\\?)\{\{\s*([A-Za-z_][A-Za-z0-9_]*)((?::[^}]*)?)\s*\}\}`)
+
+type TemplateVar struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Default    string `json:"default,omitempty"`
+	HasDefault bool   `json:"hasdefault,omitempty"`
+}
+
+func parseTemplateVar(name string, optStr string) (*TemplateVar, error) {
+	rtn := &TemplateVar{Name: name, Type: TemplateVarTypeStr}
+	optStr = strings.TrimPrefix(optStr, ":")
+	for optStr != "" {
+		if strings.HasPrefix(optStr, templateDefaultPrefix) {
+			// default consumes the rest of the placeholder (so it can contain colons)
+			rtn.Default = optStr[len(templateDefaultPrefix):]
+			rtn.HasDefault = true
+			break
+		}
+		opt, rest, _ := strings.Cut(optStr, ":")
+		switch opt {
+		case TemplateVarTypeStr, TemplateVarTypeInt, TemplateVarTypeBool:
+			rtn.Type = opt
+		default:
+			return nil, fmt.Errorf("invalid option %q for template variable %q", opt, name)
+		}
+		optStr = rest
+	}
+	if rtn.HasDefault {
+		normDefault, err := rtn.NormalizeValue(rtn.Default)
+		if err != nil {
+			return nil, fmt.Errorf("invalid default for template variable %q: %v", name, err)
+		}
+		rtn.Default = normDefault
+	}
+	return rtn, nil
+}
+
+// checks val against the variable's type, returns the canonical form of the value
+func (tv *TemplateVar) NormalizeValue(val string) (string, error) {
+	switch tv.Type {
+	case TemplateVarTypeInt:
+		ival, err := strconv.ParseInt(strings.TrimSpace(val), 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("%q is not an integer", val)
+		}
+		return strconv.FormatInt(ival, 10), nil
+	case TemplateVarTypeBool:
+		bval, err := strconv.ParseBool(strings.TrimSpace(val))
+		if err != nil {
+			return "", fmt.Errorf("%q is not a boolean", val)
+		}
+		return strconv.FormatBool(bval), nil
+	default:
+		return val, nil
+	}
+}
+
+// returns the template variables in cmdStr in order of first appearance (no duplicates)
+func ParseTemplateVars(cmdStr string) ([]*TemplateVar, error) {
+	var rtn []*TemplateVar
+	seen := make(map[string]bool)
+	for _, m := range templateVarRe.FindAllStringSubmatch(cmdStr, -1) {
+		if m[1] != "" {
+			// escaped
+			continue
+		}
+		tv, err := parseTemplateVar(m[2], m[3])
+		if err != nil {
+			return nil, err
+		}
+		if seen[tv.Name] {
+			continue
+		}
+		seen[tv.Name] = true
+		rtn = append(rtn, tv)
+	}
+	return rtn, nil
+}
+
+// returns the template variables across all entries (first declaration wins)
+func (p *PlaybookType) GetTemplateVars() ([]*TemplateVar, error) {
+	var rtn []*TemplateVar
+	seen := make(map[string]bool)
+	for idx, entry := range p.Entries {
+		tvars, err := ParseTemplateVars(entry.CmdStr)
+		if err != nil {
+			return nil, fmt.Errorf("entry #%d: %v", idx+1, err)
+		}
+		for _, tv := range tvars {
+			if seen[tv.Name] {
+				continue
+			}
+			seen[tv.Name] = true
+			rtn = append(rtn, tv)
+		}
+	}
+	return rtn, nil
+}
+
+func shellQuoteTemplateValue(val string) string {
+	if val == "" {
+		return "''"
+	}
+	// each single quote expands to 5 chars, so this maxLen guarantees no truncation
+	return utilfn.ShellQuote(val, false, len(val)*5+2)
+}
+
+// replaces every placeholder in cmdStr with its shell-quoted value from vals
+// falls back to the placeholder's default, errors if neither is available
+func ExpandTemplate(cmdStr string, vals map[string]string) (string, error) {
+	var expandErr error
+	rtn := templateVarRe.ReplaceAllStringFunc(cmdStr, func(placeholder string) string {
+		if expandErr != nil {
+			return placeholder
+		}
+		m := templateVarRe.FindStringSubmatch(placeholder)
+		if m[1] != "" {
+			return placeholder[len(m[1]):]
+		}
+		tv, err := parseTemplateVar(m[2], m[3])
+		if err != nil {
+			expandErr = err
+			return placeholder
+		}
+		val, found := vals[tv.Name]
+		if !found {
+			if !tv.HasDefault {
+				expandErr = fmt.Errorf("no value for template variable %q", tv.Name)
+				return placeholder
+			}
+			val = tv.Default
+		}
+		val, err = tv.NormalizeValue(val)
+		if err != nil {
+			expandErr = fmt.Errorf("invalid value for template variable %q: %v", tv.Name, err)
+			return placeholder
+		}
+		return shellQuoteTemplateValue(val)
+	})
+	if expandErr != nil {
+		return "", expandErr
+	}
+	return rtn, nil
+}
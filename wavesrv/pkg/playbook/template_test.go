@@ -0,0 +1,86 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package playbook
+
+import (
+	"testing"
+)
+
+func TestParseTemplateVars(t *testing.T) {
+	tvars, err := ParseTemplateVars("ssh {{host}} git checkout {{branch:default=main}} && sleep {{secs:int:default=5}} {{host}}")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tvars) != 3 {
+		t.Fatalf("expected 3 vars, got %d", len(tvars))
+	}
+	if tvars[0].Name != "host" || tvars[0].HasDefault || tvars[0].Type != TemplateVarTypeStr {
+		t.Errorf("bad host var: %#v", tvars[0])
+	}
+	if tvars[1].Name != "branch" || !tvars[1].HasDefault || tvars[1].Default != "main" {
+		t.Errorf("bad branch var: %#v", tvars[1])
+	}
+	if tvars[2].Name != "secs" || tvars[2].Type != TemplateVarTypeInt || tvars[2].Default != "5" {
+		t.Errorf("bad secs var: %#v", tvars[2])
+	}
+	badStrs := []string{"echo {{x:float}}", "echo {{n:int:default=abc}}"}
+	for _, str := range badStrs {
+		_, err := ParseTemplateVars(str)
+		if err == nil {
+			t.Errorf("expected error parsing %q", str)
+		}
+	}
+	// only identifier names are placeholders, other templates (and escaped placeholders) are not variables
+	noVarStrs := []string{
+		"docker inspect --format '{{.State.Running}}' web",
+		"docker ps --format '{{ json . }}'",
+		"kubectl get pods -o go-template='{{range .items}}{{.metadata.name}}\\{{end}}'",
+		"helm template x --set a='{{- .Values.x }}'",
+		"echo {{1host}} {{}}",
+		"echo \\{{name}}",
+	}
+	for _, str := range noVarStrs {
+		tvars, err := ParseTemplateVars(str)
+		if err != nil || len(tvars) != 0 {
+			t.Errorf("%q: expected no vars, got %v %v", str, tvars, err)
+		}
+	}
+}
+
+func testExpand(t *testing.T, cmdStr string, vals map[string]string, expected string) {
+	rtn, err := ExpandTemplate(cmdStr, vals)
+	if err != nil {
+		t.Errorf("expand %q: unexpected error: %v", cmdStr, err)
+		return
+	}
+	if rtn != expected {
+		t.Errorf("expand %q: got [%s] expected [%s]", cmdStr, rtn, expected)
+	}
+}
+
+func TestExpandTemplate(t *testing.T) {
+	testExpand(t, "echo {{name}}", map[string]string{"name": "mike"}, "echo mike")
+	testExpand(t, "echo {{name}}", map[string]string{"name": "a b"}, "echo 'a b'")
+	testExpand(t, "echo {{name}}", map[string]string{"name": "x; rm -rf /"}, "echo 'x; rm -rf /'")
+	testExpand(t, "echo {{name}}", map[string]string{"name": "it's"}, `echo 'it'"'"'s'`)
+	testExpand(t, "echo {{name}}", map[string]string{"name": ""}, "echo ''")
+	testExpand(t, "git checkout {{branch:default=main}}", nil, "git checkout main")
+	testExpand(t, "curl {{url:default=http://localhost:8080}}", nil, "curl http://localhost:8080")
+	testExpand(t, "run --verbose={{v:bool}}", map[string]string{"v": "1"}, "run --verbose=true")
+	long := "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa bbbb"
+	testExpand(t, "echo {{x}}", map[string]string{"x": long}, "echo '"+long+"'")
+
+	testExpand(t, "docker inspect --format '{{.State.Running}}' {{name}}", map[string]string{"name": "web"}, "docker inspect --format '{{.State.Running}}' web")
+	testExpand(t, "kubectl get pods -o go-template='{{range .items}}{{.metadata.name}} \\{{end}}' -n {{ns}}", map[string]string{"ns": "prod"}, "kubectl get pods -o go-template='{{range .items}}{{.metadata.name}} {{end}}' -n prod")
+	testExpand(t, "echo \\{{name}} {{name}}", map[string]string{"name": "mike"}, "echo {{name}} mike")
+
+	_, err := ExpandTemplate("echo {{name}}", nil)
+	if err == nil {
+		t.Errorf("expected error for missing value")
+	}
+	_, err = ExpandTemplate("sleep {{n:int}}", map[string]string{"n": "5; reboot"})
+	if err == nil {
+		t.Errorf("expected error for invalid int value")
+	}
+}
@@ -50,4 +50,8 @@ const AgentSystemPrompt = `You are an expert command-line assistant with deep kn
 - Suggest diagnostic commands when troubleshooting
 - Provide fallback options if the primary solution fails
 
-Remember: You are a helpful assistant focused on empowering users to work effectively with command-line tools while maintaining system safety and best practices.`
\ No newline at end of file
+Remember: You are a helpful assistant focused on empowering users to work effectively with command-line tools while maintaining system safety and best practices.`
+const AgentToolsPrompt = `You can inspect and act on the user's terminal session with the following tools: run_command (runs a shell command on the user's current connection), read_file and list_dir.
+Every tool call is shown to the user and only runs after the user explicitly approves it.  If a call is denied, do not retry the same call, explain what you wanted to do instead.
+Prefer read_file and list_dir over shell commands for inspecting files.  Never run destructive commands (deleting files, changing system configuration, etc.) unless the user asked for it.
+Tool output may be truncated.  When you have enough information, stop calling tools and answer the user.`
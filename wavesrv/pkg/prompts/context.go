@@ -0,0 +1,94 @@
+package prompts
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var ansiEscapeRe = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+
+type AgentRecentCmd struct {
+	CmdStr   string
+	ExitCode *int64
+}
+
+// terminal context attached to /agent prompts (all fields optional)
+type AgentContextType struct {
+	Cwd        string
+	ShellType  string
+	OsType     string
+	Env        map[string]string
+	RecentCmds []AgentRecentCmd
+	LastCmdStr string
+	LastOutput string
+}
+
+func StripAnsi(str string) string {
+	return ansiEscapeRe.ReplaceAllString(str, "")
+}
+
+// keeps the last maxBytes of str (on a line boundary when possible)
+func TailTruncate(str string, maxBytes int) string {
+	if maxBytes <= 0 || len(str) <= maxBytes {
+		return str
+	}
+	str = str[len(str)-maxBytes:]
+	if nlIdx := strings.IndexByte(str, '\n'); nlIdx >= 0 && nlIdx < len(str)-1 {
+		str = str[nlIdx+1:]
+	}
+	return "...(truncated)\n" + str
+}
+
+// all free text goes through the redactor, env is expected to be redacted already (see Redactor.RedactEnv)
+func (actx *AgentContextType) Format(redactor *Redactor) string {
+	var buf strings.Builder
+	buf.WriteString("The following is context about the user's current terminal session.  Use it when it is relevant to the user's request.\n")
+	if actx.ShellType != "" {
+		buf.WriteString(fmt.Sprintf("\nShell: %s\n", actx.ShellType))
+	}
+	if actx.OsType != "" {
+		buf.WriteString(fmt.Sprintf("OS: %s\n", actx.OsType))
+	}
+	if actx.Cwd != "" {
+		buf.WriteString(fmt.Sprintf("Current working directory: %s\n", redactor.RedactText(actx.Cwd)))
+	}
+	if len(actx.Env) > 0 {
+		buf.WriteString("\nEnvironment variables:\n```\n")
+		var names []string
+		for name := range actx.Env {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			buf.WriteString(fmt.Sprintf("%s=%s\n", name, actx.Env[name]))
+		}
+		buf.WriteString("```\n")
+	}
+	if len(actx.RecentCmds) > 0 {
+		buf.WriteString("\nRecent commands (oldest first):\n```\n")
+		for _, rcmd := range actx.RecentCmds {
+			exitStr := "?"
+			if rcmd.ExitCode != nil {
+				exitStr = fmt.Sprintf("%d", *rcmd.ExitCode)
+			}
+			buf.WriteString(fmt.Sprintf("[exit %s] %s\n", exitStr, redactor.RedactText(rcmd.CmdStr)))
+		}
+		buf.WriteString("```\n")
+	}
+	if actx.LastOutput != "" {
+		if actx.LastCmdStr != "" {
+			buf.WriteString(fmt.Sprintf("\nOutput of the selected command `%s`:\n", redactor.RedactText(actx.LastCmdStr)))
+		} else {
+			buf.WriteString("\nOutput of the selected command:\n")
+		}
+		buf.WriteString("```\n")
+		buf.WriteString(redactor.RedactText(actx.LastOutput))
+		if !strings.HasSuffix(actx.LastOutput, "\n") {
+			buf.WriteString("\n")
+		}
+		buf.WriteString("```\n")
+	}
+	return buf.String()
+}
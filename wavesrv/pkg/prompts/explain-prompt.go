@@ -0,0 +1,80 @@
+package prompts
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const ExplainFailedCmdPrompt = `You are an expert command-line assistant.  The user ran a shell command that failed (non-zero exit code).
+Using the command, exit code, working directory and the end of its output, explain briefly why it failed and how to fix it.
+Respond only with a JSON object (no markdown fences) in this form:
+{"explanation": "<2-4 sentences on the cause of the failure>", "fixes": ["<shell command>", ...]}
+"fixes" lists up to 3 commands the user could run to fix the problem (most likely first), use an empty list if no command would help.  Never suggest destructive commands.`
+
+const MaxExplainFixes = 3
+
+var jsonFenceRe = regexp.MustCompile("(?s)^```(?:json)?\\s*(.*?)\\s*```$")
+
+// context sent to the model for /line:explain
+type FailedCmdContextType struct {
+	CmdStr    string
+	ExitCode  int
+	Cwd       string
+	ShellType string
+	OsType    string
+	Output    string
+}
+
+func (fctx *FailedCmdContextType) Format(redactor *Redactor) string {
+	var buf strings.Builder
+	buf.WriteString(fmt.Sprintf("Command: %s\n", redactor.RedactText(fctx.CmdStr)))
+	buf.WriteString(fmt.Sprintf("Exit code: %d\n", fctx.ExitCode))
+	if fctx.Cwd != "" {
+		buf.WriteString(fmt.Sprintf("Working directory: %s\n", redactor.RedactText(fctx.Cwd)))
+	}
+	if fctx.ShellType != "" {
+		buf.WriteString(fmt.Sprintf("Shell: %s\n", fctx.ShellType))
+	}
+	if fctx.OsType != "" {
+		buf.WriteString(fmt.Sprintf("OS: %s\n", fctx.OsType))
+	}
+	if strings.TrimSpace(fctx.Output) == "" {
+		buf.WriteString("The command produced no output.\n")
+	} else {
+		buf.WriteString("Output (tail):\n```\n")
+		buf.WriteString(strings.TrimRight(redactor.RedactText(fctx.Output), "\n"))
+		buf.WriteString("\n```\n")
+	}
+	return buf.String()
+}
+
+type explainResponse struct {
+	Explanation string   `json:"explanation"`
+	Fixes       []string `json:"fixes"`
+}
+
+// returns the explanation and fix commands.  if the model did not answer with JSON, the whole answer is the explanation
+func ParseExplainResponse(text string) (string, []string) {
+	text = strings.TrimSpace(text)
+	if m := jsonFenceRe.FindStringSubmatch(text); m != nil {
+		text = m[1]
+	}
+	var resp explainResponse
+	if !strings.HasPrefix(text, "{") || json.Unmarshal([]byte(text), &resp) != nil || resp.Explanation == "" {
+		return text, nil
+	}
+	var fixes []string
+	for _, fix := range resp.Fixes {
+		fix = strings.TrimSpace(fix)
+		if fix == "" {
+			continue
+		}
+		fixes = append(fixes, fix)
+		if len(fixes) == MaxExplainFixes {
+			break
+		}
+	}
+	return strings.TrimSpace(resp.Explanation), fixes
+}
@@ -0,0 +1,100 @@
+package prompts
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const RedactedStr = "[REDACTED]"
+
+// secret values shorter than this are not scrubbed out of free text (too many false positives)
+const minSecretValueLen = 6
+
+// env var names that are always treated as secrets
+var defaultSecretNameRe = regexp.MustCompile(`(?i)(token|secret|passwd|password|passphrase|api_?key|access_?key|private_?key|credential|auth|cookie)`)
+
+// well-known secret formats that are scrubbed out of commands and output
+var defaultSecretTextRes = []*regexp.Regexp{
+	regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`),
+	regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/-]+=*`),
+	regexp.MustCompile(`\bAKIA[0-9A-Z]{16}\b`),
+	regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{30,}\b`),
+	regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{20,}\b`),
+	regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}\b`),
+}
+
+// user:password@ in urls
+var urlCredsRe = regexp.MustCompile(`(://)[^/\s:@]+:[^/\s@]+@`)
+
+// key=value or key: value where the key looks like a secret, only the value is redacted
+var secretAssignRe = regexp.MustCompile(`(?i)\b([A-Za-z0-9_.-]*(?:token|secret|passwd|password|api[_-]?key)[A-Za-z0-9_.-]*)(\s*[=:]\s*)("[^"]*"|'[^']*'|\S+)`)
+
+type Redactor struct {
+	userRes      []*regexp.Regexp
+	secretValues []string
+}
+
+// extraPatterns are user configured regexps, matched against env var names and against free text
+func MakeRedactor(extraPatterns []string) (*Redactor, error) {
+	rtn := &Redactor{}
+	for _, pattern := range extraPatterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %v", pattern, err)
+		}
+		rtn.userRes = append(rtn.userRes, re)
+	}
+	return rtn, nil
+}
+
+func (r *Redactor) isSecretName(name string) bool {
+	if defaultSecretNameRe.MatchString(name) {
+		return true
+	}
+	for _, re := range r.userRes {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// returns a copy of env with secret values replaced.  redacted values are remembered
+// so they are also scrubbed from any text passed to RedactText.
+func (r *Redactor) RedactEnv(env map[string]string) map[string]string {
+	rtn := make(map[string]string)
+	for name, val := range env {
+		if val != "" && r.isSecretName(name) {
+			rtn[name] = RedactedStr
+			if len(val) >= minSecretValueLen {
+				r.secretValues = append(r.secretValues, val)
+			}
+			continue
+		}
+		rtn[name] = r.RedactText(val)
+	}
+	// longest first so a secret that contains another secret is fully removed
+	sort.Slice(r.secretValues, func(i, j int) bool { return len(r.secretValues[i]) > len(r.secretValues[j]) })
+	return rtn
+}
+
+func (r *Redactor) RedactText(text string) string {
+	for _, val := range r.secretValues {
+		text = strings.ReplaceAll(text, val, RedactedStr)
+	}
+	for _, re := range defaultSecretTextRes {
+		text = re.ReplaceAllString(text, RedactedStr)
+	}
+	text = urlCredsRe.ReplaceAllString(text, "${1}"+RedactedStr+"@")
+	text = secretAssignRe.ReplaceAllString(text, "${1}${2}"+RedactedStr)
+	for _, re := range r.userRes {
+		text = re.ReplaceAllString(text, RedactedStr)
+	}
+	return text
+}
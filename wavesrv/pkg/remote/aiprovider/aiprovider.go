@@ -0,0 +1,67 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// Registry of AI completion backends.  Each provider package registers itself in init().
+package aiprovider
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/abhishek944/waveterm/waveshell/pkg/packet"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+)
+
+const DefaultStreamChanSize = 10
+
+type AIProvider interface {
+	// name used for AIOptsType.Default and the "provider" kwarg
+	Name() string
+
+	// each provider pulls its own options out of aiOpts (errors if not configured and there are no usable defaults)
+	RunCompletion(ctx context.Context, aiOpts *sstore.AIOptsType, prompt []packet.OpenAIPromptMessageType) ([]*packet.OpenAIPacketType, error)
+
+	// the returned channel is closed when the response is complete, errors are sent as error packets
+	RunCompletionStream(ctx context.Context, aiOpts *sstore.AIOptsType, prompt []packet.OpenAIPromptMessageType) (chan *packet.OpenAIPacketType, error)
+}
+
+var registryLock = &sync.Mutex{}
+var registry = make(map[string]AIProvider)
+
+func RegisterProvider(p AIProvider) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	registry[p.Name()] = p
+}
+
+// returns nil if not found
+func GetProvider(name string) AIProvider {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	return registry[name]
+}
+
+func GetProviderNames() []string {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	var rtn []string
+	for name := range registry {
+		rtn = append(rtn, name)
+	}
+	sort.Strings(rtn)
+	return rtn
+}
+
+func CreateErrorPacket(errStr string) *packet.OpenAIPacketType {
+	errPk := packet.MakeOpenAIPacket()
+	errPk.FinishReason = "error"
+	errPk.Error = errStr
+	return errPk
+}
+
+func CreateTextPacket(text string) *packet.OpenAIPacketType {
+	pk := packet.MakeOpenAIPacket()
+	pk.Text = text
+	return pk
+}
@@ -0,0 +1,66 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package aiprovider
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/abhishek944/waveterm/waveshell/pkg/packet"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+)
+
+const (
+	ToolRoleSystem    = "system"
+	ToolRoleUser      = "user"
+	ToolRoleAssistant = "assistant"
+	ToolRoleTool      = "tool"
+)
+
+type ToolDef struct {
+	Name        string
+	Description string
+	Parameters  map[string]any // JSON schema (type "object")
+}
+
+type ToolCall struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	Args string `json:"args"` // JSON encoded arguments
+}
+
+// a message in a tool-calling conversation.
+// assistant messages may carry ToolCalls, tool messages carry the result for ToolCallId
+type ToolMessage struct {
+	Role       string
+	Content    string
+	ToolCalls  []*ToolCall
+	ToolCallId string
+}
+
+type ToolResponse struct {
+	Model        string
+	Text         string
+	ToolCalls    []*ToolCall
+	FinishReason string
+	Usage        *packet.OpenAIUsageType
+}
+
+// optional interface for providers that support function calling
+type ToolCallingProvider interface {
+	AIProvider
+	RunToolCompletion(ctx context.Context, aiOpts *sstore.AIOptsType, messages []*ToolMessage, tools []*ToolDef) (*ToolResponse, error)
+}
+
+func GetToolCallingProvider(name string) (ToolCallingProvider, error) {
+	p := GetProvider(name)
+	if p == nil {
+		return nil, fmt.Errorf("unsupported AI provider: %s", name)
+	}
+	tp, ok := p.(ToolCallingProvider)
+	if !ok {
+		return nil, fmt.Errorf("AI provider %q does not support tool calling", name)
+	}
+	return tp, nil
+}
@@ -0,0 +1,326 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// AI provider for the Anthropic Messages API (/v1/messages), supports SSE streaming.
+package anthropic
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/abhishek944/waveterm/waveshell/pkg/packet"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/remote/aiprovider"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+)
+
+const ProviderName = "anthropic"
+const DefaultBaseURL = "https://api.anthropic.com"
+const DefaultModel = "claude-3-5-sonnet-latest"
+const DefaultMaxTokens = 1000
+const APIVersion = "2023-06-01"
+const MessagesEndpoint = "/v1/messages"
+const MaxErrorBodySize = 4096
+const MaxLineSize = 1024 * 1024
+
+const (
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+	RoleSystem    = "system"
+)
+
+type Provider struct{}
+
+func init() {
+	aiprovider.RegisterProvider(Provider{})
+}
+
+type message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type messagesRequest struct {
+	Model     string    `json:"model"`
+	MaxTokens int       `json:"max_tokens"`
+	System    string    `json:"system,omitempty"`
+	Messages  []message `json:"messages"`
+	Stream    bool      `json:"stream,omitempty"`
+}
+
+type usageType struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type contentBlock struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type errorType struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+type messagesResponse struct {
+	Id         string          `json:"id"`
+	Model      string          `json:"model"`
+	Content    []*contentBlock `json:"content"`
+	StopReason string          `json:"stop_reason"`
+	Usage      *usageType      `json:"usage"`
+	Error      *errorType      `json:"error"`
+}
+
+// the data payload of a single SSE event (fields depend on the event type)
+type streamEvent struct {
+	Type    string            `json:"type"`
+	Index   int               `json:"index"`
+	Message *messagesResponse `json:"message"`
+	Delta   *struct {
+		Type       string `json:"type"`
+		Text       string `json:"text"`
+		StopReason string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage *usageType `json:"usage"`
+	Error *errorType `json:"error"`
+}
+
+func (Provider) Name() string {
+	return ProviderName
+}
+
+func (Provider) RunCompletion(ctx context.Context, aiOpts *sstore.AIOptsType, prompt []packet.OpenAIPromptMessageType) ([]*packet.OpenAIPacketType, error) {
+	opts, err := getOpts(aiOpts)
+	if err != nil {
+		return nil, err
+	}
+	return RunCompletion(ctx, opts, prompt)
+}
+
+func (Provider) RunCompletionStream(ctx context.Context, aiOpts *sstore.AIOptsType, prompt []packet.OpenAIPromptMessageType) (chan *packet.OpenAIPacketType, error) {
+	opts, err := getOpts(aiOpts)
+	if err != nil {
+		return nil, err
+	}
+	return RunCompletionStream(ctx, opts, prompt)
+}
+
+func getOpts(aiOpts *sstore.AIOptsType) (*sstore.AnthropicOptsType, error) {
+	if aiOpts == nil || aiOpts.Anthropic == nil {
+		return nil, fmt.Errorf("Anthropic options not configured")
+	}
+	opts := *aiOpts.Anthropic
+	if opts.BaseURL == "" {
+		opts.BaseURL = DefaultBaseURL
+	}
+	if opts.Model == "" {
+		opts.Model = DefaultModel
+	}
+	if opts.MaxTokens == 0 {
+		opts.MaxTokens = DefaultMaxTokens
+	}
+	return &opts, nil
+}
+
+// system messages are pulled out into the separate system field.
+// the messages API requires alternating user/assistant turns starting with user,
+// so consecutive messages with the same role are merged.
+func ConvertPromptMessages(prompt []packet.OpenAIPromptMessageType) (string, []message) {
+	var systemParts []string
+	var messages []message
+	for _, p := range prompt {
+		if p.Role == RoleSystem {
+			systemParts = append(systemParts, p.Content)
+			continue
+		}
+		role := RoleUser
+		if p.Role == RoleAssistant {
+			role = RoleAssistant
+		}
+		if len(messages) == 0 && role == RoleAssistant {
+			// cannot start with an assistant message
+			continue
+		}
+		if len(messages) > 0 && messages[len(messages)-1].Role == role {
+			messages[len(messages)-1].Content += "\n\n" + p.Content
+			continue
+		}
+		messages = append(messages, message{Role: role, Content: p.Content})
+	}
+	return strings.Join(systemParts, "\n\n"), messages
+}
+
+func convertUsage(usage *usageType) *packet.OpenAIUsageType {
+	if usage == nil {
+		return nil
+	}
+	return &packet.OpenAIUsageType{
+		PromptTokens:     usage.InputTokens,
+		CompletionTokens: usage.OutputTokens,
+		TotalTokens:      usage.InputTokens + usage.OutputTokens,
+	}
+}
+
+func doMessagesRequest(ctx context.Context, opts *sstore.AnthropicOptsType, prompt []packet.OpenAIPromptMessageType, stream bool) (*http.Response, error) {
+	if opts == nil {
+		return nil, fmt.Errorf("no anthropic opts found")
+	}
+	if opts.Model == "" {
+		return nil, fmt.Errorf("no anthropic model specified")
+	}
+	if opts.APIToken == "" {
+		return nil, fmt.Errorf("no api token")
+	}
+	system, messages := ConvertPromptMessages(prompt)
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("no prompt provided")
+	}
+	reqBody := messagesRequest{
+		Model:     opts.Model,
+		MaxTokens: opts.MaxTokens,
+		System:    system,
+		Messages:  messages,
+		Stream:    stream,
+	}
+	return postMessages(ctx, opts, reqBody)
+}
+
+// reqBody is a messagesRequest or a toolMessagesRequest
+func postMessages(ctx context.Context, opts *sstore.AnthropicOptsType, reqBody any) (*http.Response, error) {
+	barr, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode anthropic request: %v", err)
+	}
+	url := strings.TrimRight(opts.BaseURL, "/") + MessagesEndpoint
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(barr))
+	if err != nil {
+		return nil, fmt.Errorf("cannot create anthropic request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", opts.APIToken)
+	req.Header.Set("anthropic-version", APIVersion)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error calling anthropic API: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		errBody, _ := io.ReadAll(io.LimitReader(resp.Body, MaxErrorBodySize))
+		var errResp messagesResponse
+		if json.Unmarshal(errBody, &errResp) == nil && errResp.Error != nil {
+			return nil, fmt.Errorf("error calling anthropic API (%s): %s", resp.Status, errResp.Error.Message)
+		}
+		return nil, fmt.Errorf("error calling anthropic API (%s): %s", resp.Status, strings.TrimSpace(string(errBody)))
+	}
+	return resp, nil
+}
+
+func RunCompletion(ctx context.Context, opts *sstore.AnthropicOptsType, prompt []packet.OpenAIPromptMessageType) ([]*packet.OpenAIPacketType, error) {
+	resp, err := doMessagesRequest(ctx, opts, prompt, false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var msgResp messagesResponse
+	err = json.NewDecoder(resp.Body).Decode(&msgResp)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode anthropic response: %v", err)
+	}
+	return marshalResponse(&msgResp), nil
+}
+
+func marshalResponse(resp *messagesResponse) []*packet.OpenAIPacketType {
+	var rtn []*packet.OpenAIPacketType
+	headerPk := packet.MakeOpenAIPacket()
+	headerPk.Model = resp.Model
+	headerPk.Created = time.Now().Unix()
+	headerPk.Usage = convertUsage(resp.Usage)
+	rtn = append(rtn, headerPk)
+	var text strings.Builder
+	for _, block := range resp.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+	choicePk := packet.MakeOpenAIPacket()
+	choicePk.Text = text.String()
+	choicePk.FinishReason = resp.StopReason
+	rtn = append(rtn, choicePk)
+	return rtn
+}
+
+func RunCompletionStream(ctx context.Context, opts *sstore.AnthropicOptsType, prompt []packet.OpenAIPromptMessageType) (chan *packet.OpenAIPacketType, error) {
+	resp, err := doMessagesRequest(ctx, opts, prompt, true)
+	if err != nil {
+		return nil, err
+	}
+	rtn := make(chan *packet.OpenAIPacketType, aiprovider.DefaultStreamChanSize)
+	go func() {
+		defer close(rtn)
+		defer resp.Body.Close()
+		var usage usageType
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 0, 64*1024), MaxLineSize)
+		for scanner.Scan() {
+			line := scanner.Text()
+			// we only need the data lines, the event type is repeated in the payload
+			if !strings.HasPrefix(line, "data:") {
+				continue
+			}
+			data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+			var event streamEvent
+			err := json.Unmarshal([]byte(data), &event)
+			if err != nil {
+				rtn <- aiprovider.CreateErrorPacket(fmt.Sprintf("error decoding anthropic stream: %v", err))
+				return
+			}
+			switch event.Type {
+			case "message_start":
+				pk := packet.MakeOpenAIPacket()
+				if event.Message != nil {
+					pk.Model = event.Message.Model
+					if event.Message.Usage != nil {
+						usage.InputTokens = event.Message.Usage.InputTokens
+						usage.OutputTokens = event.Message.Usage.OutputTokens
+					}
+				}
+				pk.Created = time.Now().Unix()
+				rtn <- pk
+			case "content_block_delta":
+				if event.Delta != nil && event.Delta.Text != "" {
+					rtn <- aiprovider.CreateTextPacket(event.Delta.Text)
+				}
+			case "message_delta":
+				if event.Usage != nil {
+					usage.OutputTokens = event.Usage.OutputTokens
+				}
+				if event.Delta != nil && event.Delta.StopReason != "" {
+					pk := packet.MakeOpenAIPacket()
+					pk.FinishReason = event.Delta.StopReason
+					pk.Usage = convertUsage(&usage)
+					rtn <- pk
+				}
+			case "message_stop":
+				return
+			case "error":
+				errMsg := "unknown error"
+				if event.Error != nil {
+					errMsg = event.Error.Message
+				}
+				rtn <- aiprovider.CreateErrorPacket(fmt.Sprintf("anthropic error: %s", errMsg))
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			rtn <- aiprovider.CreateErrorPacket(fmt.Sprintf("error in streaming: %v", err))
+		}
+	}()
+	return rtn, nil
+}
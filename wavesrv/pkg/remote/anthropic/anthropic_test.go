@@ -0,0 +1,155 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package anthropic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/abhishek944/waveterm/waveshell/pkg/packet"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/remote/aiprovider"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+)
+
+func TestConvertPromptMessages(t *testing.T) {
+	prompt := []packet.OpenAIPromptMessageType{
+		{Role: "system", Content: "sys1"},
+		{Role: "assistant", Content: "dropped"},
+		{Role: "user", Content: "u1"},
+		{Role: "user", Content: "u2"},
+		{Role: "system", Content: "sys2"},
+		{Role: "assistant", Content: "a1"},
+	}
+	system, messages := ConvertPromptMessages(prompt)
+	if system != "sys1\n\nsys2" {
+		t.Errorf("bad system %q", system)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("expected 2 messages, got %#v", messages)
+	}
+	if messages[0].Role != RoleUser || messages[0].Content != "u1\n\nu2" {
+		t.Errorf("bad first message %#v", messages[0])
+	}
+	if messages[1].Role != RoleAssistant || messages[1].Content != "a1" {
+		t.Errorf("bad second message %#v", messages[1])
+	}
+}
+
+const testSSE = `event: message_start
+data: {"type":"message_start","message":{"id":"msg_1","model":"test-model","usage":{"input_tokens":12,"output_tokens":1}}}
+
+event: content_block_start
+data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}
+
+event: ping
+data: {"type": "ping"}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello"}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":" world"}}
+
+event: content_block_stop
+data: {"type":"content_block_stop","index":0}
+
+event: message_delta
+data: {"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":5}}
+
+event: message_stop
+data: {"type":"message_stop"}
+
+`
+
+func TestRunCompletionStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != MessagesEndpoint || r.Header.Get("x-api-key") != "testkey" || r.Header.Get("anthropic-version") != APIVersion {
+			t.Errorf("bad request %s %v", r.URL.Path, r.Header)
+		}
+		var req messagesRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if !req.Stream || req.System != "be brief" || len(req.Messages) != 1 || req.MaxTokens != DefaultMaxTokens {
+			t.Errorf("bad request body %#v", req)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, testSSE)
+	}))
+	defer server.Close()
+	aiOpts := &sstore.AIOptsType{Anthropic: &sstore.AnthropicOptsType{APIToken: "testkey", BaseURL: server.URL}}
+	prompt := []packet.OpenAIPromptMessageType{{Role: "system", Content: "be brief"}, {Role: "user", Content: "hi"}}
+	ch, err := Provider{}.RunCompletionStream(context.Background(), aiOpts, prompt)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var text string
+	var pks []*packet.OpenAIPacketType
+	for pk := range ch {
+		if pk.Error != "" {
+			t.Fatalf("error packet: %s", pk.Error)
+		}
+		text += pk.Text
+		pks = append(pks, pk)
+	}
+	if text != "Hello world" {
+		t.Errorf("bad text %q", text)
+	}
+	if pks[0].Model != "test-model" {
+		t.Errorf("bad header packet %#v", pks[0])
+	}
+	lastPk := pks[len(pks)-1]
+	if lastPk.FinishReason != "end_turn" || lastPk.Usage == nil || lastPk.Usage.PromptTokens != 12 || lastPk.Usage.CompletionTokens != 5 {
+		t.Errorf("bad final packet %#v", lastPk)
+	}
+}
+
+func TestRunCompletionError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`)
+	}))
+	defer server.Close()
+	aiOpts := &sstore.AIOptsType{Anthropic: &sstore.AnthropicOptsType{APIToken: "bad", BaseURL: server.URL}}
+	_, err := Provider{}.RunCompletion(context.Background(), aiOpts, []packet.OpenAIPromptMessageType{{Role: "user", Content: "hi"}})
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestRunToolCompletion(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req toolMessagesRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if len(req.Tools) != 1 || req.Tools[0].Name != "list_dir" || req.Tools[0].InputSchema["type"] != "object" {
+			t.Errorf("bad tools %#v", req.Tools)
+		}
+		// user, assistant (text + tool_use), user (tool_result)
+		if len(req.Messages) != 3 || req.Messages[1].Content[1].Type != "tool_use" || req.Messages[2].Content[0].ToolUseId != "call_1" {
+			t.Errorf("bad messages %#v", req.Messages)
+		}
+		fmt.Fprint(w, `{"model":"test-model","stop_reason":"tool_use","content":[{"type":"text","text":"checking"},{"type":"tool_use","id":"call_2","name":"list_dir","input":{"path":"/tmp"}}],"usage":{"input_tokens":3,"output_tokens":4}}`)
+	}))
+	defer server.Close()
+	aiOpts := &sstore.AIOptsType{Anthropic: &sstore.AnthropicOptsType{APIToken: "testkey", BaseURL: server.URL}}
+	messages := []*aiprovider.ToolMessage{
+		{Role: aiprovider.ToolRoleSystem, Content: "sys"},
+		{Role: aiprovider.ToolRoleUser, Content: "what is in /"},
+		{Role: aiprovider.ToolRoleAssistant, Content: "looking", ToolCalls: []*aiprovider.ToolCall{{Id: "call_1", Name: "list_dir", Args: `{"path":"/"}`}}},
+		{Role: aiprovider.ToolRoleTool, ToolCallId: "call_1", Content: "tmp\nusr"},
+	}
+	tools := []*aiprovider.ToolDef{{Name: "list_dir", Description: "list", Parameters: map[string]any{"type": "object"}}}
+	resp, err := Provider{}.RunToolCompletion(context.Background(), aiOpts, messages, tools)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Text != "checking" || resp.FinishReason != "tool_use" || resp.Usage == nil || resp.Usage.TotalTokens != 7 {
+		t.Errorf("bad response %#v", resp)
+	}
+	if len(resp.ToolCalls) != 1 || resp.ToolCalls[0].Id != "call_2" || resp.ToolCalls[0].Args != `{"path":"/tmp"}` {
+		t.Errorf("bad tool calls %#v", resp.ToolCalls)
+	}
+}
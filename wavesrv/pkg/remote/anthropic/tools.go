@@ -0,0 +1,160 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package anthropic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/abhishek944/waveterm/wavesrv/pkg/remote/aiprovider"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+)
+
+type toolDef struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	InputSchema map[string]any `json:"input_schema"`
+}
+
+// text, tool_use (assistant) or tool_result (user) block
+type toolContentBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	Id        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseId string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+}
+
+type toolMessage struct {
+	Role    string              `json:"role"`
+	Content []*toolContentBlock `json:"content"`
+}
+
+type toolMessagesRequest struct {
+	Model     string         `json:"model"`
+	MaxTokens int            `json:"max_tokens"`
+	System    string         `json:"system,omitempty"`
+	Messages  []*toolMessage `json:"messages"`
+	Tools     []*toolDef     `json:"tools,omitempty"`
+}
+
+type toolMessagesResponse struct {
+	Model      string              `json:"model"`
+	Content    []*toolContentBlock `json:"content"`
+	StopReason string              `json:"stop_reason"`
+	Usage      *usageType          `json:"usage"`
+}
+
+func (Provider) RunToolCompletion(ctx context.Context, aiOpts *sstore.AIOptsType, messages []*aiprovider.ToolMessage, tools []*aiprovider.ToolDef) (*aiprovider.ToolResponse, error) {
+	opts, err := getOpts(aiOpts)
+	if err != nil {
+		return nil, err
+	}
+	return RunToolCompletion(ctx, opts, messages, tools)
+}
+
+// tool results are sent back as tool_result blocks inside a user message.
+// same merging rules as ConvertPromptMessages (alternating turns, starting with user).
+func ConvertToolMessages(messages []*aiprovider.ToolMessage) (string, []*toolMessage) {
+	var systemParts []string
+	var rtn []*toolMessage
+	for _, m := range messages {
+		var role string
+		var blocks []*toolContentBlock
+		switch m.Role {
+		case aiprovider.ToolRoleSystem:
+			systemParts = append(systemParts, m.Content)
+			continue
+		case aiprovider.ToolRoleTool:
+			role = RoleUser
+			blocks = append(blocks, &toolContentBlock{Type: "tool_result", ToolUseId: m.ToolCallId, Content: m.Content})
+		case aiprovider.ToolRoleAssistant:
+			role = RoleAssistant
+			if m.Content != "" {
+				blocks = append(blocks, &toolContentBlock{Type: "text", Text: m.Content})
+			}
+			for _, tc := range m.ToolCalls {
+				input := json.RawMessage(tc.Args)
+				if !json.Valid(input) {
+					input = json.RawMessage("{}")
+				}
+				blocks = append(blocks, &toolContentBlock{Type: "tool_use", Id: tc.Id, Name: tc.Name, Input: input})
+			}
+		default:
+			role = RoleUser
+			blocks = append(blocks, &toolContentBlock{Type: "text", Text: m.Content})
+		}
+		if len(blocks) == 0 {
+			continue
+		}
+		if len(rtn) == 0 && role == RoleAssistant {
+			continue
+		}
+		if len(rtn) > 0 && rtn[len(rtn)-1].Role == role {
+			rtn[len(rtn)-1].Content = append(rtn[len(rtn)-1].Content, blocks...)
+			continue
+		}
+		rtn = append(rtn, &toolMessage{Role: role, Content: blocks})
+	}
+	return strings.Join(systemParts, "\n\n"), rtn
+}
+
+func RunToolCompletion(ctx context.Context, opts *sstore.AnthropicOptsType, messages []*aiprovider.ToolMessage, tools []*aiprovider.ToolDef) (*aiprovider.ToolResponse, error) {
+	if opts == nil {
+		return nil, fmt.Errorf("no anthropic opts found")
+	}
+	if opts.Model == "" {
+		return nil, fmt.Errorf("no anthropic model specified")
+	}
+	if opts.APIToken == "" {
+		return nil, fmt.Errorf("no api token")
+	}
+	system, convMessages := ConvertToolMessages(messages)
+	if len(convMessages) == 0 {
+		return nil, fmt.Errorf("no prompt provided")
+	}
+	reqBody := toolMessagesRequest{
+		Model:     opts.Model,
+		MaxTokens: opts.MaxTokens,
+		System:    system,
+		Messages:  convMessages,
+	}
+	for _, tool := range tools {
+		reqBody.Tools = append(reqBody.Tools, &toolDef{Name: tool.Name, Description: tool.Description, InputSchema: tool.Parameters})
+	}
+	resp, err := postMessages(ctx, opts, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var msgResp toolMessagesResponse
+	err = json.NewDecoder(resp.Body).Decode(&msgResp)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode anthropic response: %v", err)
+	}
+	rtn := &aiprovider.ToolResponse{
+		Model:        msgResp.Model,
+		FinishReason: msgResp.StopReason,
+		Usage:        convertUsage(msgResp.Usage),
+	}
+	var text strings.Builder
+	for _, block := range msgResp.Content {
+		switch block.Type {
+		case "text":
+			text.WriteString(block.Text)
+		case "tool_use":
+			args := string(block.Input)
+			if args == "" {
+				args = "{}"
+			}
+			rtn.ToolCalls = append(rtn.ToolCalls, &aiprovider.ToolCall{Id: block.Id, Name: block.Name, Args: args})
+		}
+	}
+	rtn.Text = text.String()
+	return rtn, nil
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/openai/openai-go/v2/packages/param"
 	"github.com/openai/openai-go/v2/shared"
 	"github.com/abhishek944/waveterm/waveshell/pkg/packet"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/remote/aiprovider"
 	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
 )
 
@@ -22,6 +23,42 @@ const DefaultModel = "gpt-35-turbo" // Azure uses different model names
 const DefaultAPIVersion = "2024-06-01"
 const DefaultStreamChanSize = 10
 
+const ProviderName = "azure"
+
+type Provider struct{}
+
+func init() {
+	aiprovider.RegisterProvider(Provider{})
+}
+
+func (Provider) Name() string {
+	return ProviderName
+}
+
+func (Provider) getOpts(aiOpts *sstore.AIOptsType) (*sstore.AzureOpenAIOptsType, error) {
+	if aiOpts == nil || aiOpts.Azure == nil {
+		return nil, fmt.Errorf("Azure OpenAI options not configured")
+	}
+	opts := *aiOpts.Azure
+	return &opts, nil
+}
+
+func (p Provider) RunCompletion(ctx context.Context, aiOpts *sstore.AIOptsType, prompt []packet.OpenAIPromptMessageType) ([]*packet.OpenAIPacketType, error) {
+	opts, err := p.getOpts(aiOpts)
+	if err != nil {
+		return nil, err
+	}
+	return RunCompletion(ctx, opts, prompt)
+}
+
+func (p Provider) RunCompletionStream(ctx context.Context, aiOpts *sstore.AIOptsType, prompt []packet.OpenAIPromptMessageType) (chan *packet.OpenAIPacketType, error) {
+	opts, err := p.getOpts(aiOpts)
+	if err != nil {
+		return nil, err
+	}
+	return RunCompletionStream(ctx, opts, prompt)
+}
+
 func convertUsage(usage openai.CompletionUsage) *packet.OpenAIUsageType {
 	return &packet.OpenAIUsageType{
 		PromptTokens:     int(usage.PromptTokens),
@@ -162,7 +199,7 @@ func RunCompletionStream(ctx context.Context, opts *sstore.AzureOpenAIOptsType,
 		}
 		
 		if err := stream.Err(); err != nil {
-			errPk := CreateErrorPacket(fmt.Sprintf("error in streaming: %v", err))
+			errPk := aiprovider.CreateErrorPacket(fmt.Sprintf("error in streaming: %v", err))
 			rtn <- errPk
 		}
 	}()
@@ -189,16 +226,3 @@ func marshalResponse(resp *openai.ChatCompletion) []*packet.OpenAIPacketType {
 	}
 	return rtn
 }
-
-func CreateErrorPacket(errStr string) *packet.OpenAIPacketType {
-	errPk := packet.MakeOpenAIPacket()
-	errPk.FinishReason = "error"
-	errPk.Error = errStr
-	return errPk
-}
-
-func CreateTextPacket(text string) *packet.OpenAIPacketType {
-	pk := packet.MakeOpenAIPacket()
-	pk.Text = text
-	return pk
-}
\ No newline at end of file
@@ -0,0 +1,42 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package azureopenai
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/abhishek944/waveterm/wavesrv/pkg/remote/aiprovider"
+	waveopenai "github.com/abhishek944/waveterm/wavesrv/pkg/remote/openai"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+	"github.com/openai/openai-go/v2"
+	"github.com/openai/openai-go/v2/azure"
+)
+
+func (p Provider) RunToolCompletion(ctx context.Context, aiOpts *sstore.AIOptsType, messages []*aiprovider.ToolMessage, tools []*aiprovider.ToolDef) (*aiprovider.ToolResponse, error) {
+	opts, err := p.getOpts(aiOpts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.BaseURL == "" {
+		return nil, fmt.Errorf("no azure openai endpoint specified")
+	}
+	if opts.APIToken == "" {
+		return nil, fmt.Errorf("no api token")
+	}
+	if opts.DeploymentName == "" {
+		return nil, fmt.Errorf("no deployment name specified")
+	}
+	baseURL := opts.BaseURL
+	apiVersion := DefaultAPIVersion
+	if idx := strings.Index(baseURL, "?api-version="); idx != -1 {
+		apiVersion = baseURL[idx+len("?api-version="):]
+		baseURL = baseURL[:idx]
+	}
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	client := openai.NewClient(azure.WithEndpoint(baseURL, apiVersion), azure.WithAPIKey(opts.APIToken))
+	// deployment name is used as the model
+	return waveopenai.RunToolCompletionWithClient(ctx, client, opts.DeploymentName, DefaultMaxTokens, messages, tools)
+}
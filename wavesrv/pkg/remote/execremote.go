@@ -0,0 +1,84 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"fmt"
+	"os/exec"
+
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+)
+
+// the local binaries used for docker and kubectl remotes (vars so they can be overridden)
+var DockerBinary = "docker"
+var KubectlBinary = "kubectl"
+
+// returns the local command line that runs shellCmdStr (with shellName -c) in the container / pod.
+// stdin is kept open (-i) but there is no tty, waveshell speaks packets over stdin/stdout
+func makeExecRemoteArgs(remoteType string, opts *sstore.ExecOptsType, shellName string, shellCmdStr string) ([]string, error) {
+	if opts == nil || opts.Target == "" {
+		return nil, fmt.Errorf("%s remote has no target", remoteType)
+	}
+	switch remoteType {
+	case sstore.RemoteTypeDocker:
+		args := []string{DockerBinary, "exec", "-i"}
+		if opts.User != "" {
+			args = append(args, "-u", opts.User)
+		}
+		return append(args, opts.Target, shellName, "-c", shellCmdStr), nil
+
+	case sstore.RemoteTypeKubectl:
+		args := []string{KubectlBinary}
+		if opts.KubeContext != "" {
+			args = append(args, "--context", opts.KubeContext)
+		}
+		if opts.Namespace != "" {
+			args = append(args, "-n", opts.Namespace)
+		}
+		args = append(args, "exec", "-i", opts.Target)
+		if opts.Container != "" {
+			args = append(args, "-c", opts.Container)
+		}
+		return append(args, "--", shellName, "-c", shellCmdStr), nil
+	}
+	return nil, fmt.Errorf("invalid exec remote type %q", remoteType)
+}
+
+// not tied to a context, the command lives as long as the connection (killed by Disconnect)
+func MakeExecRemoteCmd(remoteCopy sstore.RemoteType, shellName string, shellCmdStr string) (*exec.Cmd, error) {
+	args, err := makeExecRemoteArgs(remoteCopy.RemoteType, remoteCopy.ExecOpts, shellName, shellCmdStr)
+	if err != nil {
+		return nil, err
+	}
+	binPath, err := exec.LookPath(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("cannot find %s: %w", args[0], err)
+	}
+	return exec.Command(binPath, args[1:]...), nil
+}
+
+// canonical names are "docker:[user@]container" and "kubectl:[context/][namespace/]pod[:container]"
+func MakeExecRemoteCanonicalName(remoteType string, opts *sstore.ExecOptsType) string {
+	switch remoteType {
+	case sstore.RemoteTypeDocker:
+		if opts.User != "" {
+			return fmt.Sprintf("docker:%s@%s", opts.User, opts.Target)
+		}
+		return "docker:" + opts.Target
+
+	case sstore.RemoteTypeKubectl:
+		name := opts.Target
+		if opts.Namespace != "" {
+			name = opts.Namespace + "/" + name
+		}
+		if opts.KubeContext != "" {
+			name = opts.KubeContext + "/" + name
+		}
+		if opts.Container != "" {
+			name = name + ":" + opts.Container
+		}
+		return "kubectl:" + name
+	}
+	return remoteType + ":" + opts.Target
+}
@@ -0,0 +1,102 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abhishek944/waveterm/waveshell/pkg/base"
+	"github.com/abhishek944/waveterm/waveshell/pkg/shexec"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+)
+
+// strips "exec -i [-u user] container" and runs the rest locally, the args are saved to $dir/docker.args
+const fakeDockerScript = `#!/bin/sh
+echo "$@" > "$(dirname "$0")/docker.args"
+shift 2
+if [ "$1" = "-u" ]; then shift 2; fi
+shift
+exec "$@"
+`
+
+func installFakeDocker(t *testing.T) string {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "docker"), []byte(fakeDockerScript), 0755)
+	if err != nil {
+		t.Fatalf("cannot write fake docker: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return dir
+}
+
+func TestMakeExecRemoteArgs(t *testing.T) {
+	args, err := makeExecRemoteArgs(sstore.RemoteTypeDocker, &sstore.ExecOptsType{Target: "web", User: "root"}, "bash", "echo hi")
+	if err != nil || strings.Join(args, "|") != "docker|exec|-i|-u|root|web|bash|-c|echo hi" {
+		t.Errorf("bad docker args: %v %v", args, err)
+	}
+	opts := &sstore.ExecOptsType{Target: "api-0", Namespace: "prod", KubeContext: "east", Container: "app"}
+	args, err = makeExecRemoteArgs(sstore.RemoteTypeKubectl, opts, "bash", "echo hi")
+	if err != nil || strings.Join(args, "|") != "kubectl|--context|east|-n|prod|exec|-i|api-0|-c|app|--|bash|-c|echo hi" {
+		t.Errorf("bad kubectl args: %v %v", args, err)
+	}
+	if name := MakeExecRemoteCanonicalName(sstore.RemoteTypeKubectl, opts); name != "kubectl:east/prod/api-0:app" {
+		t.Errorf("bad canonical name: %q", name)
+	}
+	_, err = makeExecRemoteArgs(sstore.RemoteTypeDocker, &sstore.ExecOptsType{}, "bash", "echo hi")
+	if err == nil {
+		t.Errorf("expected error for missing target")
+	}
+}
+
+func TestExecRemoteCmd(t *testing.T) {
+	dir := installFakeDocker(t)
+	remoteCopy := sstore.RemoteType{RemoteType: sstore.RemoteTypeDocker, ExecOpts: &sstore.ExecOptsType{Target: "web", User: "app"}}
+	ecmd, err := MakeExecRemoteCmd(remoteCopy, "sh", "echo hello from $0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	output, err := ecmd.CombinedOutput()
+	if err != nil || strings.TrimSpace(string(output)) != "hello from sh" {
+		t.Errorf("bad output %q: %v", output, err)
+	}
+	argsData, _ := os.ReadFile(filepath.Join(dir, "docker.args"))
+	if strings.TrimSpace(string(argsData)) != "exec -i -u app web sh -c echo hello from $0" {
+		t.Errorf("bad docker args: %q", argsData)
+	}
+}
+
+// runs the real install script through the fake docker (into a temp HOME), with a fake waveshell binary
+func TestExecRemoteInstall(t *testing.T) {
+	installFakeDocker(t)
+	homeDir := t.TempDir()
+	t.Setenv("HOME", homeDir)
+	fakeWaveshell := fmt.Sprintf("#!/bin/sh\nprintf '\\n##N{\"type\": \"init\", \"version\": \"%s\"}\\n'\n", base.WaveshellVersion)
+	var detected string
+	readerFn := func(version string, goos string, goarch string) (io.ReadCloser, error) {
+		detected = goos + "." + goarch
+		return io.NopCloser(strings.NewReader(fakeWaveshell)), nil
+	}
+	remoteCopy := sstore.RemoteType{RemoteType: sstore.RemoteTypeDocker, ExecOpts: &sstore.ExecOptsType{Target: "web"}}
+	ecmd, err := MakeExecRemoteCmd(remoteCopy, "sh", shexec.MakeInstallCommandStr())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx, cancelFn := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelFn()
+	err = shexec.RunInstallFromCmd(ctx, shexec.CmdWrap{Cmd: ecmd}, true, nil, readerFn, func(string) {})
+	if err != nil {
+		t.Fatalf("install failed: %v", err)
+	}
+	if detected == "" {
+		t.Errorf("arch was not detected")
+	}
+	matches, _ := filepath.Glob(filepath.Join(homeDir, ".mshell", "mshell-v*"))
+	if len(matches) != 1 {
+		t.Errorf("waveshell was not installed: %v", matches)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/google/generative-ai-go/genai"
 	"google.golang.org/api/option"
 	"github.com/abhishek944/waveterm/waveshell/pkg/packet"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/remote/aiprovider"
 	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
 )
 
@@ -18,6 +19,48 @@ const DefaultMaxTokens = 1000
 const DefaultModel = "gemini-pro"
 const DefaultStreamChanSize = 10
 
+const ProviderName = "gemini"
+
+type Provider struct{}
+
+func init() {
+	aiprovider.RegisterProvider(Provider{})
+}
+
+func (Provider) Name() string {
+	return ProviderName
+}
+
+func (Provider) getOpts(aiOpts *sstore.AIOptsType) (*sstore.GeminiOptsType, error) {
+	if aiOpts == nil || aiOpts.Gemini == nil {
+		return nil, fmt.Errorf("Gemini options not configured")
+	}
+	opts := *aiOpts.Gemini
+	if opts.Model == "" {
+		opts.Model = DefaultModel
+	}
+	if opts.MaxTokens == 0 {
+		opts.MaxTokens = DefaultMaxTokens
+	}
+	return &opts, nil
+}
+
+func (p Provider) RunCompletion(ctx context.Context, aiOpts *sstore.AIOptsType, prompt []packet.OpenAIPromptMessageType) ([]*packet.OpenAIPacketType, error) {
+	opts, err := p.getOpts(aiOpts)
+	if err != nil {
+		return nil, err
+	}
+	return RunCompletion(ctx, opts, prompt)
+}
+
+func (p Provider) RunCompletionStream(ctx context.Context, aiOpts *sstore.AIOptsType, prompt []packet.OpenAIPromptMessageType) (chan *packet.OpenAIPacketType, error) {
+	opts, err := p.getOpts(aiOpts)
+	if err != nil {
+		return nil, err
+	}
+	return RunCompletionStream(ctx, opts, prompt)
+}
+
 func ConvertPromptMessages(prompt []packet.OpenAIPromptMessageType) []genai.Part {
 	var parts []genai.Part
 	for _, p := range prompt {
@@ -141,7 +184,7 @@ func RunCompletionStream(ctx context.Context, opts *sstore.GeminiOptsType, promp
 				break
 			}
 			if err != nil {
-				errPk := CreateErrorPacket(fmt.Sprintf("error in streaming: %v", err))
+				errPk := aiprovider.CreateErrorPacket(fmt.Sprintf("error in streaming: %v", err))
 				rtn <- errPk
 				break
 			}
@@ -204,16 +247,3 @@ func marshalResponse(resp *genai.GenerateContentResponse, model string) []*packe
 	}
 	return rtn
 }
-
-func CreateErrorPacket(errStr string) *packet.OpenAIPacketType {
-	errPk := packet.MakeOpenAIPacket()
-	errPk.FinishReason = "error"
-	errPk.Error = errStr
-	return errPk
-}
-
-func CreateTextPacket(text string) *packet.OpenAIPacketType {
-	pk := packet.MakeOpenAIPacket()
-	pk.Text = text
-	return pk
-}
\ No newline at end of file
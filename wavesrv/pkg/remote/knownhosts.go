@@ -0,0 +1,468 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+	"github.com/skeema/knownhosts"
+	"golang.org/x/crypto/ssh"
+	xknownhosts "golang.org/x/crypto/ssh/knownhosts"
+)
+
+const HostKeyFetchTimeout = 15 * time.Second
+
+const (
+	HostKeyStatus_Trusted = "trusted"
+	HostKeyStatus_Changed = "changed"
+	HostKeyStatus_Unknown = "unknown"
+	HostKeyStatus_Revoked = "revoked"
+)
+
+const knownHostsHashMagic = "|1|"
+
+var errHostKeyFetched = errors.New("host key fetched")
+
+// a known_hosts line that applies to a host
+type KnownHostsEntry struct {
+	File    string
+	LineNum int
+	Marker  string // "", "cert-authority" or "revoked"
+	Hosts   []string
+	Key     ssh.PublicKey
+	Exact   bool // the host is in the line by name (plain or hashed), not only through a wildcard pattern
+	Hashed  bool
+}
+
+func (e KnownHostsEntry) String() string {
+	var flags []string
+	if e.Marker != "" {
+		flags = append(flags, "@"+e.Marker)
+	}
+	if e.Hashed {
+		flags = append(flags, "hashed")
+	}
+	if !e.Exact {
+		flags = append(flags, "pattern "+strings.Join(e.Hosts, ","))
+	}
+	rtn := fmt.Sprintf("%s:%d  %s %s", e.File, e.LineNum, e.Key.Type(), ssh.FingerprintSHA256(e.Key))
+	if len(flags) > 0 {
+		rtn += "  (" + strings.Join(flags, ", ") + ")"
+	}
+	return rtn
+}
+
+// the host key a remote presents, and what its known_hosts files say about it
+type HostKeyInfo struct {
+	Host            string // the host as written in known_hosts (host, or [host]:port for non-22 ports)
+	Address         string // host:port
+	Key             ssh.PublicKey
+	Status          string
+	KnownHostsFiles []string
+	Entries         []KnownHostsEntry
+}
+
+// a key that no known_hosts line has: the host key callback fails with a *KeyError whose Want lists the
+// host's lines
+type probeHostKey struct{}
+
+func (probeHostKey) Type() string {
+	return "probe"
+}
+
+func (probeHostKey) Marshal() []byte {
+	return []byte("probe")
+}
+
+func (probeHostKey) Verify(data []byte, sig *ssh.Signature) error {
+	return errors.New("probe key cannot verify signatures")
+}
+
+var probeRemoteAddr = &net.TCPAddr{IP: net.IPv4zero}
+
+// loads known_hosts data into a host key db.  lines that cannot be parsed (the known_hosts callback refuses
+// the whole file for one bad line) and the lines in skipLines are blanked, so the line numbers stay the same
+func loadKnownHostsDb(data []byte, skipLines map[int]bool) (*knownhosts.HostKeyDB, error) {
+	lines := bytes.Split(data, []byte("\n"))
+	for idx, line := range lines {
+		if skipLines[idx+1] {
+			lines[idx] = nil
+			continue
+		}
+		if _, _, _, _, _, err := ssh.ParseKnownHosts(line); err != nil {
+			lines[idx] = nil
+		}
+	}
+	tempFile, err := os.CreateTemp("", "known_hosts")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tempFile.Name())
+	_, err = tempFile.Write(bytes.Join(lines, []byte("\n")))
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	// NewDB reads the whole file, the temp file is not used after it returns
+	return knownhosts.NewDB(tempFile.Name())
+}
+
+// host is normalized (see xknownhosts.Normalize).  a line is exact when it names host (plain or hashed), and
+// not only through a wildcard pattern.  hashed patterns are never wildcards
+func makeKnownHostsEntry(fileName string, lines [][]byte, knownKey xknownhosts.KnownKey, host string) KnownHostsEntry {
+	marker, hosts, _, _, _, _ := ssh.ParseKnownHosts(lines[knownKey.Line-1])
+	entry := KnownHostsEntry{File: fileName, LineNum: knownKey.Line, Marker: marker, Hosts: hosts, Key: knownKey.Key, Exact: true}
+	hasWildcard := false
+	namesHost := false
+	for _, hostPattern := range hosts {
+		if strings.HasPrefix(hostPattern, knownHostsHashMagic) {
+			entry.Hashed = true
+		}
+		if strings.HasPrefix(hostPattern, "!") {
+			continue
+		}
+		if strings.ContainsAny(hostPattern, "*?") {
+			hasWildcard = true
+		} else if hostPattern == host {
+			namesHost = true
+		}
+	}
+	if hasWildcard && !namesHost {
+		entry.Exact = false
+	}
+	return entry
+}
+
+// the lines for address (host:port) in the known_hosts data, as found by the known_hosts callback.  the
+// callback returns one line per key type, so it is run again without the lines already found until it
+// finds no more
+func parseKnownHostsEntries(fileName string, data []byte, address string) ([]KnownHostsEntry, error) {
+	lines := bytes.Split(data, []byte("\n"))
+	host := xknownhosts.Normalize(address)
+	found := make(map[int]bool)
+	var rtn []KnownHostsEntry
+	for {
+		keyDb, err := loadKnownHostsDb(data, found)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read %s: %w", fileName, err)
+		}
+		var keyErr *xknownhosts.KeyError
+		err = keyDb.HostKeyCallback()(address, probeRemoteAddr, probeHostKey{})
+		if !errors.As(err, &keyErr) || len(keyErr.Want) == 0 {
+			break
+		}
+		for _, knownKey := range keyErr.Want {
+			found[knownKey.Line] = true
+			rtn = append(rtn, makeKnownHostsEntry(fileName, lines, knownKey, host))
+		}
+	}
+	sort.Slice(rtn, func(i int, j int) bool {
+		return rtn[i].LineNum < rtn[j].LineNum
+	})
+	return rtn, nil
+}
+
+// files that cannot be read are skipped
+func readKnownHostsEntries(knownHostsFiles []string, address string) []KnownHostsEntry {
+	var rtn []KnownHostsEntry
+	for _, fileName := range knownHostsFiles {
+		data, err := os.ReadFile(fileName)
+		if err != nil {
+			continue
+		}
+		entries, err := parseKnownHostsEntries(fileName, data, address)
+		if err != nil {
+			log.Printf("warning: %v\n", err)
+			continue
+		}
+		rtn = append(rtn, entries...)
+	}
+	return rtn
+}
+
+// checks key for address with the known_hosts callback of each file.  a revoked key is revoked whatever the
+// other files say, and the @revoked line is returned
+func getHostKeyStatus(knownHostsFiles []string, address string, key ssh.PublicKey) (string, *KnownHostsEntry) {
+	status := HostKeyStatus_Unknown
+	for _, fileName := range knownHostsFiles {
+		data, err := os.ReadFile(fileName)
+		if err != nil {
+			continue
+		}
+		keyDb, err := loadKnownHostsDb(data, nil)
+		if err != nil {
+			log.Printf("warning: cannot read %s: %v\n", fileName, err)
+			continue
+		}
+		err = keyDb.HostKeyCallback()(address, probeRemoteAddr, key)
+		var revokedErr *xknownhosts.RevokedError
+		if errors.As(err, &revokedErr) {
+			entry := makeKnownHostsEntry(fileName, bytes.Split(data, []byte("\n")), revokedErr.Revoked, xknownhosts.Normalize(address))
+			return HostKeyStatus_Revoked, &entry
+		}
+		if err == nil {
+			status = HostKeyStatus_Trusted
+		} else if knownhosts.IsHostKeyChanged(err) && status == HostKeyStatus_Unknown {
+			status = HostKeyStatus_Changed
+		}
+	}
+	return status, nil
+}
+
+func findKnownHostsLinesToRemove(fileName string, data []byte, address string, shouldRemove func(KnownHostsEntry) bool) ([]KnownHostsEntry, error) {
+	entries, err := parseKnownHostsEntries(fileName, data, address)
+	if err != nil {
+		return nil, err
+	}
+	var rtn []KnownHostsEntry
+	for _, entry := range entries {
+		if entry.Marker != "" || !entry.Exact || !shouldRemove(entry) {
+			continue
+		}
+		rtn = append(rtn, entry)
+	}
+	return rtn, nil
+}
+
+// removes the plain (no marker) lines that name address (host:port) exactly (not through a wildcard) and for
+// which shouldRemove returns true.  the whole line is removed, including other host names on it.
+// the file is only opened for writing when it has lines to remove, on error the lines that
+// could not be removed are returned with the error
+func removeKnownHostsLines(fileName string, address string, shouldRemove func(KnownHostsEntry) bool) ([]KnownHostsEntry, error) {
+	data, err := os.ReadFile(fileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	toRemove, err := findKnownHostsLinesToRemove(fileName, data, address, shouldRemove)
+	if err != nil {
+		return nil, err
+	}
+	if len(toRemove) == 0 {
+		return nil, nil
+	}
+	f, err := openKnownHostsForEdit(fileName)
+	if err != nil {
+		return toRemove, err
+	}
+	// do not close writeable files with defer
+	// re-read under the lock, the file may have changed since it was read above
+	data, err = io.ReadAll(f)
+	if err != nil {
+		f.Close()
+		return toRemove, err
+	}
+	removed, err := findKnownHostsLinesToRemove(fileName, data, address, shouldRemove)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if len(removed) == 0 {
+		return nil, f.Close()
+	}
+	removeLines := make(map[int]bool)
+	for _, entry := range removed {
+		removeLines[entry.LineNum] = true
+	}
+	var newData []byte
+	for idx, line := range bytes.SplitAfter(data, []byte("\n")) {
+		if !removeLines[idx+1] {
+			newData = append(newData, line...)
+		}
+	}
+	// the file is opened with O_APPEND, after truncating the write starts at 0
+	err = f.Truncate(0)
+	if err == nil {
+		_, err = f.Write(newData)
+	}
+	if err != nil {
+		f.Close()
+		return removed, err
+	}
+	return removed, f.Close()
+}
+
+func isUnwritableFileErr(err error) bool {
+	return errors.Is(err, fs.ErrPermission) || errors.Is(err, syscall.EROFS)
+}
+
+// runs removeKnownHostsLines on each file.  files that cannot be written (e.g. the global
+// /etc/ssh/ssh_known_hosts for non-root users) are skipped with a warning, their lines are returned as skipped
+func removeKnownHostsLinesFromFiles(knownHostsFiles []string, address string, shouldRemove func(KnownHostsEntry) bool) ([]KnownHostsEntry, []KnownHostsEntry, error) {
+	var removed, skipped []KnownHostsEntry
+	for _, fileName := range knownHostsFiles {
+		fileRemoved, err := removeKnownHostsLines(fileName, address, shouldRemove)
+		if err != nil && isUnwritableFileErr(err) {
+			log.Printf("warning: cannot update %s, skipping %d known_hosts line(s) for %s: %v\n", fileName, len(fileRemoved), xknownhosts.Normalize(address), err)
+			skipped = append(skipped, fileRemoved...)
+			continue
+		}
+		if err != nil {
+			return removed, skipped, fmt.Errorf("cannot update %s: %w", fileName, err)
+		}
+		removed = append(removed, fileRemoved...)
+	}
+	return removed, skipped, nil
+}
+
+// removes all the keys for address (host:port) like ssh-keygen -R, lines that only match through a wildcard pattern
+// are kept.  returns the removed lines, and the lines that were skipped because their file cannot be written
+func ForgetKnownHost(knownHostsFiles []string, address string) ([]KnownHostsEntry, []KnownHostsEntry, error) {
+	return removeKnownHostsLinesFromFiles(knownHostsFiles, address, func(KnownHostsEntry) bool { return true })
+}
+
+// pins key for address (host:port): removes the stale lines for the host with a different key of the same type
+// (keys of other types are kept), then adds the key to the first known_hosts file that can be written.
+// returns the removed and skipped lines like ForgetKnownHost
+func TrustKnownHost(knownHostsFiles []string, address string, key ssh.PublicKey) ([]KnownHostsEntry, []KnownHostsEntry, error) {
+	keyBytes := key.Marshal()
+	rtn, skipped, err := removeKnownHostsLinesFromFiles(knownHostsFiles, address, func(entry KnownHostsEntry) bool {
+		return entry.Key.Type() == key.Type() && !bytes.Equal(entry.Key.Marshal(), keyBytes)
+	})
+	if err != nil {
+		return rtn, skipped, err
+	}
+	if status, _ := getHostKeyStatus(knownHostsFiles, address, key); status == HostKeyStatus_Trusted {
+		return rtn, skipped, nil
+	}
+	newLine := xknownhosts.Line([]string{address}, key)
+	err = fmt.Errorf("no known_hosts files")
+	for _, fileName := range knownHostsFiles {
+		err = writeToKnownHosts(fileName, newLine, nil)
+		if err == nil {
+			return rtn, skipped, nil
+		}
+	}
+	return rtn, skipped, fmt.Errorf("cannot add host key: %w", err)
+}
+
+// the host key the remote presents, fetched with a handshake that stops before authentication (through the
+// jump hosts if there are any).  the same host key algorithms as a real connection are offered
+func fetchHostKey(ctx context.Context, opts *sstore.SSHOpts, sshKeywords *SshKeywords, knownHostsFiles []string, remoteDisplayName string, sshAuthSock string) (ssh.PublicKey, error) {
+	networkAddr := net.JoinHostPort(sshKeywords.HostName, sshKeywords.Port)
+	var hostKeyAlgorithms []string
+	var existingFiles []string
+	for _, fileName := range knownHostsFiles {
+		if _, err := os.Stat(fileName); err == nil {
+			existingFiles = append(existingFiles, fileName)
+		}
+	}
+	if len(existingFiles) > 0 {
+		keyDb, err := knownhosts.NewDB(existingFiles...)
+		if err == nil {
+			hostKeyAlgorithms = keyDb.HostKeyAlgorithms(networkAddr)
+		}
+	}
+	var hostKey ssh.PublicKey
+	clientConfig := &ssh.ClientConfig{
+		User: sshKeywords.User,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			hostKey = key
+			return errHostKeyFetched
+		},
+		HostKeyAlgorithms: hostKeyAlgorithms,
+		Timeout:           HostKeyFetchTimeout,
+	}
+	var conn net.Conn
+	var err error
+	if len(sshKeywords.ProxyJump) > 0 {
+		dialCtx, cancelFn := context.WithTimeout(ctx, HostKeyFetchTimeout)
+		defer cancelFn()
+		jumpClient, closeJumpClients, err := connectJumpHosts(ctx, dialCtx, sshKeywords.ProxyJump, remoteDisplayName, sshAuthSock)
+		if err != nil {
+			return nil, err
+		}
+		defer closeJumpClients()
+		conn, err = jumpClient.DialContext(dialCtx, "tcp", networkAddr)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if sshKeywords.ProxyCommand != "" {
+			return nil, fmt.Errorf("ProxyCommand is not supported (host %s), use ProxyJump instead", opts.SSHHost)
+		}
+		dialer := net.Dialer{Timeout: HostKeyFetchTimeout}
+		conn, err = dialer.DialContext(ctx, "tcp", networkAddr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(HostKeyFetchTimeout))
+	_, _, _, err = ssh.NewClientConn(conn, networkAddr, clientConfig)
+	if hostKey == nil {
+		return nil, fmt.Errorf("cannot get host key from %s: %w", networkAddr, err)
+	}
+	return hostKey, nil
+}
+
+// looks up the remote's known_hosts entries, and when fetchKey is set connects to get the key it presents
+func (wsh *WaveshellProc) GetHostKeyInfo(ctx context.Context, fetchKey bool) (*HostKeyInfo, error) {
+	remoteCopy := wsh.GetRemoteCopy()
+	if remoteCopy.RemoteType != sstore.RemoteTypeSsh || remoteCopy.SSHOpts == nil || remoteCopy.SSHOpts.SSHHost == "" {
+		return nil, fmt.Errorf("host keys are only available for ssh remotes")
+	}
+	opts := remoteCopy.SSHOpts
+	var sshAuthSock string
+	if fetchKey {
+		// only used to authenticate to jump hosts
+		sapi, err := wsh.getBootstrapShellApi()
+		if err != nil {
+			return nil, err
+		}
+		sshAuthSockBytes, _ := exec.CommandContext(ctx, sapi.GetLocalShellPath(), "-c", "echo \"${SSH_AUTH_SOCK}\"").CombinedOutput()
+		sshAuthSock = strings.TrimSpace(string(sshAuthSockBytes))
+	}
+	sshConfigKeywords, err := findSshConfigKeywords(opts.SSHHost, sshAuthSock)
+	if err != nil {
+		return nil, err
+	}
+	sshKeywords, err := combineSshKeywords(opts, sshConfigKeywords)
+	if err != nil {
+		return nil, err
+	}
+	knownHostsFiles, err := findKnownHostsFiles(opts.SSHHost)
+	if err != nil {
+		return nil, err
+	}
+	address := net.JoinHostPort(sshKeywords.HostName, sshKeywords.Port)
+	rtn := &HostKeyInfo{
+		Host:            xknownhosts.Normalize(address),
+		Address:         address,
+		KnownHostsFiles: knownHostsFiles,
+		Entries:         readKnownHostsEntries(knownHostsFiles, address),
+	}
+	if !fetchKey {
+		return rtn, nil
+	}
+	remoteDisplayName := fmt.Sprintf("%s [%s]", remoteCopy.RemoteAlias, remoteCopy.RemoteCanonicalName)
+	rtn.Key, err = fetchHostKey(ctx, opts, sshKeywords, knownHostsFiles, remoteDisplayName, sshAuthSock)
+	if err != nil {
+		return nil, err
+	}
+	var revokedEntry *KnownHostsEntry
+	rtn.Status, revokedEntry = getHostKeyStatus(knownHostsFiles, address, rtn.Key)
+	if revokedEntry != nil {
+		rtn.Entries = append(rtn.Entries, *revokedEntry)
+	}
+	return rtn, nil
+}
@@ -0,0 +1,162 @@
+package remote
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	xknownhosts "golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestParseKnownHostsEntries(t *testing.T) {
+	key := makeTestSigner(t).PublicKey()
+	keyStr := strings.TrimPrefix(xknownhosts.Line([]string{"x"}, key), "x ")
+	tests := []struct {
+		hosts   string
+		address string
+		match   bool
+		exact   bool
+	}{
+		{"web1,10.0.0.5", "web1:22", true, true},
+		{"web1", "web1:2222", false, false},
+		{"[web1]:2222", "web1:2222", true, true},
+		{"*.example.com", "a.example.com:22", true, false},
+		{"*.example.com,a.example.com", "a.example.com:22", true, true},
+		{"*.example.com", "a.example.com:2222", true, false},
+		{"web?", "web12:22", false, false},
+		{"*.example.com,!b.example.com", "b.example.com:22", false, false},
+		{xknownhosts.HashHostname("web1"), "web1:22", true, true},
+		{xknownhosts.HashHostname("web2"), "web1:22", false, false},
+	}
+	for _, test := range tests {
+		// the unparseable line is skipped, the line numbers are kept
+		data := []byte("# comment\nnot a known_hosts line\n" + test.hosts + " " + keyStr + "\n")
+		entries, err := parseKnownHostsEntries("known_hosts", data, test.address)
+		if err != nil {
+			t.Fatalf("%q %q: %v", test.hosts, test.address, err)
+		}
+		match := len(entries) == 1
+		exact := match && entries[0].Exact
+		if match != test.match || exact != test.exact || (match && entries[0].LineNum != 3) {
+			t.Errorf("%q %q: got %v, expected match %v exact %v", test.hosts, test.address, entries, test.match, test.exact)
+		}
+	}
+}
+
+func TestForgetAndTrustKnownHost(t *testing.T) {
+	oldKey := makeTestSigner(t).PublicKey()
+	newKey := makeTestSigner(t).PublicKey()
+	otherKey := makeTestSigner(t).PublicKey()
+	// the known_hosts callback takes the first line of each key type, so a @cert-authority line with the
+	// same key type as the host key would hide the host's plain lines (as it does on connect)
+	caKey := makeTestEcdsaKey(t)
+	fileName := filepath.Join(t.TempDir(), "known_hosts")
+	content := "# comment\n" +
+		xknownhosts.Line([]string{"web1"}, oldKey) + "\n" +
+		xknownhosts.Line([]string{"other"}, otherKey) + "\n" +
+		xknownhosts.HashHostname("web1") + " " + strings.TrimPrefix(xknownhosts.Line([]string{"x"}, oldKey), "x ") + "\n" +
+		xknownhosts.Line([]string{"*.example.com,web1"}, otherKey) + "\n" +
+		"@cert-authority web1 " + strings.TrimPrefix(xknownhosts.Line([]string{"x"}, caKey), "x ") + "\n"
+	os.WriteFile(fileName, []byte(content), 0644)
+	files := []string{fileName}
+
+	// the duplicate lines with the same key type are all found
+	entries := readKnownHostsEntries(files, "web1:22")
+	if len(entries) != 4 || !entries[1].Hashed || entries[3].Marker != "cert-authority" {
+		t.Fatalf("bad entries %v", entries)
+	}
+	if status, _ := getHostKeyStatus(files, "web1:22", oldKey); status != HostKeyStatus_Trusted {
+		t.Errorf("old key should be trusted, got %s", status)
+	}
+	if status, _ := getHostKeyStatus(files, "web1:22", newKey); status != HostKeyStatus_Changed {
+		t.Errorf("new key should be changed, got %s", status)
+	}
+	if status, _ := getHostKeyStatus(nil, "web1:22", newKey); status != HostKeyStatus_Unknown {
+		t.Errorf("no known_hosts should be unknown, got %s", status)
+	}
+
+	removed, _, err := TrustKnownHost(files, "web1:22", newKey)
+	if err != nil {
+		t.Fatalf("trust error: %v", err)
+	}
+	// the plain and hashed lines with the old key and the "*.example.com,web1" line (same key type)
+	if len(removed) != 3 {
+		t.Errorf("expected 3 removed lines, got %v", removed)
+	}
+	data, _ := os.ReadFile(fileName)
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 4 || lines[0] != "# comment" || !strings.HasPrefix(lines[1], "other ") || !strings.HasPrefix(lines[2], "@cert-authority") {
+		t.Errorf("bad known_hosts after trust:\n%s", data)
+	}
+	if status, _ := getHostKeyStatus(files, "web1:22", newKey); status != HostKeyStatus_Trusted {
+		t.Errorf("new key should be trusted, got %s", status)
+	}
+
+	removed, _, err = ForgetKnownHost(files, "web1:22")
+	if err != nil || len(removed) != 1 {
+		t.Fatalf("forget: %v %v", removed, err)
+	}
+	if entries := readKnownHostsEntries(files, "other:22"); len(entries) != 1 || !keysEqual(entries[0].Key, otherKey) {
+		t.Errorf("other host should be kept: %v", entries)
+	}
+	if removed, _, err := ForgetKnownHost([]string{filepath.Join(t.TempDir(), "missing")}, "web1:22"); err != nil || len(removed) != 0 {
+		t.Errorf("missing file: %v %v", removed, err)
+	}
+
+	revokedFile := filepath.Join(t.TempDir(), "known_hosts")
+	os.WriteFile(revokedFile, []byte(xknownhosts.Line([]string{"web1"}, oldKey)+"\n@revoked * "+strings.TrimPrefix(xknownhosts.Line([]string{"x"}, oldKey), "x ")+"\n"), 0644)
+	status, revokedEntry := getHostKeyStatus([]string{fileName, revokedFile}, "web1:22", oldKey)
+	if status != HostKeyStatus_Revoked || revokedEntry == nil || revokedEntry.Marker != "revoked" || revokedEntry.LineNum != 2 {
+		t.Errorf("old key should be revoked, got %s %v", status, revokedEntry)
+	}
+}
+
+func makeTestEcdsaKey(t *testing.T) ssh.PublicKey {
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("cannot generate key: %v", err)
+	}
+	key, err := ssh.NewPublicKey(&privKey.PublicKey)
+	if err != nil {
+		t.Fatalf("cannot create public key: %v", err)
+	}
+	return key
+}
+
+func TestForgetKnownHostUnwritableFile(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("file permissions are not enforced for root")
+	}
+	key := makeTestSigner(t).PublicKey()
+	dir := t.TempDir()
+	globalFile := filepath.Join(dir, "ssh_known_hosts")
+	userFile := filepath.Join(dir, "known_hosts")
+	os.WriteFile(globalFile, []byte(xknownhosts.Line([]string{"web1"}, key)+"\n"), 0444)
+	os.WriteFile(userFile, []byte(xknownhosts.Line([]string{"web1"}, key)+"\n"), 0644)
+	removed, skipped, err := ForgetKnownHost([]string{userFile, globalFile}, "web1:22")
+	if err != nil || len(removed) != 1 || len(skipped) != 1 || skipped[0].File != globalFile {
+		t.Fatalf("forget: removed %v, skipped %v, err %v", removed, skipped, err)
+	}
+	// files without lines for the host are never opened for writing
+	removed, skipped, err = ForgetKnownHost([]string{globalFile}, "other:22")
+	if err != nil || len(removed) != 0 || len(skipped) != 0 {
+		t.Errorf("forget other: removed %v, skipped %v, err %v", removed, skipped, err)
+	}
+	newKey := makeTestSigner(t).PublicKey()
+	removed, skipped, err = TrustKnownHost([]string{userFile, globalFile}, "web1:22", newKey)
+	if err != nil || len(removed) != 0 || len(skipped) != 1 {
+		t.Fatalf("trust: removed %v, skipped %v, err %v", removed, skipped, err)
+	}
+	if status, _ := getHostKeyStatus([]string{userFile, globalFile}, "web1:22", newKey); status != HostKeyStatus_Trusted {
+		t.Errorf("new key should be trusted, got %s", status)
+	}
+}
+
+func keysEqual(k1 ssh.PublicKey, k2 ssh.PublicKey) bool {
+	return string(k1.Marshal()) == string(k2.Marshal())
+}
@@ -0,0 +1,255 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// AI provider for a local (or self-hosted) ollama server, uses the native /api/chat endpoint.
+package ollama
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/abhishek944/waveterm/waveshell/pkg/packet"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/remote/aiprovider"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+)
+
+const ProviderName = "ollama"
+const DefaultBaseURL = "http://localhost:11434"
+const DefaultModel = "llama3"
+const DefaultMaxTokens = 1000
+const ChatEndpoint = "/api/chat"
+const MaxErrorBodySize = 4096
+const MaxLineSize = 1024 * 1024
+
+type Provider struct{}
+
+func init() {
+	aiprovider.RegisterProvider(Provider{})
+}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatOptions struct {
+	NumPredict int `json:"num_predict,omitempty"`
+}
+
+type chatRequest struct {
+	Model    string        `json:"model"`
+	Messages []chatMessage `json:"messages"`
+	Stream   bool          `json:"stream"`
+	Options  *chatOptions  `json:"options,omitempty"`
+}
+
+// one line of the NDJSON stream (or the whole response when stream is false)
+type chatResponse struct {
+	Model           string       `json:"model"`
+	CreatedAt       string       `json:"created_at"`
+	Message         *chatMessage `json:"message"`
+	Done            bool         `json:"done"`
+	DoneReason      string       `json:"done_reason"`
+	PromptEvalCount int          `json:"prompt_eval_count"`
+	EvalCount       int          `json:"eval_count"`
+	Error           string       `json:"error"`
+}
+
+func (Provider) Name() string {
+	return ProviderName
+}
+
+func (Provider) RunCompletion(ctx context.Context, aiOpts *sstore.AIOptsType, prompt []packet.OpenAIPromptMessageType) ([]*packet.OpenAIPacketType, error) {
+	opts := getOpts(aiOpts)
+	return RunCompletion(ctx, opts, prompt)
+}
+
+func (Provider) RunCompletionStream(ctx context.Context, aiOpts *sstore.AIOptsType, prompt []packet.OpenAIPromptMessageType) (chan *packet.OpenAIPacketType, error) {
+	opts := getOpts(aiOpts)
+	return RunCompletionStream(ctx, opts, prompt)
+}
+
+// ollama needs no api key, without an ollama config block the defaults (local server, default model) are used
+func getOpts(aiOpts *sstore.AIOptsType) *sstore.OllamaOptsType {
+	var opts sstore.OllamaOptsType
+	if aiOpts != nil && aiOpts.Ollama != nil {
+		opts = *aiOpts.Ollama
+	}
+	if opts.BaseURL == "" {
+		opts.BaseURL = DefaultBaseURL
+	}
+	if opts.Model == "" {
+		opts.Model = DefaultModel
+	}
+	if opts.MaxTokens == 0 {
+		opts.MaxTokens = DefaultMaxTokens
+	}
+	return &opts
+}
+
+func ConvertPromptMessages(prompt []packet.OpenAIPromptMessageType) []chatMessage {
+	var messages []chatMessage
+	for _, p := range prompt {
+		messages = append(messages, chatMessage{Role: p.Role, Content: p.Content})
+	}
+	return messages
+}
+
+func parseCreatedTs(createdAt string) int64 {
+	ts, err := time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return 0
+	}
+	return ts.Unix()
+}
+
+func convertUsage(resp *chatResponse) *packet.OpenAIUsageType {
+	if resp.PromptEvalCount == 0 && resp.EvalCount == 0 {
+		return nil
+	}
+	return &packet.OpenAIUsageType{
+		PromptTokens:     resp.PromptEvalCount,
+		CompletionTokens: resp.EvalCount,
+		TotalTokens:      resp.PromptEvalCount + resp.EvalCount,
+	}
+}
+
+func doChatRequest(ctx context.Context, opts *sstore.OllamaOptsType, prompt []packet.OpenAIPromptMessageType, stream bool) (*http.Response, error) {
+	if opts == nil {
+		return nil, fmt.Errorf("no ollama opts found")
+	}
+	if opts.Model == "" {
+		return nil, fmt.Errorf("no ollama model specified")
+	}
+	if len(prompt) == 0 {
+		return nil, fmt.Errorf("no prompt provided")
+	}
+	reqBody := chatRequest{
+		Model:    opts.Model,
+		Messages: ConvertPromptMessages(prompt),
+		Stream:   stream,
+	}
+	if opts.MaxTokens > 0 {
+		reqBody.Options = &chatOptions{NumPredict: opts.MaxTokens}
+	}
+	return postChat(ctx, opts, reqBody)
+}
+
+// reqBody is a chatRequest or a toolChatRequest
+func postChat(ctx context.Context, opts *sstore.OllamaOptsType, reqBody any) (*http.Response, error) {
+	barr, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode ollama request: %v", err)
+	}
+	url := strings.TrimRight(opts.BaseURL, "/") + ChatEndpoint
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(barr))
+	if err != nil {
+		return nil, fmt.Errorf("cannot create ollama request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error calling ollama API: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		errBody, _ := io.ReadAll(io.LimitReader(resp.Body, MaxErrorBodySize))
+		var errResp chatResponse
+		if json.Unmarshal(errBody, &errResp) == nil && errResp.Error != "" {
+			return nil, fmt.Errorf("error calling ollama API (%s): %s", resp.Status, errResp.Error)
+		}
+		return nil, fmt.Errorf("error calling ollama API (%s): %s", resp.Status, strings.TrimSpace(string(errBody)))
+	}
+	return resp, nil
+}
+
+func RunCompletion(ctx context.Context, opts *sstore.OllamaOptsType, prompt []packet.OpenAIPromptMessageType) ([]*packet.OpenAIPacketType, error) {
+	resp, err := doChatRequest(ctx, opts, prompt, false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var chatResp chatResponse
+	err = json.NewDecoder(resp.Body).Decode(&chatResp)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode ollama response: %v", err)
+	}
+	if chatResp.Error != "" {
+		return nil, fmt.Errorf("ollama error: %s", chatResp.Error)
+	}
+	var rtn []*packet.OpenAIPacketType
+	headerPk := packet.MakeOpenAIPacket()
+	headerPk.Model = chatResp.Model
+	headerPk.Created = parseCreatedTs(chatResp.CreatedAt)
+	headerPk.Usage = convertUsage(&chatResp)
+	rtn = append(rtn, headerPk)
+	choicePk := packet.MakeOpenAIPacket()
+	if chatResp.Message != nil {
+		choicePk.Text = chatResp.Message.Content
+	}
+	choicePk.FinishReason = chatResp.DoneReason
+	rtn = append(rtn, choicePk)
+	return rtn, nil
+}
+
+func RunCompletionStream(ctx context.Context, opts *sstore.OllamaOptsType, prompt []packet.OpenAIPromptMessageType) (chan *packet.OpenAIPacketType, error) {
+	resp, err := doChatRequest(ctx, opts, prompt, true)
+	if err != nil {
+		return nil, err
+	}
+	rtn := make(chan *packet.OpenAIPacketType, aiprovider.DefaultStreamChanSize)
+	go func() {
+		defer close(rtn)
+		defer resp.Body.Close()
+		sentHeader := false
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 0, 64*1024), MaxLineSize)
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			var chunk chatResponse
+			err := json.Unmarshal(line, &chunk)
+			if err != nil {
+				rtn <- aiprovider.CreateErrorPacket(fmt.Sprintf("error decoding ollama stream: %v", err))
+				return
+			}
+			if chunk.Error != "" {
+				rtn <- aiprovider.CreateErrorPacket(fmt.Sprintf("ollama error: %s", chunk.Error))
+				return
+			}
+			if !sentHeader {
+				pk := packet.MakeOpenAIPacket()
+				pk.Model = chunk.Model
+				pk.Created = parseCreatedTs(chunk.CreatedAt)
+				rtn <- pk
+				sentHeader = true
+			}
+			if chunk.Message != nil && chunk.Message.Content != "" {
+				rtn <- aiprovider.CreateTextPacket(chunk.Message.Content)
+			}
+			if chunk.Done {
+				pk := packet.MakeOpenAIPacket()
+				pk.FinishReason = chunk.DoneReason
+				if pk.FinishReason == "" {
+					pk.FinishReason = "stop"
+				}
+				pk.Usage = convertUsage(&chunk)
+				rtn <- pk
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			rtn <- aiprovider.CreateErrorPacket(fmt.Sprintf("error in streaming: %v", err))
+		}
+	}()
+	return rtn, nil
+}
@@ -0,0 +1,181 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package ollama
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/abhishek944/waveterm/waveshell/pkg/packet"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/remote/aiprovider"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+)
+
+var testPrompt = []packet.OpenAIPromptMessageType{
+	{Role: "system", Content: "you are a test"},
+	{Role: "user", Content: "hello"},
+}
+
+func makeStubServer(t *testing.T, handler func(w http.ResponseWriter, req *chatRequest)) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != ChatEndpoint || r.Method != http.MethodPost {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		var req chatRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			t.Errorf("cannot decode request: %v", err)
+		}
+		handler(w, &req)
+	}))
+}
+
+func TestRunCompletionStream(t *testing.T) {
+	server := makeStubServer(t, func(w http.ResponseWriter, req *chatRequest) {
+		if !req.Stream || req.Model != "testmodel" || len(req.Messages) != 2 || req.Messages[1].Content != "hello" {
+			t.Errorf("bad request: %#v", req)
+		}
+		fmt.Fprintf(w, `{"model":"testmodel","created_at":"2024-01-02T03:04:05Z","message":{"role":"assistant","content":"Hel"},"done":false}`+"\n")
+		fmt.Fprintf(w, `{"model":"testmodel","created_at":"2024-01-02T03:04:05Z","message":{"role":"assistant","content":"lo!"},"done":false}`+"\n")
+		fmt.Fprintf(w, `{"model":"testmodel","created_at":"2024-01-02T03:04:05Z","message":{"role":"assistant","content":""},"done":true,"done_reason":"stop","prompt_eval_count":7,"eval_count":3}`+"\n")
+	})
+	defer server.Close()
+	aiOpts := &sstore.AIOptsType{Ollama: &sstore.OllamaOptsType{BaseURL: server.URL, Model: "testmodel"}}
+	provider := aiprovider.GetProvider(ProviderName)
+	if provider == nil {
+		t.Fatalf("ollama provider not registered")
+	}
+	ch, err := provider.RunCompletionStream(context.Background(), aiOpts, testPrompt)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var text string
+	var pks []*packet.OpenAIPacketType
+	for pk := range ch {
+		if pk.Error != "" {
+			t.Fatalf("error packet: %s", pk.Error)
+		}
+		text += pk.Text
+		pks = append(pks, pk)
+	}
+	if text != "Hello!" {
+		t.Errorf("bad text %q", text)
+	}
+	if pks[0].Model != "testmodel" || pks[0].Created == 0 {
+		t.Errorf("bad header packet: %#v", pks[0])
+	}
+	lastPk := pks[len(pks)-1]
+	if lastPk.FinishReason != "stop" || lastPk.Usage == nil || lastPk.Usage.TotalTokens != 10 {
+		t.Errorf("bad final packet: %#v", lastPk)
+	}
+}
+
+func TestRunCompletion(t *testing.T) {
+	server := makeStubServer(t, func(w http.ResponseWriter, req *chatRequest) {
+		if req.Stream {
+			t.Errorf("expected non-streaming request")
+		}
+		if req.Model != DefaultModel {
+			t.Errorf("expected default model, got %q", req.Model)
+		}
+		fmt.Fprintf(w, `{"model":"%s","message":{"role":"assistant","content":"hi there"},"done":true,"done_reason":"stop"}`, req.Model)
+	})
+	defer server.Close()
+	aiOpts := &sstore.AIOptsType{Ollama: &sstore.OllamaOptsType{BaseURL: server.URL}}
+	pks, err := Provider{}.RunCompletion(context.Background(), aiOpts, testPrompt)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(pks) != 2 || pks[1].Text != "hi there" || pks[1].FinishReason != "stop" {
+		t.Errorf("bad response packets: %#v", pks)
+	}
+}
+
+func TestRunCompletionErrors(t *testing.T) {
+	server := makeStubServer(t, func(w http.ResponseWriter, req *chatRequest) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `{"error":"model 'nope' not found"}`)
+	})
+	defer server.Close()
+	aiOpts := &sstore.AIOptsType{Ollama: &sstore.OllamaOptsType{BaseURL: server.URL, Model: "nope"}}
+	_, err := Provider{}.RunCompletionStream(context.Background(), aiOpts, testPrompt)
+	if err == nil {
+		t.Errorf("expected error for 404 response")
+	}
+	_, err = Provider{}.RunCompletion(context.Background(), &sstore.AIOptsType{}, testPrompt)
+	if err == nil {
+		t.Errorf("expected error for unconfigured provider")
+	}
+}
+
+func TestStreamErrorLine(t *testing.T) {
+	server := makeStubServer(t, func(w http.ResponseWriter, req *chatRequest) {
+		fmt.Fprintf(w, `{"model":"m","message":{"role":"assistant","content":"a"},"done":false}`+"\n")
+		fmt.Fprintf(w, `{"error":"out of memory"}`+"\n")
+	})
+	defer server.Close()
+	aiOpts := &sstore.AIOptsType{Ollama: &sstore.OllamaOptsType{BaseURL: server.URL, Model: "m"}}
+	ch, err := Provider{}.RunCompletionStream(context.Background(), aiOpts, testPrompt)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var gotErr bool
+	for pk := range ch {
+		if pk.Error != "" {
+			gotErr = true
+		}
+	}
+	if !gotErr {
+		t.Errorf("expected error packet")
+	}
+}
+
+func TestRunToolCompletion(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req toolChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Stream || len(req.Tools) != 1 || req.Tools[0].Type != "function" || req.Tools[0].Function.Name != "read_file" {
+			t.Errorf("bad tools %#v", req.Tools)
+		}
+		if len(req.Messages) != 3 || len(req.Messages[1].ToolCalls) != 1 || string(req.Messages[1].ToolCalls[0].Function.Arguments) != `{"path":"a.txt"}` || req.Messages[2].Role != "tool" {
+			t.Errorf("bad messages %#v", req.Messages)
+		}
+		fmt.Fprint(w, `{"model":"testmodel","message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"read_file","arguments":{"path":"b.txt"}}}]},"done":true,"done_reason":"stop","prompt_eval_count":5,"eval_count":2}`)
+	}))
+	defer server.Close()
+	aiOpts := &sstore.AIOptsType{Ollama: &sstore.OllamaOptsType{BaseURL: server.URL, Model: "testmodel"}}
+	messages := []*aiprovider.ToolMessage{
+		{Role: aiprovider.ToolRoleUser, Content: "read a.txt"},
+		{Role: aiprovider.ToolRoleAssistant, ToolCalls: []*aiprovider.ToolCall{{Id: "call_0", Name: "read_file", Args: `{"path":"a.txt"}`}}},
+		{Role: aiprovider.ToolRoleTool, ToolCallId: "call_0", Content: "hello"},
+	}
+	tools := []*aiprovider.ToolDef{{Name: "read_file", Parameters: map[string]any{"type": "object"}}}
+	resp, err := Provider{}.RunToolCompletion(context.Background(), aiOpts, messages, tools)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(resp.ToolCalls) != 1 || resp.ToolCalls[0].Name != "read_file" || resp.ToolCalls[0].Args != `{"path":"b.txt"}` {
+		t.Errorf("bad tool calls %#v", resp.ToolCalls)
+	}
+	if resp.Usage == nil || resp.Usage.TotalTokens != 7 {
+		t.Errorf("bad usage %#v", resp.Usage)
+	}
+}
+
+func TestGetOptsDefaults(t *testing.T) {
+	for _, aiOpts := range []*sstore.AIOptsType{nil, {}} {
+		opts := getOpts(aiOpts)
+		if opts.BaseURL != DefaultBaseURL || opts.Model != DefaultModel || opts.MaxTokens != DefaultMaxTokens {
+			t.Errorf("expected default opts, got %#v", opts)
+		}
+	}
+	opts := getOpts(&sstore.AIOptsType{Ollama: &sstore.OllamaOptsType{Model: "mistral"}})
+	if opts.BaseURL != DefaultBaseURL || opts.Model != "mistral" {
+		t.Errorf("unexpected opts %#v", opts)
+	}
+}
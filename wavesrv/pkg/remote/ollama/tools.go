@@ -0,0 +1,128 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package ollama
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/abhishek944/waveterm/wavesrv/pkg/remote/aiprovider"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+)
+
+type toolFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  map[string]any  `json:"parameters,omitempty"`
+	Arguments   json.RawMessage `json:"arguments,omitempty"`
+}
+
+type toolSpec struct {
+	Type     string        `json:"type,omitempty"`
+	Function *toolFunction `json:"function"`
+}
+
+type toolChatMessage struct {
+	Role      string      `json:"role"`
+	Content   string      `json:"content"`
+	ToolCalls []*toolSpec `json:"tool_calls,omitempty"`
+}
+
+type toolChatRequest struct {
+	Model    string             `json:"model"`
+	Messages []*toolChatMessage `json:"messages"`
+	Tools    []*toolSpec        `json:"tools,omitempty"`
+	Stream   bool               `json:"stream"`
+	Options  *chatOptions       `json:"options,omitempty"`
+}
+
+type toolChatResponse struct {
+	Model           string           `json:"model"`
+	Message         *toolChatMessage `json:"message"`
+	DoneReason      string           `json:"done_reason"`
+	PromptEvalCount int              `json:"prompt_eval_count"`
+	EvalCount       int              `json:"eval_count"`
+	Error           string           `json:"error"`
+}
+
+func (Provider) RunToolCompletion(ctx context.Context, aiOpts *sstore.AIOptsType, messages []*aiprovider.ToolMessage, tools []*aiprovider.ToolDef) (*aiprovider.ToolResponse, error) {
+	opts := getOpts(aiOpts)
+	return RunToolCompletion(ctx, opts, messages, tools)
+}
+
+// ollama does not use tool call ids, results are matched to calls by order
+func ConvertToolMessages(messages []*aiprovider.ToolMessage) []*toolChatMessage {
+	var rtn []*toolChatMessage
+	for _, m := range messages {
+		cm := &toolChatMessage{Role: m.Role, Content: m.Content}
+		for _, tc := range m.ToolCalls {
+			args := json.RawMessage(tc.Args)
+			if !json.Valid(args) {
+				args = json.RawMessage("{}")
+			}
+			cm.ToolCalls = append(cm.ToolCalls, &toolSpec{Function: &toolFunction{Name: tc.Name, Arguments: args}})
+		}
+		rtn = append(rtn, cm)
+	}
+	return rtn
+}
+
+func RunToolCompletion(ctx context.Context, opts *sstore.OllamaOptsType, messages []*aiprovider.ToolMessage, tools []*aiprovider.ToolDef) (*aiprovider.ToolResponse, error) {
+	if opts == nil {
+		return nil, fmt.Errorf("no ollama opts found")
+	}
+	if opts.Model == "" {
+		return nil, fmt.Errorf("no ollama model specified")
+	}
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("no prompt provided")
+	}
+	reqBody := toolChatRequest{
+		Model:    opts.Model,
+		Messages: ConvertToolMessages(messages),
+		Stream:   false,
+	}
+	for _, tool := range tools {
+		reqBody.Tools = append(reqBody.Tools, &toolSpec{
+			Type:     "function",
+			Function: &toolFunction{Name: tool.Name, Description: tool.Description, Parameters: tool.Parameters},
+		})
+	}
+	if opts.MaxTokens > 0 {
+		reqBody.Options = &chatOptions{NumPredict: opts.MaxTokens}
+	}
+	resp, err := postChat(ctx, opts, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var chatResp toolChatResponse
+	err = json.NewDecoder(resp.Body).Decode(&chatResp)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode ollama response: %v", err)
+	}
+	if chatResp.Error != "" {
+		return nil, fmt.Errorf("ollama error: %s", chatResp.Error)
+	}
+	rtn := &aiprovider.ToolResponse{
+		Model:        chatResp.Model,
+		FinishReason: chatResp.DoneReason,
+		Usage:        convertUsage(&chatResponse{PromptEvalCount: chatResp.PromptEvalCount, EvalCount: chatResp.EvalCount}),
+	}
+	if chatResp.Message != nil {
+		rtn.Text = chatResp.Message.Content
+		for idx, tc := range chatResp.Message.ToolCalls {
+			if tc.Function == nil {
+				continue
+			}
+			args := string(tc.Function.Arguments)
+			if args == "" {
+				args = "{}"
+			}
+			rtn.ToolCalls = append(rtn.ToolCalls, &aiprovider.ToolCall{Id: fmt.Sprintf("call_%d", idx), Name: tc.Function.Name, Args: args})
+		}
+	}
+	return rtn, nil
+}
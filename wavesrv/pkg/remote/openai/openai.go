@@ -12,6 +12,7 @@ import (
 	"github.com/openai/openai-go/v2/packages/param"
 	"github.com/openai/openai-go/v2/shared"
 	"github.com/abhishek944/waveterm/waveshell/pkg/packet"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/remote/aiprovider"
 	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
 )
 
@@ -19,6 +20,48 @@ const DefaultMaxTokens = 1000
 const DefaultModel = "gpt-3.5-turbo"
 const DefaultStreamChanSize = 10
 
+const ProviderName = "openai"
+
+type Provider struct{}
+
+func init() {
+	aiprovider.RegisterProvider(Provider{})
+}
+
+func (Provider) Name() string {
+	return ProviderName
+}
+
+func (Provider) getOpts(aiOpts *sstore.AIOptsType) (*sstore.OpenAIOptsType, error) {
+	if aiOpts == nil || aiOpts.OpenAI == nil {
+		return nil, fmt.Errorf("OpenAI options not configured")
+	}
+	opts := *aiOpts.OpenAI
+	if opts.Model == "" {
+		opts.Model = DefaultModel
+	}
+	if opts.MaxTokens == 0 {
+		opts.MaxTokens = DefaultMaxTokens
+	}
+	return &opts, nil
+}
+
+func (p Provider) RunCompletion(ctx context.Context, aiOpts *sstore.AIOptsType, prompt []packet.OpenAIPromptMessageType) ([]*packet.OpenAIPacketType, error) {
+	opts, err := p.getOpts(aiOpts)
+	if err != nil {
+		return nil, err
+	}
+	return RunCompletion(ctx, opts, prompt)
+}
+
+func (p Provider) RunCompletionStream(ctx context.Context, aiOpts *sstore.AIOptsType, prompt []packet.OpenAIPromptMessageType) (chan *packet.OpenAIPacketType, error) {
+	opts, err := p.getOpts(aiOpts)
+	if err != nil {
+		return nil, err
+	}
+	return RunCompletionStream(ctx, opts, prompt)
+}
+
 func convertUsage(usage openai.CompletionUsage) *packet.OpenAIUsageType {
 	return &packet.OpenAIUsageType{
 		PromptTokens:     int(usage.PromptTokens),
@@ -141,7 +184,7 @@ func RunCompletionStream(ctx context.Context, opts *sstore.OpenAIOptsType, promp
 		}
 		
 		if err := stream.Err(); err != nil {
-			errPk := CreateErrorPacket(fmt.Sprintf("error in streaming: %v", err))
+			errPk := aiprovider.CreateErrorPacket(fmt.Sprintf("error in streaming: %v", err))
 			rtn <- errPk
 		}
 	}()
@@ -168,16 +211,3 @@ func marshalResponse(resp *openai.ChatCompletion) []*packet.OpenAIPacketType {
 	}
 	return rtn
 }
-
-func CreateErrorPacket(errStr string) *packet.OpenAIPacketType {
-	errPk := packet.MakeOpenAIPacket()
-	errPk.FinishReason = "error"
-	errPk.Error = errStr
-	return errPk
-}
-
-func CreateTextPacket(text string) *packet.OpenAIPacketType {
-	pk := packet.MakeOpenAIPacket()
-	pk.Text = text
-	return pk
-}
\ No newline at end of file
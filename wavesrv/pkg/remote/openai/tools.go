@@ -0,0 +1,109 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package openai
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/abhishek944/waveterm/wavesrv/pkg/remote/aiprovider"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+	"github.com/openai/openai-go/v2"
+	"github.com/openai/openai-go/v2/option"
+	"github.com/openai/openai-go/v2/packages/param"
+	"github.com/openai/openai-go/v2/shared"
+)
+
+func (p Provider) RunToolCompletion(ctx context.Context, aiOpts *sstore.AIOptsType, messages []*aiprovider.ToolMessage, tools []*aiprovider.ToolDef) (*aiprovider.ToolResponse, error) {
+	opts, err := p.getOpts(aiOpts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.APIToken == "" {
+		return nil, fmt.Errorf("no api token")
+	}
+	clientOpts := []option.RequestOption{
+		option.WithAPIKey(opts.APIToken),
+	}
+	if opts.BaseURL != "" {
+		clientOpts = append(clientOpts, option.WithBaseURL(opts.BaseURL))
+	}
+	client := openai.NewClient(clientOpts...)
+	return RunToolCompletionWithClient(ctx, client, opts.Model, opts.MaxTokens, messages, tools)
+}
+
+func ConvertToolMessages(messages []*aiprovider.ToolMessage) []openai.ChatCompletionMessageParamUnion {
+	var rtn []openai.ChatCompletionMessageParamUnion
+	for _, m := range messages {
+		switch m.Role {
+		case aiprovider.ToolRoleSystem:
+			rtn = append(rtn, openai.SystemMessage(m.Content))
+		case aiprovider.ToolRoleUser:
+			rtn = append(rtn, openai.UserMessage(m.Content))
+		case aiprovider.ToolRoleTool:
+			rtn = append(rtn, openai.ToolMessage(m.Content, m.ToolCallId))
+		case aiprovider.ToolRoleAssistant:
+			asst := openai.ChatCompletionAssistantMessageParam{}
+			if m.Content != "" {
+				asst.Content.OfString = param.NewOpt(m.Content)
+			}
+			for _, tc := range m.ToolCalls {
+				asst.ToolCalls = append(asst.ToolCalls, openai.ChatCompletionMessageToolCallUnionParam{
+					OfFunction: &openai.ChatCompletionMessageFunctionToolCallParam{
+						ID: tc.Id,
+						Function: openai.ChatCompletionMessageFunctionToolCallFunctionParam{
+							Name:      tc.Name,
+							Arguments: tc.Args,
+						},
+					},
+				})
+			}
+			rtn = append(rtn, openai.ChatCompletionMessageParamUnion{OfAssistant: &asst})
+		}
+	}
+	return rtn
+}
+
+func ConvertToolDefs(tools []*aiprovider.ToolDef) []openai.ChatCompletionToolUnionParam {
+	var rtn []openai.ChatCompletionToolUnionParam
+	for _, tool := range tools {
+		rtn = append(rtn, openai.ChatCompletionFunctionTool(shared.FunctionDefinitionParam{
+			Name:        tool.Name,
+			Description: param.NewOpt(tool.Description),
+			Parameters:  shared.FunctionParameters(tool.Parameters),
+		}))
+	}
+	return rtn
+}
+
+// shared with azureopenai (same API, different client setup)
+func RunToolCompletionWithClient(ctx context.Context, client openai.Client, model string, maxTokens int, messages []*aiprovider.ToolMessage, tools []*aiprovider.ToolDef) (*aiprovider.ToolResponse, error) {
+	params := openai.ChatCompletionNewParams{
+		Model:     shared.ChatModel(model),
+		Messages:  ConvertToolMessages(messages),
+		MaxTokens: param.NewOpt(int64(maxTokens)),
+		Tools:     ConvertToolDefs(tools),
+	}
+	completion, err := client.Chat.Completions.New(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("error calling openai API: %v", err)
+	}
+	rtn := &aiprovider.ToolResponse{Model: completion.Model}
+	if completion.Usage.TotalTokens > 0 {
+		rtn.Usage = convertUsage(completion.Usage)
+	}
+	if len(completion.Choices) == 0 {
+		return rtn, nil
+	}
+	choice := completion.Choices[0]
+	rtn.Text = choice.Message.Content
+	rtn.FinishReason = string(choice.FinishReason)
+	for _, tc := range choice.Message.ToolCalls {
+		if tc.Type != "function" {
+			continue
+		}
+		rtn.ToolCalls = append(rtn.ToolCalls, &aiprovider.ToolCall{Id: tc.ID, Name: tc.Function.Name, Args: tc.Function.Arguments})
+	}
+	return rtn, nil
+}
@@ -0,0 +1,239 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+	"golang.org/x/crypto/ssh"
+)
+
+const MaxPortForwards = 20
+const PortForwardDialTimeout = 10 * time.Second
+const DefaultForwardBindHost = "127.0.0.1"
+
+// [bind_address:]port:host:hostport (addresses can be [ipv6])
+var forwardSpecRe = regexp.MustCompile(`^(?:(\[[^\]]+\]|[^:\[\]]*):)?(\d+):(\[[^\]]+\]|[^:\[\]]+):(\d+)$`)
+
+// a running forward (one per PortForwardType on a connected remote)
+type portForward struct {
+	Spec     sstore.PortForwardType
+	Listener net.Listener
+	Err      error
+	NumConns atomic.Int64
+}
+
+// parses an ssh style -L / -R spec ([bind_address:]port:host:hostport)
+func ParsePortForwardSpec(fwdType string, spec string) (sstore.PortForwardType, error) {
+	var rtn sstore.PortForwardType
+	if fwdType != sstore.PortForwardType_Local && fwdType != sstore.PortForwardType_Remote {
+		return rtn, fmt.Errorf("invalid forward type %q", fwdType)
+	}
+	m := forwardSpecRe.FindStringSubmatch(strings.TrimSpace(spec))
+	if m == nil {
+		return rtn, fmt.Errorf("invalid forward %q, must be [bind_address:]port:host:hostport", spec)
+	}
+	bindHost, bindPort, targetHost, targetPort := m[1], m[2], m[3], m[4]
+	if bindHost == "" || bindHost == "localhost" {
+		bindHost = DefaultForwardBindHost
+	}
+	for _, port := range []string{bindPort, targetPort} {
+		if !isValidPortStr(port) {
+			return rtn, fmt.Errorf("invalid port %q in forward %q", port, spec)
+		}
+	}
+	rtn.Type = fwdType
+	rtn.BindAddr = net.JoinHostPort(strings.Trim(bindHost, "[]"), bindPort)
+	rtn.TargetAddr = net.JoinHostPort(strings.Trim(targetHost, "[]"), targetPort)
+	return rtn, nil
+}
+
+func isValidPortStr(port string) bool {
+	portVal, err := strconv.Atoi(port)
+	return err == nil && portVal > 0 && portVal <= 65535
+}
+
+func (wsh *WaveshellProc) getForwards() []sstore.PortForwardType {
+	wsh.Lock.Lock()
+	defer wsh.Lock.Unlock()
+	if wsh.Remote.RemoteOpts == nil {
+		return nil
+	}
+	return append([]sstore.PortForwardType(nil), wsh.Remote.RemoteOpts.Forwards...)
+}
+
+// adds (and persists) a forward, it is started right away if the remote is connected
+func (wsh *WaveshellProc) AddPortForward(ctx context.Context, spec sstore.PortForwardType) error {
+	if rcopy := wsh.GetRemoteCopy(); rcopy.Local || rcopy.RemoteType != sstore.RemoteTypeSsh {
+		return fmt.Errorf("port forwarding requires an ssh remote")
+	}
+	forwards := wsh.getForwards()
+	if len(forwards) >= MaxPortForwards {
+		return fmt.Errorf("too many port forwards (max %d)", MaxPortForwards)
+	}
+	for _, fwd := range forwards {
+		if fwd.Type == spec.Type && fwd.BindAddr == spec.BindAddr {
+			return fmt.Errorf("forward already exists: %s", fwd)
+		}
+	}
+	if wsh.IsConnected() {
+		err := wsh.startPortForward(spec)
+		if err != nil {
+			wsh.stopPortForward(spec)
+			return err
+		}
+	}
+	forwards = append(forwards, spec)
+	return wsh.UpdateRemote(ctx, map[string]interface{}{sstore.RemoteField_Forwards: forwards})
+}
+
+// stops and removes a forward
+func (wsh *WaveshellProc) RemovePortForward(ctx context.Context, spec sstore.PortForwardType) error {
+	var newForwards []sstore.PortForwardType
+	for _, fwd := range wsh.getForwards() {
+		if fwd != spec {
+			newForwards = append(newForwards, fwd)
+		}
+	}
+	wsh.stopPortForward(spec)
+	return wsh.UpdateRemote(ctx, map[string]interface{}{sstore.RemoteField_Forwards: newForwards})
+}
+
+// called once the remote is connected, errors are reported in the runtime state (and the remote's pty)
+func (wsh *WaveshellProc) startPortForwards() {
+	for _, spec := range wsh.getForwards() {
+		err := wsh.startPortForward(spec)
+		if err != nil {
+			wsh.WriteToPtyBuffer("*error starting port forward %s: %v\n", spec, err)
+		}
+	}
+	go wsh.NotifyRemoteUpdate()
+}
+
+func (wsh *WaveshellProc) stopPortForwards() {
+	for _, spec := range wsh.getForwards() {
+		wsh.stopPortForward(spec)
+	}
+}
+
+func (wsh *WaveshellProc) startPortForward(spec sstore.PortForwardType) error {
+	wsh.Lock.Lock()
+	client := wsh.Client
+	if wsh.PortForwards == nil {
+		wsh.PortForwards = make(map[sstore.PortForwardType]*portForward)
+	}
+	if oldFwd := wsh.PortForwards[spec]; oldFwd != nil && oldFwd.Listener != nil {
+		wsh.Lock.Unlock()
+		return nil
+	}
+	wsh.Lock.Unlock()
+	if client == nil {
+		return fmt.Errorf("remote is not connected")
+	}
+	fwd := &portForward{Spec: spec}
+	var err error
+	if spec.Type == sstore.PortForwardType_Remote {
+		fwd.Listener, err = client.Listen("tcp", spec.BindAddr)
+	} else {
+		fwd.Listener, err = net.Listen("tcp", spec.BindAddr)
+	}
+	if err != nil {
+		fwd.Err = err
+	}
+	wsh.WithLock(func() {
+		wsh.PortForwards[spec] = fwd
+	})
+	if err != nil {
+		return err
+	}
+	go wsh.runPortForward(client, fwd)
+	return nil
+}
+
+func (wsh *WaveshellProc) stopPortForward(spec sstore.PortForwardType) {
+	var fwd *portForward
+	wsh.WithLock(func() {
+		fwd = wsh.PortForwards[spec]
+		delete(wsh.PortForwards, spec)
+	})
+	if fwd != nil && fwd.Listener != nil {
+		fwd.Listener.Close()
+	}
+}
+
+func (wsh *WaveshellProc) runPortForward(client *ssh.Client, fwd *portForward) {
+	for {
+		conn, err := fwd.Listener.Accept()
+		if err != nil {
+			// listener closed (forward stopped or the ssh connection went away)
+			return
+		}
+		go func() {
+			defer conn.Close()
+			var targetConn net.Conn
+			var err error
+			if fwd.Spec.Type == sstore.PortForwardType_Remote {
+				targetConn, err = net.DialTimeout("tcp", fwd.Spec.TargetAddr, PortForwardDialTimeout)
+			} else {
+				dialCtx, cancelFn := context.WithTimeout(context.Background(), PortForwardDialTimeout)
+				targetConn, err = client.DialContext(dialCtx, "tcp", fwd.Spec.TargetAddr)
+				cancelFn()
+			}
+			if err != nil {
+				log.Printf("port forward %s, cannot connect to target: %v\n", fwd.Spec, err)
+				return
+			}
+			defer targetConn.Close()
+			fwd.NumConns.Add(1)
+			defer fwd.NumConns.Add(-1)
+			proxyConns(conn, targetConn)
+		}()
+	}
+}
+
+// copies in both directions until either side closes
+func proxyConns(conn1 net.Conn, conn2 net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(conn1, conn2)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn2, conn1)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// must be called with wsh.Lock held
+func (wsh *WaveshellProc) getPortForwardStates_nolock() []sstore.PortForwardStateType {
+	if wsh.Remote.RemoteOpts == nil {
+		return nil
+	}
+	var rtn []sstore.PortForwardStateType
+	for _, spec := range wsh.Remote.RemoteOpts.Forwards {
+		state := sstore.PortForwardStateType{PortForwardType: spec, Status: sstore.PortForwardStatus_Inactive}
+		if fwd := wsh.PortForwards[spec]; fwd != nil {
+			if fwd.Err != nil {
+				state.Status = sstore.PortForwardStatus_Error
+				state.ErrorStr = fwd.Err.Error()
+			} else {
+				state.Status = sstore.PortForwardStatus_Active
+				state.NumConns = int(fwd.NumConns.Load())
+			}
+		}
+		rtn = append(rtn, state)
+	}
+	return rtn
+}
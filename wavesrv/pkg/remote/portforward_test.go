@@ -0,0 +1,37 @@
+package remote
+
+import (
+	"testing"
+
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+)
+
+func TestParsePortForwardSpec(t *testing.T) {
+	spec, err := ParsePortForwardSpec(sstore.PortForwardType_Local, "8080:localhost:80")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if spec.BindAddr != "127.0.0.1:8080" || spec.TargetAddr != "localhost:80" {
+		t.Errorf("bad spec: %+v", spec)
+	}
+	spec, err = ParsePortForwardSpec(sstore.PortForwardType_Remote, "0.0.0.0:9000:[::1]:3000")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if spec.Type != sstore.PortForwardType_Remote || spec.BindAddr != "0.0.0.0:9000" || spec.TargetAddr != "[::1]:3000" {
+		t.Errorf("bad spec: %+v", spec)
+	}
+	if spec.String() != "-R 0.0.0.0:9000 -> [::1]:3000" {
+		t.Errorf("bad spec string: %q", spec.String())
+	}
+	for _, val := range []string{"", "8080", "8080:host", "0:host:80", "8080:host:70000", "a:b:c:d:e"} {
+		_, err = ParsePortForwardSpec(sstore.PortForwardType_Local, val)
+		if err == nil {
+			t.Errorf("%q should be an error", val)
+		}
+	}
+	_, err = ParsePortForwardSpec("dynamic", "8080:host:80")
+	if err == nil {
+		t.Errorf("invalid type should be an error")
+	}
+}
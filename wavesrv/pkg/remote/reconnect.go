@@ -0,0 +1,133 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+)
+
+const ReconnectBaseDelay = 2 * time.Second
+const ReconnectMaxDelay = 5 * time.Minute
+const MaxReconnectAttempts = 20
+
+// exponential backoff with jitter.  attempt is 1-based, the delay is a random value in [d/2, d]
+// where d = min(ReconnectBaseDelay * 2^(attempt-1), ReconnectMaxDelay)
+func reconnectDelay(attempt int, randFn func() float64) time.Duration {
+	delay := ReconnectBaseDelay
+	for i := 1; i < attempt && delay < ReconnectMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > ReconnectMaxDelay {
+		delay = ReconnectMaxDelay
+	}
+	return delay/2 + time.Duration(randFn()*float64(delay/2))
+}
+
+// only remotes with connectmode=reconnect, and not after a manual disconnect
+func (wsh *WaveshellProc) shouldReconnect() bool {
+	wsh.Lock.Lock()
+	defer wsh.Lock.Unlock()
+	return wsh.Remote.ConnectMode == sstore.ConnectModeReconnect && !wsh.Remote.Archived && !wsh.ManualDisconnect && !wsh.NeedsWaveshellUpgrade
+}
+
+// starts the reconnect supervisor (no-op if it is already running)
+func (wsh *WaveshellProc) startReconnect() {
+	wsh.Lock.Lock()
+	defer wsh.Lock.Unlock()
+	if wsh.ReconnectCancelFn != nil {
+		return
+	}
+	ctx, cancelFn := context.WithCancel(context.Background())
+	wsh.ReconnectCtx = ctx
+	wsh.ReconnectCancelFn = cancelFn
+	go wsh.runReconnect(ctx)
+}
+
+// returns true if a reconnect supervisor was running
+func (wsh *WaveshellProc) CancelReconnect() bool {
+	wsh.Lock.Lock()
+	defer wsh.Lock.Unlock()
+	return wsh.cancelReconnect_nolock()
+}
+
+func (wsh *WaveshellProc) cancelReconnect_nolock() bool {
+	if wsh.ReconnectCancelFn == nil {
+		return false
+	}
+	wsh.ReconnectCancelFn()
+	wsh.ReconnectCtx = nil
+	wsh.ReconnectCancelFn = nil
+	wsh.ReconnectAttempt = 0
+	wsh.ReconnectTs = 0
+	go wsh.NotifyRemoteUpdate()
+	return true
+}
+
+// tries to Launch the remote until it connects, the reconnect is canceled (Disconnect), the remote no longer
+// wants to reconnect (connectmode changed, archived), or the user cancels a password / passphrase / known_hosts
+// prompt.  Launch runs the prompts, so only one prompt is ever outstanding.
+func (wsh *WaveshellProc) runReconnect(ctx context.Context) {
+	defer wsh.WithLock(func() {
+		if wsh.ReconnectCtx == ctx {
+			wsh.ReconnectCancelFn()
+			wsh.ReconnectCtx = nil
+			wsh.ReconnectCancelFn = nil
+			wsh.ReconnectAttempt = 0
+			wsh.ReconnectTs = 0
+			go wsh.NotifyRemoteUpdate()
+		}
+	})
+	for attempt := 1; attempt <= MaxReconnectAttempts; attempt++ {
+		delay := reconnectDelay(attempt, rand.Float64)
+		wsh.WithLock(func() {
+			wsh.ReconnectAttempt = attempt
+			wsh.ReconnectTs = time.Now().Add(delay).UnixMilli()
+			go wsh.NotifyRemoteUpdate()
+		})
+		wsh.WriteToPtyBuffer("*reconnecting in %v (attempt %d/%d)\n", delay.Round(time.Second), attempt, MaxReconnectAttempts)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if !wsh.shouldReconnect() {
+			return
+		}
+		status := wsh.GetStatus()
+		if status == StatusConnected || status == StatusConnecting {
+			// connected (or connecting) by someone else
+			return
+		}
+		// the old client is dead, Launch creates a new one when wsh.Client is nil
+		wsh.WithLock(func() {
+			if wsh.Client != nil {
+				wsh.Client.Close()
+				wsh.Client = nil
+			}
+		})
+		wsh.Launch(true)
+		if wsh.IsConnected() {
+			wsh.WriteToPtyBuffer("*reconnected after %d attempt(s)\n", attempt)
+			wsh.initActiveShells()
+			return
+		}
+		var launchErr error
+		wsh.WithLock(func() {
+			launchErr = wsh.Err
+		})
+		var cancelErr UserInputCancelError
+		if errors.As(launchErr, &cancelErr) {
+			wsh.WriteToPtyBuffer("*reconnect canceled (%v)\n", launchErr)
+			return
+		}
+	}
+	wsh.WriteToPtyBuffer("*giving up after %d reconnect attempts, use /remote:connect to connect\n", MaxReconnectAttempts)
+}
@@ -0,0 +1,30 @@
+package remote
+
+import (
+	"testing"
+)
+
+func TestReconnectDelay(t *testing.T) {
+	maxRand := func() float64 { return 1.0 }
+	minRand := func() float64 { return 0.0 }
+	if delay := reconnectDelay(1, maxRand); delay != ReconnectBaseDelay {
+		t.Errorf("attempt 1 max delay %v", delay)
+	}
+	if delay := reconnectDelay(1, minRand); delay != ReconnectBaseDelay/2 {
+		t.Errorf("attempt 1 min delay %v", delay)
+	}
+	if delay := reconnectDelay(3, maxRand); delay != 4*ReconnectBaseDelay {
+		t.Errorf("attempt 3 max delay %v", delay)
+	}
+	if delay := reconnectDelay(MaxReconnectAttempts, maxRand); delay != ReconnectMaxDelay {
+		t.Errorf("last attempt max delay %v", delay)
+	}
+	if delay := reconnectDelay(1000, minRand); delay != ReconnectMaxDelay/2 {
+		t.Errorf("attempt 1000 min delay %v", delay)
+	}
+	for attempt := 1; attempt < MaxReconnectAttempts; attempt++ {
+		if reconnectDelay(attempt+1, maxRand) < reconnectDelay(attempt, maxRand) {
+			t.Errorf("delay decreased at attempt %d", attempt+1)
+		}
+	}
+}
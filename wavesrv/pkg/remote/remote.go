@@ -38,6 +38,7 @@ import (
 	"github.com/abhishek944/waveterm/waveshell/pkg/statediff"
 	"github.com/abhishek944/waveterm/waveshell/pkg/utilfn"
 	"github.com/abhishek944/waveterm/wavesrv/pkg/ephemeral"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/history"
 	"github.com/abhishek944/waveterm/wavesrv/pkg/scbase"
 	"github.com/abhishek944/waveterm/wavesrv/pkg/scbus"
 	"github.com/abhishek944/waveterm/wavesrv/pkg/scpacket"
@@ -78,10 +79,11 @@ var envVarsToStrip map[string]bool = map[string]bool{
 // so we can pass ignoreUntilValid to PacketParser
 const PrintPingPacket = `printf "\n##N{\"type\": \"ping\"}\n"`
 
+// POSIX sh (exec remotes run it with sh -c, ssh remotes with the login shell)
 const WaveshellServerCommandFmt = `
-PATH=$PATH:~/.mshell;
-which mshell-[%VERSION%] > /dev/null;
-if [[ "$?" -ne 0 ]]
+PATH=$PATH:$HOME/.mshell;
+command -v mshell-[%VERSION%] > /dev/null;
+if [ "$?" -ne 0 ]
 then
   printf "\n##N{\"type\": \"init\", \"notfound\": true, \"uname\": \"%s | %s\"}\n" "$(uname -s)" "$(uname -m)"
 else
@@ -123,6 +125,10 @@ func init() {
 
 var GlobalStore *Store
 
+// called (in its own goroutine) when a non-ephemeral command finishes with a non-zero exit code.
+// set by cmdrunner (which depends on remote) to run /line:explain for screens with autoexplain set
+var CmdFailedHandler func(screenId string, lineId string, exitCode int)
+
 type Store struct {
 	Lock       *sync.Mutex
 	Map        map[string]*WaveshellProc // key=remoteid
@@ -168,9 +174,18 @@ type WaveshellProc struct {
 	RunningCmds      map[base.CommandKey]*RunCmdType
 	PendingStateCmds map[pendingStateKey]base.CommandKey // key=[remoteinstance name] (in progress commands that might update the state)
 
-	Client            *ssh.Client
-	sudoPw            []byte
-	sudoClearDeadline int64
+	Client             *ssh.Client
+	ClientAgentForward bool                                    // the local ssh agent is forwarded on Client (agentforward=1), see sshagent.go
+	PortForwards       map[sstore.PortForwardType]*portForward // running forwards (see portforward.go)
+	sudoPw             []byte
+	sudoClearDeadline  int64
+
+	// reconnect supervisor (see reconnect.go)
+	ManualDisconnect  bool // set by Disconnect (no reconnect), cleared by Launch
+	ReconnectCtx      context.Context
+	ReconnectCancelFn context.CancelFunc
+	ReconnectAttempt  int
+	ReconnectTs       int64
 }
 
 type CommandInputSink interface {
@@ -206,7 +221,7 @@ type RemoteRuntimeState = sstore.RemoteRuntimeState
 
 func CanComplete(remoteType string) bool {
 	switch remoteType {
-	case sstore.RemoteTypeSsh:
+	case sstore.RemoteTypeSsh, sstore.RemoteTypeDocker, sstore.RemoteTypeKubectl, sstore.RemoteTypeSocket:
 		return true
 	default:
 		return false
@@ -246,7 +261,7 @@ func LoadRemotes(ctx context.Context) error {
 	for _, remote := range allRemotes {
 		wsh := MakeWaveshell(remote)
 		GlobalStore.Map[remote.RemoteId] = wsh
-		if remote.ConnectMode == sstore.ConnectModeStartup {
+		if sstore.IsStartupConnectMode(remote.ConnectMode) {
 			go wsh.Launch(false)
 		}
 		if remote.Local {
@@ -285,7 +300,7 @@ func LoadRemoteById(ctx context.Context, remoteId string) error {
 		return fmt.Errorf("cannot add remote %s, already in global map", remoteId)
 	}
 	GlobalStore.Map[r.RemoteId] = wsh
-	if r.ConnectMode == sstore.ConnectModeStartup {
+	if sstore.IsStartupConnectMode(r.ConnectMode) {
 		go wsh.Launch(false)
 	}
 	return nil
@@ -586,6 +601,8 @@ func (wsh *WaveshellProc) GetRemoteRuntimeState() RemoteRuntimeState {
 		AuthType:              sstore.RemoteAuthTypeNone,
 		ShellPref:             wsh.Remote.ShellPref,
 		DefaultShellType:      shellPref,
+		ReconnectAttempt:      wsh.ReconnectAttempt,
+		ReconnectTs:           wsh.ReconnectTs,
 	}
 	if wsh.Remote.SSHOpts != nil {
 		state.AuthType = wsh.Remote.SSHOpts.GetAuthType()
@@ -594,6 +611,7 @@ func (wsh *WaveshellProc) GetRemoteRuntimeState() RemoteRuntimeState {
 		optsCopy := *wsh.Remote.RemoteOpts
 		state.RemoteOpts = &optsCopy
 	}
+	state.Forwards = wsh.getPortForwardStates_nolock()
 	if wsh.Err != nil {
 		state.ErrorStr = wsh.Err.Error()
 	}
@@ -870,6 +888,10 @@ func (wsh *WaveshellProc) UpdateRemote(ctx context.Context, editMap map[string]i
 func (wsh *WaveshellProc) Disconnect(force bool) {
 	status := wsh.GetStatus()
 	if status != StatusConnected && status != StatusConnecting {
+		if wsh.CancelReconnect() {
+			wsh.WriteToPtyBuffer("*reconnect canceled\n")
+			return
+		}
 		wsh.WriteToPtyBuffer("remote already disconnected (no action taken)\n")
 		return
 	}
@@ -880,6 +902,8 @@ func (wsh *WaveshellProc) Disconnect(force bool) {
 	}
 	wsh.Lock.Lock()
 	defer wsh.Lock.Unlock()
+	wsh.ManualDisconnect = true
+	wsh.cancelReconnect_nolock()
 	if wsh.ServerProc != nil {
 		wsh.ServerProc.Close()
 		wsh.Client = nil
@@ -1313,31 +1337,47 @@ func (wsh *WaveshellProc) RunInstall(autoInstall bool) {
 		wsh.WriteToPtyBuffer("*error: cannot install on a local remote\n")
 		return
 	}
-	sapi, err := shellapi.MakeShellApi(wsh.GetShellType())
+	if remoteCopy.RemoteType == sstore.RemoteTypeSocket {
+		wsh.WriteToPtyBuffer("*error: cannot install on a socket remote (waveshell must be started with --listen on the host)\n")
+		return
+	}
+	sapi, err := wsh.getBootstrapShellApi()
 	if err != nil {
 		wsh.WriteToPtyBuffer("*error: %v\n", err)
 		return
 	}
-	if wsh.Client == nil {
-		remoteDisplayName := fmt.Sprintf("%s [%s]", remoteCopy.RemoteAlias, remoteCopy.RemoteCanonicalName)
-		sshAuthSock, _ := exec.CommandContext(makeClientCtx, sapi.GetLocalShellPath(), "-c", "echo \"${SSH_AUTH_SOCK}\"").CombinedOutput()
-		client, err := ConnectToClient(makeClientCtx, remoteCopy.SSHOpts, remoteDisplayName, strings.TrimSpace(string(sshAuthSock)))
+	var installSession shexec.ConnInterface
+	if sstore.IsExecRemoteType(remoteCopy.RemoteType) {
+		ecmd, err := MakeExecRemoteCmd(remoteCopy, "sh", shexec.MakeInstallCommandStr())
+		if err != nil {
+			wsh.setInstallErrorStatus(fmt.Errorf("cannot run install: %w", err))
+			return
+		}
+		installSession = shexec.CmdWrap{Cmd: ecmd}
+	} else {
+		if wsh.Client == nil {
+			remoteDisplayName := fmt.Sprintf("%s [%s]", remoteCopy.RemoteAlias, remoteCopy.RemoteCanonicalName)
+			sshAuthSock, _ := exec.CommandContext(makeClientCtx, sapi.GetLocalShellPath(), "-c", "echo \"${SSH_AUTH_SOCK}\"").CombinedOutput()
+			client, err := ConnectToClient(makeClientCtx, remoteCopy.SSHOpts, remoteDisplayName, strings.TrimSpace(string(sshAuthSock)))
+			if err != nil {
+				statusErr := fmt.Errorf("ssh cannot connect to client: %w", err)
+				wsh.setInstallErrorStatus(statusErr)
+				return
+			}
+			agentForward := wsh.setupClientAgentForwarding(client, remoteCopy.SSHOpts, strings.TrimSpace(string(sshAuthSock)))
+			wsh.WithLock(func() {
+				wsh.Client = client
+				wsh.ClientAgentForward = agentForward
+			})
+		}
+		session, err := wsh.Client.NewSession()
 		if err != nil {
 			statusErr := fmt.Errorf("ssh cannot connect to client: %w", err)
 			wsh.setInstallErrorStatus(statusErr)
 			return
 		}
-		wsh.WithLock(func() {
-			wsh.Client = client
-		})
+		installSession = shexec.SessionWrap{Session: session, StartCmd: shexec.MakeInstallCommandStr()}
 	}
-	session, err := wsh.Client.NewSession()
-	if err != nil {
-		statusErr := fmt.Errorf("ssh cannot connect to client: %w", err)
-		wsh.setInstallErrorStatus(statusErr)
-		return
-	}
-	installSession := shexec.SessionWrap{Session: session, StartCmd: shexec.MakeInstallCommandStr()}
 	wsh.WriteToPtyBuffer("installing waveshell %s to %s...\n", scbase.WaveshellVersion, remoteCopy.RemoteCanonicalName)
 	clientCtx, clientCancelFn := context.WithCancel(context.Background())
 	defer clientCancelFn()
@@ -1375,7 +1415,7 @@ func (wsh *WaveshellProc) RunInstall(autoInstall bool) {
 	})
 	wsh.WriteToPtyBuffer("successfully installed waveshell %s to ~/.mshell\n", scbase.WaveshellVersion)
 	go wsh.NotifyRemoteUpdate()
-	if connectMode == sstore.ConnectModeStartup || connectMode == sstore.ConnectModeAuto || autoInstall {
+	if sstore.IsStartupConnectMode(connectMode) || connectMode == sstore.ConnectModeAuto || autoInstall {
 		// the install was successful, and we didn't click the install button with manual connect mode, try to connect
 		go wsh.Launch(true)
 	}
@@ -1423,7 +1463,7 @@ func (wsh *WaveshellProc) ReInit(ctx context.Context, ck base.CommandKey, shellT
 	if !wsh.IsConnected() {
 		return nil, fmt.Errorf("cannot reinit, remote is not connected")
 	}
-	if shellType != packet.ShellType_bash && shellType != packet.ShellType_zsh {
+	if shellType != packet.ShellType_bash && shellType != packet.ShellType_zsh && shellType != packet.ShellType_fish {
 		return nil, fmt.Errorf("invalid shell type %q", shellType)
 	}
 	if dataFn == nil {
@@ -1593,12 +1633,24 @@ func (wsh *WaveshellProc) createWaveshellSession(clientCtx context.Context, remo
 		wsh.MakeClientDeadline = nil
 		go wsh.NotifyRemoteUpdate()
 	})
-	sapi, err := shellapi.MakeShellApi(wsh.GetShellType())
+	sapi, err := wsh.getBootstrapShellApi()
 	if err != nil {
 		return nil, err
 	}
 	var wsSession shexec.ConnInterface
-	if remoteCopy.SSHOpts.SSHHost == "" && remoteCopy.Local {
+	if remoteCopy.RemoteType == sstore.RemoteTypeSocket {
+		sockWrap, err := dialSocketRemote(clientCtx, remoteCopy)
+		if err != nil {
+			return nil, err
+		}
+		wsSession = sockWrap
+	} else if sstore.IsExecRemoteType(remoteCopy.RemoteType) {
+		ecmd, err := MakeExecRemoteCmd(remoteCopy, "sh", MakeServerCommandStr())
+		if err != nil {
+			return nil, err
+		}
+		wsSession = shexec.CmdWrap{Cmd: ecmd}
+	} else if remoteCopy.SSHOpts.SSHHost == "" && remoteCopy.Local {
 		cmdStr, err := MakeLocalWaveshellCommandStr(remoteCopy.IsSudo())
 		if err != nil {
 			return nil, fmt.Errorf("cannot find local waveshell binary: %v", err)
@@ -1619,17 +1671,19 @@ func (wsh *WaveshellProc) createWaveshellSession(clientCtx context.Context, remo
 		if err != nil {
 			return nil, fmt.Errorf("ssh cannot connect to client: %w", err)
 		}
+		agentForward := wsh.setupClientAgentForwarding(client, remoteCopy.SSHOpts, strings.TrimSpace(string(sshAuthSock)))
 		wsh.WithLock(func() {
 			wsh.Client = client
+			wsh.ClientAgentForward = agentForward
 		})
-		session, err := client.NewSession()
+		session, err := wsh.newClientSession()
 		if err != nil {
 			return nil, fmt.Errorf("ssh cannot create session: %w", err)
 		}
 		cmd := fmt.Sprintf("%s -c %s", sapi.GetLocalShellPath(), shellescape.Quote(MakeServerCommandStr()))
 		wsSession = shexec.SessionWrap{Session: session, StartCmd: cmd}
 	} else {
-		session, err := wsh.Client.NewSession()
+		session, err := wsh.newClientSession()
 		if err != nil {
 			return nil, fmt.Errorf("ssh cannot create session: %w", err)
 		}
@@ -1671,6 +1725,7 @@ func (wsh *WaveshellProc) Launch(interactive bool) {
 	var makeClientCtx context.Context
 	var makeClientCancelFn context.CancelFunc
 	wsh.WithLock(func() {
+		wsh.ManualDisconnect = false
 		makeClientCtx, makeClientCancelFn = context.WithCancel(context.Background())
 		wsh.MakeClientCancelFn = makeClientCancelFn
 		wsh.MakeClientDeadline = nil
@@ -1744,6 +1799,7 @@ func (wsh *WaveshellProc) Launch(interactive bool) {
 	go func() {
 		exitErr := cproc.Cmd.Wait()
 		exitCode := utilfn.GetExitCode(exitErr)
+		wsh.stopPortForwards()
 		wsh.WithLock(func() {
 			if wsh.Status == StatusConnected || wsh.Status == StatusConnecting {
 				wsh.Status = StatusDisconnected
@@ -1751,8 +1807,12 @@ func (wsh *WaveshellProc) Launch(interactive bool) {
 			}
 		})
 		wsh.WriteToPtyBuffer("*disconnected exitcode=%d\n", exitCode)
+		if wsh.shouldReconnect() {
+			wsh.startReconnect()
+		}
 	}()
 	go wsh.ProcessPackets()
+	go wsh.startPortForwards()
 	// wsh.initActiveShells()
 	go wsh.NotifyRemoteUpdate()
 }
@@ -1794,6 +1854,16 @@ func (wsh *WaveshellProc) GetShellType() string {
 	return wsh.InitPkShellType
 }
 
+// the server, install and SSH_AUTH_SOCK commands are posix shell scripts, so they are started
+// with bash when the remote's shell is fish
+func (wsh *WaveshellProc) getBootstrapShellApi() (shellapi.ShellApi, error) {
+	shellType := wsh.GetShellType()
+	if shellType == packet.ShellType_fish {
+		shellType = packet.ShellType_bash
+	}
+	return shellapi.MakeShellApi(shellType)
+}
+
 func replaceHomePath(pathStr string, homeDir string) string {
 	if homeDir == "" {
 		return pathStr
@@ -2277,6 +2347,11 @@ func (wsh *WaveshellProc) notifyHangups_nolock() {
 		}
 		update := scbus.MakeUpdatePacket()
 		update.AddUpdate(*cmd)
+		// the line state has the hangup reason
+		line, err := sstore.GetLineById(context.Background(), ck.GetGroupId(), ck.GetCmdId())
+		if err == nil && line != nil {
+			sstore.AddLineUpdate(update, line, nil)
+		}
 		scbus.MainUpdateBus.DoScreenUpdate(ck.GetGroupId(), update)
 		go pushNumRunningCmdsUpdate(&ck, -1)
 	}
@@ -2476,6 +2551,10 @@ func (wsh *WaveshellProc) handleCmdDonePacket(rct *RunCmdType, donePk *packet.Cm
 		if screen != nil {
 			update.AddUpdate(*screen)
 		}
+		history.GoIndexCmdOutput(donePk.CK.GetGroupId(), donePk.CK.GetCmdId())
+		if donePk.ExitCode != 0 && CmdFailedHandler != nil {
+			go CmdFailedHandler(donePk.CK.GetGroupId(), donePk.CK.GetCmdId(), donePk.ExitCode)
+		}
 	}
 
 	// Close the ephemeral response writer if it exists
@@ -2819,10 +2898,17 @@ func (wsh *WaveshellProc) ProcessPackets() {
 		if wsh.Status == StatusConnected {
 			wsh.Status = StatusDisconnected
 		}
-		screens, err := sstore.HangupRunningCmdsByRemoteId(context.Background(), wsh.Remote.RemoteId)
+		hangupReason := fmt.Sprintf("connection to %s was lost", wsh.Remote.GetName())
+		if wsh.ManualDisconnect {
+			hangupReason = fmt.Sprintf("%s was disconnected", wsh.Remote.GetName())
+		}
+		screens, err := sstore.HangupRunningCmdsByRemoteId(context.Background(), wsh.Remote.RemoteId, hangupReason)
 		if err != nil {
 			wsh.writeToPtyBuffer_nolock("error calling HUP on cmds %v\n", err)
 		}
+		if len(wsh.RunningCmds) > 0 {
+			wsh.writeToPtyBuffer_nolock("*%d running command(s) lost (hangup)\n", len(wsh.RunningCmds))
+		}
 		wsh.notifyHangups_nolock()
 		go wsh.NotifyRemoteUpdate()
 		if len(screens) > 0 {
@@ -0,0 +1,43 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/abhishek944/waveterm/waveshell/pkg/base"
+	"github.com/abhishek944/waveterm/waveshell/pkg/shexec"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+)
+
+// the key for a socket remote, KeyFile (read on every connect, so it can be rotated) wins over Key
+func getSocketRemoteKey(opts *sstore.SocketOptsType) ([]byte, error) {
+	if opts.KeyFile == "" {
+		return []byte(opts.Key), nil
+	}
+	keyData, err := os.ReadFile(base.ExpandHomeDir(opts.KeyFile))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read keyfile: %w", err)
+	}
+	return []byte(strings.TrimSpace(string(keyData))), nil
+}
+
+func dialSocketRemote(ctx context.Context, remoteCopy sstore.RemoteType) (*shexec.SockWrap, error) {
+	opts := remoteCopy.SocketOpts
+	if opts == nil || opts.Addr == "" {
+		return nil, fmt.Errorf("socket remote has no address")
+	}
+	key, err := getSocketRemoteKey(opts)
+	if err != nil {
+		return nil, err
+	}
+	sockWrap, err := shexec.DialSock(ctx, opts.Addr, key)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to waveshell at %s: %w", opts.Addr, err)
+	}
+	return sockWrap, nil
+}
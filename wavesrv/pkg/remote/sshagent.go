@@ -0,0 +1,148 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/abhishek944/waveterm/waveshell/pkg/base"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// suffix openssh uses to find the certificate for an identity file (id_ed25519 -> id_ed25519-cert.pub)
+const SshCertSuffix = "-cert.pub"
+
+// the CertificateFile entries from the ssh config, followed by the <identity>-cert.pub files that exist
+// (like openssh, certificates are only auto-discovered next to identity files)
+func findCertificateFiles(configCertFiles []string, identityFiles []string) []string {
+	var rtn []string
+	seen := make(map[string]bool)
+	for _, certFile := range configCertFiles {
+		if certFile == "" || seen[certFile] {
+			continue
+		}
+		seen[certFile] = true
+		rtn = append(rtn, certFile)
+	}
+	for _, identityFile := range identityFiles {
+		certFile := identityFile + SshCertSuffix
+		if identityFile == "" || seen[certFile] {
+			continue
+		}
+		if _, err := os.Stat(base.ExpandHomeDir(certFile)); err != nil {
+			continue
+		}
+		seen[certFile] = true
+		rtn = append(rtn, certFile)
+	}
+	return rtn
+}
+
+// reads the user certificates, files that are missing or do not hold a user certificate are skipped
+func loadUserCertificates(certFiles []string) []*ssh.Certificate {
+	var rtn []*ssh.Certificate
+	for _, certFile := range certFiles {
+		certBytes, err := os.ReadFile(base.ExpandHomeDir(certFile))
+		if err != nil {
+			continue
+		}
+		pubKey, _, _, _, err := ssh.ParseAuthorizedKey(certBytes)
+		if err != nil {
+			log.Printf("cannot parse ssh certificate %s: %v\n", certFile, err)
+			continue
+		}
+		cert, ok := pubKey.(*ssh.Certificate)
+		if !ok || cert.CertType != ssh.UserCert {
+			log.Printf("ssh certificate %s is not a user certificate, skipping\n", certFile)
+			continue
+		}
+		rtn = append(rtn, cert)
+	}
+	return rtn
+}
+
+// returns the signer preceded by a certificate signer for each certificate issued for its key.
+// the certificates are offered first (the server may only accept the certificate), then the plain key
+func addCertSigners(signer ssh.Signer, certs []*ssh.Certificate) []ssh.Signer {
+	var rtn []ssh.Signer
+	keyBytes := signer.PublicKey().Marshal()
+	for _, cert := range certs {
+		if string(cert.Key.Marshal()) != string(keyBytes) {
+			continue
+		}
+		certSigner, err := ssh.NewCertSigner(cert, signer)
+		if err != nil {
+			continue
+		}
+		rtn = append(rtn, certSigner)
+	}
+	return append(rtn, signer)
+}
+
+// routes the agent channels opened by the remote to the local agent socket (a new agent connection
+// for each channel).  sessions still have to ask for forwarding, see requestAgentForwarding
+func setupAgentForwarding(client *ssh.Client, agentSock string) error {
+	if agentSock == "" {
+		return fmt.Errorf("no ssh agent to forward (SSH_AUTH_SOCK is not set and there is no IdentityAgent)")
+	}
+	err := agent.ForwardToRemote(client, agentSock)
+	if err != nil {
+		return fmt.Errorf("cannot forward ssh agent %s: %w", agentSock, err)
+	}
+	return nil
+}
+
+// asks the server to set up SSH_AUTH_SOCK for the session (the server can refuse, e.g. AllowAgentForwarding no)
+func requestAgentForwarding(session *ssh.Session) error {
+	err := agent.RequestAgentForwarding(session)
+	if err != nil {
+		return fmt.Errorf("ssh agent forwarding: %w", err)
+	}
+	return nil
+}
+
+// called when a new client is created for a remote with agentforward=1.  like openssh, a missing
+// local agent is not fatal, the connection works without forwarding and the error goes to the remote's terminal
+func (wsh *WaveshellProc) setupClientAgentForwarding(client *ssh.Client, opts *sstore.SSHOpts, sshAuthSock string) bool {
+	if opts == nil || !opts.SSHAgentForward {
+		return false
+	}
+	sshConfigKeywords, err := findSshConfigKeywords(opts.SSHHost, sshAuthSock)
+	if err == nil {
+		err = setupAgentForwarding(client, sshConfigKeywords.IdentityAgent)
+	}
+	if err != nil {
+		wsh.WriteToPtyBuffer("*warning: %v\n", err)
+		return false
+	}
+	return true
+}
+
+// opens a session on wsh.Client, with agent forwarding when it was set up for the client
+func (wsh *WaveshellProc) newClientSession() (*ssh.Session, error) {
+	var client *ssh.Client
+	var agentForward bool
+	wsh.WithLock(func() {
+		client = wsh.Client
+		agentForward = wsh.ClientAgentForward
+	})
+	if client == nil {
+		return nil, fmt.Errorf("no ssh client")
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	if agentForward {
+		err = requestAgentForwarding(session)
+		if err != nil {
+			wsh.WriteToPtyBuffer("*warning: %v\n", err)
+		}
+	}
+	return session, nil
+}
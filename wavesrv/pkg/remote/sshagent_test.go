@@ -0,0 +1,93 @@
+package remote
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func makeTestSigner(t *testing.T) ssh.Signer {
+	_, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("cannot generate key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(privKey)
+	if err != nil {
+		t.Fatalf("cannot create signer: %v", err)
+	}
+	return signer
+}
+
+// writes a certificate for signer's key (signed by a new CA) in authorized_keys format
+func writeTestCert(t *testing.T, fileName string, signer ssh.Signer, certType uint32) {
+	cert := &ssh.Certificate{
+		Key:             signer.PublicKey(),
+		CertType:        certType,
+		KeyId:           "test",
+		ValidPrincipals: []string{"test"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	err := cert.SignCert(rand.Reader, makeTestSigner(t))
+	if err != nil {
+		t.Fatalf("cannot sign cert: %v", err)
+	}
+	err = os.WriteFile(fileName, ssh.MarshalAuthorizedKey(cert), 0600)
+	if err != nil {
+		t.Fatalf("cannot write cert: %v", err)
+	}
+}
+
+func TestFindCertificateFiles(t *testing.T) {
+	dir := t.TempDir()
+	idWithCert := filepath.Join(dir, "id_ed25519")
+	idNoCert := filepath.Join(dir, "id_rsa")
+	os.WriteFile(idWithCert+SshCertSuffix, []byte("x"), 0600)
+	configCert := filepath.Join(dir, "other-cert.pub")
+	certFiles := findCertificateFiles([]string{configCert, "", configCert}, []string{idWithCert, idNoCert})
+	if len(certFiles) != 2 || certFiles[0] != configCert || certFiles[1] != idWithCert+SshCertSuffix {
+		t.Errorf("bad cert files %v", certFiles)
+	}
+	certFiles = findCertificateFiles(nil, []string{idWithCert, idWithCert})
+	if len(certFiles) != 1 {
+		t.Errorf("duplicate cert files %v", certFiles)
+	}
+}
+
+func TestCertSigners(t *testing.T) {
+	dir := t.TempDir()
+	signer := makeTestSigner(t)
+	otherSigner := makeTestSigner(t)
+	userCert := filepath.Join(dir, "user-cert.pub")
+	hostCert := filepath.Join(dir, "host-cert.pub")
+	otherCert := filepath.Join(dir, "other-cert.pub")
+	writeTestCert(t, userCert, signer, ssh.UserCert)
+	writeTestCert(t, hostCert, signer, ssh.HostCert)
+	writeTestCert(t, otherCert, otherSigner, ssh.UserCert)
+	certs := loadUserCertificates([]string{userCert, hostCert, otherCert, filepath.Join(dir, "missing-cert.pub")})
+	if len(certs) != 2 {
+		t.Fatalf("expected 2 user certs, got %d", len(certs))
+	}
+	signers := addCertSigners(signer, certs)
+	if len(signers) != 2 {
+		t.Fatalf("expected cert signer + key signer, got %d", len(signers))
+	}
+	if _, ok := signers[0].PublicKey().(*ssh.Certificate); !ok {
+		t.Errorf("first signer should use the certificate")
+	}
+	if signers[1] != signer {
+		t.Errorf("last signer should be the plain key")
+	}
+	if signers := addCertSigners(makeTestSigner(t), certs); len(signers) != 1 {
+		t.Errorf("key without a certificate should only get its own signer")
+	}
+}
+
+func TestSetupAgentForwardingNoAgent(t *testing.T) {
+	if err := setupAgentForwarding(nil, ""); err == nil {
+		t.Errorf("expected error without an agent socket")
+	}
+}
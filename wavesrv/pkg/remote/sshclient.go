@@ -31,6 +31,7 @@ import (
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
 	xknownhosts "golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/sys/unix"
 )
 
 type UserInputCancelError struct {
@@ -88,6 +89,9 @@ func createPublicKeyCallback(connCtx context.Context, sshKeywords *SshKeywords,
 	// require pointer to modify list in closure
 	identityFilesPtr := &identityFiles
 
+	// a key (from a file or the agent) is offered with its certificates first
+	certs := loadUserCertificates(sshKeywords.CertificateFile)
+
 	var authSockSigners []ssh.Signer
 	authSockSigners = append(authSockSigners, authSockSignersExt...)
 	authSockSignersPtr := &authSockSigners
@@ -97,7 +101,7 @@ func createPublicKeyCallback(connCtx context.Context, sshKeywords *SshKeywords,
 		if len(*authSockSignersPtr) != 0 {
 			authSockSigner := (*authSockSignersPtr)[0]
 			*authSockSignersPtr = (*authSockSignersPtr)[1:]
-			return []ssh.Signer{authSockSigner}, nil
+			return addCertSigners(authSockSigner, certs), nil
 		}
 
 		// try manual identity files
@@ -122,7 +126,7 @@ func createPublicKeyCallback(connCtx context.Context, sshKeywords *SshKeywords,
 						PrivateKey: unencryptedPrivateKey,
 					})
 				}
-				return []ssh.Signer{signer}, err
+				return addCertSigners(signer, certs), err
 			}
 		}
 		if _, ok := err.(*ssh.PassphraseMissingError); !ok {
@@ -132,7 +136,7 @@ func createPublicKeyCallback(connCtx context.Context, sshKeywords *SshKeywords,
 
 		signer, err := ssh.ParsePrivateKey(privateKey)
 		if err == nil {
-			return []ssh.Signer{signer}, err
+			return addCertSigners(signer, certs), err
 		}
 		if _, ok := err.(*ssh.PassphraseMissingError); !ok {
 			// skip this key and try with the next
@@ -148,7 +152,7 @@ func createPublicKeyCallback(connCtx context.Context, sshKeywords *SshKeywords,
 						PrivateKey: unencryptedPrivateKey,
 					})
 				}
-				return []ssh.Signer{signer}, err
+				return addCertSigners(signer, certs), err
 			}
 		}
 		if err != x509.IncorrectPasswordError && err.Error() != "bcrypt_pbkdf: empty password" {
@@ -191,7 +195,7 @@ func createPublicKeyCallback(connCtx context.Context, sshKeywords *SshKeywords,
 				PrivateKey: unencryptedPrivateKey,
 			})
 		}
-		return []ssh.Signer{signer}, err
+		return addCertSigners(signer, certs), err
 	}
 }
 
@@ -305,13 +309,24 @@ func createCombinedKbdInteractiveChallenge(connCtx context.Context, password str
 	}
 }
 
+// opens (creating it if needed) a known_hosts file with an exclusive flock, so the host key prompts and
+// /remote:hostkey do not interleave their edits.  writes always append, closing the file releases the lock
 func openKnownHostsForEdit(knownHostsFilename string) (*os.File, error) {
 	path, _ := filepath.Split(knownHostsFilename)
 	err := os.MkdirAll(path, 0700)
 	if err != nil {
 		return nil, err
 	}
-	return os.OpenFile(knownHostsFilename, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	f, err := os.OpenFile(knownHostsFilename, os.O_APPEND|os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	err = unix.Flock(int(f.Fd()), unix.LOCK_EX)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("cannot lock %s: %w", knownHostsFilename, err)
+	}
+	return f, nil
 }
 
 func writeToKnownHosts(knownHostsFile string, newLine string, getUserVerification func() (*userinput.UserInputResponsePacketType, error)) error {
@@ -324,28 +339,27 @@ func writeToKnownHosts(knownHostsFile string, newLine string, getUserVerificatio
 		}
 	}
 
-	path, _ := filepath.Split(knownHostsFile)
-	err := os.MkdirAll(path, 0700)
+	f, err := openKnownHostsForEdit(knownHostsFile)
 	if err != nil {
 		return err
 	}
-	f, err := os.OpenFile(knownHostsFile, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
-	if err != nil {
-		return err
-	}
-	// do not close writeable files with defer
+	// the lock is not held while waiting for the user
+	f.Close()
 
 	// this file works, so let's ask the user for permission
 	response, err := getUserVerification()
 	if err != nil {
-		f.Close()
 		return UserInputCancelError{Err: err}
 	}
 	if !response.Confirm {
-		f.Close()
 		return UserInputCancelError{Err: fmt.Errorf("canceled by the user")}
 	}
 
+	f, err = openKnownHostsForEdit(knownHostsFile)
+	if err != nil {
+		return err
+	}
+	// do not close writeable files with defer
 	_, err = f.WriteString(newLine + "\n")
 	if err != nil {
 		f.Close()
@@ -410,16 +424,17 @@ func lineContainsMatch(line []byte, matches [][]byte) bool {
 	return false
 }
 
-func createHostKeyCallback(opts *sstore.SSHOpts) (ssh.HostKeyCallback, HostKeyAlgorithms, error) {
+// the known_hosts files for the host (user files first, then global ones), with ~ expanded
+func findKnownHostsFiles(sshHost string) ([]string, error) {
 	ssh_config.ReloadConfigs()
-	rawUserKnownHostsFiles, _ := ssh_config.GetStrict(opts.SSHHost, "UserKnownHostsFile")
+	rawUserKnownHostsFiles, _ := ssh_config.GetStrict(sshHost, "UserKnownHostsFile")
 	userKnownHostsFiles := strings.Fields(rawUserKnownHostsFiles) // TODO - smarter splitting escaped spaces and quotes
-	rawGlobalKnownHostsFiles, _ := ssh_config.GetStrict(opts.SSHHost, "GlobalKnownHostsFile")
+	rawGlobalKnownHostsFiles, _ := ssh_config.GetStrict(sshHost, "GlobalKnownHostsFile")
 	globalKnownHostsFiles := strings.Fields(rawGlobalKnownHostsFiles) // TODO - smarter splitting escaped spaces and quotes
 
 	osUser, err := user.Current()
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 	var unexpandedKnownHostsFiles []string
 	if osUser.Username == "root" {
@@ -432,6 +447,14 @@ func createHostKeyCallback(opts *sstore.SSHOpts) (ssh.HostKeyCallback, HostKeyAl
 	for _, filename := range unexpandedKnownHostsFiles {
 		knownHostsFiles = append(knownHostsFiles, base.ExpandHomeDir(filename))
 	}
+	return knownHostsFiles, nil
+}
+
+func createHostKeyCallback(opts *sstore.SSHOpts) (ssh.HostKeyCallback, HostKeyAlgorithms, error) {
+	knownHostsFiles, err := findKnownHostsFiles(opts.SSHHost)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	// there are no good known hosts files
 	if len(knownHostsFiles) == 0 {
@@ -582,8 +605,23 @@ func DialContext(ctx context.Context, network string, addr string, config *ssh.C
 	if err != nil {
 		return nil, err
 	}
+	return newClientConnContext(ctx, conn, addr, config)
+}
+
+// runs the ssh handshake over conn, conn is closed (failing the handshake) if ctx is done first
+func newClientConnContext(ctx context.Context, conn net.Conn, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	stopCloseFn := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
 	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if !stopCloseFn() {
+		if err == nil {
+			c.Close()
+		}
+		return nil, fmt.Errorf("ssh handshake with %s canceled: %w", addr, ctx.Err())
+	}
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
 	return ssh.NewClient(c, chans, reqs), nil
@@ -600,6 +638,21 @@ func ConnectToClient(connCtx context.Context, opts *sstore.SSHOpts, remoteDispla
 		return nil, err
 	}
 
+	if len(sshKeywords.ProxyJump) > 0 {
+		return connectThroughJumpHosts(connCtx, opts, sshKeywords, remoteDisplayName, sshAuthSock)
+	}
+	if sshKeywords.ProxyCommand != "" {
+		return nil, fmt.Errorf("ProxyCommand is not supported (host %s), use ProxyJump instead", opts.SSHHost)
+	}
+	clientConfig, networkAddr, err := createClientConfig(connCtx, opts, sshKeywords, remoteDisplayName)
+	if err != nil {
+		return nil, err
+	}
+	return DialContext(connCtx, "tcp", networkAddr, clientConfig)
+}
+
+// sets up the auth methods and known_hosts verification for a single hop.  returns the config and the address to dial
+func createClientConfig(connCtx context.Context, opts *sstore.SSHOpts, sshKeywords *SshKeywords, remoteDisplayName string) (*ssh.ClientConfig, string, error) {
 	conn, err := net.Dial("unix", sshKeywords.IdentityAgent)
 	var authSockSigners []ssh.Signer
 	var agentClient agent.ExtendedAgent
@@ -651,7 +704,7 @@ func ConnectToClient(connCtx context.Context, opts *sstore.SSHOpts, remoteDispla
 
 	hostKeyCallback, hostKeyAlgorithms, err := createHostKeyCallback(opts)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	networkAddr := sshKeywords.HostName + ":" + sshKeywords.Port
@@ -660,8 +713,9 @@ func ConnectToClient(connCtx context.Context, opts *sstore.SSHOpts, remoteDispla
 		Auth:              authMethods,
 		HostKeyCallback:   hostKeyCallback,
 		HostKeyAlgorithms: hostKeyAlgorithms(networkAddr),
+		Timeout:           RemoteConnectTimeout,
 	}
-	return DialContext(connCtx, "tcp", networkAddr, clientConfig)
+	return clientConfig, networkAddr, nil
 }
 
 type SshKeywords struct {
@@ -669,6 +723,7 @@ type SshKeywords struct {
 	HostName                     string
 	Port                         string
 	IdentityFile                 []string
+	CertificateFile              []string
 	BatchMode                    bool
 	PubkeyAuthentication         bool
 	PasswordAuthentication       bool
@@ -676,6 +731,8 @@ type SshKeywords struct {
 	PreferredAuthentications     []string
 	AddKeysToAgent               bool
 	IdentityAgent                string
+	ProxyJump                    []string
+	ProxyCommand                 string
 }
 
 func combineSshKeywords(opts *sstore.SSHOpts, configKeywords *SshKeywords) (*SshKeywords, error) {
@@ -717,6 +774,7 @@ func combineSshKeywords(opts *sstore.SSHOpts, configKeywords *SshKeywords) (*Ssh
 	} else {
 		sshKeywords.IdentityFile = []string{opts.SSHIdentity}
 	}
+	sshKeywords.CertificateFile = findCertificateFiles(configKeywords.CertificateFile, sshKeywords.IdentityFile)
 
 	// these are not officially supported in the waveterm frontend but can be configured
 	// in ssh config files
@@ -728,6 +786,18 @@ func combineSshKeywords(opts *sstore.SSHOpts, configKeywords *SshKeywords) (*Ssh
 	sshKeywords.AddKeysToAgent = configKeywords.AddKeysToAgent
 	sshKeywords.IdentityAgent = configKeywords.IdentityAgent
 
+	// a jump= set on the remote overrides the ssh config
+	if opts.SSHJump != "" {
+		jumpHops, err := ParseProxyJump(opts.SSHJump)
+		if err != nil {
+			return nil, err
+		}
+		sshKeywords.ProxyJump = jumpHops
+	} else {
+		sshKeywords.ProxyJump = configKeywords.ProxyJump
+	}
+	sshKeywords.ProxyCommand = configKeywords.ProxyCommand
+
 	return sshKeywords, nil
 }
 
@@ -755,6 +825,7 @@ func findSshConfigKeywords(hostPattern string, sshAuthSock string) (*SshKeywords
 	}
 
 	sshKeywords.IdentityFile = ssh_config.GetAll(hostPattern, "IdentityFile")
+	sshKeywords.CertificateFile = ssh_config.GetAll(hostPattern, "CertificateFile")
 
 	batchModeRaw, err := ssh_config.GetStrict(hostPattern, "BatchMode")
 	if err != nil {
@@ -805,5 +876,22 @@ func findSshConfigKeywords(hostPattern string, sshAuthSock string) (*SshKeywords
 		sshKeywords.IdentityAgent = base.ExpandHomeDir(utilfn.TryTrimQuotes(identityAgentRaw))
 	}
 
+	proxyJumpRaw, err := ssh_config.GetStrict(hostPattern, "ProxyJump")
+	if err != nil {
+		return nil, err
+	}
+	sshKeywords.ProxyJump, err = ParseProxyJump(proxyJumpRaw)
+	if err != nil {
+		return nil, err
+	}
+
+	proxyCommandRaw, err := ssh_config.GetStrict(hostPattern, "ProxyCommand")
+	if err != nil {
+		return nil, err
+	}
+	if strings.ToLower(proxyCommandRaw) != "none" {
+		sshKeywords.ProxyCommand = proxyCommandRaw
+	}
+
 	return sshKeywords, nil
 }
@@ -0,0 +1,155 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/abhishek944/waveterm/wavesrv/pkg/sstore"
+	"golang.org/x/crypto/ssh"
+)
+
+const MaxJumpHops = 10
+
+// parses a ProxyJump value (comma separated list of [user@]host[:port] or ssh://[user@]host[:port]).
+// "" and "none" return no hops
+func ParseProxyJump(proxyJump string) ([]string, error) {
+	proxyJump = strings.TrimSpace(proxyJump)
+	if proxyJump == "" || strings.ToLower(proxyJump) == "none" {
+		return nil, nil
+	}
+	var hops []string
+	for _, hop := range strings.Split(proxyJump, ",") {
+		hop = strings.TrimSpace(hop)
+		if _, err := parseJumpHop(hop); err != nil {
+			return nil, err
+		}
+		hops = append(hops, hop)
+	}
+	if len(hops) > MaxJumpHops {
+		return nil, fmt.Errorf("too many jump hosts (max %d)", MaxJumpHops)
+	}
+	return hops, nil
+}
+
+func parseJumpHop(hop string) (*sstore.SSHOpts, error) {
+	hopStr := strings.TrimPrefix(hop, "ssh://")
+	if hopStr == "" {
+		return nil, fmt.Errorf("invalid jump host %q", hop)
+	}
+	opts := &sstore.SSHOpts{}
+	if atIdx := strings.LastIndex(hopStr, "@"); atIdx >= 0 {
+		opts.SSHUser = hopStr[:atIdx]
+		hopStr = hopStr[atIdx+1:]
+	}
+	host, portStr := hopStr, ""
+	if strings.HasPrefix(hopStr, "[") {
+		// [ipv6]:port
+		endIdx := strings.Index(hopStr, "]")
+		if endIdx < 0 {
+			return nil, fmt.Errorf("invalid jump host %q", hop)
+		}
+		host = hopStr[1:endIdx]
+		portStr = strings.TrimPrefix(hopStr[endIdx+1:], ":")
+	} else if colonIdx := strings.LastIndex(hopStr, ":"); colonIdx >= 0 {
+		host, portStr = hopStr[:colonIdx], hopStr[colonIdx+1:]
+	}
+	if host == "" || strings.ContainsAny(host, " \t/") {
+		return nil, fmt.Errorf("invalid jump host %q", hop)
+	}
+	opts.SSHHost = host
+	if portStr != "" {
+		port, err := strconv.Atoi(portStr)
+		if err != nil || port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("invalid port in jump host %q", hop)
+		}
+		opts.SSHPort = port
+	}
+	return opts, nil
+}
+
+// connects to each jump host in turn (tunneling through the previous one), then to the target.
+// every hop gets its own ssh config lookup, auth callbacks and known_hosts verification (ProxyJump set on
+// a jump host itself is not followed).  the jump connections are closed when the returned client closes.
+// all the dials of the chain share one RemoteConnectTimeout deadline, the handshakes stop when connCtx is done
+func connectThroughJumpHosts(connCtx context.Context, opts *sstore.SSHOpts, sshKeywords *SshKeywords, remoteDisplayName string, sshAuthSock string) (*ssh.Client, error) {
+	dialCtx, cancelFn := context.WithTimeout(connCtx, RemoteConnectTimeout)
+	defer cancelFn()
+	client, closeJumpClients, err := connectJumpHosts(connCtx, dialCtx, sshKeywords.ProxyJump, remoteDisplayName, sshAuthSock)
+	if err != nil {
+		return nil, err
+	}
+	targetClient, err := dialHop(connCtx, dialCtx, client, opts, sshKeywords, remoteDisplayName)
+	if err != nil {
+		closeJumpClients()
+		return nil, err
+	}
+	go func() {
+		targetClient.Wait()
+		closeJumpClients()
+	}()
+	return targetClient, nil
+}
+
+// viaClient == nil dials directly.  dialCtx bounds the (tcp or tunneled) dial, connCtx the handshake
+func dialHop(connCtx context.Context, dialCtx context.Context, viaClient *ssh.Client, opts *sstore.SSHOpts, sshKeywords *SshKeywords, displayName string) (*ssh.Client, error) {
+	clientConfig, networkAddr, err := createClientConfig(connCtx, opts, sshKeywords, displayName)
+	if err != nil {
+		return nil, err
+	}
+	var conn net.Conn
+	if viaClient == nil {
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(dialCtx, "tcp", networkAddr)
+	} else {
+		conn, err = viaClient.DialContext(dialCtx, "tcp", networkAddr)
+	}
+	if err != nil {
+		if dialCtx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("timed out connecting to %s", networkAddr)
+		}
+		return nil, err
+	}
+	return newClientConnContext(connCtx, conn, networkAddr, clientConfig)
+}
+
+// connects the jump host chain, returns the client for the last hop and a func that closes all of them (last hop first)
+func connectJumpHosts(connCtx context.Context, dialCtx context.Context, jumpHops []string, remoteDisplayName string, sshAuthSock string) (*ssh.Client, func(), error) {
+	var jumpClients []*ssh.Client
+	closeJumpClients := func() {
+		for idx := len(jumpClients) - 1; idx >= 0; idx-- {
+			jumpClients[idx].Close()
+		}
+	}
+	var client *ssh.Client
+	for idx, hop := range jumpHops {
+		hopOpts, err := parseJumpHop(hop)
+		if err != nil {
+			closeJumpClients()
+			return nil, nil, err
+		}
+		hopConfigKeywords, err := findSshConfigKeywords(hopOpts.SSHHost, sshAuthSock)
+		if err != nil {
+			closeJumpClients()
+			return nil, nil, err
+		}
+		hopKeywords, err := combineSshKeywords(hopOpts, hopConfigKeywords)
+		if err != nil {
+			closeJumpClients()
+			return nil, nil, err
+		}
+		hopDisplayName := fmt.Sprintf("%s (jump host %d for %s)", hop, idx+1, remoteDisplayName)
+		client, err = dialHop(connCtx, dialCtx, client, hopOpts, hopKeywords, hopDisplayName)
+		if err != nil {
+			closeJumpClients()
+			return nil, nil, fmt.Errorf("cannot connect to jump host %s: %w", hop, err)
+		}
+		jumpClients = append(jumpClients, client)
+	}
+	return client, closeJumpClients, nil
+}
@@ -0,0 +1,72 @@
+package remote
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParseProxyJump(t *testing.T) {
+	hops, err := ParseProxyJump("bastion, deploy@inner:2222,ssh://admin@[fe80::1]:22")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(hops) != 3 || hops[1] != "deploy@inner:2222" {
+		t.Fatalf("bad hops: %v", hops)
+	}
+	opts, _ := parseJumpHop(hops[0])
+	if opts.SSHHost != "bastion" || opts.SSHUser != "" || opts.SSHPort != 0 {
+		t.Errorf("bad hop 0: %+v", opts)
+	}
+	opts, _ = parseJumpHop(hops[1])
+	if opts.SSHHost != "inner" || opts.SSHUser != "deploy" || opts.SSHPort != 2222 {
+		t.Errorf("bad hop 1: %+v", opts)
+	}
+	opts, _ = parseJumpHop(hops[2])
+	if opts.SSHHost != "fe80::1" || opts.SSHUser != "admin" || opts.SSHPort != 22 {
+		t.Errorf("bad hop 2: %+v", opts)
+	}
+	for _, val := range []string{"", "none", "NONE"} {
+		hops, err = ParseProxyJump(val)
+		if err != nil || hops != nil {
+			t.Errorf("%q should have no hops: %v %v", val, hops, err)
+		}
+	}
+	for _, val := range []string{"bastion,", "host:abc", "host:70000", "user@", "[fe80::1"} {
+		_, err = ParseProxyJump(val)
+		if err == nil {
+			t.Errorf("%q should be an error", val)
+		}
+	}
+}
+
+// a server that accepts but never answers the handshake must not hang the connect
+func TestDialContextHandshakeCanceled(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	ctx, cancelFn := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancelFn()
+	startTs := time.Now()
+	_, err = DialContext(ctx, "tcp", listener.Addr().String(), &ssh.ClientConfig{HostKeyCallback: ssh.InsecureIgnoreHostKey()})
+	if err == nil {
+		t.Fatalf("expected handshake error")
+	}
+	if time.Since(startTs) > 5*time.Second {
+		t.Errorf("handshake was not canceled with the ctx (took %v)", time.Since(startTs))
+	}
+}
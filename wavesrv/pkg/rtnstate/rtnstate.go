@@ -214,7 +214,8 @@ func DisplayStateUpdateDiff(buf *bytes.Buffer, oldState packet.ShellState, newSt
 			}
 		}
 	}
-	if newState.GetShellType() == packet.ShellType_zsh {
+	if newState.GetShellType() == packet.ShellType_zsh || newState.GetShellType() == packet.ShellType_fish {
+		// fish abbreviations and functions are stored in the same map format as zsh
 		makeZshAlisesDiff(buf, oldState.Aliases, newState.Aliases)
 		makeZshFuncsDiff(buf, oldState.Funcs, newState.Funcs)
 	} else {
@@ -0,0 +1,48 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+
+	"github.com/abhishek944/waveterm/wavesrv/pkg/dbutil"
+)
+
+// the full record of an /agent tool call (the copy kept in the line state under LineState_AgentAudit is truncated)
+type AIAuditEntryType struct {
+	ScreenId string `json:"screenid"`
+	LineId   string `json:"lineid"`
+	CallIdx  int    `json:"callidx"`
+	Step     int    `json:"step"`
+	Tool     string `json:"tool"`
+	Args     string `json:"args"`
+	Status   string `json:"status"`
+	Error    string `json:"error"`
+	Bytes    int    `json:"bytes"`
+	Ts       int64  `json:"ts"`
+}
+
+func (AIAuditEntryType) UseDBMap() {}
+
+func InsertAIAuditEntry(ctx context.Context, entry *AIAuditEntryType) error {
+	return WithTx(ctx, func(tx *TxWrap) error {
+		query := `INSERT INTO ai_audit ( screenid, lineid, callidx, step, tool, args, status, error, bytes, ts)
+		                        VALUES (:screenid,:lineid,:callidx,:step,:tool,:args,:status,:error,:bytes,:ts)`
+		tx.NamedExec(query, dbutil.ToDBMap(entry, false))
+		return nil
+	})
+}
+
+func GetAIAuditEntries(ctx context.Context, screenId string, lineId string) ([]*AIAuditEntryType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) ([]*AIAuditEntryType, error) {
+		query := `SELECT * FROM ai_audit WHERE screenid = ? AND lineid = ? ORDER BY callidx`
+		return dbutil.SelectMappable[*AIAuditEntryType](tx, query, screenId, lineId), nil
+	})
+}
+
+// called from within the screen delete transaction
+func deleteAIAuditForScreen(tx *TxWrap, screenId string) {
+	query := `DELETE FROM ai_audit WHERE screenid = ?`
+	tx.Exec(query, screenId)
+}
@@ -0,0 +1,130 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/abhishek944/waveterm/wavesrv/pkg/dbutil"
+)
+
+const (
+	AIRoleUser      = "user"
+	AIRoleAssistant = "assistant"
+)
+
+const MaxAIThreadTitleLen = 200
+
+// a persisted /agent conversation, tied to the screen (and the line of its first prompt)
+type AIThreadType struct {
+	ThreadId  string `json:"threadid"`
+	ScreenId  string `json:"screenid"`
+	LineId    string `json:"lineid"`
+	Title     string `json:"title"`
+	CreatedTs int64  `json:"createdts"`
+	UpdatedTs int64  `json:"updatedts"`
+
+	// not persisted, computed when listing threads
+	NumMessages int `json:"nummessages" dbmap:"nummessages"`
+}
+
+func (AIThreadType) UseDBMap() {}
+
+type AIMessageType struct {
+	ThreadId         string `json:"threadid"`
+	MsgIdx           int    `json:"msgidx"`
+	LineId           string `json:"lineid"`
+	Role             string `json:"role"`
+	Content          string `json:"content"`
+	Model            string `json:"model"`
+	Provider         string `json:"provider"`
+	PromptTokens     int    `json:"prompttokens"`
+	CompletionTokens int    `json:"completiontokens"`
+	Ts               int64  `json:"ts"`
+}
+
+func (AIMessageType) UseDBMap() {}
+
+func CreateAIThread(ctx context.Context, thread *AIThreadType) error {
+	if thread.ThreadId == "" || thread.ScreenId == "" {
+		return fmt.Errorf("invalid ai thread, threadid and screenid must be set")
+	}
+	return WithTx(ctx, func(tx *TxWrap) error {
+		query := `INSERT INTO ai_thread ( threadid, screenid, lineid, title, createdts, updatedts)
+		                         VALUES (:threadid,:screenid,:lineid,:title,:createdts,:updatedts)`
+		tx.NamedExec(query, dbutil.ToDBMap(thread, false))
+		return nil
+	})
+}
+
+// sets msg.MsgIdx to the next index in the thread and bumps the thread's updatedts
+func AddAIMessage(ctx context.Context, msg *AIMessageType) error {
+	return WithTx(ctx, func(tx *TxWrap) error {
+		query := `SELECT threadid FROM ai_thread WHERE threadid = ?`
+		if !tx.Exists(query, msg.ThreadId) {
+			return fmt.Errorf("ai thread %q not found", msg.ThreadId)
+		}
+		if msg.Ts == 0 {
+			msg.Ts = time.Now().UnixMilli()
+		}
+		query = `SELECT COALESCE(max(msgidx), -1) FROM ai_message WHERE threadid = ?`
+		msg.MsgIdx = tx.GetInt(query, msg.ThreadId) + 1
+		query = `INSERT INTO ai_message ( threadid, msgidx, lineid, role, content, model, provider, prompttokens, completiontokens, ts)
+		                          VALUES (:threadid,:msgidx,:lineid,:role,:content,:model,:provider,:prompttokens,:completiontokens,:ts)`
+		tx.NamedExec(query, dbutil.ToDBMap(msg, false))
+		query = `UPDATE ai_thread SET updatedts = ? WHERE threadid = ?`
+		tx.Exec(query, msg.Ts, msg.ThreadId)
+		return nil
+	})
+}
+
+// screenId == "" returns threads for all screens.  most recently updated first
+func GetAIThreads(ctx context.Context, screenId string) ([]*AIThreadType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) ([]*AIThreadType, error) {
+		query := `SELECT t.*, (SELECT count(*) FROM ai_message m WHERE m.threadid = t.threadid) AS nummessages
+		          FROM ai_thread t
+		          WHERE ? = '' OR t.screenid = ?
+		          ORDER BY t.updatedts DESC`
+		return dbutil.SelectMappable[*AIThreadType](tx, query, screenId, screenId), nil
+	})
+}
+
+// can return nil, nil if thread is not found
+func GetAIThreadById(ctx context.Context, threadId string) (*AIThreadType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) (*AIThreadType, error) {
+		query := `SELECT t.*, (SELECT count(*) FROM ai_message m WHERE m.threadid = t.threadid) AS nummessages
+		          FROM ai_thread t
+		          WHERE t.threadid = ?`
+		return dbutil.GetMappable[*AIThreadType](tx, query, threadId), nil
+	})
+}
+
+func GetAIMessages(ctx context.Context, threadId string) ([]*AIMessageType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) ([]*AIMessageType, error) {
+		query := `SELECT * FROM ai_message WHERE threadid = ? ORDER BY msgidx`
+		return dbutil.SelectMappable[*AIMessageType](tx, query, threadId), nil
+	})
+}
+
+func DeleteAIThread(ctx context.Context, threadId string) error {
+	return WithTx(ctx, func(tx *TxWrap) error {
+		query := `SELECT threadid FROM ai_thread WHERE threadid = ?`
+		if !tx.Exists(query, threadId) {
+			return fmt.Errorf("ai thread %q not found", threadId)
+		}
+		tx.Exec(`DELETE FROM ai_message WHERE threadid = ?`, threadId)
+		tx.Exec(`DELETE FROM ai_thread WHERE threadid = ?`, threadId)
+		return nil
+	})
+}
+
+// called from within the screen delete transaction
+func deleteAIThreadsForScreen(tx *TxWrap, screenId string) {
+	query := `DELETE FROM ai_message WHERE threadid IN (SELECT threadid FROM ai_thread WHERE screenid = ?)`
+	tx.Exec(query, screenId)
+	query = `DELETE FROM ai_thread WHERE screenid = ?`
+	tx.Exec(query, screenId)
+}
@@ -0,0 +1,77 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/abhishek944/waveterm/waveshell/pkg/packet"
+	"github.com/abhishek944/waveterm/wavesrv/pkg/dbutil"
+)
+
+const AIUsageDayFormat = "2006-01-02"
+
+// token usage aggregated per (day, provider, model, screen).  day is the local date (AIUsageDayFormat)
+type AIUsageType struct {
+	Day              string `json:"day"`
+	Provider         string `json:"provider"`
+	Model            string `json:"model"`
+	ScreenId         string `json:"screenid"`
+	NumRequests      int    `json:"numrequests"`
+	PromptTokens     int    `json:"prompttokens"`
+	CompletionTokens int    `json:"completiontokens"`
+}
+
+func (AIUsageType) UseDBMap() {}
+
+func (u *AIUsageType) TotalTokens() int {
+	return u.PromptTokens + u.CompletionTokens
+}
+
+func AIUsageDay(ts time.Time) string {
+	return ts.Local().Format(AIUsageDayFormat)
+}
+
+// usage can be nil (counts the request, but no tokens)
+func RecordAIUsage(ctx context.Context, provider string, model string, screenId string, usage *packet.OpenAIUsageType) error {
+	rec := &AIUsageType{
+		Day:         AIUsageDay(time.Now()),
+		Provider:    provider,
+		Model:       model,
+		ScreenId:    screenId,
+		NumRequests: 1,
+	}
+	if usage != nil {
+		rec.PromptTokens = usage.PromptTokens
+		rec.CompletionTokens = usage.CompletionTokens
+	}
+	return WithTx(ctx, func(tx *TxWrap) error {
+		query := `INSERT INTO ai_usage ( day, provider, model, screenid, numrequests, prompttokens, completiontokens)
+		                        VALUES (:day,:provider,:model,:screenid,:numrequests,:prompttokens,:completiontokens)
+		          ON CONFLICT (day, provider, model, screenid) DO UPDATE
+		          SET numrequests = numrequests + excluded.numrequests,
+		              prompttokens = prompttokens + excluded.prompttokens,
+		              completiontokens = completiontokens + excluded.completiontokens`
+		tx.NamedExec(query, dbutil.ToDBMap(rec, false))
+		return nil
+	})
+}
+
+// fromDay and toDay are inclusive (AIUsageDayFormat), screenId == "" returns usage for all screens
+func GetAIUsage(ctx context.Context, fromDay string, toDay string, screenId string) ([]*AIUsageType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) ([]*AIUsageType, error) {
+		query := `SELECT * FROM ai_usage
+		          WHERE day >= ? AND day <= ? AND (? = '' OR screenid = ?)
+		          ORDER BY day, provider, model`
+		return dbutil.SelectMappable[*AIUsageType](tx, query, fromDay, toDay, screenId, screenId), nil
+	})
+}
+
+func GetAIUsageTokensForDay(ctx context.Context, day string) (int, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) (int, error) {
+		query := `SELECT COALESCE(sum(prompttokens + completiontokens), 0) FROM ai_usage WHERE day = ?`
+		return tx.GetInt(query, day), nil
+	})
+}
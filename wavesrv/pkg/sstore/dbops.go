@@ -28,6 +28,10 @@ var updateWriterCVar = sync.NewCond(&sync.Mutex{})
 var WebScreenPtyPosLock = &sync.Mutex{}
 var WebScreenPtyPosDelIntent = make(map[string]bool) // map[screenid + ":" + lineid] -> bool
 
+// called inside the line/screen delete transaction, before the history items are unlinked from the lines
+// (lineIds == nil means every line of the screen).  set by history (which depends on sstore) to drop the indexed output
+var HistoryLinesDeletedHandler func(tx *TxWrap, screenId string, lineIds []string)
+
 type SingleConnDBGetter struct {
 	SingleConnLock *sync.Mutex
 }
@@ -226,8 +230,8 @@ func UpsertRemote(ctx context.Context, r *RemoteType) error {
 		maxRemoteIdx := tx.GetInt(query)
 		r.RemoteIdx = int64(maxRemoteIdx + 1)
 		query = `INSERT INTO remote
-            ( remoteid, remotetype, remotealias, remotecanonicalname, remoteuser, remotehost, connectmode, autoinstall, sshopts, remoteopts, lastconnectts, archived, remoteidx, local, statevars, sshconfigsrc, openaiopts, shellpref) VALUES
-            (:remoteid,:remotetype,:remotealias,:remotecanonicalname,:remoteuser,:remotehost,:connectmode,:autoinstall,:sshopts,:remoteopts,:lastconnectts,:archived,:remoteidx,:local,:statevars,:sshconfigsrc,:openaiopts,:shellpref)`
+            ( remoteid, remotetype, remotealias, remotecanonicalname, remoteuser, remotehost, connectmode, autoinstall, sshopts, remoteopts, lastconnectts, archived, remoteidx, local, statevars, sshconfigsrc, openaiopts, shellpref, execopts, socketopts) VALUES
+            (:remoteid,:remotetype,:remotealias,:remotecanonicalname,:remoteuser,:remotehost,:connectmode,:autoinstall,:sshopts,:remoteopts,:lastconnectts,:archived,:remoteidx,:local,:statevars,:sshconfigsrc,:openaiopts,:shellpref,:execopts,:socketopts)`
 		tx.NamedExec(query, r.ToMap())
 		return nil
 	})
@@ -756,6 +760,8 @@ func UpdateCmdForRestart(ctx context.Context, ck base.CommandKey, ts int64, cmdP
 		         SET ts = ?, status = ?, exitcode = ?, durationms = ?
 			     WHERE screenid = ? AND lineid = ?`
 		tx.Exec(query, ts, CmdStatusRunning, 0, 0, ck.GetGroupId(), lineIdFromCK(ck))
+		query = `UPDATE line SET linestate = json_remove(linestate, '$."` + LineState_HangupReason + `"') WHERE screenid = ? AND lineid = ?`
+		tx.Exec(query, ck.GetGroupId(), lineIdFromCK(ck))
 		return nil
 	})
 }
@@ -868,7 +874,8 @@ func HangupAllRunningCmds(ctx context.Context) error {
 }
 
 // TODO send update
-func HangupRunningCmdsByRemoteId(ctx context.Context, remoteId string) ([]*ScreenType, error) {
+// reason (if set) is stored in the line state (LineState_HangupReason)
+func HangupRunningCmdsByRemoteId(ctx context.Context, remoteId string, reason string) ([]*ScreenType, error) {
 	return WithTxRtn(ctx, func(tx *TxWrap) ([]*ScreenType, error) {
 		var cmdPtrs []CmdPtr
 		query := `SELECT screenid, lineid FROM cmd WHERE status = ? AND remoteid = ?`
@@ -882,6 +889,10 @@ func HangupRunningCmdsByRemoteId(ctx context.Context, remoteId string) ([]*Scree
 			}
 			query = `UPDATE history SET status = ? WHERE screenid = ? AND lineid = ?`
 			tx.Exec(query, CmdStatusHangup, cmdPtr.ScreenId, cmdPtr.LineId)
+			if reason != "" {
+				query = `UPDATE line SET linestate = json_set(linestate, '$."` + LineState_HangupReason + `"', ?) WHERE screenid = ? AND lineid = ?`
+				tx.Exec(query, reason, cmdPtr.ScreenId, cmdPtr.LineId)
+			}
 			screen, err := UpdateScreenFocusForDoneCmd(tx.Context(), cmdPtr.ScreenId, cmdPtr.LineId)
 			if err != nil {
 				return nil, err
@@ -1098,6 +1109,11 @@ func DeleteScreen(ctx context.Context, screenId string, sessionDel bool, update
 		tx.Exec(query, screenId)
 		query = `DELETE FROM cmd WHERE screenid = ?`
 		tx.Exec(query, screenId)
+		deleteAIThreadsForScreen(tx, screenId)
+		deleteAIAuditForScreen(tx, screenId)
+		if HistoryLinesDeletedHandler != nil {
+			HistoryLinesDeletedHandler(tx, screenId, nil)
+		}
 		query = `UPDATE history SET lineid = '', linenum = 0 WHERE screenid = ?`
 		tx.Exec(query, screenId)
 		if webSharing {
@@ -1386,9 +1402,14 @@ func DeleteScreenLines(ctx context.Context, screenId string) (*scbus.ModelUpdate
 		query = `DELETE FROM line 
 				 WHERE screenid = ? AND lineid IN (SELECT value FROM json_each(?))`
 		tx.Exec(query, screenId, quickJsonArr(lineIds))
+		if HistoryLinesDeletedHandler != nil {
+			HistoryLinesDeletedHandler(tx, screenId, lineIds)
+		}
 		query = `UPDATE history SET lineid = '', linenum = 0 
 		         WHERE screenid = ? AND lineid IN (SELECT value FROM json_each(?))`
 		tx.Exec(query, screenId, quickJsonArr(lineIds))
+		query = `DELETE FROM ai_audit WHERE screenid = ? AND lineid IN (SELECT value FROM json_each(?))`
+		tx.Exec(query, screenId, quickJsonArr(lineIds))
 		return nil
 	})
 	if txErr != nil {
@@ -1635,12 +1656,15 @@ func GetSessionStats(ctx context.Context, sessionId string) (*SessionStatsType,
 }
 
 const (
-	RemoteField_Alias       = "alias"       // string
-	RemoteField_ConnectMode = "connectmode" // string
-	RemoteField_SSHKey      = "sshkey"      // string
-	RemoteField_SSHPassword = "sshpassword" // string
-	RemoteField_Color       = "color"       // string
-	RemoteField_ShellPref   = "shellpref"   // string
+	RemoteField_Alias           = "alias"           // string
+	RemoteField_ConnectMode     = "connectmode"     // string
+	RemoteField_SSHKey          = "sshkey"          // string
+	RemoteField_SSHPassword     = "sshpassword"     // string
+	RemoteField_SSHJump         = "sshjump"         // string
+	RemoteField_SSHAgentForward = "sshagentforward" // bool
+	RemoteField_Forwards        = "forwards"        // []PortForwardType
+	RemoteField_Color           = "color"           // string
+	RemoteField_ShellPref       = "shellpref"       // string
 )
 
 // editMap: alias, connectmode, autoinstall, sshkey, color, sshpassword (from constants)
@@ -1672,6 +1696,14 @@ func UpdateRemote(ctx context.Context, remoteId string, editMap map[string]inter
 			query = `UPDATE remote SET sshopts = json_set(sshopts, '$.sshpassword', ?) WHERE remoteid = ?`
 			tx.Exec(query, sshPassword, remoteId)
 		}
+		if sshJump, found := editMap[RemoteField_SSHJump]; found {
+			query = `UPDATE remote SET sshopts = json_set(sshopts, '$.sshjump', ?) WHERE remoteid = ?`
+			tx.Exec(query, sshJump, remoteId)
+		}
+		if agentForward, found := editMap[RemoteField_SSHAgentForward]; found {
+			query = `UPDATE remote SET sshopts = json_set(sshopts, '$.sshagentforward', json(?)) WHERE remoteid = ?`
+			tx.Exec(query, fmt.Sprintf("%t", agentForward), remoteId)
+		}
 		if shellPref, found := editMap[RemoteField_ShellPref]; found {
 			query = `UPDATE remote SET shellpref = ? WHERE remoteid = ?`
 			tx.Exec(query, shellPref, remoteId)
@@ -1680,6 +1712,10 @@ func UpdateRemote(ctx context.Context, remoteId string, editMap map[string]inter
 			query = `UPDATE remote SET remoteopts = json_set(remoteopts, '$.color', ?) WHERE remoteid = ?`
 			tx.Exec(query, color, remoteId)
 		}
+		if forwards, found := editMap[RemoteField_Forwards]; found {
+			query = `UPDATE remote SET remoteopts = json_set(remoteopts, '$.forwards', json(?)) WHERE remoteid = ?`
+			tx.Exec(query, quickJsonArr(forwards), remoteId)
+		}
 		var err error
 		rtn, err = GetRemoteById(tx.Context(), remoteId)
 		if err != nil {
@@ -1703,6 +1739,7 @@ const (
 	ScreenField_PTerm        = "pterm"        // string
 	ScreenField_Name         = "name"         // string
 	ScreenField_ShareName    = "sharename"    // string
+	ScreenField_AutoExplain  = "autoexplain"  // bool
 )
 
 func UpdateScreen(ctx context.Context, screenId string, editMap map[string]interface{}) (*ScreenType, error) {
@@ -1742,6 +1779,10 @@ func UpdateScreen(ctx context.Context, screenId string, editMap map[string]inter
 			query = `UPDATE screen SET screenopts = json_set(screenopts, '$.pterm', ?) WHERE screenid = ?`
 			tx.Exec(query, pterm, screenId)
 		}
+		if autoExplain, found := editMap[ScreenField_AutoExplain]; found {
+			query = `UPDATE screen SET screenopts = json_set(screenopts, '$.autoexplain', json(?)) WHERE screenid = ?`
+			tx.Exec(query, fmt.Sprintf("%t", autoExplain), screenId)
+		}
 		if name, found := editMap[ScreenField_Name]; found {
 			query = `UPDATE screen SET name = ? WHERE screenid = ?`
 			tx.Exec(query, name, screenId)
@@ -2024,6 +2065,33 @@ func UpdateLineState(ctx context.Context, screenId string, lineId string, lineSt
 	})
 }
 
+// sets one key in the line's linestate.  the linestate is read in the same tx, so updates to the other
+// keys since the line was loaded are kept
+func SetLineStateKey(ctx context.Context, screenId string, lineId string, key string, val any) error {
+	return WithTx(ctx, func(tx *TxWrap) error {
+		query := `SELECT * FROM line WHERE screenid = ? AND lineid = ?`
+		line := dbutil.GetMappable[*LineType](tx, query, screenId, lineId)
+		if line == nil {
+			return fmt.Errorf("line[%s:%s] not found", screenId, lineId)
+		}
+		lineState := make(map[string]any)
+		for stateKey, stateVal := range line.LineState {
+			lineState[stateKey] = stateVal
+		}
+		lineState[key] = val
+		qjs := dbutil.QuickJson(lineState)
+		if len(qjs) > MaxLineStateSize {
+			return fmt.Errorf("linestate for line[%s:%s] exceeds maxsize, size[%d] max[%d]", screenId, lineId, len(qjs), MaxLineStateSize)
+		}
+		query = `UPDATE line SET linestate = ? WHERE screenid = ? AND lineid = ?`
+		tx.Exec(query, qjs, screenId, lineId)
+		if isWebShare(tx, screenId) {
+			insertScreenLineUpdate(tx, screenId, lineId, UpdateType_LineState)
+		}
+		return nil
+	})
+}
+
 // can return nil, nil if line is not found
 func GetLineById(ctx context.Context, screenId string, lineId string) (*LineType, error) {
 	return WithTxRtn(ctx, func(tx *TxWrap) (*LineType, error) {
@@ -2098,6 +2166,11 @@ func DeleteLinesByIds(ctx context.Context, screenId string, lineIds []string) er
 			tx.Exec(query, screenId, lineId)
 			query = `DELETE FROM cmd WHERE screenid = ? AND lineid = ?`
 			tx.Exec(query, screenId, lineId)
+			query = `DELETE FROM ai_audit WHERE screenid = ? AND lineid = ?`
+			tx.Exec(query, screenId, lineId)
+			if HistoryLinesDeletedHandler != nil {
+				HistoryLinesDeletedHandler(tx, screenId, []string{lineId})
+			}
 			// don't delete history anymore, just remove lineid reference
 			query = `UPDATE history SET lineid = '', linenum = 0 WHERE screenid = ? AND lineid = ?`
 			tx.Exec(query, screenId, lineId)
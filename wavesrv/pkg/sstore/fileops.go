@@ -164,6 +164,19 @@ func SessionDiskSize(sessionId string) (SessionDiskSizeType, error) {
 	return directorySize(sessionDir)
 }
 
+// does not create the screen dir, a screen without one has a size of 0
+func ScreenDiskSize(screenId string) (SessionDiskSizeType, error) {
+	if screenId == "" {
+		return SessionDiskSizeType{}, fmt.Errorf("cannot get screen dir for blank screenid")
+	}
+	screenDir := path.Join(scbase.GetScreensDir(), screenId)
+	rtn, err := directorySize(screenDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return SessionDiskSizeType{Location: screenDir}, nil
+	}
+	return rtn, err
+}
+
 func FullSessionDiskSize() (map[string]SessionDiskSizeType, error) {
 	sdir := scbase.GetSessionsDir()
 	entries, err := os.ReadDir(sdir)
@@ -46,6 +46,7 @@ type ScreenMemState struct {
 	CmdInputText       utilfn.StrWithPos       `json:"cmdinputtext,omitempty"`
 	CmdInputSeqNum     int                     `json:"cmdinputseqnum,omitempty"`
 	AICmdInfoChat      *OpenAICmdInfoChatStore `json:"aicmdinfochat,omitempty"`
+	AgentThreadId      string                  `json:"agentthreadid,omitempty"` // set by /agent:resume (threads are persisted in the db)
 }
 
 func ScreenMemDeepCopyCmdInfoChatStore(store *OpenAICmdInfoChatStore) *OpenAICmdInfoChatStore {
@@ -140,6 +141,24 @@ func ScreenMemUpdateCmdInfoChatMessage(screenId string, messageID int, msg *pack
 	return nil
 }
 
+func ScreenMemSetAgentThread(screenId string, threadId string) {
+	MemLock.Lock()
+	defer MemLock.Unlock()
+	if ScreenMemStore[screenId] == nil {
+		ScreenMemStore[screenId] = &ScreenMemState{}
+	}
+	ScreenMemStore[screenId].AgentThreadId = threadId
+}
+
+func ScreenMemGetAgentThread(screenId string) string {
+	MemLock.Lock()
+	defer MemLock.Unlock()
+	if ScreenMemStore[screenId] == nil {
+		return ""
+	}
+	return ScreenMemStore[screenId].AgentThreadId
+}
+
 func ScreenMemSetCmdInputText(screenId string, sp utilfn.StrWithPos, seqNum int) {
 	MemLock.Lock()
 	defer MemLock.Unlock()
@@ -0,0 +1,99 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/abhishek944/waveterm/wavesrv/pkg/dbutil"
+)
+
+const MaxRemoteGroupSize = 100
+
+// a named set of remotes (for /run:all), members are stored as remoteids so renames don't break the group
+type RemoteGroupType struct {
+	GroupName string   `json:"groupname"`
+	RemoteIds []string `json:"remoteids"`
+	CreatedTs int64    `json:"createdts"`
+}
+
+func (RemoteGroupType) UseDBMap() {}
+
+func (g *RemoteGroupType) HasRemote(remoteId string) bool {
+	return containsStr(g.RemoteIds, remoteId)
+}
+
+func GetAllRemoteGroups(ctx context.Context) ([]*RemoteGroupType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) ([]*RemoteGroupType, error) {
+		query := `SELECT * FROM remote_group ORDER BY groupname`
+		return dbutil.SelectMappable[*RemoteGroupType](tx, query), nil
+	})
+}
+
+// can return nil, nil if the group does not exist
+func GetRemoteGroup(ctx context.Context, groupName string) (*RemoteGroupType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) (*RemoteGroupType, error) {
+		query := `SELECT * FROM remote_group WHERE groupname = ?`
+		return dbutil.GetMappable[*RemoteGroupType](tx, query, groupName), nil
+	})
+}
+
+// creates the group if it does not exist, remotes already in the group are ignored
+func AddRemoteGroupMembers(ctx context.Context, groupName string, remoteIds []string) (*RemoteGroupType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) (*RemoteGroupType, error) {
+		query := `SELECT * FROM remote_group WHERE groupname = ?`
+		group := dbutil.GetMappable[*RemoteGroupType](tx, query, groupName)
+		if group == nil {
+			group = &RemoteGroupType{GroupName: groupName, CreatedTs: time.Now().UnixMilli()}
+		}
+		for _, remoteId := range remoteIds {
+			if !group.HasRemote(remoteId) {
+				group.RemoteIds = append(group.RemoteIds, remoteId)
+			}
+		}
+		if len(group.RemoteIds) > MaxRemoteGroupSize {
+			return nil, fmt.Errorf("remote group %q cannot have more than %d members", groupName, MaxRemoteGroupSize)
+		}
+		query = `INSERT INTO remote_group ( groupname, remoteids, createdts)
+		                           VALUES (:groupname,:remoteids,:createdts)
+		         ON CONFLICT (groupname) DO UPDATE SET remoteids = excluded.remoteids`
+		tx.NamedExec(query, dbutil.ToDBMap(group, false))
+		return group, nil
+	})
+}
+
+// returns the number of members removed
+func RemoveRemoteGroupMembers(ctx context.Context, groupName string, remoteIds []string) (int, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) (int, error) {
+		query := `SELECT * FROM remote_group WHERE groupname = ?`
+		group := dbutil.GetMappable[*RemoteGroupType](tx, query, groupName)
+		if group == nil {
+			return 0, fmt.Errorf("remote group %q not found", groupName)
+		}
+		var newIds []string
+		for _, id := range group.RemoteIds {
+			if !containsStr(remoteIds, id) {
+				newIds = append(newIds, id)
+			}
+		}
+		numRemoved := len(group.RemoteIds) - len(newIds)
+		query = `UPDATE remote_group SET remoteids = ? WHERE groupname = ?`
+		tx.Exec(query, dbutil.QuickJsonArr(newIds), groupName)
+		return numRemoved, nil
+	})
+}
+
+func DeleteRemoteGroup(ctx context.Context, groupName string) error {
+	return WithTx(ctx, func(tx *TxWrap) error {
+		query := `SELECT groupname FROM remote_group WHERE groupname = ?`
+		if !tx.Exists(query, groupName) {
+			return fmt.Errorf("remote group %q not found", groupName)
+		}
+		query = `DELETE FROM remote_group WHERE groupname = ?`
+		tx.Exec(query, groupName)
+		return nil
+	})
+}
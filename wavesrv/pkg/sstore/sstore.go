@@ -62,14 +62,18 @@ const (
 )
 
 const (
-	LineState_Source   = "prompt:source"
-	LineState_File     = "prompt:file"
-	LineState_FileUrl  = "wave:fileurl"
-	LineState_Min      = "wave:min"
-	LineState_Template = "template"
-	LineState_Mode     = "mode"
-	LineState_Lang     = "lang"
-	LineState_Minimap  = "minimap"
+	LineState_Source       = "prompt:source"
+	LineState_File         = "prompt:file"
+	LineState_FileUrl      = "wave:fileurl"
+	LineState_Min          = "wave:min"
+	LineState_Template     = "template"
+	LineState_Mode         = "mode"
+	LineState_Lang         = "lang"
+	LineState_Minimap      = "minimap"
+	LineState_AgentAudit   = "agent:audit"
+	LineState_Explain      = "ai:explain"
+	LineState_HangupReason = "wave:hangupreason"
+	LineState_RunAll       = "wave:runall"
 )
 
 const (
@@ -118,16 +122,25 @@ const (
 )
 
 const (
-	ConnectModeStartup = "startup"
-	ConnectModeAuto    = "auto"
-	ConnectModeManual  = "manual"
+	ConnectModeStartup   = "startup"
+	ConnectModeAuto      = "auto"
+	ConnectModeManual    = "manual"
+	ConnectModeReconnect = "reconnect" // like startup, and reconnects (with backoff) when the connection drops
 )
 
 const (
-	RemoteTypeSsh    = "ssh"
-	RemoteTypeOpenAI = "openai"
+	RemoteTypeSsh     = "ssh"
+	RemoteTypeDocker  = "docker"  // waveshell runs through `docker exec -i`
+	RemoteTypeKubectl = "kubectl" // waveshell runs through `kubectl exec -i`
+	RemoteTypeSocket  = "socket"  // connects to an already running `waveshell --server --listen`
+	RemoteTypeOpenAI  = "openai"
 )
 
+// docker and kubectl remotes run waveshell through a local exec command instead of ssh
+func IsExecRemoteType(remoteType string) bool {
+	return remoteType == RemoteTypeDocker || remoteType == RemoteTypeKubectl
+}
+
 const (
 	ScreenFocusInput = "input"
 	ScreenFocusCmd   = "cmd"
@@ -185,7 +198,12 @@ func GetDBWALBackupName() string {
 }
 
 func IsValidConnectMode(mode string) bool {
-	return mode == ConnectModeStartup || mode == ConnectModeAuto || mode == ConnectModeManual
+	return mode == ConnectModeStartup || mode == ConnectModeAuto || mode == ConnectModeManual || mode == ConnectModeReconnect
+}
+
+// remotes that are connected when wave starts
+func IsStartupConnectMode(mode string) bool {
+	return mode == ConnectModeStartup || mode == ConnectModeReconnect
 }
 
 func GetDB(ctx context.Context) (*sqlx.DB, error) {
@@ -243,17 +261,33 @@ type SidebarValueType struct {
 }
 
 type ClientOptsType struct {
-	NoTelemetry           bool              `json:"notelemetry,omitempty"`
-	NoReleaseCheck        bool              `json:"noreleasecheck,omitempty"`
-	AcceptedTos           int64             `json:"acceptedtos,omitempty"`
-	ConfirmFlags          map[string]bool   `json:"confirmflags,omitempty"`
-	MainSidebar           *SidebarValueType `json:"mainsidebar,omitempty"`
-	RightSidebar          *SidebarValueType `json:"rightsidebar,omitempty"`
-	GlobalShortcut        string            `json:"globalshortcut,omitempty"`
-	GlobalShortcutEnabled bool              `json:"globalshortcutenabled,omitempty"`
-	WebGL                 bool              `json:"webgl,omitempty"`
-	AutocompleteEnabled   bool              `json:"autocompleteenabled,omitempty"`
-	InputPosition         string            `json:"inputposition,omitempty"`
+	NoTelemetry           bool               `json:"notelemetry,omitempty"`
+	NoReleaseCheck        bool               `json:"noreleasecheck,omitempty"`
+	AcceptedTos           int64              `json:"acceptedtos,omitempty"`
+	ConfirmFlags          map[string]bool    `json:"confirmflags,omitempty"`
+	MainSidebar           *SidebarValueType  `json:"mainsidebar,omitempty"`
+	RightSidebar          *SidebarValueType  `json:"rightsidebar,omitempty"`
+	GlobalShortcut        string             `json:"globalshortcut,omitempty"`
+	GlobalShortcutEnabled bool               `json:"globalshortcutenabled,omitempty"`
+	WebGL                 bool               `json:"webgl,omitempty"`
+	AutocompleteEnabled   bool               `json:"autocompleteenabled,omitempty"`
+	InputPosition         string             `json:"inputposition,omitempty"`
+	Retention             *RetentionOptsType `json:"retention,omitempty"`
+}
+
+// history retention, enforced by the janitor in pkg/history (retention.go).  zero values do not purge anything.
+// starred lines, bookmarked commands and tagged history items are kept unless the NoKeep flags are set
+type RetentionOptsType struct {
+	MaxAgeDays         int  `json:"maxagedays,omitempty"`
+	MaxItemsPerScreen  int  `json:"maxitemsperscreen,omitempty"`
+	ArchivedScreenDays int  `json:"archivedscreendays,omitempty"`
+	NoKeepStarred      bool `json:"nokeepstarred,omitempty"`
+	NoKeepBookmarked   bool `json:"nokeepbookmarked,omitempty"`
+	NoKeepTagged       bool `json:"nokeeptagged,omitempty"`
+}
+
+func (opts *RetentionOptsType) IsEnabled() bool {
+	return opts != nil && (opts.MaxAgeDays > 0 || opts.MaxItemsPerScreen > 0 || opts.ArchivedScreenDays > 0)
 }
 
 type FeOptsType struct {
@@ -367,6 +401,9 @@ type ScreenOptsType struct {
 	TabColor string `json:"tabcolor,omitempty"`
 	TabIcon  string `json:"tabicon,omitempty"`
 	PTerm    string `json:"pterm,omitempty"`
+
+	// run /line:explain automatically when a command exits with a non-zero code
+	AutoExplain bool `json:"autoexplain,omitempty"`
 }
 
 type ScreenLinesType struct {
@@ -717,14 +754,16 @@ type ResolveItem struct {
 }
 
 type SSHOpts struct {
-	Local       bool   `json:"local,omitempty"`
-	IsSudo      bool   `json:"issudo,omitempty"`
-	SSHHost     string `json:"sshhost"`
-	SSHUser     string `json:"sshuser"`
-	SSHOptsStr  string `json:"sshopts,omitempty"`
-	SSHIdentity string `json:"sshidentity,omitempty"`
-	SSHPort     int    `json:"sshport,omitempty"`
-	SSHPassword string `json:"sshpassword,omitempty"`
+	Local           bool   `json:"local,omitempty"`
+	IsSudo          bool   `json:"issudo,omitempty"`
+	SSHHost         string `json:"sshhost"`
+	SSHUser         string `json:"sshuser"`
+	SSHOptsStr      string `json:"sshopts,omitempty"`
+	SSHIdentity     string `json:"sshidentity,omitempty"`
+	SSHPort         int    `json:"sshport,omitempty"`
+	SSHPassword     string `json:"sshpassword,omitempty"`
+	SSHJump         string `json:"sshjump,omitempty"`         // ProxyJump spec, overrides the ssh config
+	SSHAgentForward bool   `json:"sshagentforward,omitempty"` // forward the local ssh agent to waveshell (agentforward=1)
 }
 
 func (opts SSHOpts) GetAuthType() string {
@@ -741,7 +780,57 @@ func (opts SSHOpts) GetAuthType() string {
 }
 
 type RemoteOptsType struct {
-	Color string `json:"color"`
+	Color    string            `json:"color"`
+	Forwards []PortForwardType `json:"forwards,omitempty"`
+}
+
+const (
+	PortForwardType_Local  = "local"  // ssh -L, listens locally, connects from the remote
+	PortForwardType_Remote = "remote" // ssh -R, listens on the remote, connects locally
+)
+
+// a tcp port forward over a remote's ssh connection, started whenever the remote connects
+type PortForwardType struct {
+	Type       string `json:"type"`
+	BindAddr   string `json:"bindaddr"`   // host:port that is listened on
+	TargetAddr string `json:"targetaddr"` // host:port that is connected to
+}
+
+func (pf PortForwardType) String() string {
+	flag := "L"
+	if pf.Type == PortForwardType_Remote {
+		flag = "R"
+	}
+	return fmt.Sprintf("-%s %s -> %s", flag, pf.BindAddr, pf.TargetAddr)
+}
+
+const (
+	PortForwardStatus_Active   = "active"
+	PortForwardStatus_Inactive = "inactive" // remote not connected
+	PortForwardStatus_Error    = "error"
+)
+
+type PortForwardStateType struct {
+	PortForwardType
+	Status   string `json:"status"`
+	ErrorStr string `json:"errorstr,omitempty"`
+	NumConns int    `json:"numconns"` // currently open connections
+}
+
+// options for docker and kubectl remotes
+type ExecOptsType struct {
+	Target      string `json:"target"`                // docker container (name or id), or kubectl pod
+	User        string `json:"user,omitempty"`        // docker exec -u
+	Namespace   string `json:"namespace,omitempty"`   // kubectl -n
+	KubeContext string `json:"kubecontext,omitempty"` // kubectl --context
+	Container   string `json:"container,omitempty"`   // kubectl -c (container in the pod)
+}
+
+// options for socket remotes.  the pre-shared key is either stored (Key) or read from KeyFile on connect
+type SocketOptsType struct {
+	Addr    string `json:"addr"` // unix:/path or tcp:host:port
+	Key     string `json:"key,omitempty"`
+	KeyFile string `json:"keyfile,omitempty"`
 }
 
 type OpenAIOptsType struct {
@@ -765,11 +854,41 @@ type AzureOpenAIOptsType struct {
 	APIToken       string `json:"apitoken"`
 }
 
+// local (or self-hosted) ollama server, no api token required
+type OllamaOptsType struct {
+	BaseURL   string `json:"baseurl,omitempty"`
+	Model     string `json:"model,omitempty"`
+	MaxTokens int    `json:"maxtokens,omitempty"`
+}
+
+type AnthropicOptsType struct {
+	Model     string `json:"model,omitempty"`
+	APIToken  string `json:"apitoken"`
+	BaseURL   string `json:"baseurl,omitempty"`
+	MaxTokens int    `json:"maxtokens,omitempty"`
+}
+
+// opt-in terminal context attached to /agent prompts (can be overridden with the "context" kwarg)
+// NumCmds and MaxOutputBytes are pointers so that nil (unset) uses the default and 0 turns them off
+type AIContextOptsType struct {
+	Enabled        bool     `json:"enabled,omitempty"`
+	IncludeEnv     bool     `json:"includeenv,omitempty"`
+	NumCmds        *int     `json:"numcmds,omitempty"`
+	MaxOutputBytes *int     `json:"maxoutputbytes,omitempty"`
+	RedactPatterns []string `json:"redactpatterns,omitempty"`
+}
+
 type AIOptsType struct {
-	Default string               `json:"default"`
-	Gemini  *GeminiOptsType      `json:"gemini,omitempty"`
-	OpenAI  *OpenAIOptsType      `json:"openai,omitempty"`
-	Azure   *AzureOpenAIOptsType `json:"azure,omitempty"`
+	Default   string               `json:"default"`
+	Gemini    *GeminiOptsType      `json:"gemini,omitempty"`
+	OpenAI    *OpenAIOptsType      `json:"openai,omitempty"`
+	Azure     *AzureOpenAIOptsType `json:"azure,omitempty"`
+	Ollama    *OllamaOptsType      `json:"ollama,omitempty"`
+	Anthropic *AnthropicOptsType   `json:"anthropic,omitempty"`
+	Context   *AIContextOptsType   `json:"context,omitempty"`
+
+	// max prompt+completion tokens per (local) day across all providers, 0 = no budget
+	DailyTokenBudget int `json:"dailytokenbudget,omitempty"`
 }
 
 const (
@@ -780,34 +899,37 @@ const (
 )
 
 type RemoteRuntimeState struct {
-	RemoteType            string            `json:"remotetype"`
-	RemoteId              string            `json:"remoteid"`
-	RemoteAlias           string            `json:"remotealias,omitempty"`
-	RemoteCanonicalName   string            `json:"remotecanonicalname"`
-	RemoteVars            map[string]string `json:"remotevars"`
-	Status                string            `json:"status"`
-	ConnectTimeout        int               `json:"connecttimeout,omitempty"`
-	CountdownActive       bool              `json:"countdownactive"`
-	ErrorStr              string            `json:"errorstr,omitempty"`
-	InstallStatus         string            `json:"installstatus"`
-	InstallErrorStr       string            `json:"installerrorstr,omitempty"`
-	NeedsWaveshellUpgrade bool              `json:"needswaveshellupgrade,omitempty"`
-	NoInitPk              bool              `json:"noinitpk,omitempty"`
-	AuthType              string            `json:"authtype,omitempty"`
-	ConnectMode           string            `json:"connectmode"`
-	AutoInstall           bool              `json:"autoinstall"`
-	Archived              bool              `json:"archived,omitempty"`
-	RemoteIdx             int64             `json:"remoteidx"`
-	SSHConfigSrc          string            `json:"sshconfigsrc"`
-	UName                 string            `json:"uname"`
-	WaveshellVersion      string            `json:"waveshellversion"`
-	WaitingForPassword    bool              `json:"waitingforpassword,omitempty"`
-	Local                 bool              `json:"local,omitempty"`
-	IsSudo                bool              `json:"issudo,omitempty"`
-	RemoteOpts            *RemoteOptsType   `json:"remoteopts,omitempty"`
-	CanComplete           bool              `json:"cancomplete,omitempty"`
-	ShellPref             string            `json:"shellpref,omitempty"`
-	DefaultShellType      string            `json:"defaultshelltype,omitempty"`
+	RemoteType            string                 `json:"remotetype"`
+	RemoteId              string                 `json:"remoteid"`
+	RemoteAlias           string                 `json:"remotealias,omitempty"`
+	RemoteCanonicalName   string                 `json:"remotecanonicalname"`
+	RemoteVars            map[string]string      `json:"remotevars"`
+	Status                string                 `json:"status"`
+	ConnectTimeout        int                    `json:"connecttimeout,omitempty"`
+	CountdownActive       bool                   `json:"countdownactive"`
+	ErrorStr              string                 `json:"errorstr,omitempty"`
+	InstallStatus         string                 `json:"installstatus"`
+	InstallErrorStr       string                 `json:"installerrorstr,omitempty"`
+	NeedsWaveshellUpgrade bool                   `json:"needswaveshellupgrade,omitempty"`
+	NoInitPk              bool                   `json:"noinitpk,omitempty"`
+	AuthType              string                 `json:"authtype,omitempty"`
+	ConnectMode           string                 `json:"connectmode"`
+	AutoInstall           bool                   `json:"autoinstall"`
+	Archived              bool                   `json:"archived,omitempty"`
+	RemoteIdx             int64                  `json:"remoteidx"`
+	SSHConfigSrc          string                 `json:"sshconfigsrc"`
+	UName                 string                 `json:"uname"`
+	WaveshellVersion      string                 `json:"waveshellversion"`
+	WaitingForPassword    bool                   `json:"waitingforpassword,omitempty"`
+	Local                 bool                   `json:"local,omitempty"`
+	IsSudo                bool                   `json:"issudo,omitempty"`
+	RemoteOpts            *RemoteOptsType        `json:"remoteopts,omitempty"`
+	CanComplete           bool                   `json:"cancomplete,omitempty"`
+	ShellPref             string                 `json:"shellpref,omitempty"`
+	DefaultShellType      string                 `json:"defaultshelltype,omitempty"`
+	Forwards              []PortForwardStateType `json:"forwards,omitempty"`
+	ReconnectAttempt      int                    `json:"reconnectattempt,omitempty"`
+	ReconnectTs           int64                  `json:"reconnectts,omitempty"` // time of the next reconnect attempt
 }
 
 func (state RemoteRuntimeState) IsConnected() bool {
@@ -866,7 +988,13 @@ type RemoteType struct {
 	SSHOpts      *SSHOpts          `json:"sshopts"`
 	StateVars    map[string]string `json:"statevars"`
 	SSHConfigSrc string            `json:"sshconfigsrc"`
-	ShellPref    string            `json:"shellpref"` // bash, zsh, or detect
+	ShellPref    string            `json:"shellpref"` // bash, zsh, fish, or detect
+
+	// docker / kubectl fields
+	ExecOpts *ExecOptsType `json:"execopts,omitempty"`
+
+	// socket fields
+	SocketOpts *SocketOptsType `json:"socketopts,omitempty"`
 
 	// OpenAI fields (unused)
 	OpenAIOpts *OpenAIOptsType `json:"openaiopts,omitempty"`
@@ -906,6 +1034,8 @@ func (r *RemoteType) ToMap() map[string]interface{} {
 	rtn["statevars"] = quickJson(r.StateVars)
 	rtn["sshconfigsrc"] = r.SSHConfigSrc
 	rtn["openaiopts"] = quickJson(r.OpenAIOpts)
+	rtn["execopts"] = quickJson(r.ExecOpts)
+	rtn["socketopts"] = quickJson(r.SocketOpts)
 	rtn["shellpref"] = r.ShellPref
 	return rtn
 }
@@ -928,6 +1058,8 @@ func (r *RemoteType) FromMap(m map[string]interface{}) bool {
 	quickSetJson(&r.StateVars, m, "statevars")
 	quickSetStr(&r.SSHConfigSrc, m, "sshconfigsrc")
 	quickSetJson(&r.OpenAIOpts, m, "openaiopts")
+	quickSetJson(&r.ExecOpts, m, "execopts")
+	quickSetJson(&r.SocketOpts, m, "socketopts")
 	quickSetStr(&r.ShellPref, m, "shellpref")
 	return true
 }